@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// Alert is an alert as returned by the Alertmanager API v2.
+type Alert struct {
+	Labels   map[string]string `json:"labels"`
+	StartsAt time.Time         `json:"startsAt"`
+	EndsAt   time.Time         `json:"endsAt"`
+}
+
+func (a Alert) Name() string {
+	return a.Labels["alertname"]
+}
+
+// alertmanagerClient times out, so an unresponsive Alertmanager doesn't hang the report.
+var alertmanagerClient = &http.Client{Timeout: 30 * time.Second}
+
+// FetchAlerts returns the alerts known to the Alertmanager, including silenced and inhibited ones. The API only
+// returns active alerts, so alerts resolved by the time of the run are missing.
+func FetchAlerts(ctx context.Context, baseURL string) ([]Alert, error) {
+	url := strings.TrimRight(baseURL, "/") + "/api/v2/alerts?active=true&silenced=true&inhibited=true&unprocessed=true"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := alertmanagerClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	var alerts []Alert
+	if err := json.NewDecoder(resp.Body).Decode(&alerts); err != nil {
+		return nil, fmt.Errorf("failed to decode alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// PrintAlertCorrelation prints a timeline of alerting rule evaluation time in buckets of the given size,
+// marking buckets where the evaluation time spiked above mean + 2 stdev and listing alerts
+// that started firing in each bucket. Only spikes and buckets with new alerts are shown.
+func PrintAlertCorrelation(logs querystats.LogEntries, alerts []Alert, bucket time.Duration) {
+	evalTime := make(map[int64]float64)
+	var first, last int64 = math.MaxInt64, math.MinInt64
+	for _, entry := range logs {
+		// recording rules don't fire alerts
+		if ruleIndex.Classify(entry) != RuleKindAlerting {
+			continue
+		}
+		b := entry.TS.Truncate(bucket).Unix()
+		evalTime[b] += entry.Stats.Timings.ExecTotalTime
+		first, last = min(first, b), max(last, b)
+	}
+	if len(evalTime) == 0 {
+		fmt.Println("No alerting rule evaluations to correlate alerts with")
+		return
+	}
+
+	step := int64(bucket / time.Second)
+	var sum, sumSq float64
+	n := 0
+	for b := first; b <= last; b += step {
+		sum += evalTime[b]
+		sumSq += evalTime[b] * evalTime[b]
+		n++
+	}
+	mean := sum / float64(n)
+	threshold := mean + 2*math.Sqrt(math.Max(sumSq/float64(n)-mean*mean, 0))
+
+	started := make(map[int64]map[string]int)
+	for _, alert := range alerts {
+		b := alert.StartsAt.Truncate(bucket).Unix()
+		if b < first || b > last {
+			continue
+		}
+		if started[b] == nil {
+			started[b] = make(map[string]int)
+		}
+		started[b][alert.Name()]++
+	}
+
+	fmt.Printf("Alerting rule evaluation time vs. active alerts started per %s (spike threshold %.3fs):\n", bucket, threshold)
+	for b := first; b <= last; b += step {
+		spike := evalTime[b] > threshold
+		if !spike && len(started[b]) == 0 {
+			continue
+		}
+		marker := " "
+		if spike {
+			marker = "▲"
+		}
+		fmt.Printf("%s %s rules=%.3fs", formatTime(time.Unix(b, 0)), marker, evalTime[b])
+		if len(started[b]) > 0 {
+			names := make([]string, 0, len(started[b]))
+			for name, count := range started[b] {
+				names = append(names, fmt.Sprintf("%s(%d)", escapeTerminal(name), count))
+			}
+			sort.Strings(names)
+			fmt.Printf(" alerts: %s", strings.Join(names, " "))
+		}
+		fmt.Println()
+	}
+}
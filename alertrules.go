@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The metrics of the alerting rules are rates of rule groups rather than values of entries, so they only format
+// their thresholds.
+var (
+	alertFailureRatio     = Metric{Name: "failure-ratio", Title: "failure ratio"}
+	alertSamplesPerSecond = Metric{Name: "samples-per-second", Title: "samples per second"}
+	alertCostPerHour      = Metric{Name: "cost-per-hour", Title: "cost per hour"}
+)
+
+// AlertThresholds configures the alerting rules generated for the metrics of the serve subcommand.
+// Rules whose threshold is zero are not generated.
+type AlertThresholds struct {
+	// LatencyQuantile and Latency alert when the quantile of query execution time of a rule group exceeds Latency
+	LatencyQuantile float64
+	Latency         *Threshold
+	// FailureRatio alerts when the ratio of failed or timed out executions of a rule group exceeds it
+	FailureRatio *Threshold
+	// SamplesPerSecond alerts when a rule group loads more samples per second
+	SamplesPerSecond *Threshold
+	// CostPerHour alerts when the estimated cost of a rule group per hour exceeds it. Requires a cost model
+	CostPerHour *Threshold
+	For         time.Duration
+	Window      time.Duration
+}
+
+// NewAlertThresholds returns the thresholds of the flags of alert-rules with their defaults.
+func NewAlertThresholds() AlertThresholds {
+	return AlertThresholds{
+		LatencyQuantile:  0.99,
+		Latency:          NewThreshold("latency", MetricExecTotalTime, TableMax).WithDefault(10),
+		FailureRatio:     NewThreshold("failure-ratio", alertFailureRatio, TableAvg).WithDefault(0.01),
+		SamplesPerSecond: NewThreshold("samples-per-second", alertSamplesPerSecond, TableAvg),
+		CostPerHour:      NewThreshold("cost-per-hour", alertCostPerHour, TableSum),
+		For:              15 * time.Minute,
+		Window:           5 * time.Minute,
+	}
+}
+
+type alertRule struct {
+	name, expr, summary string
+}
+
+func (t AlertThresholds) rules(cost CostModel) []alertRule {
+	window := "[" + formatPromDuration(t.Window) + "]"
+	var rules []alertRule
+	if t.Latency.Limit > 0 {
+		rules = append(rules, alertRule{
+			"PromQueryLatencyHigh",
+			fmt.Sprintf("histogram_quantile(%s, sum by (le, rule_group) (rate(prom_query_stats_query_duration_seconds_bucket%s))) > %s",
+				strconv.FormatFloat(t.LatencyQuantile, 'f', -1, 64), window, strconv.FormatFloat(t.Latency.Limit, 'f', -1, 64)),
+			fmt.Sprintf("%s quantile of query execution time is above %s", strconv.FormatFloat(t.LatencyQuantile, 'f', -1, 64), t.Latency),
+		})
+	}
+	if t.FailureRatio.Limit > 0 {
+		rules = append(rules, alertRule{
+			"PromQueryFailures",
+			fmt.Sprintf(`sum by (rule_group) (rate(prom_query_stats_queries_total{outcome!="ok"}%s)) / sum by (rule_group) (rate(prom_query_stats_queries_total%s)) > %s`,
+				window, window, t.FailureRatio),
+			fmt.Sprintf("more than %s%% of queries fail or time out", strconv.FormatFloat(t.FailureRatio.Limit*100, 'f', -1, 64)),
+		})
+	}
+	if t.SamplesPerSecond.Limit > 0 {
+		rules = append(rules, alertRule{
+			"PromQuerySamplesHigh",
+			fmt.Sprintf("sum by (rule_group) (rate(prom_query_stats_queryable_samples_total%s)) > %s", window, t.SamplesPerSecond),
+			fmt.Sprintf("queries load more than %s samples per second", t.SamplesPerSecond),
+		})
+	}
+	if t.CostPerHour.Limit > 0 && cost.Enabled() {
+		rules = append(rules, alertRule{
+			"PromQueryCostHigh",
+			fmt.Sprintf("3600 * (sum by (rule_group) (rate(prom_query_stats_query_duration_seconds_sum%s)) * %s + sum by (rule_group) (rate(prom_query_stats_queryable_samples_total%s)) / 1e6 * %s) > %s",
+				window, strconv.FormatFloat(cost.PerEngineSecond, 'f', -1, 64),
+				window, strconv.FormatFloat(cost.PerMillionSamples, 'f', -1, 64),
+				t.CostPerHour),
+			fmt.Sprintf("estimated query cost is above %s per hour", t.CostPerHour),
+		})
+	}
+	return rules
+}
+
+// formatPromDuration formats d in the PromQL duration syntax, e.g. 1h30m instead of Go's 1h30m0s.
+func formatPromDuration(d time.Duration) string {
+	units := []struct {
+		name string
+		d    time.Duration
+	}{{"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}, {"ms", time.Millisecond}}
+	s := ""
+	for _, u := range units {
+		if n := d / u.d; n > 0 {
+			s += strconv.FormatInt(int64(n), 10) + u.name
+			d -= n * u.d
+		}
+	}
+	if s == "" {
+		return "0s"
+	}
+	return s
+}
+
+// WriteAlertRules writes a Prometheus rule file with alerts on the metrics exposed by the serve subcommand.
+func WriteAlertRules(w io.Writer, t AlertThresholds, cost CostModel) error {
+	if _, err := fmt.Fprintf(w, "groups:\n  - name: prom-query-stats\n    rules:\n"); err != nil {
+		return err
+	}
+	for _, r := range t.rules(cost) {
+		_, err := fmt.Fprintf(w, "      - alert: %s\n        expr: %q\n        for: %s\n        annotations:\n          summary: %q\n",
+			r.name, r.expr, formatPromDuration(t.For), "{{ $labels.rule_group }}: "+r.summary)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAlertRules implements the alert-rules subcommand.
+func runAlertRules(args []string) {
+	fs := flag.NewFlagSet("alert-rules", flag.ExitOnError)
+	t := NewAlertThresholds()
+	var cost CostModel
+	fs.Float64Var(&t.LatencyQuantile, "latency-quantile", t.LatencyQuantile, "quantile of query execution time checked against -latency")
+	fs.Var(t.Latency, t.Latency.Name, "alert when the -latency-quantile of execution time of a rule group exceeds this, e.g. 10s. 0 disables")
+	fs.Var(t.FailureRatio, t.FailureRatio.Name, "alert when the ratio of failed or timed out queries of a rule group exceeds this. 0 disables")
+	fs.Var(t.SamplesPerSecond, t.SamplesPerSecond.Name, "alert when the queries of a rule group load more samples per second. 0 disables")
+	fs.Var(t.CostPerHour, t.CostPerHour.Name, "alert when the estimated cost of a rule group per hour exceeds this. Requires -cost-per-second or -cost-per-msamples")
+	fs.Float64Var(&cost.PerEngineSecond, "cost-per-second", 0, "estimated cost of one second of query execution time")
+	fs.Float64Var(&cost.PerMillionSamples, "cost-per-msamples", 0, "estimated cost of one million queryable samples")
+	fs.DurationVar(&t.For, "for", t.For, "how long a threshold must be exceeded before alerts fire")
+	fs.DurationVar(&t.Window, "window", t.Window, "range of the rate() calls in the alerts")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s alert-rules [flags] > rules.yml\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if t.LatencyQuantile <= 0 || t.LatencyQuantile >= 1 {
+		fatal("-latency-quantile must be between 0 and 1")
+	}
+	if err := cost.Validate(); err != nil {
+		fatal(err)
+	}
+	if t.CostPerHour.Limit > 0 && !cost.Enabled() {
+		fatal("-cost-per-hour requires -cost-per-second or -cost-per-msamples")
+	}
+	if t.Window <= 0 || t.For < 0 {
+		fatal("-window must be positive and -for cannot be negative")
+	}
+	if err := WriteAlertRules(os.Stdout, t, cost); err != nil {
+		fatalf("Failed to write the rules: %s", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// madScale makes the median absolute deviation of normally distributed values equal to their standard deviation,
+// so thresholds mean the same with both methods.
+const madScale = 1.4826
+
+// AnomalyOptions select the executions reported as anomalous.
+type AnomalyOptions struct {
+	// Method is stddev, deviations from the mean in standard deviations, or mad, deviations from the median in
+	// scaled median absolute deviations, which the outliers themselves don't inflate
+	Method    string
+	Threshold float64
+	// MinExecutions is the number of executions a query needs for a baseline
+	MinExecutions int
+}
+
+var anomalyOptions AnomalyOptions
+
+func init() {
+	flag.StringVar(&anomalyOptions.Method, "anomaly-method", "mad", "how anomalous executions deviate from the baseline of their query: mad, from the median by scaled median absolute deviations, or stddev, from the mean by standard deviations")
+	flag.Float64Var(&anomalyOptions.Threshold, "anomaly-threshold", 5, "report executions whose execution time or total queryable samples deviate by more than this many deviations of -anomaly-method from the baseline of their query")
+	flag.IntVar(&anomalyOptions.MinExecutions, "anomaly-min-executions", 20, "number of executions a query needs for anomalous executions to be reported")
+}
+
+func (o AnomalyOptions) Validate() error {
+	if o.Method != "mad" && o.Method != "stddev" {
+		return fmt.Errorf("-anomaly-method must be mad or stddev")
+	}
+	if o.Threshold <= 0 || o.MinExecutions < 2 {
+		return fmt.Errorf("-anomaly-threshold must be positive and -anomaly-min-executions at least 2")
+	}
+	return nil
+}
+
+// Anomaly is an execution deviating from the baseline of its query.
+type Anomaly struct {
+	Query  *querystats.Query
+	Entry  *querystats.LogEntry
+	Metric Metric
+	// Baseline is the median or the mean of the metric over the executions of the query
+	Baseline float64
+	// Score is the deviation from the baseline in deviations of the method
+	Score float64
+}
+
+// baseline returns the center and the scale of the values by the method, or a zero scale if they don't vary.
+func (o AnomalyOptions) baseline(values []float64) (center, scale float64) {
+	if o.Method == "stddev" {
+		return querystats.Avg(values), querystats.StdDev(values)
+	}
+	mad, median := querystats.MAD(values)
+	return median, madScale * mad
+}
+
+// FindAnomalies returns the executions of queries with at least MinExecutions whose execution time or total
+// queryable samples deviate from the baseline of the query by more than Threshold, the largest deviations first.
+// Deviations below the baseline, e.g. of cached results, are not anomalies.
+func FindAnomalies(queries []*querystats.Query, o AnomalyOptions) []Anomaly {
+	var anomalies []Anomaly
+	for _, q := range queries {
+		if len(q.Logs) < o.MinExecutions {
+			continue
+		}
+		for _, m := range []Metric{MetricExecTotalTime, MetricTotalQueryableSamples} {
+			center, scale := o.baseline(m.Values(q))
+			if scale == 0 {
+				continue
+			}
+			for _, e := range q.Logs {
+				if score := (m.Value(e) - center) / scale; score > o.Threshold {
+					anomalies = append(anomalies, Anomaly{q, e, m, center, score})
+				}
+			}
+		}
+	}
+	sort.SliceStable(anomalies, func(i, j int) bool { return anomalies[i].Score > anomalies[j].Score })
+	return anomalies
+}
+
+// PrintAnomalies prints the first top anomalous executions with their time, to correlate them with incidents.
+func PrintAnomalies(anomalies []Anomaly, top int, o AnomalyOptions) {
+	unit := "scaled MADs from the median"
+	if o.Method == "stddev" {
+		unit = "standard deviations from the mean"
+	}
+	if len(anomalies) == 0 {
+		fmt.Printf("No executions deviate by more than %g %s of queries with at least %d executions\n", o.Threshold, unit, o.MinExecutions)
+		return
+	}
+	top = min(top, len(anomalies))
+	fmt.Printf("Top %d of %d anomalous executions deviating by more than %g %s of queries with at least %d executions:\n",
+		top, len(anomalies), o.Threshold, unit, o.MinExecutions)
+	for i, a := range anomalies[:top] {
+		fmt.Printf("%2d) t=%s %s=%s baseline=%s score=%-6.1f n=%-6d %s", i+1, formatTime(*a.Entry.TS), a.Metric.Name,
+			a.Metric.Format(a.Metric.Value(a.Entry)), a.Metric.Format(a.Baseline), a.Score, len(a.Query.Logs), queryWithID(a.Query.Query))
+		printRuleName(a.Query)
+		fmt.Println()
+	}
+}
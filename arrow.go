@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+const arrowBatchSize = 64 * 1024
+
+var arrowTimestamp = &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}
+
+func arrowSchema(withCost bool) *arrow.Schema {
+	fields := []arrow.Field{
+		{Name: "ts", Type: arrowTimestamp},
+		{Name: "query", Type: arrow.BinaryTypes.String},
+		{Name: "query_key", Type: arrow.BinaryTypes.String},
+		{Name: "rule_group", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "rule_file", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "start", Type: arrowTimestamp, Nullable: true},
+		{Name: "end", Type: arrowTimestamp, Nullable: true},
+		{Name: "step", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "eval_total_time", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "exec_queue_time", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "exec_total_time", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "inner_eval_time", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "query_preparation_time", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "result_sort_time", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "total_queryable_samples", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "peak_samples", Type: arrow.PrimitiveTypes.Int64},
+	}
+	if withCost {
+		fields = append(fields, arrow.Field{Name: "cost", Type: arrow.PrimitiveTypes.Float64})
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+func appendArrowTime(b *array.TimestampBuilder, t *time.Time) {
+	if t == nil {
+		b.AppendNull()
+		return
+	}
+	b.Append(arrow.Timestamp(t.UnixMilli()))
+}
+
+func appendArrowString(b *array.StringBuilder, s string, valid bool) {
+	if !valid {
+		b.AppendNull()
+		return
+	}
+	b.Append(s)
+}
+
+// WriteArrow writes the entries as an Arrow IPC file (Feather v2), one row per entry,
+// enriched with the grouping key and the estimated cost when the cost model is enabled. The run metadata, if
+// any, is stored in the schema metadata.
+func WriteArrow(w io.Writer, logs querystats.LogEntries, normalizer querystats.Normalizer, cost CostModel, run *RunMetadata) error {
+	schema := arrowSchema(cost.Enabled())
+	if run != nil {
+		// the run metadata is stored as JSON in the schema metadata, e.g. pyarrow's schema.metadata
+		md := arrow.NewMetadata([]string{"prom-query-stats.run"}, []string{run.JSON()})
+		schema = arrow.NewSchema(schema.Fields(), &md)
+	}
+	fw, err := ipc.NewFileWriter(w, ipc.WithSchema(schema), ipc.WithZstd())
+	if err != nil {
+		return err
+	}
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	flush := func() error {
+		rec := b.NewRecord()
+		defer rec.Release()
+		return fw.Write(rec)
+	}
+
+	for i, entry := range logs {
+		t := entry.Stats.Timings
+		b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(entry.TS.UnixMilli()))
+		b.Field(1).(*array.StringBuilder).Append(entry.Params.Query)
+		b.Field(2).(*array.StringBuilder).Append(normalizer.Normalize(entry.Params.Query))
+		if entry.RuleGroup != nil {
+			appendArrowString(b.Field(3).(*array.StringBuilder), entry.RuleGroup.Name, true)
+			appendArrowString(b.Field(4).(*array.StringBuilder), entry.RuleGroup.File, true)
+		} else {
+			appendArrowString(b.Field(3).(*array.StringBuilder), "", false)
+			appendArrowString(b.Field(4).(*array.StringBuilder), "", false)
+		}
+		appendArrowTime(b.Field(5).(*array.TimestampBuilder), entry.Params.Start)
+		appendArrowTime(b.Field(6).(*array.TimestampBuilder), entry.Params.End)
+		b.Field(7).(*array.Int64Builder).Append(int64(entry.Params.Step))
+		b.Field(8).(*array.Float64Builder).Append(t.EvalTotalTime)
+		b.Field(9).(*array.Float64Builder).Append(t.ExecQueueTime)
+		b.Field(10).(*array.Float64Builder).Append(t.ExecTotalTime)
+		b.Field(11).(*array.Float64Builder).Append(t.InnerEvalTime)
+		b.Field(12).(*array.Float64Builder).Append(t.QueryPreparationTime)
+		b.Field(13).(*array.Float64Builder).Append(t.ResultSortTime)
+		b.Field(14).(*array.Int64Builder).Append(int64(entry.Stats.Samples.TotalQueryableSamples))
+		b.Field(15).(*array.Int64Builder).Append(int64(entry.Stats.Samples.PeakSamples))
+		if cost.Enabled() {
+			b.Field(16).(*array.Float64Builder).Append(cost.EntryCost(entry))
+		}
+		if (i+1)%arrowBatchSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(logs)%arrowBatchSize != 0 || len(logs) == 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return fw.Close()
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+const artifactVersion = 1
+
+// Artifact is a compact, mergeable summary of a query log. Agents can produce artifacts
+// next to each Prometheus server and the merge subcommand combines them centrally,
+// so fleet-wide statistics don't require shipping raw logs.
+type Artifact struct {
+	Version int       `json:"version"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Entries int       `json:"entries"`
+	Sources []string  `json:"sources,omitempty"`
+	// Runs describe how the artifact and the artifacts merged into it were produced
+	Runs    []*RunMetadata   `json:"runs,omitempty"`
+	Queries []*ArtifactQuery `json:"queries"`
+	// ExecTimeDigest and SamplesDigest summarize all entries for global percentiles
+	ExecTimeDigest *Digest `json:"execTimeDigest"`
+	SamplesDigest  *Digest `json:"samplesDigest"`
+
+	// execTimePercentiles and samplesPercentiles are the exact global percentiles computed by StreamArtifact
+	// with -spill-after. They aren't written, as they can't be merged
+	execTimePercentiles map[int]float64
+	samplesPercentiles  map[int]float64
+}
+
+type ArtifactQuery struct {
+	Query          string    `json:"query"`
+	RuleGroup      string    `json:"ruleGroup,omitempty"`
+	Count          int       `json:"count"`
+	SumExecTime    float64   `json:"sumExecTime"`
+	MaxExecTime    float64   `json:"maxExecTime"`
+	MaxExecTimeTS  time.Time `json:"maxExecTimeTs"`
+	SumSamples     int64     `json:"sumSamples"`
+	MaxPeakSamples int       `json:"maxPeakSamples"`
+	ExecTimeDigest *Digest   `json:"execTimeDigest"`
+	// sumExecTime is SumExecTime summed with -summation while streaming
+	sumExecTime querystats.KahanSum
+}
+
+// NewArtifact summarizes the loaded queries. logs must be sorted by time.
+func NewArtifact(queries []*querystats.Query, logs querystats.LogEntries, source string) *Artifact {
+	a := &Artifact{
+		Version:        artifactVersion,
+		From:           *logs[0].TS,
+		To:             *logs[len(logs)-1].TS,
+		Entries:        len(logs),
+		Sources:        []string{source},
+		ExecTimeDigest: NewDigest(digestAccuracy),
+		SamplesDigest:  NewDigest(digestAccuracy),
+	}
+	for _, entry := range logs {
+		a.ExecTimeDigest.Add(entry.Stats.Timings.ExecTotalTime)
+		a.SamplesDigest.Add(float64(entry.Stats.Samples.TotalQueryableSamples))
+	}
+	for _, q := range queries {
+		aq := &ArtifactQuery{
+			Query:          q.Query,
+			Count:          len(q.Logs),
+			SumExecTime:    q.SumExecTotalTime,
+			MaxExecTime:    q.MaxExecTotalTimeEntry.Stats.Timings.ExecTotalTime,
+			MaxExecTimeTS:  *q.MaxExecTotalTimeEntry.TS,
+			SumSamples:     int64(q.SumTotalQueryableSamples),
+			MaxPeakSamples: q.MaxPeakSamplesEntry.Stats.Samples.PeakSamples,
+			ExecTimeDigest: NewDigest(digestAccuracy),
+		}
+		if q.Logs[0].RuleGroup != nil {
+			aq.RuleGroup = q.Logs[0].RuleGroup.Name
+		}
+		for _, entry := range q.Logs {
+			aq.ExecTimeDigest.Add(entry.Stats.Timings.ExecTotalTime)
+		}
+		a.Queries = append(a.Queries, aq)
+	}
+	return a
+}
+
+// Merge adds other to a. Queries are matched by their text and rule group.
+func (a *Artifact) Merge(other *Artifact) {
+	if other.From.Before(a.From) {
+		a.From = other.From
+	}
+	if other.To.After(a.To) {
+		a.To = other.To
+	}
+	a.Entries += other.Entries
+	a.Sources = append(a.Sources, other.Sources...)
+	a.Runs = append(a.Runs, other.Runs...)
+	a.ExecTimeDigest.Merge(other.ExecTimeDigest)
+	a.SamplesDigest.Merge(other.SamplesDigest)
+	a.execTimePercentiles, a.samplesPercentiles = nil, nil
+
+	type key struct{ query, ruleGroup string }
+	index := make(map[key]*ArtifactQuery, len(a.Queries))
+	for _, q := range a.Queries {
+		index[key{q.Query, q.RuleGroup}] = q
+	}
+	for _, oq := range other.Queries {
+		q, ok := index[key{oq.Query, oq.RuleGroup}]
+		if !ok {
+			a.Queries = append(a.Queries, oq)
+			index[key{oq.Query, oq.RuleGroup}] = oq
+			continue
+		}
+		q.Count += oq.Count
+		q.SumExecTime += oq.SumExecTime
+		if oq.MaxExecTime > q.MaxExecTime {
+			q.MaxExecTime, q.MaxExecTimeTS = oq.MaxExecTime, oq.MaxExecTimeTS
+		}
+		q.SumSamples += oq.SumSamples
+		q.MaxPeakSamples = max(q.MaxPeakSamples, oq.MaxPeakSamples)
+		q.ExecTimeDigest.Merge(oq.ExecTimeDigest)
+	}
+}
+
+func WriteArtifact(w io.Writer, a *Artifact) error {
+	return json.NewEncoder(w).Encode(a)
+}
+
+func ReadArtifactFile(name string) (*Artifact, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var a Artifact
+	if err := json.NewDecoder(file).Decode(&a); err != nil {
+		return nil, err
+	}
+	if a.Version != artifactVersion {
+		return nil, fmt.Errorf("unsupported artifact version %d", a.Version)
+	}
+	if a.ExecTimeDigest == nil || a.SamplesDigest == nil {
+		return nil, fmt.Errorf("the artifact has no digests")
+	}
+	for _, q := range a.Queries {
+		if q.ExecTimeDigest == nil {
+			return nil, fmt.Errorf("query %q has no digest", q.Query)
+		}
+	}
+	return &a, nil
+}
+
+func printArtifactTable(queries []*ArtifactQuery, top int, title string, value func(q *ArtifactQuery) string, less func(a, b *ArtifactQuery) bool) {
+	sort.SliceStable(queries, func(i, j int) bool { return less(queries[j], queries[i]) })
+	top = min(top, len(queries))
+	fmt.Printf("Top %d queries by %s:\n", top, title)
+	for i, q := range queries[:top] {
+		fmt.Printf("%2d) n=%-6d %s %s", i+1, q.Count, value(q), queryWithID(q.Query))
+		printRuleGroupName(q.RuleGroup)
+		fmt.Println()
+	}
+}
+
+// PrintArtifact prints a report of a (merged) artifact.
+func PrintArtifact(a *Artifact, top, perc int) {
+	if v, ok := a.execTimePercentiles[perc]; ok {
+		fmt.Printf("The %dth percentile of total execution time is %.3f seconds\n", perc, v)
+	} else {
+		fmt.Printf("The %dth percentile of total execution time is ~%.3f seconds\n", perc, a.ExecTimeDigest.Quantile(perc))
+	}
+	if v, ok := a.samplesPercentiles[perc]; ok {
+		fmt.Printf("The %dth percentile of total queryable samples is %.0f\n", perc, v)
+	} else {
+		fmt.Printf("The %dth percentile of total queryable samples is ~%.0f\n", perc, a.SamplesDigest.Quantile(perc))
+	}
+
+	fmt.Println()
+	printArtifactTable(a.Queries, top, "average execution time",
+		func(q *ArtifactQuery) string { return fmt.Sprintf("%.3fs", q.SumExecTime/float64(q.Count)) },
+		func(x, y *ArtifactQuery) bool { return x.SumExecTime/float64(x.Count) < y.SumExecTime/float64(y.Count) })
+	fmt.Println()
+	printArtifactTable(a.Queries, top, fmt.Sprintf("%dth percentile execution time", perc),
+		func(q *ArtifactQuery) string { return fmt.Sprintf("~%.3fs", q.ExecTimeDigest.Quantile(perc)) },
+		func(x, y *ArtifactQuery) bool {
+			return x.ExecTimeDigest.Quantile(perc) < y.ExecTimeDigest.Quantile(perc)
+		})
+	fmt.Println()
+	printArtifactTable(a.Queries, top, "max execution time",
+		func(q *ArtifactQuery) string {
+			return fmt.Sprintf("t=%s %.3fs", formatTime(q.MaxExecTimeTS), q.MaxExecTime)
+		},
+		func(x, y *ArtifactQuery) bool { return x.MaxExecTime < y.MaxExecTime })
+	fmt.Println()
+	printArtifactTable(a.Queries, top, "total execution time",
+		func(q *ArtifactQuery) string { return fmt.Sprintf("%.3fs", q.SumExecTime) },
+		func(x, y *ArtifactQuery) bool { return x.SumExecTime < y.SumExecTime })
+	fmt.Println()
+	printArtifactTable(a.Queries, top, "total queryable samples",
+		func(q *ArtifactQuery) string { return fmt.Sprintf("%d", q.SumSamples) },
+		func(x, y *ArtifactQuery) bool { return x.SumSamples < y.SumSamples })
+	fmt.Println()
+	printArtifactTable(a.Queries, top, "max peak samples",
+		func(q *ArtifactQuery) string { return fmt.Sprintf("%d", q.MaxPeakSamples) },
+		func(x, y *ArtifactQuery) bool { return x.MaxPeakSamples < y.MaxPeakSamples })
+}
+
+// runMerge implements the merge subcommand combining artifacts produced with -o artifact.
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := fs.String("o", "text", "output format: text or artifact")
+	top := fs.Int("top", 10, "number of top queries to display")
+	perc := fs.Int("p", 95, "percentile rank")
+	fs.Var(locationFlag{}, "tz", tzUsage)
+	out := addOutputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s merge [flags] artifact...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *perc <= 0 || *perc > 100 {
+		fatal("The percentile rank does not make sense. Must be between 0 and 100")
+	}
+
+	var merged *Artifact
+	for _, name := range fs.Args() {
+		a, err := ReadArtifactFile(name)
+		if err != nil {
+			fatalf("Failed to read artifact %s: %s", name, err)
+		}
+		if merged == nil {
+			merged = a
+		} else if a.ExecTimeDigest.Accuracy != merged.ExecTimeDigest.Accuracy {
+			fatalf("Artifact %s has digests of accuracy %g, not %g like the artifacts before it", name, a.ExecTimeDigest.Accuracy, merged.ExecTimeDigest.Accuracy)
+		} else {
+			merged.Merge(a)
+		}
+	}
+
+	defer startOutput(*out)()
+	switch *output {
+	case "artifact":
+		if err := WriteArtifact(os.Stdout, merged); err != nil {
+			fatalf("Failed to write the artifact: %s", err)
+		}
+	case "text":
+		fmt.Printf("Merged %d artifacts with %d entries from [%s] to [%s]\n", len(merged.Sources), merged.Entries, formatTime(merged.From), formatTime(merged.To))
+		fmt.Println()
+		PrintArtifact(merged, *top, *perc)
+	default:
+		fatalf("Unknown output format %q", *output)
+	}
+}
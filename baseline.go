@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+const baselineVersion = 1
+
+// Baseline is a compact snapshot of the statistics of each query, saved before a change such as a Prometheus
+// upgrade and compared with a run after it.
+type Baseline struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Entries int       `json:"entries"`
+	Sources []string  `json:"sources,omitempty"`
+	// Rank is the percentile rank of the statistics, which a comparison computes the same way
+	Rank     int     `json:"rank"`
+	ExecTime float64 `json:"execTime"`
+	Samples  float64 `json:"samples"`
+	// Normalize are the normalizations queries were grouped by, which a comparison applies too
+	Normalize string           `json:"normalize,omitempty"`
+	Queries   []*BaselineQuery `json:"queries"`
+}
+
+// BaselineQuery holds the percentiles of the execution time and the total queryable samples of a query.
+type BaselineQuery struct {
+	Query          string  `json:"query"`
+	RuleGroup      string  `json:"ruleGroup,omitempty"`
+	Count          int     `json:"count"`
+	ExecTime       float64 `json:"execTime"`
+	Samples        float64 `json:"samples"`
+	MaxPeakSamples int     `json:"maxPeakSamples"`
+}
+
+// NewBaseline summarizes the queries by the percentiles of rank, which must have been computed by GroupQueries.
+// logs must be sorted by time.
+func NewBaseline(queries []*querystats.Query, logs querystats.LogEntries, rank int, normalize string, sources []string) *Baseline {
+	execTimes := make([]float64, 0, len(logs))
+	samples := make([]int, 0, len(logs))
+	for _, e := range logs {
+		execTimes = append(execTimes, e.Stats.Timings.ExecTotalTime)
+		samples = append(samples, e.Stats.Samples.TotalQueryableSamples)
+	}
+	b := &Baseline{
+		Version:   baselineVersion,
+		Created:   time.Now().UTC(),
+		From:      *logs[0].TS,
+		To:        *logs[len(logs)-1].TS,
+		Entries:   len(logs),
+		Sources:   sources,
+		Rank:      rank,
+		Normalize: normalize,
+	}
+	b.ExecTime, _ = querystats.Percentile(rank, execTimes)
+	s, _ := querystats.Percentile(rank, samples)
+	b.Samples = float64(s)
+	for _, q := range queries {
+		bq := &BaselineQuery{
+			Query:          q.Query,
+			Count:          len(q.Logs),
+			ExecTime:       q.ExecTotalTimePercentiles[rank],
+			Samples:        q.TotalQueryableSamplesPercentiles[rank],
+			MaxPeakSamples: q.MaxPeakSamplesEntry.Stats.Samples.PeakSamples,
+		}
+		if rg := q.Logs[0].RuleGroup; rg != nil {
+			bq.RuleGroup = rg.Name
+		}
+		b.Queries = append(b.Queries, bq)
+	}
+	sort.Slice(b.Queries, func(i, j int) bool { return b.Queries[i].Query < b.Queries[j].Query })
+	return b
+}
+
+func WriteBaseline(w io.Writer, b *Baseline) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(b)
+}
+
+func ReadBaselineFile(name string) (*Baseline, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var b Baseline
+	if err := json.NewDecoder(file).Decode(&b); err != nil {
+		return nil, err
+	}
+	if b.Version != baselineVersion {
+		return nil, fmt.Errorf("unsupported baseline version %d", b.Version)
+	}
+	if b.Rank <= 0 || b.Rank > 100 {
+		return nil, fmt.Errorf("invalid percentile rank %d", b.Rank)
+	}
+	return &b, nil
+}
+
+// baselineRegression is a query whose percentile of execution time or samples grew from the baseline.
+type baselineRegression struct {
+	old, cur *BaselineQuery
+}
+
+// growth is the largest ratio of the current to the baseline percentiles.
+func (r baselineRegression) growth() float64 {
+	return max(ratio(r.old.ExecTime, r.cur.ExecTime), ratio(r.old.Samples, r.cur.Samples))
+}
+
+// CompareBaseline prints how the current run differs from the baseline and the queries whose percentile of
+// execution time or samples grew by more than the regression ratio, e.g. 0.2 for 20%. Queries executed fewer than
+// minCount times in either run are too noisy to compare and left out. It returns the number of regressed queries.
+func CompareBaseline(old, cur *Baseline, top int, regression float64, minCount int) int {
+	fmt.Printf("Baseline: %d entries from [%s] to [%s], saved at %s\n", old.Entries, formatTime(old.From), formatTime(old.To), formatTime(old.Created))
+	fmt.Printf("Current:  %d entries from [%s] to [%s]\n", cur.Entries, formatTime(cur.From), formatTime(cur.To))
+	fmt.Println()
+	fmt.Printf("p%d %s: %.3f -> %.3f %s\n", old.Rank, MetricExecTotalTime.Title, old.ExecTime, cur.ExecTime, formatTrend(old.ExecTime, cur.ExecTime))
+	fmt.Printf("p%d %s: %.0f -> %.0f %s\n", old.Rank, MetricTotalQueryableSamples.Title, old.Samples, cur.Samples, formatTrend(old.Samples, cur.Samples))
+
+	baseline := make(map[string]*BaselineQuery, len(old.Queries))
+	for _, q := range old.Queries {
+		baseline[reportKey(q.Query, q.RuleGroup)] = q
+	}
+	var regressed []baselineRegression
+	added, compared, improved := 0, 0, 0
+	for _, q := range cur.Queries {
+		key := reportKey(q.Query, q.RuleGroup)
+		prev, ok := baseline[key]
+		if !ok {
+			added++
+			continue
+		}
+		delete(baseline, key)
+		if prev.Count < minCount || q.Count < minCount {
+			continue
+		}
+		compared++
+		r := baselineRegression{prev, q}
+		if r.growth() > 1+regression {
+			regressed = append(regressed, r)
+		} else if ratio(prev.ExecTime, q.ExecTime) < 1-regression {
+			improved++
+		}
+	}
+	sort.Slice(regressed, func(i, j int) bool {
+		if regressed[i].growth() != regressed[j].growth() {
+			return regressed[i].growth() > regressed[j].growth()
+		}
+		return regressed[i].cur.Query < regressed[j].cur.Query
+	})
+
+	fmt.Println()
+	fmt.Printf("%d queries compared, %d only in the baseline, %d new, %d improved by more than %.0f%% in p%d execution time\n",
+		compared, len(baseline), added, improved, regression*100, old.Rank)
+	fmt.Println()
+	fmt.Printf("%d queries regressed by more than %.0f%% in p%d execution time or samples, top %d:\n",
+		len(regressed), regression*100, old.Rank, min(top, len(regressed)))
+	for i, r := range regressed[:min(top, len(regressed))] {
+		fmt.Printf("%2d) n=%d->%d p%d=%.3fs->%.3fs %s p%d_samples=%.0f->%.0f %s %s", i+1, r.old.Count, r.cur.Count,
+			old.Rank, r.old.ExecTime, r.cur.ExecTime, formatTrend(r.old.ExecTime, r.cur.ExecTime),
+			old.Rank, r.old.Samples, r.cur.Samples, formatTrend(r.old.Samples, r.cur.Samples),
+			queryWithID(r.cur.Query))
+		printRuleGroupName(r.cur.RuleGroup)
+		fmt.Println()
+	}
+	return len(regressed)
+}
+
+// baselineInputFlags are the flags selecting the entries of a baseline, shared by baseline save and compare.
+type baselineInputFlags struct {
+	from, to timeFlag
+	format   *string
+	jobs     *int
+}
+
+func addBaselineInputFlags(fs *flag.FlagSet) *baselineInputFlags {
+	f := &baselineInputFlags{}
+	fs.Var(&f.from, "from", "load only entries after this time. Accepts the same formats as -from of analyze")
+	fs.Var(&f.to, "to", "load only entries until this time")
+	f.format = fs.String("format", "prometheus", "format of the query log, see analyze -h")
+	f.jobs = fs.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel")
+	return f
+}
+
+// load reads the files and builds their baseline.
+func (f *baselineInputFlags) load(names []string, rank int, normalize string) (*Baseline, error) {
+	decoder, ok := querystats.LookupDecoder(*f.format)
+	if !ok {
+		return nil, fmt.Errorf("unknown query log format %q", *f.format)
+	}
+	normalizer, err := querystats.ParseNormalizer(normalize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -normalize value: %w", err)
+	}
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	files, err := ExpandInputs(names)
+	if err != nil {
+		return nil, err
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+	opts := querystats.LoadOptions{
+		From:            f.from.Time,
+		To:              f.to.Time,
+		Decoder:         &decoder,
+		Jobs:            *f.jobs,
+		Normalizer:      normalizer,
+		PercentileRanks: []int{rank},
+		Log:             logLoad,
+	}
+	entries, _, err := querystats.ReadLogEntries(input, opts)
+	if err != nil {
+		return nil, err
+	}
+	queries, logs, err := querystats.GroupQueries(entries, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("no entries")
+	}
+	sort.Sort(querystats.ByTime{LogEntries: logs})
+	return NewBaseline(queries, logs, rank, normalize, files), nil
+}
+
+// runBaseline implements the baseline subcommand saving a baseline and comparing later runs with it.
+func runBaseline(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s baseline save [flags] -o baseline.json [file...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s baseline compare [flags] baseline.json [file...]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Saves the percentiles of each query before a change, e.g. a Prometheus upgrade, and compares a run after it with them")
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "save":
+		runBaselineSave(args[1:])
+	case "compare":
+		runBaselineCompare(args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func runBaselineSave(args []string) {
+	fs := flag.NewFlagSet("baseline save", flag.ExitOnError)
+	input := addBaselineInputFlags(fs)
+	output := fs.String("o", "", "file to write the baseline to")
+	perc := fs.Int("p", 95, "percentile rank of the execution time and samples of each query")
+	normalize := fs.String("normalize", "", "comma-separated list of normalizations applied to queries before grouping: whitespace, case, matchers, fingerprint. Comparisons apply the same")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s baseline save [flags] -o baseline.json [file...]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files. Reads stdin if none is given")
+		fs.PrintDefaults()
+	}
+	files := parseArgs(fs, args)
+	if *output == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *perc <= 0 || *perc > 100 {
+		fatal("The percentile rank does not make sense. Must be between 0 and 100")
+	}
+	b, err := input.load(files, *perc, *normalize)
+	if err != nil {
+		fatalf("Failed to read the query log: %s", err)
+	}
+	if err := writeFileAtomic(*output, func(w io.Writer) error { return WriteBaseline(w, b) }); err != nil {
+		fatalf("Failed to write the baseline: %s", err)
+	}
+	slog.Info("Saved the baseline", "queries", len(b.Queries), "entries", b.Entries, "file", *output)
+}
+
+func runBaselineCompare(args []string) {
+	fs := flag.NewFlagSet("baseline compare", flag.ExitOnError)
+	input := addBaselineInputFlags(fs)
+	top := fs.Int("top", 10, "number of regressed queries to display")
+	regression := fs.Float64("regression", 0.2, "report queries whose percentile of execution time or samples grew by more than this ratio, e.g. 0.2 for 20%")
+	minCount := fs.Int("min-count", 1, "compare only queries executed at least this many times in both runs, as percentiles of a few executions are noisy")
+	fs.Var(locationFlag{}, "tz", tzUsage)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s baseline compare [flags] baseline.json [file...]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Exits with status %d if any query regressed. Inputs can be globs, directories or .gz files. Reads stdin if none is given\n", thresholdExitCode)
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *regression < 0 {
+		fatal("-regression must not be negative")
+	}
+	old, err := ReadBaselineFile(args[0])
+	if err != nil {
+		fatalf("Failed to read baseline %s: %s", args[0], err)
+	}
+	cur, err := input.load(args[1:], old.Rank, old.Normalize)
+	if err != nil {
+		fatalf("Failed to read the query log: %s", err)
+	}
+	if CompareBaseline(old, cur, *top, *regression, *minCount) > 0 {
+		os.Exit(thresholdExitCode)
+	}
+}
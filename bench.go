@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+type benchCase struct {
+	Name string
+	Run  func(r io.Reader) error
+}
+
+var benchCases = []benchCase{
+	{"scan", func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+		}
+		return scanner.Err()
+	}},
+	{"parse", func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			var entry querystats.LogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}},
+	{"load", func(r io.Reader) error {
+		_, _, err := querystats.LoadQueriesFromLog(r, querystats.LoadOptions{})
+		return err
+	}},
+	{"load-parallel", func(r io.Reader) error {
+		_, _, err := querystats.LoadQueriesFromLog(r, querystats.LoadOptions{Jobs: runtime.NumCPU()})
+		return err
+	}},
+	{"load-normalized", func(r io.Reader) error {
+		_, _, err := querystats.LoadQueriesFromLog(r, querystats.LoadOptions{Normalizer: querystats.Normalizer{Whitespace: true, Case: true, Matchers: true}})
+		return err
+	}},
+	{"stream", func(r io.Reader) error {
+		_, _, err := StreamArtifact(r, querystats.LoadOptions{}, "bench", nil, 0)
+		return err
+	}},
+}
+
+// runBench implements the bench subcommand, which measures throughput of the parsing
+// and aggregation stages on a given file. The file is read into memory once, so disk I/O is not measured.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	file := fs.String("f", "-", "path to the query log file. Pass '-' to read from stdin")
+	iterations := fs.Int("n", 3, "number of iterations per stage")
+	parseFlags(fs, args)
+
+	input := os.Stdin
+	if *file != "-" {
+		var err error
+		input, err = os.Open(*file)
+		if err != nil {
+			fatalf("Failed to read the query log file: %s", err)
+		}
+		defer input.Close()
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	lines := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+	slog.Info("Benchmarking", "lines", lines, "bytes", len(data), "iterations", *iterations)
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer slog.SetDefault(logger)
+	fmt.Printf("%-16s %14s %10s %14s %14s\n", "stage", "lines/s", "MB/s", "allocs/line", "bytes/line")
+	for _, bc := range benchCases {
+		var elapsed time.Duration
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		for range *iterations {
+			start := time.Now()
+			if err := bc.Run(bytes.NewReader(data)); err != nil {
+				slog.SetDefault(logger)
+				fatalf("Stage %s failed: %s", bc.Name, err)
+			}
+			elapsed += time.Since(start)
+		}
+		runtime.ReadMemStats(&after)
+		totalLines := float64(lines * *iterations)
+		fmt.Printf(
+			"%-16s %14.0f %10.1f %14.1f %14.1f\n",
+			bc.Name,
+			totalLines/elapsed.Seconds(),
+			float64(len(data)**iterations)/1e6/elapsed.Seconds(),
+			float64(after.Mallocs-before.Mallocs)/totalLines,
+			float64(after.TotalAlloc-before.TotalAlloc)/totalLines,
+		)
+	}
+}
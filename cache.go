@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// cacheVersion is bumped whenever the cached representation of decoded logs changes.
+const cacheVersion = 2
+
+var cacheDir string
+
+func init() {
+	flag.StringVar(&cacheDir, "cache-dir", "", "cache the parsed lines of each query log file in this directory, keyed by the SHA-256 of the file, so later analyses of the same file with other flags skip parsing it. Only local files are cached")
+}
+
+type cachedLog struct {
+	Version int
+	Log     *querystats.DecodedLog
+}
+
+// cacheKey returns the name of the cache file of a file by its digest and the options affecting how it is parsed.
+func cacheKey(digest []byte, opts querystats.LoadOptions) string {
+	format := "prometheus"
+	if opts.Decoder != nil {
+		format = opts.Decoder.Name
+	}
+	h := sha256.New()
+	h.Write(digest)
+	fmt.Fprintf(h, "version=%d format=%s max-entry-size=%d skip-noise=%t", cacheVersion, format, opts.MaxEntrySize, opts.SkipNoise)
+	return hex.EncodeToString(h.Sum(nil)) + ".gob"
+}
+
+// ValidateCacheInputs checks that the inputs can be cached by -cache-dir.
+func ValidateCacheInputs(files []string) error {
+	for _, name := range files {
+		if name == "-" || isRemote(name) {
+			return fmt.Errorf("-cache-dir caches only local files, not %s", name)
+		}
+	}
+	return nil
+}
+
+// ReadCachedLogEntries reads the entries of the files like ReadLogEntries, but parses each file only if it isn't
+// in -cache-dir yet and then stores it there. Files after an interruption by opts.Context are left out.
+func ReadCachedLogEntries(files []string, opts querystats.LoadOptions) (querystats.LogEntries, querystats.LoadStats, error) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, querystats.LoadStats{}, err
+	}
+	logs := make([]*querystats.DecodedLog, 0, len(files))
+	for _, name := range files {
+		if opts.Context != nil && opts.Context.Err() != nil {
+			break
+		}
+		decoded, err := loadCachedLog(name, opts)
+		if err != nil {
+			return nil, querystats.LoadStats{}, fmt.Errorf("%s: %w", name, err)
+		}
+		logs = append(logs, decoded)
+	}
+	entries, stats, err := querystats.ReplayLogEntries(logs, opts)
+	stats.Interrupted = stats.Interrupted || len(logs) < len(files)
+	return entries, stats, err
+}
+
+// loadCachedLog returns the decoded lines of the file from the cache, or parses the file and caches them.
+func loadCachedLog(name string, opts querystats.LoadOptions) (*querystats.DecodedLog, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	d := &inputDigest{name: name, hash: sha256.New()}
+	openedInputs = append(openedInputs, d)
+	_, err = io.Copy(d, file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(cacheDir, cacheKey(d.hash.Sum(nil), opts))
+
+	decoded, err := readCachedLog(path)
+	if err == nil {
+		slog.Debug("Read the parsed query log from the cache", "file", name)
+		return decoded, nil
+	}
+	if !os.IsNotExist(err) {
+		slog.Warn("Ignoring the cache", "file", name, "err", err)
+	}
+
+	slog.Info("Reading the query log", "file", name)
+	// cached inputs are local files, see ValidateCacheInputs
+	r, closers, err := openInput(context.Background(), name, nil)
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	decoded, err = querystats.DecodeLogLines(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if decoded.Interrupted {
+		return decoded, nil
+	}
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(cachedLog{cacheVersion, decoded})
+	})
+	if err != nil {
+		slog.Warn("Failed to cache the parsed query log", "file", name, "err", err)
+	}
+	return decoded, nil
+}
+
+func readCachedLog(path string) (*querystats.DecodedLog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var c cachedLog
+	if err := gob.NewDecoder(file).Decode(&c); err != nil {
+		return nil, err
+	}
+	if c.Version != cacheVersion {
+		return nil, fmt.Errorf("unsupported cache version %d", c.Version)
+	}
+	return c.Log, nil
+}
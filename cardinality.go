@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// SelectorLabels returns the metric name and the label names used in matchers of each vector selector of the query.
+func SelectorLabels(query string) (map[string][]string, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		for _, m := range vs.LabelMatchers {
+			if m.Name == labels.MetricName {
+				continue
+			}
+			if !slices.Contains(result[vs.Name], m.Name) {
+				result[vs.Name] = append(result[vs.Name], m.Name)
+			}
+		}
+		if _, ok := result[vs.Name]; !ok {
+			result[vs.Name] = nil
+		}
+		return nil
+	})
+	return result, nil
+}
+
+type cardinalityHint struct {
+	Metric   string
+	Label    string
+	Values   int
+	Queries  int
+	ExecTime float64
+}
+
+// PrintCardinalityHints lists labels matched by the top queries by total execution time. When a Prometheus client is
+// given, the number of values of each label among series of the metric is fetched and hints are ordered by it.
+func PrintCardinalityHints(ctx context.Context, queries []*querystats.Query, top int, client *PromClient) error {
+	sorted := slices.Clone(queries)
+	SortQueries(sorted, MetricExecTotalTime, TableSum)
+	sorted = sorted[:min(top, len(sorted))]
+
+	type key struct{ metric, label string }
+	hints := make(map[key]*cardinalityHint)
+	for _, q := range sorted {
+		selectors, err := SelectorLabels(q.Query)
+		if err != nil {
+			continue
+		}
+		for metric, labelNames := range selectors {
+			for _, label := range labelNames {
+				k := key{metric, label}
+				if hints[k] == nil {
+					hints[k] = &cardinalityHint{Metric: metric, Label: label, Values: -1}
+				}
+				hints[k].Queries++
+				hints[k].ExecTime += q.SumExecTotalTime
+			}
+		}
+	}
+
+	result := make([]*cardinalityHint, 0, len(hints))
+	for _, h := range hints {
+		if client != nil {
+			selector := ""
+			if h.Metric != "" {
+				selector = "{__name__=" + strconv.Quote(h.Metric) + "}"
+			}
+			values, err := client.LabelValues(ctx, h.Label, selector)
+			if err != nil {
+				return fmt.Errorf("failed to get values of label %s: %w", h.Label, err)
+			}
+			h.Values = len(values)
+		}
+		result = append(result, h)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Values != result[j].Values {
+			return result[i].Values > result[j].Values
+		}
+		return result[i].ExecTime > result[j].ExecTime
+	})
+
+	fmt.Printf("Labels matched by the top %d queries by total execution time:\n", len(sorted))
+	for i, h := range result {
+		metric := h.Metric
+		if metric == "" {
+			metric = "<any>"
+		}
+		values := "?"
+		if h.Values >= 0 {
+			values = strconv.Itoa(h.Values)
+		}
+		fmt.Printf("%2d) values=%-7s queries=%-4d exec=%.3fs %s{%s}\n", i+1, values, h.Queries, h.ExecTime, escapeTerminal(metric), escapeTerminal(h.Label))
+	}
+	return nil
+}
+
+const (
+	// seriesLookback is the lookback delta of Prometheus, so selectors match the series an instant query would now
+	seriesLookback = 5 * time.Minute
+	// maxCountedSeries caps the series fetched per selector by servers supporting the limit of the series API
+	maxCountedSeries = 100000
+)
+
+// QuerySelectors returns the vector selectors of the query, e.g. node_load1{instance="a"}, without their offsets and
+// duplicates.
+func QuerySelectors(query string) ([]string, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		matchers := make([]string, 0, len(vs.LabelMatchers))
+		for _, m := range vs.LabelMatchers {
+			matchers = append(matchers, m.String())
+		}
+		selector := "{" + strings.Join(matchers, ",") + "}"
+		if !slices.Contains(result, selector) {
+			result = append(result, selector)
+		}
+		return nil
+	})
+	return result, nil
+}
+
+// PrintSeriesCardinality prints how many series the vector selectors of the top queries by total execution time
+// match on the Prometheus server now, next to the samples the queries loaded, to tell queries that are expensive
+// because of cardinality from those loading long ranges of few series. Selectors shared by queries are counted once.
+func PrintSeriesCardinality(ctx context.Context, queries []*querystats.Query, top int, client *PromClient) error {
+	sorted := slices.Clone(queries)
+	SortQueries(sorted, MetricExecTotalTime, TableSum)
+	sorted = sorted[:min(top, len(sorted))]
+
+	end := time.Now()
+	start := end.Add(-seriesLookback)
+	counts := make(map[string]int)
+	fmt.Printf("Series matched by the selectors of the top %d queries by total execution time over the last %s:\n", len(sorted), seriesLookback)
+	for i, q := range sorted {
+		selectors, err := QuerySelectors(q.Query)
+		if err != nil {
+			continue
+		}
+		total := 0
+		for _, selector := range selectors {
+			if _, ok := counts[selector]; !ok {
+				n, err := client.CountSeries(ctx, selector, start, end, maxCountedSeries)
+				if err != nil {
+					return fmt.Errorf("failed to count the series of %s: %w", selector, err)
+				}
+				counts[selector] = n
+			}
+			total += counts[selector]
+		}
+		perSeries := "-"
+		if total > 0 {
+			perSeries = strconv.FormatFloat(q.AvgTotalQueryableSamples/float64(total), 'f', 1, 64)
+		}
+		fmt.Printf("%2d) series=%-9s avg_samples=%-12.0f samples_per_series=%-8s total=%.3fs %s", i+1, formatSeriesCount(total),
+			q.AvgTotalQueryableSamples, perSeries, q.SumExecTotalTime, queryWithID(q.Query))
+		printRuleName(q)
+		fmt.Println()
+		if len(selectors) > 1 {
+			for _, selector := range selectors {
+				fmt.Printf("    %-9s %s\n", formatSeriesCount(counts[selector]), escapeTerminal(selector))
+			}
+		}
+	}
+	return nil
+}
+
+// formatSeriesCount marks counts that reached maxCountedSeries as lower bounds.
+func formatSeriesCount(n int) string {
+	if n >= maxCountedSeries {
+		return ">=" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+const unassignedTeam = "unassigned"
+
+var namespaceMatcherRe = regexp.MustCompile(`\bnamespace\s*=\s*"([^"]*)"`)
+
+// TeamRule assigns log entries to a team. Kind is one of "rule_file", "rule_group", "namespace" or "tenant" and
+// Pattern is a shell pattern as understood by path.Match. The tenant of an entry is the label of the input it was
+// read from, e.g. team-a of -f team-a=/prometheus/query.log, as the query log doesn't record tenants itself.
+type TeamRule struct {
+	Kind    string
+	Pattern string
+	Team    string
+}
+
+type TeamMapping []TeamRule
+
+// LoadTeamMapping reads a mapping file where each line has the form "<kind> <pattern> <team>".
+// Empty lines and lines starting with '#' are ignored.
+func LoadTeamMapping(r io.Reader) (TeamMapping, error) {
+	var mapping TeamMapping
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 fields, got %d", lineNum, len(fields))
+		}
+		switch fields[0] {
+		case "rule_file", "rule_group", "namespace", "tenant":
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %q", lineNum, fields[0])
+		}
+		if _, err := path.Match(fields[1], ""); err != nil {
+			return nil, fmt.Errorf("line %d: bad pattern %q: %w", lineNum, fields[1], err)
+		}
+		mapping = append(mapping, TeamRule{fields[0], fields[1], fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+func (r TeamRule) Matches(entry *querystats.LogEntry) bool {
+	var candidates []string
+	switch r.Kind {
+	case "rule_file":
+		if entry.RuleGroup != nil {
+			candidates = append(candidates, entry.RuleGroup.File)
+		}
+	case "rule_group":
+		if entry.RuleGroup != nil {
+			candidates = append(candidates, entry.RuleGroup.Name)
+		}
+	case "namespace":
+		for _, m := range namespaceMatcherRe.FindAllStringSubmatch(entry.Params.Query, -1) {
+			candidates = append(candidates, m[1])
+		}
+	case "tenant":
+		if entry.Instance != "" {
+			candidates = append(candidates, entry.Instance)
+		}
+	}
+	for _, c := range candidates {
+		if ok, _ := path.Match(r.Pattern, c); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Team returns the team owning the entry. Rules are evaluated in order and the first match wins.
+func (m TeamMapping) Team(entry *querystats.LogEntry) string {
+	for _, rule := range m {
+		if rule.Matches(entry) {
+			return rule.Team
+		}
+	}
+	return unassignedTeam
+}
+
+type ChargebackRow struct {
+	Team                  string
+	Day                   string
+	Entries               int
+	ExecTotalTime         float64
+	TotalQueryableSamples int
+	execTotalTime         querystats.KahanSum
+}
+
+// Chargeback sums up engine time and samples per team per day (UTC).
+func Chargeback(mapping TeamMapping, logs querystats.LogEntries) []*ChargebackRow {
+	type key struct{ team, day string }
+	rows := make(map[key]*ChargebackRow)
+	for _, entry := range logs {
+		k := key{mapping.Team(entry), entry.TS.UTC().Format("2006-01-02")}
+		row, ok := rows[k]
+		if !ok {
+			row = &ChargebackRow{Team: k.team, Day: k.day, execTotalTime: newSum()}
+			rows[k] = row
+		}
+		row.Entries++
+		row.execTotalTime.Add(entry.Stats.Timings.ExecTotalTime)
+		row.TotalQueryableSamples += entry.Stats.Samples.TotalQueryableSamples
+	}
+
+	result := make([]*ChargebackRow, 0, len(rows))
+	for _, row := range rows {
+		row.ExecTotalTime = row.execTotalTime.Value()
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Day != result[j].Day {
+			return result[i].Day < result[j].Day
+		}
+		return result[i].Team < result[j].Team
+	})
+	return result
+}
+
+func PrintChargeback(rows []*ChargebackRow, cost CostModel) {
+	fmt.Println("Chargeback by team and day:")
+	for _, row := range rows {
+		fmt.Printf(
+			"%s %-20s n=%-6d exec=%.3fs samples=%d",
+			row.Day,
+			escapeTerminal(row.Team),
+			row.Entries,
+			row.ExecTotalTime,
+			row.TotalQueryableSamples,
+		)
+		if cost.Enabled() {
+			fmt.Printf(" cost=%.2f", cost.Cost(row.ExecTotalTime, row.TotalQueryableSamples))
+		}
+		fmt.Println()
+	}
+}
+
+func WriteChargebackCSV(w io.Writer, rows []*ChargebackRow, cost CostModel) error {
+	cw := csv.NewWriter(w)
+	header := []string{"day", "team", "entries", "exec_total_time_seconds", "total_queryable_samples"}
+	if cost.Enabled() {
+		header = append(header, "cost")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			row.Day,
+			escapeCSV(row.Team),
+			strconv.Itoa(row.Entries),
+			strconv.FormatFloat(row.ExecTotalTime, 'f', 3, 64),
+			strconv.Itoa(row.TotalQueryableSamples),
+		}
+		if cost.Enabled() {
+			record = append(record, strconv.FormatFloat(cost.Cost(row.ExecTotalTime, row.TotalQueryableSamples), 'f', 2, 64))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func loadTeamMappingFile(name string) (TeamMapping, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadTeamMapping(file)
+}
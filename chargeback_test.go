@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+func TestTeamMapping(t *testing.T) {
+	mapping, err := LoadTeamMapping(strings.NewReader(`
+# the first match wins
+rule_group node-* infra
+namespace payments-* payments
+tenant team-a* team-a
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := querystats.ReadLogEntries(strings.NewReader(logOf(`up{namespace="payments-eu"}`, "up", "up")), querystats.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[0].Instance = "team-a-prod"
+	entries[1].Instance = "team-a-prod"
+	want := []string{"payments", "team-a", unassignedTeam}
+	for i, entry := range entries {
+		if got := mapping.Team(entry); got != want[i] {
+			t.Errorf("Team of entry %d = %q, want %q", i, got, want[i])
+		}
+	}
+
+	if _, err := LoadTeamMapping(strings.NewReader("cluster prod-* infra\n")); err == nil {
+		t.Error("LoadTeamMapping accepted an unknown kind")
+	}
+}
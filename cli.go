@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command is a subcommand of the CLI with its own flag set.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"analyze", "analyze query logs and print a report. The default command", runAnalyze},
+		{"top", "print only the selected top tables, e.g. the queries with the highest average execution time", runTop},
+		{"tail", "follow a growing query log and print the top tables over a sliding window", runTail},
+		{"serve", "tail the query log and expose its statistics as Prometheus metrics", runServe},
+		{"listen", "analyze query logs sent over a TCP or Unix socket and answer with the JSON report", runListen},
+		{"diff", "compare two query logs or two time windows of one", runDiff},
+		{"lint", "flag PromQL anti-patterns in the logged queries with how expensive they were", runLint},
+		{"compare", "show 2 to 5 queries side by side", runCompare},
+		{"show", "print every execution of a single query", runShow},
+		{"report-diff", "compare two reports written with -o json", runReportDiff},
+		{"baseline", "save the percentiles of each query and compare later runs with them", runBaseline},
+		{"replay", "re-execute the top queries against a Prometheus server and compare the latencies with the logged ones", runReplay},
+		{"export", "write the parsed entries and the statistics of each query into a SQLite database", runExport},
+		{"merge", "merge artifacts written with -o artifact", runMerge},
+		{"alert-rules", "generate a Prometheus rule file alerting on the metrics of serve", runAlertRules},
+		{"timeseries", "write percentiles of execution time and samples per time bucket as CSV or JSON for plotting", runTimeseries},
+		{"suggest-rules", "draft recording rules for the expensive queries dashboards execute often", runSuggestRules},
+		{"bench", "measure the throughput of the parser on a query log", runBench},
+	}
+	flag.Usage = printUsage
+}
+
+// lookupCommand returns the subcommand with the name or nil.
+func lookupCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// printUsage prints the subcommands and the flags of analyze, which is the default command.
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags] [file...]\n", os.Args[0])
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-13s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Run '%s <command> -h' for the flags of a command. Flags of analyze:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// parseArgs parses flags of fs interspersed with positional arguments, so that flags may follow files,
+// and returns the positional arguments. Everything after "--" is positional. Flags not set on the command line
+// are then taken from the config file, except -f if files were passed as arguments. Logging is set up with the flags of addLogFlags.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	config := fs.String("config", "", "path to a YAML file of flag defaults, see the README. Defaults to ~/"+defaultConfigName+" if it exists")
+	logging := addLogFlags(fs)
+	var positional []string
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	if err := applyConfigFile(fs, *config, len(positional) > 0); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		os.Exit(2)
+	}
+	logging.setup(fs)
+	return positional
+}
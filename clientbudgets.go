@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var (
+	clientBudgets          bool
+	clientBudgetPercentile int
+)
+
+func init() {
+	flag.BoolVar(&clientBudgets, "client-budgets", false, "report per client network, rolled up like -top-talkers, the budgets covering -client-budget-percentile of its queries: execution time, peak samples, query range and queries per minute, e.g. to configure per-tenant limits of a query frontend")
+	flag.IntVar(&clientBudgetPercentile, "client-budget-percentile", 95, "share of the queries of a client in percent the budgets of -client-budgets cover")
+}
+
+// ClientBudget is the workload of a client network and the limits covering a percentile of it.
+type ClientBudget struct {
+	Network string
+	Entries int
+	// Share is the share of the execution time of all clients
+	Share float64
+	// ExecTime, PeakSamples and Range are the percentiles of the executions of the client, Range over its range
+	// queries only. Rate is the percentile of its queries per minute over the minutes it sent any
+	ExecTime    float64
+	PeakSamples int
+	Range       time.Duration
+	Rate        int
+}
+
+// ClientBudgets computes the budgets covering the p-th percentile of the queries of each client network, ordered by
+// their share of the execution time. It also returns the number of entries without a valid client IP.
+func ClientBudgets(networks ClientNetworks, logs querystats.LogEntries, prefix4, prefix6, p int) ([]ClientBudget, int) {
+	byNetwork := make(map[string]querystats.LogEntries)
+	unknown := 0
+	var total float64
+	for _, entry := range logs {
+		if entry.HTTPRequest == nil {
+			unknown++
+			continue
+		}
+		addr, err := netip.ParseAddr(entry.HTTPRequest.ClientIP)
+		if err != nil {
+			unknown++
+			continue
+		}
+		name := networks.Network(addr, prefix4, prefix6)
+		byNetwork[name] = append(byNetwork[name], entry)
+		total += entry.Stats.Timings.ExecTotalTime
+	}
+
+	budgets := make([]ClientBudget, 0, len(byNetwork))
+	for name, entries := range byNetwork {
+		b := ClientBudget{Network: name, Entries: len(entries)}
+		execTimes := make([]float64, 0, len(entries))
+		peaks := make([]int, 0, len(entries))
+		var ranges []float64
+		perMinute := make(map[int64]int)
+		var exec float64
+		for _, e := range entries {
+			exec += e.Stats.Timings.ExecTotalTime
+			execTimes = append(execTimes, e.Stats.Timings.ExecTotalTime)
+			peaks = append(peaks, e.Stats.Samples.PeakSamples)
+			if e.Type() == querystats.QueryTypeRange {
+				ranges = append(ranges, e.Range().Seconds())
+			}
+			if e.TS != nil {
+				perMinute[e.TS.Unix()/60]++
+			}
+		}
+		if total > 0 {
+			b.Share = exec / total
+		}
+		b.ExecTime, _ = querystats.Percentile(p, execTimes)
+		b.PeakSamples, _ = querystats.Percentile(p, peaks)
+		if len(ranges) > 0 {
+			r, _ := querystats.Percentile(p, ranges)
+			b.Range = time.Duration(r * float64(time.Second))
+		}
+		rates := make([]int, 0, len(perMinute))
+		for _, n := range perMinute {
+			rates = append(rates, n)
+		}
+		if len(rates) > 0 {
+			b.Rate, _ = querystats.Percentile(p, rates)
+		}
+		budgets = append(budgets, b)
+	}
+	sort.Slice(budgets, func(i, j int) bool {
+		if budgets[i].Share != budgets[j].Share {
+			return budgets[i].Share > budgets[j].Share
+		}
+		return budgets[i].Network < budgets[j].Network
+	})
+	return budgets, unknown
+}
+
+// PrintClientBudgets prints the budgets of the client networks with the most expensive workload. Each maps to a
+// per-tenant limit of a query frontend: the query timeout, the maximum samples, the maximum query length and the
+// rate limit.
+func PrintClientBudgets(budgets []ClientBudget, unknown, top, p int) {
+	fmt.Printf("Top %d client networks by execution time with budgets covering %d%% of their queries", min(top, len(budgets)), p)
+	if unknown > 0 {
+		fmt.Printf(" (%d entries without a client IP, e.g. rule evaluations, are not counted)", unknown)
+	}
+	fmt.Println(":")
+	for i, b := range budgets[:min(top, len(budgets))] {
+		queryRange := "-"
+		if b.Range > 0 {
+			queryRange = formatPromDuration(b.Range.Round(time.Second))
+		}
+		fmt.Printf("%2d) n=%-6d share=%5.1f%% timeout=%-8s max_samples=%-10d max_range=%-8s rate=%d/min %s\n",
+			i+1, b.Entries, 100*b.Share, fmt.Sprintf("%.3fs", b.ExecTime), b.PeakSamples, queryRange, b.Rate, escapeTerminal(b.Network))
+	}
+}
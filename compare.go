@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// latencyTimeline returns the average execution time of the query in each of n buckets of [from, to].
+// Buckets without executions are -1.
+func latencyTimeline(q *querystats.Query, from, to time.Time, n int) []float64 {
+	return metricTimeline(q, MetricExecTotalTime, from, to, n)
+}
+
+// metricTimeline returns the average of the metric over the executions of the query in each of n buckets of
+// [from, to]. Buckets without executions are -1.
+func metricTimeline(q *querystats.Query, m Metric, from, to time.Time, n int) []float64 {
+	sums := make([]float64, n)
+	counts := make([]int, n)
+	width := to.Sub(from) / time.Duration(n)
+	for _, log := range q.Logs {
+		i := n - 1
+		if width > 0 {
+			i = min(int(log.TS.Sub(from)/width), n-1)
+		}
+		sums[i] += m.Value(log)
+		counts[i]++
+	}
+	for i := range sums {
+		if counts[i] == 0 {
+			sums[i] = -1
+		} else {
+			sums[i] /= float64(counts[i])
+		}
+	}
+	return sums
+}
+
+// sparkline renders the values as bars scaled to peak. Negative values, i.e. buckets without executions, are blank.
+func sparkline(values []float64, peak float64) string {
+	var b strings.Builder
+	for _, v := range values {
+		switch {
+		case v < 0:
+			b.WriteRune(' ')
+		case peak == 0:
+			b.WriteRune(sparkBars[0])
+		default:
+			b.WriteRune(sparkBars[min(int(v/peak*float64(len(sparkBars))), len(sparkBars)-1)])
+		}
+	}
+	return b.String()
+}
+
+// PrintCompare prints the statistics of the queries side by side and their latency timelines on a common scale.
+func PrintCompare(queries []*querystats.Query, buckets int) {
+	labels := "ABCDE"
+	fmt.Println("Queries:")
+	for i, q := range queries {
+		fmt.Printf(" %c) %s %s\n", labels[i], QueryID(q.Query), escapeTerminal(q.Query))
+	}
+
+	rows := []struct {
+		name  string
+		value func(q *querystats.Query) string
+	}{
+		{"executions", func(q *querystats.Query) string { return fmt.Sprint(len(q.Logs)) }},
+		{"avg exec time", func(q *querystats.Query) string { return fmt.Sprintf("%.3fs", q.AvgExecTotalTime) }},
+		{"p50 exec time", func(q *querystats.Query) string {
+			v, _ := querystats.Percentile(50, MetricExecTotalTime.Values(q))
+			return fmt.Sprintf("%.3fs", v)
+		}},
+		{"p95 exec time", func(q *querystats.Query) string {
+			v, _ := querystats.Percentile(95, MetricExecTotalTime.Values(q))
+			return fmt.Sprintf("%.3fs", v)
+		}},
+		{"max exec time", func(q *querystats.Query) string {
+			return fmt.Sprintf("%.3fs", q.MaxExecTotalTimeEntry.Stats.Timings.ExecTotalTime)
+		}},
+		{"total exec time", func(q *querystats.Query) string { return fmt.Sprintf("%.3fs", q.SumExecTotalTime) }},
+		{"avg samples", func(q *querystats.Query) string { return fmt.Sprintf("%.0f", q.AvgTotalQueryableSamples) }},
+		{"max peak samples", func(q *querystats.Query) string { return fmt.Sprint(q.MaxPeakSamplesEntry.Stats.Samples.PeakSamples) }},
+		{"errors", func(q *querystats.Query) string { errors, _ := q.Failures(timeoutProxy); return fmt.Sprint(errors) }},
+		{"timeouts", func(q *querystats.Query) string { _, timeouts := q.Failures(timeoutProxy); return fmt.Sprint(timeouts) }},
+	}
+	fmt.Println()
+	fmt.Printf("%-17s", "")
+	for i := range queries {
+		fmt.Printf(" %12c", labels[i])
+	}
+	fmt.Println()
+	for _, row := range rows {
+		fmt.Printf("%-17s", row.name)
+		for _, q := range queries {
+			fmt.Printf(" %12s", row.value(q))
+		}
+		fmt.Println()
+	}
+
+	from, to := *queries[0].Logs[0].TS, *queries[0].Logs[0].TS
+	for _, q := range queries {
+		for _, log := range q.Logs {
+			if log.TS.Before(from) {
+				from = *log.TS
+			}
+			if log.TS.After(to) {
+				to = *log.TS
+			}
+		}
+	}
+	timelines := make([][]float64, len(queries))
+	var peak float64
+	for i, q := range queries {
+		timelines[i] = latencyTimeline(q, from, to, buckets)
+		for _, v := range timelines[i] {
+			peak = max(peak, v)
+		}
+	}
+	fmt.Println()
+	fmt.Printf("Average execution time from %s to %s, %s per character, full bar is %.3fs:\n",
+		formatTime(from), formatTime(to), (to.Sub(from) / time.Duration(buckets)).Round(time.Second), peak)
+	for i, timeline := range timelines {
+		fmt.Printf(" %c) |%s|\n", labels[i], sparkline(timeline, peak))
+	}
+}
+
+// runCompare implements the compare subcommand showing 2-5 queries side by side.
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	var files fileList
+	fs.Var(&files, "f", "path to a query log file, a directory of them or a glob pattern. Can be repeated. Defaults to stdin")
+	buckets := fs.Int("buckets", 60, "number of characters of the latency timelines")
+	fs.Var(locationFlag{}, "tz", tzUsage)
+	out := addOutputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compare [flags] query...\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Compares 2 to 5 queries given by their id (see the id column) or text. Queries differing only in literals are treated as one")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() < 2 || fs.NArg() > 5 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *buckets <= 0 {
+		fatal("-buckets must be positive")
+	}
+	if len(files) == 0 {
+		files = fileList{"-"}
+	}
+
+	entries, err := readInputEntries(files)
+	if err != nil {
+		fatalf("Failed to read the query log: %s", err)
+	}
+	queries, _, err := querystats.GroupQueries(entries, querystats.LoadOptions{Normalizer: querystats.Normalizer{Fingerprint: true}, Log: logLoad})
+	if err != nil {
+		fatalf("Failed to group the queries: %s", err)
+	}
+	byID := make(map[string]*querystats.Query, len(queries))
+	for _, q := range queries {
+		byID[QueryID(q.Query)] = q
+	}
+
+	var selected []*querystats.Query
+	for _, arg := range fs.Args() {
+		q, ok := byID[arg]
+		if !ok {
+			q, ok = byID[QueryID(arg)]
+		}
+		if !ok {
+			fatalf("Query %q not found in the query log", arg)
+		}
+		selected = append(selected, q)
+	}
+	defer startOutput(*out)()
+	PrintCompare(selected, *buckets)
+}
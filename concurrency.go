@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// concurrencyTimeFormat is RFC 3339 with milliseconds, as saturation periods are often shorter than a second
+const concurrencyTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// maxConcurrency is the concurrency at which the engine is considered saturated.
+var maxConcurrency int
+
+func init() {
+	flag.IntVar(&maxConcurrency, "max-concurrency", 20, "the server's --query.max-concurrency. Periods when this many queries were executing at once are reported as saturated")
+}
+
+// ConcurrencyStats describe how many queries were executing at once.
+type ConcurrencyStats struct {
+	Max int
+	// MaxAt is when Max was first reached
+	MaxAt time.Time
+	// Percentile is the concurrency not exceeded during the given share of the time when queries were executing
+	Percentile int
+	// Saturated are the periods with at least the saturation concurrency, longest first
+	Saturated []ConcurrencyPeriod
+}
+
+// ConcurrencyPeriod is a period of time with at least the saturation concurrency.
+type ConcurrencyPeriod struct {
+	From, To time.Time
+	Max      int
+}
+
+// EstimateConcurrency reconstructs the number of queries executing at once. An entry is logged when its query
+// finishes, so it was executing from TS minus its execution time, excluding the time spent in the queue, until
+// TS. perc is the rank of the percentile of the concurrency weighted by the time when any query was executing.
+func EstimateConcurrency(logs querystats.LogEntries, perc, saturation int) ConcurrencyStats {
+	type event struct {
+		t     time.Time
+		delta int
+	}
+	events := make([]event, 0, 2*len(logs))
+	for _, log := range logs {
+		running := log.Stats.Timings.ExecTotalTime - log.Stats.Timings.ExecQueueTime
+		if running <= 0 {
+			continue
+		}
+		start := log.TS.Add(-time.Duration(running * float64(time.Second)))
+		events = append(events, event{start, 1}, event{*log.TS, -1})
+	}
+	// ends before starts at the same time, so back-to-back queries don't overlap
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].t.Equal(events[j].t) {
+			return events[i].t.Before(events[j].t)
+		}
+		return events[i].delta < events[j].delta
+	})
+
+	var stats ConcurrencyStats
+	durations := make(map[int]time.Duration)
+	var total time.Duration
+	var period *ConcurrencyPeriod
+	current := 0
+	for i, e := range events {
+		current += e.delta
+		if current > stats.Max {
+			stats.Max, stats.MaxAt = current, e.t
+		}
+		if current >= saturation {
+			if period == nil {
+				stats.Saturated = append(stats.Saturated, ConcurrencyPeriod{From: e.t})
+				period = &stats.Saturated[len(stats.Saturated)-1]
+			}
+			period.Max = max(period.Max, current)
+		} else if period != nil {
+			period.To = e.t
+			period = nil
+		}
+		if i+1 < len(events) && current > 0 {
+			d := events[i+1].t.Sub(e.t)
+			durations[current] += d
+			total += d
+		}
+	}
+
+	levels := make([]int, 0, len(durations))
+	for level := range durations {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	var covered time.Duration
+	for _, level := range levels {
+		covered += durations[level]
+		if float64(covered) >= float64(total)*float64(perc)/100 {
+			stats.Percentile = level
+			break
+		}
+	}
+
+	sort.SliceStable(stats.Saturated, func(i, j int) bool {
+		return stats.Saturated[i].To.Sub(stats.Saturated[i].From) > stats.Saturated[j].To.Sub(stats.Saturated[j].From)
+	})
+	return stats
+}
+
+// PrintConcurrency prints the estimated concurrency and the longest periods of saturation.
+func PrintConcurrency(stats ConcurrencyStats, perc, saturation, top int) {
+	fmt.Printf("Estimated concurrency: max %d at %s, p%d %d while any query was executing\n", stats.Max, displayTime(stats.MaxAt).Format(concurrencyTimeFormat), perc, stats.Percentile)
+	if len(stats.Saturated) == 0 {
+		fmt.Printf("No periods with %d or more queries executing at once, see -max-concurrency\n", saturation)
+		return
+	}
+	var saturated time.Duration
+	for _, p := range stats.Saturated {
+		saturated += p.To.Sub(p.From)
+	}
+	fmt.Printf("Top %d of %d periods with %d or more queries executing at once, %s in total:\n",
+		min(top, len(stats.Saturated)), len(stats.Saturated), saturation, saturated.Round(time.Millisecond))
+	for i, p := range stats.Saturated[:min(top, len(stats.Saturated))] {
+		fmt.Printf("%2d) %s - %s (%s) max=%d\n", i+1, displayTime(p.From).Format(concurrencyTimeFormat), displayTime(p.To).Format(concurrencyTimeFormat),
+			p.To.Sub(p.From).Round(time.Millisecond), p.Max)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigName is the name of the config file in the home directory read when -config is not set.
+const defaultConfigName = ".prom-query-stats.yaml"
+
+// Config holds defaults of flags, so options used for every run don't have to be typed each time. Top-level keys
+// are flags of analyze without the dash, e.g. top: 20, and lists set comma-separated flags such as p: [50, 99].
+// commands maps subcommands to the defaults of their flags. env sets environment variables that aren't set yet,
+// e.g. the credentials of S3 or SMTP_PASSWORD. Flags on the command line override the config.
+type Config struct {
+	Flags    map[string]any
+	Commands map[string]map[string]any
+	Env      map[string]string
+}
+
+// LoadConfig reads a config file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	c := &Config{Flags: make(map[string]any), Commands: make(map[string]map[string]any), Env: make(map[string]string)}
+	for key, value := range raw {
+		switch {
+		case key == "env":
+			env, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("env must map variable names to values")
+			}
+			for name, v := range env {
+				s, err := configScalar(v)
+				if err != nil {
+					return nil, fmt.Errorf("env %s: %w", name, err)
+				}
+				c.Env[name] = s
+			}
+		case key == "commands":
+			commands, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("commands must map subcommands to their flags")
+			}
+			for name, v := range commands {
+				if lookupCommand(name) == nil {
+					return nil, fmt.Errorf("unknown command %q", name)
+				}
+				flags, ok := v.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("commands %s must map flags of the command to values", name)
+				}
+				c.Commands[name] = flags
+			}
+		default:
+			c.Flags[key] = value
+		}
+	}
+	return c, nil
+}
+
+// configScalar converts a YAML scalar to the text accepted by flag.Value.Set.
+func configScalar(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		// unquoted timestamps, e.g. from: 2025-01-28T00:00:00Z
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		return "", fmt.Errorf("must be a string, number or boolean")
+	}
+}
+
+// Apply sets the flags of the command that weren't set on the command line. command is empty for analyze. hasFiles
+// reports whether files were passed as arguments, which counts as setting -f.
+func (c *Config) Apply(fs *flag.FlagSet, command string, hasFiles bool) error {
+	values := c.Flags
+	if command != "" {
+		values = c.Commands[command]
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if hasFiles {
+		set["f"] = true
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			if command == "" {
+				return fmt.Errorf("unknown flag %q", name)
+			}
+			return fmt.Errorf("unknown flag %q of the %s command", name, command)
+		}
+		if set[name] {
+			continue
+		}
+		items, ok := values[name].([]any)
+		if !ok {
+			items = []any{values[name]}
+		}
+		var texts []string
+		for _, item := range items {
+			s, err := configScalar(item)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			texts = append(texts, s)
+		}
+		// lists are comma-separated on the command line, except for the repeatable -f and -notify-maintenance
+		switch fs.Lookup(name).Value.(type) {
+		case *fileList, *maintenanceWindows:
+		default:
+			texts = []string{strings.Join(texts, ",")}
+		}
+		for _, s := range texts {
+			if err := fs.Set(name, s); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	for name, value := range c.Env {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+	return nil
+}
+
+// applyConfigFile applies the config file given with -config, or else the default config file if it exists, to
+// the flags of fs. hasFiles is passed on to Config.Apply.
+func applyConfigFile(fs *flag.FlagSet, path string, hasFiles bool) error {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, defaultConfigName)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the config file: %w", err)
+	}
+	command := fs.Name()
+	if fs == flag.CommandLine {
+		command = ""
+	}
+	if err := c.Apply(fs, command, hasFiles); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
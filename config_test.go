@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file into a temporary directory and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseArgsConfigPrecedence(t *testing.T) {
+	config := writeConfig(t, "commands:\n  top:\n    top: 20\n    f: [a.log, b.log]\n    p: [50, 99]\n    from: 2025-01-28T00:00:00Z\n")
+	tests := []struct {
+		name      string
+		args      []string
+		wantTop   int
+		wantFiles []string
+		wantArgs  []string
+	}{
+		{"config", []string{"-config", config}, 20, []string{"a.log", "b.log"}, nil},
+		{"flags override the config", []string{"-config", config, "-top", "5", "-f", "c.log"}, 5, []string{"c.log"}, nil},
+		{"files as arguments override -f of the config", []string{"c.log", "-config", config}, 20, nil, []string{"c.log"}},
+		{"flags after --", []string{"-config", config, "--", "-top"}, 20, nil, []string{"-top"}},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("top", flag.ContinueOnError)
+		top := fs.Int("top", 10, "")
+		p := fs.String("p", "", "")
+		var files fileList
+		fs.Var(&files, "f", "")
+		var from timeFlag
+		fs.Var(&from, "from", "")
+		args := parseArgs(fs, tt.args)
+		if *top != tt.wantTop || !slices.Equal(files, tt.wantFiles) || !slices.Equal(args, tt.wantArgs) {
+			t.Errorf("%s: top = %d, -f = %v, arguments = %v, want %d, %v, %v", tt.name, *top, files, args, tt.wantTop, tt.wantFiles, tt.wantArgs)
+		}
+		if *p != "50,99" {
+			t.Errorf("%s: p = %q, want 50,99", tt.name, *p)
+		}
+		if want := time.Date(2025, 1, 28, 0, 0, 0, 0, time.UTC); from.Time == nil || !from.Time.Equal(want) {
+			t.Errorf("%s: from = %v, want %v", tt.name, from.Time, want)
+		}
+	}
+}
+
+func TestConfigApplyUnknownFlag(t *testing.T) {
+	c, err := LoadConfig(writeConfig(t, "commands:\n  top:\n    nope: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	if err := c.Apply(fs, "top", false); err == nil || err.Error() != `unknown flag "nope" of the top command` {
+		t.Errorf("Apply = %v, want an unknown flag error", err)
+	}
+}
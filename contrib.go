@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// PercentileContributor is a query with executions above a percentile.
+type PercentileContributor struct {
+	Query *querystats.Query
+	Above int
+}
+
+// FindPercentileContributors returns the queries whose executions exceed the threshold, usually a global percentile
+// of the metric, ordered by the number of such executions.
+func FindPercentileContributors(queries []*querystats.Query, metric Metric, threshold float64) []PercentileContributor {
+	var rows []PercentileContributor
+	for _, q := range queries {
+		above := 0
+		for _, log := range q.Logs {
+			if metric.Value(log) > threshold {
+				above++
+			}
+		}
+		if above > 0 {
+			rows = append(rows, PercentileContributor{q, above})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Above > rows[j].Above })
+	return rows
+}
+
+// PrintPercentileContributors prints the top contributors to the perc-th percentile of the metric. This connects
+// the headline percentile to the queries responsible for it.
+func PrintPercentileContributors(rows []PercentileContributor, top int, metric Metric, threshold float64, perc int) {
+	total := 0
+	for _, r := range rows {
+		total += r.Above
+	}
+	top = min(top, len(rows))
+
+	fmt.Printf("Top %d queries contributing %d executions above the %dth percentile of %s (%s):\n", top, total, perc, metric.Title, metric.Format(threshold))
+	for i, r := range rows[:top] {
+		fmt.Printf(
+			"%2d) above=%-6d share=%5.1f%% of_query=%5.1f%% %s",
+			i+1,
+			r.Above,
+			100*float64(r.Above)/float64(total),
+			100*float64(r.Above)/float64(len(r.Query.Logs)),
+			queryWithID(r.Query.Query),
+		)
+		printRuleName(r.Query)
+		fmt.Println()
+	}
+}
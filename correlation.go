@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// slowForSamplesRatio is how many times longer than the fit predicts a query must take to be flagged.
+var slowForSamplesRatio float64
+
+func init() {
+	flag.Float64Var(&slowForSamplesRatio, "slow-for-samples-ratio", 3, "flag queries reading fewer samples than the median query whose average execution time is more than this many times what the linear fit of execution time over samples predicts. They are usually slow because of the engine, e.g. regular expression matchers or series churn, rather than data volume")
+}
+
+// SampleCorrelation is the correlation of the execution time with the total queryable samples across entries and
+// the least squares fit exec time = Intercept + Slope * samples.
+type SampleCorrelation struct {
+	Entries int
+	// R is the Pearson correlation coefficient
+	R         float64
+	Intercept float64
+	Slope     float64
+}
+
+// CorrelateSamples computes the correlation of execution time with samples. ok is false if there are fewer than two
+// entries or either metric is constant, e.g. in logs without sample statistics.
+func CorrelateSamples(logs querystats.LogEntries) (c SampleCorrelation, ok bool) {
+	if len(logs) < 2 {
+		return c, false
+	}
+	var meanX, meanY float64
+	for _, e := range logs {
+		meanX += float64(e.Stats.Samples.TotalQueryableSamples)
+		meanY += e.Stats.Timings.ExecTotalTime
+	}
+	n := float64(len(logs))
+	meanX /= n
+	meanY /= n
+	var covariance, varX, varY float64
+	for _, e := range logs {
+		dx := float64(e.Stats.Samples.TotalQueryableSamples) - meanX
+		dy := e.Stats.Timings.ExecTotalTime - meanY
+		covariance += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return c, false
+	}
+	c.Entries = len(logs)
+	c.R = covariance / math.Sqrt(varX*varY)
+	c.Slope = covariance / varX
+	c.Intercept = meanY - c.Slope*meanX
+	return c, true
+}
+
+// Predict returns the execution time the fit predicts for the samples, at least 0.
+func (c SampleCorrelation) Predict(samples float64) float64 {
+	return max(c.Intercept+c.Slope*samples, 0)
+}
+
+// PrintSampleCorrelation prints the correlation of execution time with samples and the queries slow for their
+// samples: those reading fewer samples on average than the median query and taking more than ratio times what the
+// fit predicts, ordered by how much longer.
+func PrintSampleCorrelation(queries []*querystats.Query, c SampleCorrelation, top int, ratio float64) {
+	fmt.Printf("Correlation of execution time with total queryable samples over %d entries: r=%.2f, fit exec=%.4fs%+.3gs*samples\n",
+		c.Entries, c.R, c.Intercept, c.Slope)
+	if c.R*c.R < 0.5 {
+		fmt.Println("Samples explain less than half of the variance of execution time, much of the load doesn't come from data volume")
+	}
+
+	avgSamples := make([]float64, 0, len(queries))
+	for _, q := range queries {
+		avgSamples = append(avgSamples, q.AvgTotalQueryableSamples)
+	}
+	median, _ := querystats.Percentile(50, avgSamples)
+	type row struct {
+		query     *querystats.Query
+		predicted float64
+		slowdown  float64
+	}
+	var rows []row
+	for _, q := range queries {
+		if q.AvgTotalQueryableSamples >= median {
+			continue
+		}
+		predicted := c.Predict(q.AvgTotalQueryableSamples)
+		// a fit predicting no time at all can't tell how much slower a query is
+		if predicted <= 0 || q.AvgExecTotalTime <= ratio*predicted {
+			continue
+		}
+		rows = append(rows, row{q, predicted, q.AvgExecTotalTime / predicted})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].slowdown > rows[j].slowdown })
+
+	fmt.Println()
+	if len(rows) == 0 {
+		fmt.Printf("No queries slow for their samples, reading fewer than the median query and taking more than %gx the fit\n", ratio)
+		return
+	}
+	fmt.Printf("Top %d of %d queries slow for their samples, reading fewer than the median query and taking more than %gx the fit:\n",
+		min(top, len(rows)), len(rows), ratio)
+	for i, r := range rows[:min(top, len(rows))] {
+		fmt.Printf("%2d) x%-6.1f n=%-6d avg=%.3fs predicted=%.3fs avg_samples=%-10.0f %s", i+1, r.slowdown, len(r.query.Logs),
+			r.query.AvgExecTotalTime, r.predicted, r.query.AvgTotalQueryableSamples, queryWithID(r.query.Query))
+		printRuleName(r.query)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// CostModel assigns an estimated cost to the work done by the query engine.
+// Costs are expressed in an arbitrary unit, e.g. dollars or "credits".
+type CostModel struct {
+	PerEngineSecond   float64
+	PerMillionSamples float64
+}
+
+var costModel CostModel
+
+func init() {
+	flag.Float64Var(&costModel.PerEngineSecond, "cost-per-second", 0, "estimated cost of one second of query execution time. Enables cost columns when set")
+	flag.Float64Var(&costModel.PerMillionSamples, "cost-per-msamples", 0, "estimated cost of one million queryable samples. Enables cost columns when set")
+}
+
+func (c CostModel) Enabled() bool {
+	return c.PerEngineSecond > 0 || c.PerMillionSamples > 0
+}
+
+func (c CostModel) Validate() error {
+	if c.PerEngineSecond < 0 || c.PerMillionSamples < 0 {
+		return fmt.Errorf("cost cannot be negative")
+	}
+	return nil
+}
+
+// Cost returns the estimated cost of spending execTime seconds to process samples queryable samples.
+func (c CostModel) Cost(execTime float64, samples int) float64 {
+	return execTime*c.PerEngineSecond + float64(samples)/1e6*c.PerMillionSamples
+}
+
+// EntryCost returns the estimated cost of a single log entry.
+func (c CostModel) EntryCost(entry *querystats.LogEntry) float64 {
+	return c.Cost(entry.Stats.Timings.ExecTotalTime, entry.Stats.Samples.TotalQueryableSamples)
+}
+
+// QueryCost returns the estimated cost of all executions of a query.
+func (c CostModel) QueryCost(q *querystats.Query) float64 {
+	return c.Cost(q.SumExecTotalTime, q.SumTotalQueryableSamples)
+}
+
+type ByCost struct {
+	querystats.Queries
+	Model CostModel
+}
+
+func (q ByCost) Less(i, j int) bool {
+	return q.Model.QueryCost(q.Queries[i]) < q.Model.QueryCost(q.Queries[j])
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// share returns v as a fraction of total, or 0 if total is 0.
+func share(v, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return v / total
+}
+
+// costScorer returns the cost score of queries in percent: the average of their share of the total execution time
+// and their share of the total queryable samples of all queries. Query logs without sample statistics are scored
+// by execution time alone.
+func costScorer(queries []*querystats.Query) (score func(q *querystats.Query) float64, totalExec, totalSamples float64) {
+	for _, q := range queries {
+		totalExec += q.SumExecTotalTime
+		totalSamples += float64(q.SumTotalQueryableSamples)
+	}
+	score = func(q *querystats.Query) float64 {
+		if totalSamples == 0 {
+			return 100 * share(q.SumExecTotalTime, totalExec)
+		}
+		return 100 * (share(q.SumExecTotalTime, totalExec) + share(float64(q.SumTotalQueryableSamples), totalSamples)) / 2
+	}
+	return score, totalExec, totalSamples
+}
+
+// PrintCostScores ranks queries by their share of the resources the query engine spent over the analyzed window.
+// The score is the costScorer, so a cheap query executed every few seconds ranks above a heavy one executed once,
+// and neither needs a -cost-per-* model to be configured. logs must be sorted by time.
+func PrintCostScores(queries []*querystats.Query, logs querystats.LogEntries, top int) {
+	score, totalExec, totalSamples := costScorer(queries)
+	window := logs[len(logs)-1].TS.Sub(*logs[0].TS).Seconds()
+
+	sorted := make([]*querystats.Query, len(queries))
+	copy(sorted, queries)
+	sort.SliceStable(sorted, func(i, j int) bool { return score(sorted[i]) > score(sorted[j]) })
+	sorted = sorted[:min(top, len(sorted))]
+
+	fmt.Printf("Top %d queries by cost score, the average share of total execution time and total queryable samples:\n", len(sorted))
+	for i, q := range sorted {
+		fmt.Printf("%2d) score=%5.2f%% n=%-6d exec=%5.1f%%", i+1, score(q), len(q.Logs), 100*share(q.SumExecTotalTime, totalExec))
+		if totalSamples > 0 {
+			fmt.Printf(" samples=%5.1f%%", 100*share(float64(q.SumTotalQueryableSamples), totalSamples))
+			if window > 0 {
+				fmt.Printf(" samples/s=%-10.1f", float64(q.SumTotalQueryableSamples)/window)
+			}
+		}
+		if costModel.Enabled() {
+			fmt.Printf(" cost=%.4f", costModel.QueryCost(q))
+		}
+		fmt.Printf(" %s", queryWithID(q.Query))
+		printRuleName(q)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// WriteQueriesCSV writes a row of statistics per distinct query ordered by total execution time.
+// comma separates the fields, e.g. '\t' for TSV. ranks are the ranks of the exec time percentile columns.
+// The run metadata, if any, precedes the header as lines starting with '#'. Queries of labeled inputs have an
+// instances column with their executions on each Prometheus server.
+func WriteQueriesCSV(w io.Writer, queries []*querystats.Query, ranks []int, comma rune, run *RunMetadata) error {
+	PrintRunMetadata(w, run, "# ")
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	header := []string{
+		"id", "query", "rule_group", "rule_file", "rule_kind", "count", "first_seen", "last_seen",
+		"executions_per_second", "mean_interval_seconds",
+		"avg_exec_time_seconds", "min_exec_time_seconds", "median_exec_time_seconds", "stddev_exec_time_seconds", "max_exec_time_seconds",
+	}
+	for _, p := range ranks {
+		header = append(header, fmt.Sprintf("p%d_exec_time_seconds", p))
+	}
+	header = append(header, "sum_exec_time_seconds",
+		"avg_total_queryable_samples", "min_total_queryable_samples", "median_total_queryable_samples", "stddev_total_queryable_samples",
+		"max_total_queryable_samples", "sum_total_queryable_samples",
+		"avg_peak_samples", "max_peak_samples", "sum_points", "points_per_second", "errors", "timeouts", "error_rate",
+		"dominant_phase",
+	)
+	if costModel.Enabled() {
+		header = append(header, "cost")
+	}
+	labeled := len(queries) > 0 && queries[0].Logs[0].Instance != ""
+	if labeled {
+		header = append(header, "instances")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	sorted := slices.Clone(queries)
+	SortQueries(sorted, MetricExecTotalTime, TableSum)
+	for _, q := range sorted {
+		s := NewQueryStats(q, ranks[0], QueryLinks{})
+		record := []string{
+			s.ID,
+			escapeCSV(s.Query),
+			escapeCSV(s.RuleGroup),
+			escapeCSV(s.RuleFile),
+			string(s.RuleKind),
+			strconv.Itoa(s.Count),
+			formatTime(s.FirstSeen),
+			formatTime(s.LastSeen),
+			strconv.FormatFloat(s.ExecutionsPerSecond, 'f', 6, 64),
+			strconv.FormatFloat(s.MeanInterval, 'f', 3, 64),
+			strconv.FormatFloat(s.AvgExecTotalTime, 'f', 3, 64),
+			strconv.FormatFloat(s.MinExecTotalTime, 'f', 3, 64),
+			strconv.FormatFloat(s.MedianExecTotalTime, 'f', 3, 64),
+			strconv.FormatFloat(s.StdDevExecTotalTime, 'f', 3, 64),
+			strconv.FormatFloat(s.MaxExecTotalTime, 'f', 3, 64),
+		}
+		for _, p := range ranks {
+			record = append(record, strconv.FormatFloat(queryPercentile(q, MetricExecTotalTime, p), 'f', 3, 64))
+		}
+		record = append(record,
+			strconv.FormatFloat(s.SumExecTotalTime, 'f', 3, 64),
+			strconv.FormatFloat(s.AvgTotalQueryableSamples, 'f', 0, 64),
+			strconv.Itoa(s.MinTotalQueryableSamples),
+			strconv.Itoa(s.MedianTotalQueryableSamples),
+			strconv.FormatFloat(s.StdDevTotalQueryableSamples, 'f', 0, 64),
+			strconv.Itoa(s.MaxTotalQueryableSamples),
+			strconv.Itoa(s.SumTotalQueryableSamples),
+			strconv.FormatFloat(s.AvgPeakSamples, 'f', 0, 64),
+			strconv.Itoa(s.MaxPeakSamples),
+			strconv.Itoa(s.SumPoints),
+			strconv.FormatFloat(s.PointsPerSecond, 'f', 1, 64),
+			strconv.Itoa(s.Errors),
+			strconv.Itoa(s.Timeouts),
+			strconv.FormatFloat(s.ErrorRate, 'f', 4, 64),
+			s.DominantPhase,
+		)
+		if s.Cost != nil {
+			record = append(record, strconv.FormatFloat(*s.Cost, 'f', 2, 64))
+		}
+		if labeled {
+			record = append(record, formatInstances(s.Instances))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
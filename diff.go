@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// buildWindowReport groups the entries accepted by opts and builds their report.
+func buildWindowReport(entries querystats.LogEntries, opts querystats.LoadOptions, top, perc int) (*Report, error) {
+	var accepted querystats.LogEntries
+	for _, entry := range entries {
+		if opts.Accept(entry) {
+			accepted = append(accepted, entry)
+		}
+	}
+	queries, logs, err := querystats.GroupQueries(accepted, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("no entries")
+	}
+	sort.Sort(querystats.ByTime{LogEntries: logs})
+	return BuildReport(queries, logs, querystats.LoadStats{}, top, []int{perc}, QueryLinks{})
+}
+
+func readInputEntries(names []string) (querystats.LogEntries, error) {
+	files, err := ExpandInputs(names)
+	if err != nil {
+		return nil, err
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+	entries, _, err := querystats.ReadLogEntries(input, querystats.LoadOptions{Log: logLoad})
+	return entries, err
+}
+
+// runDiff implements the diff subcommand comparing two query logs, or two time windows of one query log.
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	var oldFrom, oldTo, newFrom, newTo timeFlag
+	fs.Var(&oldFrom, "old-from", "start of the old time window. Accepts the same formats as -from of the report")
+	fs.Var(&oldTo, "old-to", "end of the old time window")
+	fs.Var(&newFrom, "new-from", "start of the new time window")
+	fs.Var(&newTo, "new-to", "end of the new time window")
+	top := fs.Int("top", 10, "number of queries to display in each section")
+	perc := fs.Int("p", 95, "percentile rank")
+	normalize := fs.String("normalize", "", "comma-separated list of normalizations applied to queries before grouping: whitespace, case, matchers, fingerprint")
+	regression := fs.Float64("regression", 0.2, "report queries whose average execution time or samples grew by more than this ratio")
+	queryID := fs.String("query-id", "", "comma-separated list of query ids, see the id column, to compare only entries of")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [flags] old.log new.log\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s diff [flags] -old-from ... -old-to ... -new-from ... -new-to ... query.log\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *perc <= 0 || *perc > 100 {
+		fatal("The percentile rank does not make sense. Must be between 0 and 100")
+	}
+	normalizer, err := querystats.ParseNormalizer(*normalize)
+	if err != nil {
+		fatalf("Invalid -normalize value: %s", err)
+	}
+
+	queryIDs, err := ParseQueryIDs(*queryID)
+	if err != nil {
+		fatalf("Invalid -query-id value: %s", err)
+	}
+	filter := QueryIDFilter(queryIDs)
+
+	oldOpts := querystats.LoadOptions{From: oldFrom.Time, To: oldTo.Time, Normalizer: normalizer, QueryFilter: filter, Log: logLoad}
+	newOpts := querystats.LoadOptions{From: newFrom.Time, To: newTo.Time, Normalizer: normalizer, QueryFilter: filter, Log: logLoad}
+	var oldEntries, newEntries querystats.LogEntries
+	if fs.NArg() == 1 {
+		if oldFrom.Time == nil && oldTo.Time == nil || newFrom.Time == nil && newTo.Time == nil {
+			fatal("Comparing windows of a single query log requires -old-from/-old-to and -new-from/-new-to")
+		}
+		if oldEntries, err = readInputEntries([]string{fs.Arg(0)}); err != nil {
+			fatalf("Failed to read %s: %s", fs.Arg(0), err)
+		}
+		newEntries = oldEntries
+	} else {
+		if oldEntries, err = readInputEntries([]string{fs.Arg(0)}); err != nil {
+			fatalf("Failed to read %s: %s", fs.Arg(0), err)
+		}
+		if newEntries, err = readInputEntries([]string{fs.Arg(1)}); err != nil {
+			fatalf("Failed to read %s: %s", fs.Arg(1), err)
+		}
+	}
+
+	oldReport, err := buildWindowReport(oldEntries, oldOpts, *top, *perc)
+	if err != nil {
+		fatalf("Failed to analyze the old entries: %s", err)
+	}
+	newReport, err := buildWindowReport(newEntries, newOpts, *top, *perc)
+	if err != nil {
+		fatalf("Failed to analyze the new entries: %s", err)
+	}
+	PrintReportDiff(oldReport, newReport, *top, *regression)
+}
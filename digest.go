@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// Digest is a mergeable quantile sketch with a bounded relative error. Values are counted in
+// logarithmically sized buckets, so any quantile estimate is within Accuracy of the true value
+// relative to it. Digests with the same accuracy can be merged by adding bucket counts.
+type Digest struct {
+	Accuracy float64        `json:"accuracy"`
+	Zeros    uint64         `json:"zeros,omitempty"`
+	Buckets  map[int]uint64 `json:"buckets"`
+	Count    uint64         `json:"count"`
+}
+
+var (
+	// digestAccuracy is the relative accuracy of new digests
+	digestAccuracy = 0.01
+	// estimatePercentiles makes global percentiles be estimated with digests in a single pass instead of sorting
+	estimatePercentiles bool
+)
+
+func init() {
+	flag.Float64Var(&digestAccuracy, "digest-accuracy", 0.01, "relative error of percentiles estimated with digests by -stream, -estimate-percentiles and -o artifact, e.g. 0.001. Lower values need more memory. Artifacts can only be merged with the same accuracy")
+	flag.BoolVar(&estimatePercentiles, "estimate-percentiles", false, "estimate percentiles over all entries with digests of -digest-accuracy in a single pass instead of sorting all values, which is faster on large logs")
+}
+
+// ValidateDigestAccuracy checks the -digest-accuracy flag.
+func ValidateDigestAccuracy() error {
+	if digestAccuracy <= 0 || digestAccuracy >= 1 {
+		return fmt.Errorf("-digest-accuracy must be between 0 and 1")
+	}
+	return nil
+}
+
+func NewDigest(accuracy float64) *Digest {
+	return &Digest{Accuracy: accuracy, Buckets: make(map[int]uint64)}
+}
+
+func (d *Digest) gamma() float64 {
+	return (1 + d.Accuracy) / (1 - d.Accuracy)
+}
+
+// Add records a value. Negative values are counted as zeros.
+func (d *Digest) Add(v float64) {
+	d.Count++
+	if v <= 0 {
+		d.Zeros++
+		return
+	}
+	d.Buckets[int(math.Ceil(math.Log(v)/math.Log(d.gamma())))]++
+}
+
+// metricDigest returns a digest of the metric over the entries.
+func metricDigest(logs querystats.LogEntries, metric Metric) *Digest {
+	d := NewDigest(digestAccuracy)
+	for _, log := range logs {
+		d.Add(metric.Value(log))
+	}
+	return d
+}
+
+// Merge adds the values of other to d. Both digests must have the same accuracy.
+func (d *Digest) Merge(other *Digest) {
+	d.Count += other.Count
+	d.Zeros += other.Zeros
+	for k, c := range other.Buckets {
+		d.Buckets[k] += c
+	}
+}
+
+// Quantile returns an estimate of the p-th percentile with the nearest-rank method.
+func (d *Digest) Quantile(p int) float64 {
+	if d.Count == 0 {
+		return math.NaN()
+	}
+	rank := uint64(max(math.Ceil(float64(p)/100.0*float64(d.Count)), 1))
+	if rank <= d.Zeros {
+		return 0
+	}
+	seen := d.Zeros
+	keys := make([]int, 0, len(d.Buckets))
+	for k := range d.Buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	gamma := d.gamma()
+	for _, k := range keys {
+		seen += d.Buckets[k]
+		if seen >= rank {
+			// the midpoint of the bucket (gamma^(k-1), gamma^k] in terms of relative error
+			return 2 * math.Pow(gamma, float64(k)) / (gamma + 1)
+		}
+	}
+	return math.Pow(gamma, float64(keys[len(keys)-1]))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// duplicateSource is a rule group or the HTTP API evaluating an expression.
+type duplicateSource struct {
+	// RuleGroup and RuleFile are empty for queries from the HTTP API
+	RuleGroup, RuleFile string
+	Executions          int
+	ExecTime            float64
+	Samples             int
+}
+
+func (s *duplicateSource) String() string {
+	if s.RuleGroup == "" && s.RuleFile == "" {
+		return "HTTP API"
+	}
+	if s.RuleFile == "" {
+		return fmt.Sprintf("rule group %s", s.RuleGroup)
+	}
+	return fmt.Sprintf("rule group %s (%s)", s.RuleGroup, s.RuleFile)
+}
+
+// DuplicateExpression is an expression evaluated by more than one rule group, or by a rule group and the HTTP API,
+// e.g. a recording rule whose expression dashboards still query directly.
+type DuplicateExpression struct {
+	Expr    string
+	Sources []*duplicateSource
+	// Variants is the number of distinct query texts of the expression, which differ in formatting only, or for
+	// near-duplicates, the number of expressions differing in literals
+	Variants   int
+	Executions int
+	ExecTime   float64
+	Samples    int
+}
+
+// canonicalExpr returns the expression as printed by the PromQL parser, so differences in formatting, e.g.
+// whitespace or the position of the by clause, don't matter. Queries that fail to parse are only stripped of
+// whitespace.
+func canonicalExpr(query string) string {
+	if expr, err := parser.ParseExpr(query); err == nil {
+		return expr.String()
+	}
+	return querystats.Normalizer{Whitespace: true}.Normalize(query)
+}
+
+// FindDuplicateExpressions returns the expressions evaluated by more than one source, ordered by their combined
+// execution time. Expressions are compared after canonicalization, or by their querystats.Fingerprint if near is
+// set. Near-duplicates are expressions differing only in literals such as thresholds, exact duplicates are left out.
+func FindDuplicateExpressions(queries []*querystats.Query, near bool) []*DuplicateExpression {
+	type sourceKey struct{ group, file string }
+	type entry struct {
+		sources  map[sourceKey]*duplicateSource
+		variants map[string]bool
+	}
+	byExpr := make(map[string]*entry)
+	for _, q := range queries {
+		key := canonicalExpr(q.Query)
+		if near {
+			if fp, err := querystats.Fingerprint(q.Query); err == nil {
+				key = fp
+			}
+		}
+		e := byExpr[key]
+		if e == nil {
+			e = &entry{make(map[sourceKey]*duplicateSource), make(map[string]bool)}
+			byExpr[key] = e
+		}
+		if near {
+			e.variants[canonicalExpr(q.Query)] = true
+		} else {
+			e.variants[q.Query] = true
+		}
+		for _, log := range q.Logs {
+			var k sourceKey
+			if log.RuleGroup != nil {
+				k = sourceKey{log.RuleGroup.Name, log.RuleGroup.File}
+			}
+			s := e.sources[k]
+			if s == nil {
+				s = &duplicateSource{RuleGroup: k.group, RuleFile: k.file}
+				e.sources[k] = s
+			}
+			s.Executions++
+			s.ExecTime += log.Stats.Timings.ExecTotalTime
+			s.Samples += log.Stats.Samples.TotalQueryableSamples
+		}
+	}
+
+	var result []*DuplicateExpression
+	for expr, e := range byExpr {
+		if len(e.sources) < 2 || near && len(e.variants) < 2 {
+			continue
+		}
+		d := &DuplicateExpression{Expr: expr, Variants: len(e.variants)}
+		for _, s := range e.sources {
+			d.Sources = append(d.Sources, s)
+			d.Executions += s.Executions
+			d.ExecTime += s.ExecTime
+			d.Samples += s.Samples
+		}
+		sort.Slice(d.Sources, func(i, j int) bool {
+			if d.Sources[i].ExecTime != d.Sources[j].ExecTime {
+				return d.Sources[i].ExecTime > d.Sources[j].ExecTime
+			}
+			return d.Sources[i].String() < d.Sources[j].String()
+		})
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ExecTime != result[j].ExecTime {
+			return result[i].ExecTime > result[j].ExecTime
+		}
+		return result[i].Expr < result[j].Expr
+	})
+	return result
+}
+
+// PrintDuplicateExpressions prints the first top duplicate expressions with the cost of each source. Evaluating an
+// expression once, e.g. in a recording rule the other sources read, saves the cost of all but one source.
+func PrintDuplicateExpressions(duplicates []*DuplicateExpression, top int, near bool) {
+	kind := "Duplicate expressions"
+	if near {
+		kind = "Near-duplicate expressions differing only in literals"
+	}
+	if len(duplicates) == 0 {
+		fmt.Printf("%s: none evaluated by more than one rule group or by rules and the HTTP API\n", kind)
+		return
+	}
+	var total float64
+	for _, d := range duplicates {
+		total += d.ExecTime
+	}
+	fmt.Printf("%s: %d evaluated by more than one rule group or by rules and the HTTP API, total=%.3fs\n", kind, len(duplicates), total)
+	for i, d := range duplicates[:min(top, len(duplicates))] {
+		fmt.Printf("%2d) sources=%-3d n=%-6d total=%.3fs samples=%-12d", i+1, len(d.Sources), d.Executions, d.ExecTime, d.Samples)
+		if costModel.Enabled() {
+			fmt.Printf(" cost=%.4f", costModel.Cost(d.ExecTime, d.Samples))
+		}
+		if d.Variants > 1 {
+			fmt.Printf(" variants=%d", d.Variants)
+		}
+		fmt.Printf(" %s\n", escapeTerminal(d.Expr))
+		for _, s := range d.Sources {
+			fmt.Printf("    n=%-6d total=%.3fs %s\n", s.Executions, s.ExecTime, escapeTerminal(s.String()))
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultEmailSubject = `Prometheus query report {{.From.Format "2006-01-02 15:04"}} - {{.To.Format "2006-01-02 15:04"}}`
+
+const defaultEmailBody = `{{.Entries}} entries of {{.DistinctQueries}} distinct queries from {{.From.Format "2006-01-02T15:04:05Z07:00"}} to {{.To.Format "2006-01-02T15:04:05Z07:00"}}.
+{{range .Percentiles}}
+The {{.Rank}}th percentile of {{.Metric}} is {{printf "%.6g" .Value}}
+{{- end}}
+
+Top queries by total execution time:
+{{- range top 10 .Queries}}
+  {{printf "%10.3fs" .SumExecTotalTime}} n={{.Count}} {{.Query}}
+{{- end}}
+
+The full report is attached.
+`
+
+// emailConfig holds the -email-* and -smtp-* flags.
+type emailConfig struct {
+	To      string
+	From    string
+	Server  string
+	User    string
+	Subject string
+	// BodyFile is the path to a template of the body. The default body is used if it's empty
+	BodyFile string
+}
+
+var emailSettings emailConfig
+
+func init() {
+	flag.StringVar(&emailSettings.To, "email-to", "", "comma-separated list of addresses to email a summary of the report to, with the HTML report attached. Requires -smtp-server")
+	flag.StringVar(&emailSettings.From, "email-from", "prom-query-stats@localhost", "sender address of -email-to")
+	flag.StringVar(&emailSettings.Server, "smtp-server", "", "host:port of the SMTP server used by -email-to. STARTTLS is used if the server supports it")
+	flag.StringVar(&emailSettings.User, "smtp-user", "", "user to authenticate to the SMTP server as. The password is read from the SMTP_PASSWORD environment variable")
+	flag.StringVar(&emailSettings.Subject, "email-subject", defaultEmailSubject, "Go template of the email subject, executed over the JSON report")
+	flag.StringVar(&emailSettings.BodyFile, "email-body", "", "path to a Go template of the email body, executed over the JSON report. Defaults to the percentiles and the top queries by total execution time")
+}
+
+// Enabled reports whether emails should be sent.
+func (c emailConfig) Enabled() bool {
+	return c.To != ""
+}
+
+// templates parses the subject and body templates.
+func (c emailConfig) templates() (*template.Template, *template.Template, error) {
+	funcs := template.FuncMap{
+		"top": func(n int, queries []*QueryStats) []*QueryStats { return queries[:min(n, len(queries))] },
+	}
+	subject, err := template.New("subject").Funcs(funcs).Parse(c.Subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("-email-subject: %w", err)
+	}
+	body := defaultEmailBody
+	if c.BodyFile != "" {
+		data, err := os.ReadFile(c.BodyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = string(data)
+	}
+	bodyTemplate, err := template.New("body").Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("-email-body: %w", err)
+	}
+	return subject, bodyTemplate, nil
+}
+
+// Validate checks the flags and the templates.
+func (c emailConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.Server == "" {
+		return fmt.Errorf("-email-to requires -smtp-server")
+	}
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return fmt.Errorf("-smtp-server: %w", err)
+	}
+	_, _, err := c.templates()
+	return err
+}
+
+// BuildEmail renders the message with the templated subject and body and the HTML report attached.
+func (c emailConfig) BuildEmail(report *Report, html []byte) ([]byte, error) {
+	subjectTemplate, bodyTemplate, err := c.templates()
+	if err != nil {
+		return nil, err
+	}
+	var subject, body bytes.Buffer
+	if err := subjectTemplate.Execute(&subject, report); err != nil {
+		return nil, err
+	}
+	if err := bodyTemplate.Execute(&body, report); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	w := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", c.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBase64(part, body.Bytes()); err != nil {
+		return nil, err
+	}
+	part, err = w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {`attachment; filename="prom-query-stats-` + report.To.UTC().Format("20060102-1504") + `.html"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBase64(part, html); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// writeBase64 writes data encoded with base64 in lines of 76 characters as required by MIME.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(76, len(encoded))
+		if _, err := fmt.Fprintf(w, "%s\r\n", encoded[:n]); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// SendReportEmail emails the report to the -email-to addresses.
+func (c emailConfig) SendReportEmail(report *Report, html []byte) error {
+	msg, err := c.BuildEmail(report, html)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if c.User != "" {
+		host, _, _ := net.SplitHostPort(c.Server)
+		auth = smtp.PlainAuth("", c.User, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	var to []string
+	for _, addr := range strings.Split(c.To, ",") {
+		to = append(to, strings.TrimSpace(addr))
+	}
+	return smtp.SendMail(c.Server, auth, c.From, to, msg)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Query text is user-controlled. It can contain newlines, ANSI escape sequences
+// or bidirectional overrides that garble or spoof the report when printed as is.
+
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069') || r == '\u200e' || r == '\u200f'
+}
+
+// escapeTerminal collapses line breaks together with the following indentation,
+// as queries are often written across multiple lines in rule files and dashboards,
+// and replaces any other control character with its Go escape sequence.
+func escapeTerminal(str string) string {
+	var b strings.Builder
+	b.Grow(len(str))
+	skipSpace := false
+	for _, r := range str {
+		if r == '\n' || r == '\r' {
+			skipSpace = true
+			continue
+		}
+		if skipSpace && unicode.IsSpace(r) {
+			continue
+		}
+		skipSpace = false
+		switch {
+		case r == '\t':
+			b.WriteByte(' ')
+		case unicode.IsControl(r) && r < 0x100:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case unicode.IsControl(r) || isBidiControl(r):
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// csvFormulaPrefixes are the characters that make spreadsheets interpret a field as a formula. Tab and carriage
+// return are stripped by some before looking at the next character.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// escapeCSV prepares a field for CSV output. encoding/csv takes care of quoting,
+// but spreadsheets interpret fields starting with one of csvFormulaPrefixes as formulas,
+// so such fields are prefixed with a single quote. Both the field and its escaped form are checked,
+// as escaping drops leading line breaks and turns tabs into spaces.
+func escapeCSV(str string) string {
+	escaped := escapeTerminal(str)
+	if str != "" && strings.ContainsRune(csvFormulaPrefixes, rune(str[0])) ||
+		escaped != "" && strings.ContainsRune(csvFormulaPrefixes, rune(escaped[0])) {
+		return "'" + escaped
+	}
+	return escaped
+}
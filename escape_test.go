@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestEscapeCSV(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"up", "up"},
+		{"", ""},
+		{"=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "' =1"},
+		{"\r=1", "'=1"},
+		{"\n  =1", "'=1"},
+		{"sum(\n  rate(x[1m])\n)", "sum(rate(x[1m]))"},
+		{"up\x1b[31m", `up\x1b[31m`},
+		{"up\u202e", `up\u202e`},
+	}
+	for _, tt := range tests {
+		if got := escapeCSV(tt.in); got != tt.want {
+			t.Errorf("escapeCSV(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+type metricExplanation struct {
+	Term        string
+	Explanation string
+}
+
+var metricGlossary = []metricExplanation{
+	{"execution time", "execTotalTime: wall-clock seconds the query spent in the engine, including the time waiting in the queue for a free query slot (execQueueTime)."},
+	{"total queryable samples", "totalQueryableSamples: the number of samples the query loaded from storage over its whole evaluation. It is the best proxy for I/O and CPU cost."},
+	{"peak samples", "peakSamples: the maximum number of samples held in memory at once during evaluation. Queries are aborted when it exceeds --query.max-samples."},
+	{"phases", "execQueueTime (queue), queryPreparationTime (prep), innerEvalTime (eval) and resultSortTime (sort) are the parts of the execution time spent waiting for a query slot, selecting series, evaluating and sorting the result. other is the execution time not attributed to any of them."},
+	{"points", "estimated evaluation points: (end - start) / step + 1 for range queries and 1 for instant queries. points/s is the throughput of a query, all its points divided by its total execution time. Unusually low throughput points to slow storage rather than heavy math."},
+	{"n", "the number of log entries, i.e. executions, of the query in the analyzed window."},
+	{"rate", "executions of a query per minute between its first and last execution, and interval the mean time between them. The overall query rate is all entries divided by the analyzed window."},
+	{"average tables", "rank queries by the mean over all their executions. A query executed once weighs as much as one executed thousands of times."},
+	{"tavg", "time-weighted average: executions are grouped into -time-weight-bucket intervals and the averages of the intervals are averaged. It is not skewed by bursts of executions, e.g. while a dashboard is open."},
+	{"trate", "time-weighted rate: the metric per second while the query is executed, i.e. its sum over the -time-weight-bucket intervals with executions divided by their length. A dashboard open for an hour of a day isn't diluted by the rest of the day."},
+	{"total tables", "rank queries by the sum over all their executions, i.e. the number of executions × the average. Cheap queries run thousands of times top these tables, which makes them the best signal for capacity planning."},
+	{"max tables", "rank queries by their single worst execution, shown with its timestamp. One outlier, e.g. during a restart or compaction, is enough to top these tables."},
+	{"percentiles", "are computed with the nearest-rank method: values are sorted and the one at rank p% is reported. The -p percentiles are over all log entries, pNN tables and columns over the executions of each query."},
+	{"ruleName", "the rule group the query was evaluated for. Queries without it came from the HTTP API, e.g. dashboards."},
+	{"cost", "estimated as execution time × -cost-per-second + samples / 1e6 × -cost-per-msamples, summed over all executions in average tables and for the worst execution in max tables."},
+}
+
+func printMetricsGlossary(w io.Writer) {
+	fmt.Fprintln(w, "Explanation of metrics:")
+	for _, m := range metricGlossary {
+		fmt.Fprintf(w, "  * %s - %s\n", m.Term, m.Explanation)
+	}
+}
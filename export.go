@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTime is the layout of times in the SQLite export, which the date and time functions of SQLite accept.
+const sqliteTime = "2006-01-02 15:04:05.000"
+
+// sqliteSchema creates the tables of the SQLite export. entries.query_key and query_percentiles.query_key refer
+// to queries.key, numbering the distinct queries. queries.id, the id column of the text report, is not unique since
+// queries differing in literals only share it.
+const sqliteSchema = `
+CREATE TABLE queries (
+	key INTEGER PRIMARY KEY,
+	id TEXT NOT NULL,
+	query TEXT NOT NULL,
+	fingerprint TEXT,
+	rule_group TEXT,
+	rule_file TEXT,
+	rule_kind TEXT,
+	count INTEGER NOT NULL,
+	first_seen TEXT NOT NULL,
+	last_seen TEXT NOT NULL,
+	executions_per_second REAL,
+	avg_exec_total_time REAL,
+	min_exec_total_time REAL,
+	median_exec_total_time REAL,
+	stddev_exec_total_time REAL,
+	max_exec_total_time REAL,
+	sum_exec_total_time REAL,
+	avg_total_queryable_samples REAL,
+	min_total_queryable_samples INTEGER,
+	median_total_queryable_samples INTEGER,
+	stddev_total_queryable_samples REAL,
+	max_total_queryable_samples INTEGER,
+	sum_total_queryable_samples INTEGER,
+	avg_peak_samples REAL,
+	max_peak_samples INTEGER,
+	sum_points INTEGER,
+	points_per_second REAL,
+	errors INTEGER,
+	timeouts INTEGER,
+	cost REAL
+);
+CREATE TABLE query_percentiles (
+	query_key INTEGER NOT NULL REFERENCES queries (key),
+	metric TEXT NOT NULL,
+	rank INTEGER NOT NULL,
+	value REAL NOT NULL,
+	PRIMARY KEY (query_key, metric, rank)
+);
+CREATE TABLE entries (
+	id INTEGER PRIMARY KEY,
+	ts TEXT NOT NULL,
+	query_key INTEGER NOT NULL REFERENCES queries (key),
+	query_id TEXT NOT NULL,
+	query TEXT NOT NULL,
+	fingerprint TEXT,
+	type TEXT NOT NULL,
+	start TEXT,
+	end TEXT,
+	step INTEGER,
+	rule_group TEXT,
+	rule_file TEXT,
+	client_ip TEXT,
+	method TEXT,
+	path TEXT,
+	eval_total_time REAL,
+	exec_queue_time REAL,
+	exec_total_time REAL,
+	inner_eval_time REAL,
+	query_preparation_time REAL,
+	result_sort_time REAL,
+	total_queryable_samples INTEGER,
+	peak_samples INTEGER,
+	error TEXT,
+	status INTEGER
+);
+CREATE INDEX entries_ts ON entries (ts);
+CREATE INDEX entries_fingerprint ON entries (fingerprint);
+CREATE INDEX entries_rule_group ON entries (rule_group, rule_file);
+CREATE INDEX entries_query_key ON entries (query_key);
+CREATE INDEX entries_query_id ON entries (query_id);
+CREATE INDEX queries_id ON queries (id);
+CREATE INDEX queries_fingerprint ON queries (fingerprint);
+CREATE INDEX queries_rule_group ON queries (rule_group, rule_file);
+`
+
+// sqlNullString stores empty strings as NULL.
+func sqlNullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// sqlNullTime stores a time in the layout of the export, or NULL.
+func sqlNullTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format(sqliteTime), Valid: true}
+}
+
+// sqlFingerprint returns the querystats.Fingerprint of the query, or NULL for queries that fail to parse.
+func sqlFingerprint(query string) sql.NullString {
+	fp, err := querystats.Fingerprint(query)
+	return sql.NullString{String: fp, Valid: err == nil}
+}
+
+// WriteSQLite writes the entries and the statistics of each query into a new SQLite database at path.
+func WriteSQLite(path string, queries []*querystats.Query, perc int, links QueryLinks) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create the tables: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	insertQuery, err := tx.Prepare(`INSERT INTO queries VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	insertPercentile, err := tx.Prepare(`INSERT INTO query_percentiles VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	insertEntry, err := tx.Prepare(`INSERT INTO entries (ts, query_key, query_id, query, fingerprint, type, start, end, step, rule_group,
+		rule_file, client_ip, method, path, eval_total_time, exec_queue_time, exec_total_time, inner_eval_time,
+		query_preparation_time, result_sort_time, total_queryable_samples, peak_samples, error, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+
+	for key, q := range queries {
+		s := NewQueryStats(q, perc, links)
+		fingerprint := sqlFingerprint(q.Query)
+		var cost sql.NullFloat64
+		if s.Cost != nil {
+			cost = sql.NullFloat64{Float64: *s.Cost, Valid: true}
+		}
+		_, err := insertQuery.Exec(key, s.ID, s.Query, fingerprint, sqlNullString(s.RuleGroup), sqlNullString(s.RuleFile),
+			sqlNullString(string(s.RuleKind)), s.Count, s.FirstSeen.UTC().Format(sqliteTime), s.LastSeen.UTC().Format(sqliteTime),
+			s.ExecutionsPerSecond, s.AvgExecTotalTime, s.MinExecTotalTime, s.MedianExecTotalTime, s.StdDevExecTotalTime,
+			s.MaxExecTotalTime, s.SumExecTotalTime, s.AvgTotalQueryableSamples, s.MinTotalQueryableSamples,
+			s.MedianTotalQueryableSamples, s.StdDevTotalQueryableSamples, s.MaxTotalQueryableSamples,
+			s.SumTotalQueryableSamples, s.AvgPeakSamples, s.MaxPeakSamples, s.SumPoints, s.PointsPerSecond, s.Errors,
+			s.Timeouts, cost)
+		if err != nil {
+			return fmt.Errorf("failed to insert query %s: %w", s.ID, err)
+		}
+		for metric, percentiles := range map[string]map[int]float64{
+			MetricExecTotalTime.Name:         q.ExecTotalTimePercentiles,
+			MetricTotalQueryableSamples.Name: q.TotalQueryableSamplesPercentiles,
+		} {
+			for rank, value := range percentiles {
+				if _, err := insertPercentile.Exec(key, metric, rank, value); err != nil {
+					return fmt.Errorf("failed to insert the percentiles of query %s: %w", s.ID, err)
+				}
+			}
+		}
+
+		for _, e := range q.Logs {
+			var ruleGroup, ruleFile, clientIP, method, path string
+			if e.RuleGroup != nil {
+				ruleGroup, ruleFile = e.RuleGroup.Name, e.RuleGroup.File
+			}
+			if e.HTTPRequest != nil {
+				clientIP, method, path = e.HTTPRequest.ClientIP, e.HTTPRequest.Method, e.HTTPRequest.Path
+			}
+			// entries of a query grouped by -normalize can differ from it, but share the fingerprint mostly
+			entryFingerprint := fingerprint
+			if e.Params.Query != q.Query {
+				entryFingerprint = sqlFingerprint(e.Params.Query)
+			}
+			t, smp := e.Stats.Timings, e.Stats.Samples
+			_, err := insertEntry.Exec(e.TS.UTC().Format(sqliteTime), key, s.ID, e.Params.Query, entryFingerprint, string(e.Type()),
+				sqlNullTime(e.Params.Start), sqlNullTime(e.Params.End), e.Params.Step, sqlNullString(ruleGroup),
+				sqlNullString(ruleFile), sqlNullString(clientIP), sqlNullString(method), sqlNullString(path),
+				t.EvalTotalTime, t.ExecQueueTime, t.ExecTotalTime, t.InnerEvalTime, t.QueryPreparationTime,
+				t.ResultSortTime, smp.TotalQueryableSamples, smp.PeakSamples, sqlNullString(e.Error), e.Status)
+			if err != nil {
+				return fmt.Errorf("failed to insert an entry of query %s: %w", s.ID, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// runExport implements the export subcommand writing the parsed entries and the statistics of each query into a
+// database for ad-hoc analysis.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var from, to timeFlag
+	fs.Var(&from, "from", "load log entries after this time. Accepts the same formats as -from of analyze")
+	fs.Var(&to, "to", "load log entries until this time")
+	out := fs.String("out", "", "path of the database to create. Must not exist")
+	normalize := fs.String("normalize", "", "comma-separated list of normalizations applied to queries before grouping: whitespace, case, matchers, fingerprint")
+	match := fs.String("query-match", "", "export only entries whose query matches this regular expression")
+	format := fs.String("format", "prometheus", "format of the query log, see analyze -h")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export sqlite -out file.db [flags] [file...]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Writes every entry and the statistics of each distinct query into the tables entries, queries and query_percentiles")
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files. Reads stdin if none is given")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "sqlite" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	files := parseArgs(fs, args[1:])
+	if *out == "" {
+		fatal("-out is required")
+	}
+	if _, err := os.Stat(*out); err == nil {
+		fatalf("%s already exists", *out)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fatal(err)
+	}
+	normalizer, err := querystats.ParseNormalizer(*normalize)
+	if err != nil {
+		fatalf("Invalid -normalize value: %s", err)
+	}
+	decoder, ok := querystats.LookupDecoder(*format)
+	if !ok {
+		fatalf("Unknown query log format %q", *format)
+	}
+	opts := querystats.LoadOptions{
+		From:            from.Time,
+		To:              to.Time,
+		Normalizer:      normalizer,
+		Decoder:         &decoder,
+		MaxEntrySize:    querystats.DefaultMaxEntrySize,
+		PercentileRanks: queryPercentileRanks,
+		Jobs:            *jobs,
+		Log:             logLoad,
+	}
+	if *match != "" {
+		if opts.QueryMatch, err = regexp.Compile(*match); err != nil {
+			fatalf("Invalid -query-match value: %s", err)
+		}
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if files, err = ExpandInputs(files); err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	defer closeInput()
+	queries, logs, err := querystats.LoadQueriesFromLog(input, opts)
+	if err != nil {
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	if len(queries) == 0 {
+		fatal("Loaded 0 queries")
+	}
+
+	if err := WriteSQLite(*out, queries, globalPercentileRanks[0], QueryLinks{}); err != nil {
+		os.Remove(*out)
+		fatalf("Failed to write %s: %s", *out, err)
+	}
+	slog.Info("Exported the entries", "entries", len(logs), "queries", len(queries), "file", *out)
+}
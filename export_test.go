@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// logOf returns a query log with an entry of each query.
+func logOf(queries ...string) string {
+	var b strings.Builder
+	for _, q := range queries {
+		query := strings.ReplaceAll(q, `"`, `\"`)
+		b.WriteString(`{"params":{"query":"` + query + `","start":"2025-01-28T00:00:00Z","end":"2025-01-28T00:00:00Z","step":0},` +
+			`"stats":{"timings":{"execTotalTime":0.5},"samples":{"totalQueryableSamples":10,"peakSamples":1}},"ts":"2025-01-28T00:00:00Z"}` + "\n")
+	}
+	return b.String()
+}
+
+func TestWriteSQLiteLiteralVariants(t *testing.T) {
+	queries, _, err := querystats.LoadQueriesFromLog(strings.NewReader(logOf(`up{job="a"}`, `up{job="b"}`, `up{job="a"}`)),
+		querystats.LoadOptions{PercentileRanks: queryPercentileRanks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || QueryID(queries[0].Query) != QueryID(queries[1].Query) {
+		t.Fatalf("want 2 queries sharing an id, got %d", len(queries))
+	}
+	path := filepath.Join(t.TempDir(), "queries.db")
+	if err := WriteSQLite(path, queries, 99, QueryLinks{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT count(DISTINCT key) FROM queries`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("exported %d queries, want 2", n)
+	}
+	if err := db.QueryRow(`SELECT count(*) FROM entries JOIN queries ON queries.key = entries.query_key
+		WHERE queries.query = 'up{job="a"}'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("joined %d entries of up{job=\"a\"}, want 2", n)
+	}
+}
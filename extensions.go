@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// ReportRenderer writes the report in an output format that isn't built in, e.g. of an in-house dashboard.
+// Renderers are compiled in by adding a file to this package that registers them with RegisterReportRenderer from
+// an init function, together with decoders and metrics registered with querystats.RegisterEntryDecoder and
+// querystats.RegisterMetric.
+type ReportRenderer interface {
+	Render(w io.Writer, report *Report) error
+}
+
+// builtinOutputFormats are the formats of -o handled by the CLI itself.
+var builtinOutputFormats = []string{"text", "json", "html", "markdown", "csv", "tsv", "arrow", "artifact"}
+
+var reportRenderers = make(map[string]ReportRenderer)
+
+// RegisterReportRenderer makes the renderer available as -o format. Like database/sql.Register, it panics if the
+// renderer is nil, the format is built in or a renderer of the format is already registered.
+func RegisterReportRenderer(format string, r ReportRenderer) {
+	if r == nil {
+		panic("RegisterReportRenderer: renderer of " + format + " is nil")
+	}
+	if slices.Contains(builtinOutputFormats, format) {
+		panic("RegisterReportRenderer: " + format + " is a built-in output format")
+	}
+	if _, ok := reportRenderers[format]; ok {
+		panic("RegisterReportRenderer: called twice for format " + format)
+	}
+	reportRenderers[format] = r
+}
+
+// reportRendererNames returns the formats of the registered renderers in alphabetical order.
+func reportRendererNames() []string {
+	names := make([]string, 0, len(reportRenderers))
+	for name := range reportRenderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registerCustomMetrics makes the metrics registered with querystats.RegisterMetric available by name like the
+// built-in ones. Custom metrics can't replace built-in ones.
+func registerCustomMetrics() error {
+	for _, custom := range querystats.CustomMetrics() {
+		if _, ok := Metrics[custom.Name]; ok {
+			return fmt.Errorf("custom metric %q clashes with a built-in metric", custom.Name)
+		}
+		if _, ok := reportMetrics[custom.Name]; ok {
+			return fmt.Errorf("custom metric %q clashes with a built-in metric", custom.Name)
+		}
+		m := Metric{custom.Name, custom.Title, custom.Unit, custom.Int, custom.Extractor.Extract}
+		Metrics[m.Name] = m
+		reportMetrics[m.Name] = func() Metric { return m }
+	}
+	return nil
+}
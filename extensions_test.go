@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+type nopRenderer struct{}
+
+func (nopRenderer) Render(io.Writer, *Report) error { return nil }
+
+func TestRegisterReportRendererPanics(t *testing.T) {
+	defer delete(reportRenderers, "test-format")
+	RegisterReportRenderer("test-format", nopRenderer{})
+	for _, format := range []string{"text", "json", "test-format"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %s didn't panic", format)
+				}
+			}()
+			RegisterReportRenderer(format, nopRenderer{})
+		}()
+	}
+}
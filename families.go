@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+const unnamedFamily = "<unnamed>"
+
+// FamilyRollup maps metric names matching Pattern (as understood by path.Match) to the family Name.
+type FamilyRollup struct {
+	Name    string
+	Pattern string
+}
+
+// ParseFamilyRollups parses a comma-separated list of rollups. Each rollup is either a pattern, e.g. "kube_*",
+// that is also the name of the family, or "<name>=<pattern>", e.g. "cadvisor=container_*".
+func ParseFamilyRollups(value string) ([]FamilyRollup, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var rollups []FamilyRollup
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		name, pattern, ok := strings.Cut(item, "=")
+		if !ok {
+			pattern = name
+		}
+		if name == "" || pattern == "" {
+			return nil, fmt.Errorf("empty rollup in %q", item)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		rollups = append(rollups, FamilyRollup{name, pattern})
+	}
+	return rollups, nil
+}
+
+// MetricFamily returns the family of the metric. The first matching rollup wins. Metrics not matched by any
+// rollup are rolled up by the prefix before the first underscore, e.g. "node_load1" belongs to "node_*".
+func MetricFamily(metric string, rollups []FamilyRollup) string {
+	if metric == "" {
+		return unnamedFamily
+	}
+	for _, r := range rollups {
+		if ok, _ := path.Match(r.Pattern, metric); ok {
+			return r.Name
+		}
+	}
+	if prefix, _, ok := strings.Cut(metric, "_"); ok && prefix != "" {
+		return prefix + "_*"
+	}
+	return metric
+}
+
+type familyStats struct {
+	Name       string
+	Queries    int
+	Executions int
+	ExecTime   float64
+	Samples    int
+	Cost       float64
+}
+
+// aggregateMetricLoad sums the load of the queries per group of the metrics they select, as returned by group.
+// A query selecting metrics of several groups counts towards each of them. It also returns the number of
+// queries that could not be parsed.
+func aggregateMetricLoad(queries []*querystats.Query, group func(metric string) string) ([]*familyStats, int) {
+	families := make(map[string]*familyStats)
+	unparsable := 0
+	for _, q := range queries {
+		selectors, err := SelectorLabels(q.Query)
+		if err != nil {
+			unparsable++
+			continue
+		}
+		seen := make(map[string]bool)
+		for metric := range selectors {
+			name := group(metric)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			f := families[name]
+			if f == nil {
+				f = &familyStats{Name: name}
+				families[name] = f
+			}
+			f.Queries++
+			f.Executions += len(q.Logs)
+			f.ExecTime += q.SumExecTotalTime
+			f.Samples += q.SumTotalQueryableSamples
+			if costModel.Enabled() {
+				f.Cost += costModel.QueryCost(q)
+			}
+		}
+	}
+
+	result := make([]*familyStats, 0, len(families))
+	for _, f := range families {
+		result = append(result, f)
+	}
+	return result, unparsable
+}
+
+func printFamilyStats(i int, f *familyStats) {
+	fmt.Printf("%2d) queries=%-4d n=%-7d exec=%.3fs samples=%-12d", i+1, f.Queries, f.Executions, f.ExecTime, f.Samples)
+	if costModel.Enabled() {
+		fmt.Printf(" cost=%-10.2f", f.Cost)
+	}
+	fmt.Printf(" %s\n", escapeTerminal(f.Name))
+}
+
+// PrintMetricFamilies prints the engine load per metric family. A query selecting metrics of several families
+// counts towards each of them, so the totals of all families may exceed the totals of the log.
+func PrintMetricFamilies(queries []*querystats.Query, top int, rollups []FamilyRollup) {
+	result, unparsable := aggregateMetricLoad(queries, func(metric string) string { return MetricFamily(metric, rollups) })
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Cost != result[j].Cost {
+			return result[i].Cost > result[j].Cost
+		}
+		if result[i].ExecTime != result[j].ExecTime {
+			return result[i].ExecTime > result[j].ExecTime
+		}
+		return result[i].Name < result[j].Name
+	})
+	result = result[:min(top, len(result))]
+
+	by := "total execution time"
+	if costModel.Enabled() {
+		by = "estimated cost"
+	}
+	fmt.Printf("Top %d metric families by %s:\n", len(result), by)
+	for i, f := range result {
+		printFamilyStats(i, f)
+	}
+	if unparsable > 0 {
+		fmt.Printf("%d queries could not be parsed and are not included\n", unparsable)
+	}
+}
+
+// PrintMetricLoad prints the metric names whose queries account for the most cumulative execution time and
+// queryable samples. Queries selecting several metrics count towards each of them.
+func PrintMetricLoad(queries []*querystats.Query, top int) {
+	result, unparsable := aggregateMetricLoad(queries, func(metric string) string {
+		if metric == "" {
+			return unnamedFamily
+		}
+		return metric
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ExecTime != result[j].ExecTime {
+			return result[i].ExecTime > result[j].ExecTime
+		}
+		return result[i].Name < result[j].Name
+	})
+	fmt.Printf("Top %d metrics by total execution time:\n", min(top, len(result)))
+	for i, f := range result[:min(top, len(result))] {
+		printFamilyStats(i, f)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Samples != result[j].Samples {
+			return result[i].Samples > result[j].Samples
+		}
+		return result[i].Name < result[j].Name
+	})
+	fmt.Println()
+	fmt.Printf("Top %d metrics by total queryable samples:\n", min(top, len(result)))
+	for i, f := range result[:min(top, len(result))] {
+		printFamilyStats(i, f)
+	}
+	if unparsable > 0 {
+		fmt.Printf("%d queries could not be parsed and are not included\n", unparsable)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+const (
+	// findingQueueShare is the share of queue time in the execution time of the slowest 1% of
+	// executions above which the engine is considered saturated
+	findingQueueShare = 0.2
+	// findingQueryShare is the share of the total execution time a single query may take
+	findingQueryShare = 0.1
+)
+
+// Finding is a problem found in the analysis and the report section with the details.
+type Finding struct {
+	Message string
+	See     string
+}
+
+// Findings evaluates rules of thumb over the loaded queries. logs must be sorted by time.
+func Findings(queries []*querystats.Query, logs querystats.LogEntries, irregularity float64) []Finding {
+	var findings []Finding
+
+	if len(logs) > 0 {
+		execTimes := MetricExecTotalTime.Values(&querystats.Query{Logs: logs})
+		p99, _ := querystats.Percentile(99, execTimes)
+		var exec, queue float64
+		for _, log := range logs {
+			if log.Stats.Timings.ExecTotalTime >= p99 {
+				exec += log.Stats.Timings.ExecTotalTime
+				queue += log.Stats.Timings.ExecQueueTime
+			}
+		}
+		if exec > 0 && queue/exec >= findingQueueShare {
+			findings = append(findings, Finding{
+				fmt.Sprintf("Queue time makes up %.0f%% of the execution time of the slowest 1%% of executions. Queries wait for a free slot of --query.max-concurrency", 100*queue/exec),
+				"top queries by max execution time",
+			})
+		}
+	}
+
+	overloaded, missing := ruleGroupRisks(queries)
+	if len(overloaded) > 0 {
+		findings = append(findings, Finding{
+			fmt.Sprintf("%d rule groups take more than %.0f%% of their evaluation interval or overran it, risking missed evaluations, the worst is %q", len(overloaded), 100*ruleBudget, escapeTerminal(overloaded[0].Name)),
+			"top rule groups by share of the evaluation interval",
+		})
+	}
+	if len(missing) > 0 {
+		missed, most := 0, missing[0]
+		for _, b := range missing {
+			missed += b.Missed
+			if b.Missed > most.Missed {
+				most = b
+			}
+		}
+		findings = append(findings, Finding{
+			fmt.Sprintf("%d rule groups missed %d evaluations judging by the gaps between them, the most %q with %d", len(missing), missed, escapeTerminal(most.Name), most.Missed),
+			"the missed column of the top rule groups by share of the evaluation interval",
+		})
+	}
+
+	var total float64
+	for _, q := range queries {
+		total += q.SumExecTotalTime
+	}
+	heavy, heavyTime := 0, 0.0
+	for _, q := range queries {
+		if total > 0 && q.SumExecTotalTime/total >= findingQueryShare {
+			heavy++
+			heavyTime += q.SumExecTotalTime
+		}
+	}
+	if heavy > 0 {
+		findings = append(findings, Finding{
+			fmt.Sprintf("%d queries consume %.0f%% of the engine time, each more than %.0f%%", heavy, 100*heavyTime/total, 100*findingQueryShare),
+			"top queries by total execution time",
+		})
+	}
+
+	failing, failed := 0, 0
+	irregular := 0
+	for _, q := range queries {
+		if errors, timeouts := q.Failures(timeoutProxy); errors+timeouts > 0 {
+			failing++
+			failed += errors + timeouts
+		}
+		if q.Logs[0].RuleGroup != nil {
+			if _, score, ok := q.IntervalRegularity(); ok && score > irregularity {
+				irregular++
+			}
+		}
+	}
+	if failing > 0 {
+		findings = append(findings, Finding{
+			fmt.Sprintf("%d executions of %d queries failed or timed out", failed, failing),
+			"top queries by failure rate",
+		})
+	}
+	if irregular > 0 {
+		findings = append(findings, Finding{
+			fmt.Sprintf("%d rule queries are evaluated at irregular intervals, which usually means missed evaluations", irregular),
+			"top rule queries by execution interval irregularity",
+		})
+	}
+	return findings
+}
+
+// ruleGroupRisks returns the rule groups at risk of missed evaluations by -rule-budget, the one consuming the
+// largest share of its evaluation interval first, and the rule groups that missed evaluations.
+func ruleGroupRisks(queries []*querystats.Query) (overloaded, missing []*RuleGroupBudget) {
+	for _, b := range RuleGroupBudgets(queries, ruleGroupIntervals) {
+		if b.AtRisk(ruleBudget) {
+			overloaded = append(overloaded, b)
+		}
+		if b.Missed > 0 {
+			missing = append(missing, b)
+		}
+	}
+	return overloaded, missing
+}
+
+// PrintFindings prints the findings, or that there are none.
+func PrintFindings(findings []Finding) {
+	fmt.Println("Findings:")
+	if len(findings) == 0 {
+		fmt.Println(" Nothing stands out")
+		return
+	}
+	for _, f := range findings {
+		fmt.Printf(" * %s. See %s\n", f.Message, f.See)
+	}
+}
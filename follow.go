@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// followReadSize is the most bytes Read reads at once, so a large backlog is processed in chunks instead of being
+// read into memory whole.
+const followReadSize = 4 << 20
+
+// Follower reads lines appended to a file like tail -F. It reopens the file when it is
+// replaced, e.g. by log rotation, and starts over when it is truncated.
+type Follower struct {
+	path    string
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+	// resume is the offset reading starts at when the file is opened for the first time, if it is the file
+	// identified by resumeID. A zero resumeID matches any file
+	resume   int64
+	resumeID fileID
+	// more is set when the last Read stopped at followReadSize before the end of the file
+	more bool
+}
+
+func NewFollower(path string) *Follower {
+	return &Follower{path: path}
+}
+
+// Read returns the complete lines appended since the previous call, reading at most followReadSize bytes. A
+// trailing partial line is kept until it is terminated. More reports whether there is more to read already.
+func (f *Follower) Read() ([]byte, error) {
+	if f.file != nil {
+		info, err := os.Stat(f.path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// rotated away and not recreated yet
+		case err != nil:
+			return nil, err
+		case !os.SameFile(info, f.info):
+			// drain what was written to the old file before it was rotated, completing the kept partial line
+			data, err := f.readChunk()
+			if err != nil {
+				return nil, err
+			}
+			chunk := f.complete(data)
+			if f.more {
+				return chunk, nil
+			}
+			// a line left unterminated in the old file doesn't continue in the new one
+			if len(f.partial) > 0 {
+				chunk = append(append(chunk, f.partial...), '\n')
+			}
+			f.close()
+			// the new file is read by the next call
+			f.more = true
+			return chunk, nil
+		case info.Size() < f.offset:
+			slog.Warn("The query log was truncated, reading from the start", "file", f.path)
+			if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			f.offset, f.partial = 0, nil
+		}
+	}
+	if f.file == nil {
+		f.more = false
+		file, err := os.Open(f.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if f.info, err = file.Stat(); err != nil {
+			file.Close()
+			return nil, err
+		}
+		f.file, f.offset = file, 0
+		id, identified := identifyFile(f.info)
+		sameFile := f.resumeID == fileID{} || !identified || id == f.resumeID
+		if f.resume > 0 && sameFile && f.info.Size() >= f.resume {
+			if f.offset, err = file.Seek(f.resume, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		f.resume, f.resumeID = 0, fileID{}
+	}
+	data, err := f.readChunk()
+	if err != nil {
+		return nil, err
+	}
+	return f.complete(data), nil
+}
+
+// More returns whether the last Read stopped before the end of the file, so the next one returns more lines
+// right away.
+func (f *Follower) More() bool {
+	return f.more
+}
+
+// Position returns the offset of the first line not returned by Read yet.
+func (f *Follower) Position() int64 {
+	return f.offset - int64(len(f.partial))
+}
+
+// File returns the identity of the file being read. It is false if no file is open or it can't be identified.
+func (f *Follower) File() (fileID, bool) {
+	if f.file == nil {
+		return fileID{}, false
+	}
+	return identifyFile(f.info)
+}
+
+// Resume makes the first Read start at offset, e.g. a Position saved by a previous process, if the file is the one
+// identified by id. It is ignored if the file is another one or shorter, as it was rotated meanwhile. A zero id,
+// e.g. where files can't be identified, matches any file.
+func (f *Follower) Resume(offset int64, id fileID) {
+	f.resume, f.resumeID = offset, id
+}
+
+func (f *Follower) readChunk() ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(f.file, followReadSize))
+	f.offset += int64(len(data))
+	f.more = len(data) == followReadSize
+	return data, err
+}
+
+// complete prepends the kept partial line to data and returns the complete lines.
+func (f *Follower) complete(data []byte) []byte {
+	data = append(f.partial, data...)
+	end := bytes.LastIndexByte(data, '\n') + 1
+	f.partial = bytes.Clone(data[end:])
+	return data[:end]
+}
+
+func (f *Follower) close() {
+	f.file.Close()
+	f.file, f.info, f.offset = nil, nil, 0
+	f.partial = nil
+}
+
+func (f *Follower) Close() {
+	if f.file != nil {
+		f.close()
+	}
+}
+
+// Follow keeps reading the query log at path and prints the percentiles of the given ranks and the top tables over
+// the entries of the last window every interval. Percentiles since the start are estimated with digests, so memory
+// doesn't grow with the log. The notifier, if not nil, checks the entries of the window every interval. When ctx is
+// done, it stops reading and prints the report of the entries read so far a last time.
+func Follow(ctx context.Context, path string, opts querystats.LoadOptions, window, interval time.Duration, top int, ranks []int, columns []string, notifier *Notifier) error {
+	follower := NewFollower(path)
+	defer follower.Close()
+
+	var entries querystats.LogEntries
+	total := map[string]*Digest{
+		MetricExecTotalTime.Name:         NewDigest(digestAccuracy),
+		MetricTotalQueryableSamples.Name: NewDigest(digestAccuracy),
+	}
+	for ctx.Err() == nil {
+		// the first reads can be a huge backlog, which is read in chunks until caught up
+		for ctx.Err() == nil {
+			lines, err := follower.Read()
+			if err != nil {
+				return err
+			}
+			for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+				if len(bytes.TrimSpace(line)) == 0 {
+					continue
+				}
+				// lines are parsed one by one, so a malformed line doesn't stop following
+				if _, err := querystats.ScanLogEntries(bytes.NewReader(line), opts, func(entry *querystats.LogEntry) {
+					entries = append(entries, entry)
+					total[MetricExecTotalTime.Name].Add(MetricExecTotalTime.Value(entry))
+					total[MetricTotalQueryableSamples.Name].Add(MetricTotalQueryableSamples.Value(entry))
+				}); err != nil {
+					slog.Warn("Skipping a line", "err", err)
+				}
+			}
+			entries = entriesSince(entries, time.Now().Add(-window))
+			if !follower.More() {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		printFollowReport(entries, total, opts, window, top, ranks, columns)
+		if notifier != nil {
+			queries, _, _ := querystats.GroupQueries(entries, opts)
+			notifier.Check(entries, queries, window)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+	fmt.Println("Interrupted, the last report covers the entries read so far:")
+	printFollowReport(entries, total, opts, window, top, ranks, columns)
+	return nil
+}
+
+// entriesSince drops the entries before since in place and returns the rest.
+func entriesSince(entries querystats.LogEntries, since time.Time) querystats.LogEntries {
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.TS != nil && !entry.TS.Before(since) {
+			kept = append(kept, entry)
+		}
+	}
+	clear(entries[len(kept):])
+	return kept
+}
+
+// formatDigestPercentiles renders the percentiles of the ranks estimated by the digest of the metric.
+func formatDigestPercentiles(d *Digest, m Metric, ranks []int) string {
+	percentiles := make([]string, 0, len(ranks))
+	for _, p := range ranks {
+		percentiles = append(percentiles, fmt.Sprintf("p%d=%s", p, m.Format(d.Quantile(p))))
+	}
+	return strings.Join(percentiles, " ")
+}
+
+func printFollowReport(entries querystats.LogEntries, total map[string]*Digest, opts querystats.LoadOptions, window time.Duration, top int, ranks []int, columns []string) {
+	fmt.Printf("=== %s: %d entries in the last %s ===\n", formatTime(time.Now()), len(entries), window)
+	for _, m := range []Metric{MetricExecTotalTime, MetricTotalQueryableSamples} {
+		if len(entries) > 0 {
+			fmt.Printf("Percentiles of %s in the last %s: %s\n", m.Title, window, formatDigestPercentiles(metricDigest(entries, m), m, ranks))
+		}
+		if d := total[m.Name]; d.Count > 0 {
+			fmt.Printf("Percentiles of %s since the start over %d entries: %s\n", m.Title, d.Count, formatDigestPercentiles(d, m, ranks))
+		}
+	}
+	opts.Strict = false
+	queries, _, err := querystats.GroupQueries(entries, opts)
+	if err != nil || len(queries) == 0 {
+		fmt.Println()
+		return
+	}
+	sort.Sort(sort.Reverse(querystats.ByAvgExecTotalTime{Queries: queries}))
+	fmt.Println()
+	PrintTable(queries, top, MetricExecTotalTime, TableAvg, columns)
+	sort.Sort(sort.Reverse(querystats.ByMaxExecTotalTime{Queries: queries}))
+	fmt.Println()
+	PrintTable(queries, top, MetricExecTotalTime, TableMax, columns)
+	sort.Sort(sort.Reverse(querystats.ByAvgTotalQueryableSamples{Queries: queries}))
+	fmt.Println()
+	PrintTable(queries, top, MetricTotalQueryableSamples, TableAvg, columns)
+	fmt.Println()
+}
+
+// runTail implements the tail subcommand following a growing query log.
+func runTail(args []string) {
+	// not fs, which is the io/fs package here
+	flags := flag.NewFlagSet("tail", flag.ExitOnError)
+	window := flags.Duration("window", time.Hour, "sliding time window the top tables are computed over")
+	interval := flags.Duration("interval", 10*time.Second, "how often the top tables are printed")
+	top := flags.Int("top", 10, "number of top queries to display")
+	columns := flags.String("columns", "", "comma-separated list of columns shown in the top tables, see analyze -h")
+	flags.IntVar(&queryWidth, "query-width", 0, "truncate queries to this many terminal columns and pad shorter ones, see analyze -h")
+	flags.BoolVar(&fullQuery, "full-query", false, "print queries in full instead of fitting rows to the terminal")
+	ranks := percentileRanks{50, 95, 99}
+	flags.Var(&ranks, "p", "comma-separated list of percentile ranks of execution time and total queryable samples printed over the window and since the start")
+	flags.Float64Var(&digestAccuracy, "digest-accuracy", 0.01, "relative error of the estimated percentiles, see analyze -h")
+	normalize := flags.String("normalize", "", "comma-separated list of normalizations applied to queries before grouping: whitespace, case, matchers, fingerprint")
+	match := flags.String("query-match", "", "follow only entries whose query matches this regular expression")
+	exclude := flags.String("query-exclude", "", "skip entries whose query matches this regular expression")
+	notifyURL := flags.String("notify-url", "", "webhook URL, e.g. of a Slack incoming webhook, a JSON summary with the top offending queries is posted to when the entries of the window breach -notify-p95-exec or -notify-max-peak, and again once they no longer do")
+	notifyExecTime := NewThreshold("notify-p95-exec", MetricExecTotalTime, PercentileTable(95))
+	flags.Var(notifyExecTime, notifyExecTime.Name, "notify -notify-url when the 95th percentile of execution time over the window exceeds this, e.g. 2s")
+	notifyPeakSamples := NewThreshold("notify-max-peak", MetricPeakSamples, TableMax)
+	flags.Var(notifyPeakSamples, notifyPeakSamples.Name, "notify -notify-url when the peak samples of any entry of the window exceed this")
+	notifyRepeat := flags.Duration("notify-repeat", 15*time.Minute, "how often -notify-url is notified again while the thresholds are still breached")
+	var maintenance maintenanceWindows
+	flags.Var(&maintenance, "notify-maintenance", "maintenance window as start/end, each in the formats of analyze -from, e.g. 2024-05-01T22:00:00Z/2024-05-02T02:00:00Z, no notifications are posted in, e.g. during a planned load test or backfill. Can be passed multiple times")
+	muteURL := flags.String("notify-mute-url", "", "URL asked before each notification whether notifications are muted. Notifications are suppressed while it responds with {\"muted\": true}")
+	flags.Var(locationFlag{}, "tz", tzUsage)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s tail [flags] query.log\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Keeps reading the query log as it grows, like tail -F, and prints the top tables over the last -window every -interval")
+		flags.PrintDefaults()
+	}
+	files := parseArgs(flags, args)
+	if len(files) != 1 || files[0] == "-" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *window <= 0 || *interval <= 0 {
+		fatal("-window and -interval must be positive")
+	}
+	if err := ValidateDigestAccuracy(); err != nil {
+		fatal(err)
+	}
+	var notifier *Notifier
+	if *notifyURL != "" {
+		if !notifyExecTime.IsSet && !notifyPeakSamples.IsSet {
+			fatal("-notify-url requires -notify-p95-exec or -notify-max-peak")
+		}
+		notifier = NewNotifier(*notifyURL, notifyExecTime, notifyPeakSamples, *notifyRepeat)
+		notifier.Maintenance, notifier.MuteURL = maintenance, *muteURL
+	} else if notifyExecTime.IsSet || notifyPeakSamples.IsSet || len(maintenance) > 0 || *muteURL != "" {
+		fatal("-notify-p95-exec, -notify-max-peak, -notify-maintenance and -notify-mute-url require -notify-url")
+	}
+	cols, err := ParseColumns(*columns)
+	if err != nil {
+		fatalf("Invalid -columns value: %s", err)
+	}
+	normalizer, err := querystats.ParseNormalizer(*normalize)
+	if err != nil {
+		fatalf("Invalid -normalize value: %s", err)
+	}
+	opts := querystats.LoadOptions{
+		Normalizer:      normalizer,
+		MaxEntrySize:    querystats.DefaultMaxEntrySize,
+		PercentileRanks: queryPercentileRanks,
+		Log:             logLoad,
+	}
+	if *match != "" {
+		if opts.QueryMatch, err = regexp.Compile(*match); err != nil {
+			fatalf("Invalid -query-match value: %s", err)
+		}
+	}
+	if *exclude != "" {
+		if opts.QueryExclude, err = regexp.Compile(*exclude); err != nil {
+			fatalf("Invalid -query-exclude value: %s", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("Following the query log", "file", files[0])
+	if err := Follow(ctx, files[0], opts, *window, *interval, *top, ranks, cols, notifier); err != nil {
+		fatalf("Failed to follow the query log: %s", err)
+	}
+}
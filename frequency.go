@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// QueryRate returns how often the query was executed while it was seen: the executions per second between its first
+// and last execution and the mean interval between them. ok is false if it was executed less than twice.
+func QueryRate(q *querystats.Query) (perSecond float64, meanInterval time.Duration, ok bool) {
+	if len(q.Logs) < 2 {
+		return 0, 0, false
+	}
+	first, last := *q.Logs[0].TS, *q.Logs[0].TS
+	for _, log := range q.Logs[1:] {
+		if log.TS.Before(first) {
+			first = *log.TS
+		}
+		if log.TS.After(last) {
+			last = *log.TS
+		}
+	}
+	span := last.Sub(first)
+	if span <= 0 {
+		return 0, 0, false
+	}
+	return float64(len(q.Logs)-1) / span.Seconds(), span / time.Duration(len(q.Logs)-1), true
+}
+
+// PrintQueryRate prints the rate of queries over the analyzed window and the first top queries by number of
+// executions. logs must be sorted by time.
+func PrintQueryRate(queries []*querystats.Query, logs querystats.LogEntries, top int) {
+	window := logs[len(logs)-1].TS.Sub(*logs[0].TS)
+	if window <= 0 {
+		fmt.Printf("Query rate: %d entries at a single point in time\n", len(logs))
+		return
+	}
+	rules := 0
+	for _, log := range logs {
+		if log.RuleGroup != nil {
+			rules++
+		}
+	}
+	fmt.Printf("Query rate: %.3f queries per second over %s, %.3f from rules and %.3f from the HTTP API\n",
+		float64(len(logs))/window.Seconds(), window.Round(time.Second),
+		float64(rules)/window.Seconds(), float64(len(logs)-rules)/window.Seconds())
+	fmt.Println()
+
+	sorted := make([]*querystats.Query, len(queries))
+	copy(sorted, queries)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Logs) > len(sorted[j].Logs) })
+	fmt.Printf("Top %d most frequently executed queries:\n", min(top, len(sorted)))
+	for i, q := range sorted[:min(top, len(sorted))] {
+		rate, interval := "-", "-"
+		if perSecond, meanInterval, ok := QueryRate(q); ok {
+			rate = fmt.Sprintf("%.2f/min", 60*perSecond)
+			interval = meanInterval.Round(time.Second).String()
+		}
+		fmt.Printf("%2d) n=%-6d rate=%-10s interval=%-8s %s", i+1, len(q.Logs), rate, interval, queryWithID(q.Query))
+		printRuleName(q)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var (
+	printHeatmap bool
+	heatmapTZ    string
+)
+
+func init() {
+	flag.BoolVar(&printHeatmap, "heatmap", false, "print heat maps of the execution time and the total queryable samples of all entries by weekday and hour of day, revealing periodic load such as nightly reports")
+	flag.StringVar(&heatmapTZ, "heatmap-tz", "", "time zone of the hours of -heatmap, e.g. local or Europe/Berlin. Defaults to -tz")
+}
+
+// heatmapRamp are the shades of cells from no load to the most loaded cell.
+const heatmapRamp = " .:-=+*#%@"
+
+// heatmapColors are the ANSI 256-color backgrounds of the shades of heatmapRamp, from blue to red.
+var heatmapColors = []int{0, 17, 19, 27, 33, 44, 148, 214, 202, 196}
+
+// PrintHeatmap prints the sum of the metric over the entries in a grid of weekdays and hours of the day in the
+// location. Entries of all weeks of the log are summed into the same grid.
+func PrintHeatmap(logs querystats.LogEntries, m Metric, loc *time.Location, color bool) {
+	var cells [7][24]float64
+	var days [7]float64
+	var highest float64
+	for _, log := range logs {
+		ts := log.TS.In(loc)
+		// weeks start on Monday
+		day := (int(ts.Weekday()) + 6) % 7
+		cells[day][ts.Hour()] += m.Value(log)
+		days[day] += m.Value(log)
+		highest = max(highest, cells[day][ts.Hour()])
+	}
+
+	fmt.Printf("Sum of %s by weekday and hour in %s over %d entries, max %s per cell:\n", m.Title, loc, len(logs), m.Format(highest))
+	var header strings.Builder
+	header.WriteString("    ")
+	for hour := 0; hour < 24; hour++ {
+		fmt.Fprintf(&header, "%02d ", hour)
+	}
+	fmt.Println(strings.TrimRight(header.String(), " ") + "  total")
+	for day, name := range []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"} {
+		var row strings.Builder
+		row.WriteString(name + " ")
+		for hour := 0; hour < 24; hour++ {
+			shade := 0
+			if v := cells[day][hour]; v > 0 && highest > 0 {
+				// any load is visible, the most loaded cells get the last shade
+				shade = 1 + min(int(v/highest*float64(len(heatmapRamp)-1)), len(heatmapRamp)-2)
+			}
+			cell := strings.Repeat(string(heatmapRamp[shade]), 2)
+			if color && shade > 0 {
+				cell = fmt.Sprintf("\x1b[48;5;%dm%s\x1b[0m", heatmapColors[shade], cell)
+			}
+			row.WriteString(cell + " ")
+		}
+		fmt.Printf("%s %s\n", row.String(), m.Format(days[day]))
+	}
+	fmt.Printf("Shades from none to the max: %q\n", heatmapRamp)
+}
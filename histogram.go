@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// histogramWidth is the number of characters of the longest bar
+const histogramWidth = 40
+
+var printHistograms bool
+
+func init() {
+	flag.BoolVar(&printHistograms, "hist", false, "print histograms of the execution time and peak samples of all entries with log-scaled buckets")
+}
+
+// logBuckets returns the 1-2-5 bounds of each decade from the last one not above lowest to the first one above
+// highest. lowest must be positive.
+func logBuckets(lowest, highest float64) []float64 {
+	var bounds []float64
+	for decade := math.Pow(10, math.Floor(math.Log10(lowest))); ; decade *= 10 {
+		for _, m := range []float64{1, 2, 5} {
+			b := decade * m
+			if b <= lowest {
+				bounds = []float64{b}
+				continue
+			}
+			bounds = append(bounds, b)
+			if b > highest {
+				return bounds
+			}
+		}
+	}
+}
+
+// formatBound formats a bucket bound without trailing zeros. Seconds are formatted as durations, e.g. 500µs.
+func formatBound(m Metric, v float64) string {
+	if m.Unit == "s" {
+		return time.Duration(math.Round(v * float64(time.Second))).String()
+	}
+	if m.Int {
+		return strconv.FormatFloat(v, 'f', 0, 64) + m.Unit
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64) + m.Unit
+}
+
+// PrintHistogram prints the distribution of the metric over the entries as horizontal bars. Buckets are log-scaled,
+// with 1-2-5 bounds in each decade, and zero values are counted separately.
+func PrintHistogram(logs querystats.LogEntries, m Metric) {
+	lowest, highest, zeros := math.Inf(1), 0.0, 0
+	for _, log := range logs {
+		v := m.Value(log)
+		if v <= 0 {
+			zeros++
+			continue
+		}
+		lowest, highest = min(lowest, v), max(highest, v)
+	}
+	fmt.Printf("Distribution of %s over %d entries:\n", m.Title, len(logs))
+
+	type bucket struct {
+		label string
+		count int
+	}
+	var buckets []bucket
+	if zeros > 0 {
+		buckets = append(buckets, bucket{"0", zeros})
+	}
+	if highest > 0 {
+		bounds := logBuckets(lowest, highest)
+		counts := make([]int, len(bounds)-1)
+		for _, log := range logs {
+			if v := m.Value(log); v > 0 {
+				// the first bound above v ends its bucket
+				i := 1
+				for i < len(bounds)-1 && v >= bounds[i] {
+					i++
+				}
+				counts[i-1]++
+			}
+		}
+		for i, count := range counts {
+			buckets = append(buckets, bucket{formatBound(m, bounds[i]) + " - " + formatBound(m, bounds[i+1]), count})
+		}
+	}
+
+	labelWidth, peak := 0, 0
+	for _, b := range buckets {
+		labelWidth, peak = max(labelWidth, querystats.DisplayWidth(b.label)), max(peak, b.count)
+	}
+	for _, b := range buckets {
+		n := (b.count*histogramWidth + peak - 1) / peak
+		bar := strings.Repeat("█", n) + strings.Repeat(" ", histogramWidth-n)
+		pad := strings.Repeat(" ", labelWidth-querystats.DisplayWidth(b.label))
+		fmt.Printf("  %s%s |%s %d (%.1f%%)\n", pad, b.label, bar, b.count, 100*float64(b.count)/float64(len(logs)))
+	}
+}
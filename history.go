@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// HistoryPoint summarizes a single run. Points are appended to the history file as JSON lines,
+// so the query load can be followed across months without external infrastructure.
+type HistoryPoint struct {
+	Time            time.Time `json:"time"`
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	Entries         int       `json:"entries"`
+	DistinctQueries int       `json:"distinctQueries"`
+	PercentileRank  int       `json:"percentileRank"`
+	// ExecTimePercentile is the PercentileRank-th percentile of the execution time of all entries
+	ExecTimePercentile float64 `json:"execTimePercentile"`
+	TotalExecTime      float64 `json:"totalExecTime"`
+	TotalSamples       int64   `json:"totalSamples"`
+	Errors             int     `json:"errors"`
+	Timeouts           int     `json:"timeouts"`
+}
+
+// NewHistoryPoint summarizes the loaded queries. logs must be sorted by time.
+func NewHistoryPoint(queries []*querystats.Query, logs querystats.LogEntries, perc int) (HistoryPoint, error) {
+	p := HistoryPoint{
+		Time:            now,
+		From:            *logs[0].TS,
+		To:              *logs[len(logs)-1].TS,
+		Entries:         len(logs),
+		DistinctQueries: len(queries),
+		PercentileRank:  perc,
+	}
+	var err error
+	if p.ExecTimePercentile, err = metricPercentile(perc, logs, MetricExecTotalTime); err != nil {
+		return p, err
+	}
+	for _, q := range queries {
+		p.TotalExecTime += q.SumExecTotalTime
+		p.TotalSamples += int64(q.SumTotalQueryableSamples)
+		errors, timeouts := q.Failures(timeoutProxy)
+		p.Errors += errors
+		p.Timeouts += timeouts
+	}
+	return p, nil
+}
+
+// AppendHistoryPoint appends the point to the history file, creating it if needed.
+func AppendHistoryPoint(name string, p HistoryPoint) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(p); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadHistoryFile returns all points of the history file in the order they were appended.
+func ReadHistoryFile(name string) ([]HistoryPoint, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var points []HistoryPoint
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var p HistoryPoint
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		points = append(points, p)
+	}
+	return points, scanner.Err()
+}
+
+// PrintHistory prints the last points of the history.
+func PrintHistory(points []HistoryPoint, last int) {
+	points = points[max(len(points)-last, 0):]
+	fmt.Printf("Query load of the last %d runs:\n", len(points))
+	for i, p := range points {
+		trend := ""
+		if i > 0 {
+			trend = formatTrend(points[i-1].TotalExecTime, p.TotalExecTime)
+		}
+		fmt.Printf("%2d) %s..%s n=%-8d queries=%-6d p%d=%.3fs total=%.3fs %-8s samples=%-12d errors=%d timeouts=%d\n",
+			i+1, formatTime(p.From), formatTime(p.To), p.Entries, p.DistinctQueries,
+			p.PercentileRank, p.ExecTimePercentile, p.TotalExecTime, trend, p.TotalSamples, p.Errors, p.Timeouts)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+const (
+	htmlChartBuckets = 120
+	htmlChartWidth   = 960
+	htmlChartHeight  = 200
+	// htmlTopQueries is the number of queries in the bar chart of the top queries
+	htmlTopQueries = 10
+)
+
+// htmlChart is a line chart rendered as an inline SVG polyline.
+type htmlChart struct {
+	Title  string
+	Points string
+	Max    string
+	From   string
+	To     string
+}
+
+// newHTMLChart scales values to the chart area. format formats the value at the top of the y axis.
+func newHTMLChart(title string, values []float64, from, to time.Time, format func(float64) string) htmlChart {
+	var peak float64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for i, v := range values {
+		x := 0.0
+		if len(values) > 1 {
+			x = float64(i) * htmlChartWidth / float64(len(values)-1)
+		}
+		y := float64(htmlChartHeight)
+		if peak > 0 {
+			y -= v / peak * htmlChartHeight
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f ", x, y)
+	}
+	return htmlChart{title, b.String(), format(peak), formatTime(from), formatTime(to)}
+}
+
+// htmlBar is a bar of the top queries chart.
+type htmlBar struct {
+	Query string
+	Width float64
+	Value string
+}
+
+type htmlReport struct {
+	*Report
+	PercentileRank int
+	Charts         []htmlChart
+	TopQueries     []htmlBar
+	CostEnabled    bool
+	Generated      string
+	// Glossary explains the metrics with -explain-metrics
+	Glossary []metricExplanation
+}
+
+// timeline sums the metric over entries in n buckets of the time range of logs, which must be sorted by time.
+func timeline(logs querystats.LogEntries, metric Metric, n int) []float64 {
+	sums := make([]float64, n)
+	from, to := *logs[0].TS, *logs[len(logs)-1].TS
+	width := to.Sub(from) / time.Duration(n)
+	for _, log := range logs {
+		i := n - 1
+		if width > 0 {
+			i = min(int(log.TS.Sub(from)/width), n-1)
+		}
+		sums[i] += metric.Value(log)
+	}
+	return sums
+}
+
+// WriteHTMLReport writes the report as a self-contained HTML page with sortable tables and charts of the
+// execution time and samples over time and of the history file, if it has more than one run. logs must be
+// sorted by time.
+func WriteHTMLReport(w io.Writer, report *Report, logs querystats.LogEntries, history []HistoryPoint, perc int) error {
+	from, to := *logs[0].TS, *logs[len(logs)-1].TS
+	step := (to.Sub(from) / htmlChartBuckets).Round(time.Second)
+	data := htmlReport{
+		Report:         report,
+		PercentileRank: perc,
+		CostEnabled:    costModel.Enabled(),
+		Generated:      formatTime(now),
+		Charts: []htmlChart{
+			newHTMLChart(fmt.Sprintf("Total execution time per %s", step), timeline(logs, MetricExecTotalTime, htmlChartBuckets), from, to,
+				func(v float64) string { return fmt.Sprintf("%.3fs", v) }),
+			newHTMLChart(fmt.Sprintf("Total queryable samples per %s", step), timeline(logs, MetricTotalQueryableSamples, htmlChartBuckets), from, to,
+				func(v float64) string { return fmt.Sprintf("%.0f", v) }),
+		},
+	}
+	if len(history) > 1 {
+		var execTimes, percentiles []float64
+		for _, p := range history {
+			execTimes = append(execTimes, p.TotalExecTime)
+			percentiles = append(percentiles, p.ExecTimePercentile)
+		}
+		first, last := history[0].Time, history[len(history)-1].Time
+		data.Charts = append(data.Charts,
+			newHTMLChart(fmt.Sprintf("Total execution time of the last %d runs", len(history)), execTimes, first, last,
+				func(v float64) string { return fmt.Sprintf("%.3fs", v) }),
+			newHTMLChart(fmt.Sprintf("Percentile of execution time of the last %d runs", len(history)), percentiles, first, last,
+				func(v float64) string { return fmt.Sprintf("%.3fs", v) }),
+		)
+	}
+
+	if *argExplain {
+		data.Glossary = metricGlossary
+	}
+
+	// queries of the report are sorted by total execution time
+	top := report.Queries[:min(htmlTopQueries, len(report.Queries))]
+	for _, q := range top {
+		bar := htmlBar{Query: q.Query, Value: fmt.Sprintf("%.3fs", q.SumExecTotalTime)}
+		if top[0].SumExecTotalTime > 0 {
+			bar.Width = 100 * q.SumExecTotalTime / top[0].SumExecTotalTime
+		}
+		data.TopQueries = append(data.TopQueries, bar)
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"seconds": func(v float64) string { return fmt.Sprintf("%.3f", v) },
+	"time":    formatTime,
+	"deref":   func(v *float64) float64 { return *v },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Prometheus query log report {{time .From}} - {{time .To}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; font-size: 13px; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 8px; text-align: right; vertical-align: top; }
+th { cursor: pointer; background: #f4f4f4; position: sticky; top: 0; }
+footer { margin-top: 2em; font-family: monospace; font-size: small; color: #666; }
+td.query { text-align: left; font-family: monospace; white-space: pre-wrap; word-break: break-all; max-width: 60em; }
+svg { background: #fafafa; border: 1px solid #ddd; }
+polyline { fill: none; stroke: #e6522c; stroke-width: 1.5; }
+.axis { font-size: 12px; color: #666; display: flex; justify-content: space-between; width: 960px; }
+.bar { background: #e6522c; height: 14px; }
+</style>
+</head>
+<body>
+<h1>Prometheus query log report</h1>
+<p>{{.Entries}} entries of {{.DistinctQueries}} distinct queries from {{time .From}} to {{time .To}}.
+{{- if .LogFormat}} Query log format: {{.LogFormat}}.{{end}}
+{{- if .Partial}} Loading was interrupted, the report is partial.{{end}}
+{{- if .ZeroTimingEntries}} {{.ZeroTimingEntries}} entries have all timings equal to zero.{{end}}
+{{- if .MalformedLines}} {{.MalformedLines}} malformed lines were skipped.{{end}}
+{{- if .NoiseLines}} {{.NoiseLines}} lines that are not query log entries were skipped.{{end}}
+Generated at {{.Generated}}.</p>
+<ul>
+{{- range .Percentiles}}
+<li>The {{.Rank}}th percentile of {{.Metric}} is {{printf "%.3f" .Value}}</li>
+{{- end}}
+{{- range .Summaries}}
+<li>{{.Metric}}: min {{printf "%.3f" .Min}}, median {{printf "%.3f" .Median}}, avg {{printf "%.3f" .Avg}}, stddev {{printf "%.3f" .StdDev}}, max {{printf "%.3f" .Max}}</li>
+{{- end}}
+{{- range .QueryTypes}}
+<li>{{.Entries}} {{.Type}} queries took {{printf "%.3f" .SumExecTotalTime}}s in total
+{{- range .Summaries}}; {{.Metric}}: median {{printf "%.3f" .Median}}, max {{printf "%.3f" .Max}}{{end}}</li>
+{{- end}}
+</ul>
+
+{{range .Charts}}
+<h2>{{.Title}}</h2>
+<div class="axis"><span>max {{.Max}}</span></div>
+<svg width="960" height="200" viewBox="0 0 960 200" preserveAspectRatio="none"><polyline points="{{.Points}}"/></svg>
+<div class="axis"><span>{{.From}}</span><span>{{.To}}</span></div>
+{{end}}
+
+<h2>Top queries by total execution time</h2>
+<table>
+{{- range .TopQueries}}
+<tr><td style="width: 20em"><div class="bar" style="width: {{printf "%.1f" .Width}}%"></div></td><td>{{.Value}}</td><td class="query">{{.Query}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Queries</h2>
+<p>Click a column header to sort.</p>
+<table class="sortable">
+<thead><tr>
+<th>id</th><th>n</th><th>avg exec (s)</th><th>max exec (s)</th><th>p{{.PercentileRank}} exec (s)</th><th>total exec (s)</th>
+<th>avg samples</th><th>max peak samples</th><th>errors</th><th>timeouts</th>{{if .CostEnabled}}<th>cost</th>{{end}}<th>rule group</th><th>query</th>
+</tr></thead>
+<tbody>
+{{- range .Queries}}
+<tr>
+<td>{{.ID}}</td><td>{{.Count}}</td><td>{{seconds .AvgExecTotalTime}}</td><td>{{seconds .MaxExecTotalTime}}</td>
+<td>{{seconds .PercentileExecTotalTime}}</td><td>{{seconds .SumExecTotalTime}}</td><td>{{printf "%.0f" .AvgTotalQueryableSamples}}</td>
+<td>{{.MaxPeakSamples}}</td><td>{{.Errors}}</td><td>{{.Timeouts}}</td>{{if $.CostEnabled}}<td>{{printf "%.2f" (deref .Cost)}}</td>{{end}}
+<td class="query">{{.RuleGroup}}</td>
+<td class="query">{{.Query}}
+{{- if .PrometheusURL}} <a href="{{.PrometheusURL}}">Prometheus</a>{{end}}
+{{- if .GrafanaURL}} <a href="{{.GrafanaURL}}">Grafana</a>{{end}}</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+{{- if .Glossary}}
+
+<h2>Explanation of metrics</h2>
+<dl>
+{{- range .Glossary}}
+<dt>{{.Term}}</dt><dd>{{.Explanation}}</dd>
+{{- end}}
+</dl>
+{{- end}}
+
+<script>
+document.querySelectorAll("table.sortable th").forEach(function (th, column) {
+  th.addEventListener("click", function () {
+    var tbody = th.closest("table").tBodies[0];
+    var desc = th.dataset.order !== "desc";
+    th.dataset.order = desc ? "desc" : "asc";
+    var rows = Array.from(tbody.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[column].textContent, y = b.cells[column].textContent;
+      var nx = parseFloat(x), ny = parseFloat(y);
+      var cmp = isNaN(nx) || isNaN(ny) ? x.localeCompare(y) : nx - ny;
+      return desc ? -cmp : cmp;
+    });
+    rows.forEach(function (row) { tbody.appendChild(row); });
+  });
+});
+</script>
+{{- with .Run}}
+<footer>
+{{- range .Lines}}
+<div>{{.}}</div>
+{{- end}}
+</footer>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// RequestGroupStats aggregates the entries received over the HTTP API from a client IP or for a request path.
+type RequestGroupStats struct {
+	Key        string
+	Executions int
+	// Queries is the number of distinct queries
+	Queries  int
+	ExecTime float64
+	Samples  int
+	// Top is the query with the highest total execution time of the group
+	Top         string
+	topExecTime float64
+	execTime    querystats.KahanSum
+	queries     map[string]float64
+}
+
+// GroupByHTTPRequest aggregates entries by the client IP or the path of their HTTP request, ordered by total
+// execution time. Entries without an HTTP request, i.e. rule evaluations, are aggregated in a group with an empty key.
+func GroupByHTTPRequest(logs querystats.LogEntries, by string) []*RequestGroupStats {
+	groups := make(map[string]*RequestGroupStats)
+	for _, e := range logs {
+		var key string
+		if r := e.HTTPRequest; r != nil {
+			key = r.ClientIP
+			if by == "path" {
+				key = r.Path
+			}
+		}
+		g := groups[key]
+		if g == nil {
+			g = &RequestGroupStats{Key: key, execTime: newSum(), queries: make(map[string]float64)}
+			groups[key] = g
+		}
+		g.Executions++
+		g.execTime.Add(e.Stats.Timings.ExecTotalTime)
+		g.Samples += e.Stats.Samples.TotalQueryableSamples
+		g.queries[e.Params.Query] += e.Stats.Timings.ExecTotalTime
+	}
+
+	result := make([]*RequestGroupStats, 0, len(groups))
+	for _, g := range groups {
+		g.ExecTime = g.execTime.Value()
+		g.Queries = len(g.queries)
+		for q, t := range g.queries {
+			if t > g.topExecTime || (t == g.topExecTime && q < g.Top) || g.Top == "" {
+				g.Top, g.topExecTime = q, t
+			}
+		}
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ExecTime != result[j].ExecTime {
+			return result[i].ExecTime > result[j].ExecTime
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// PrintRequestGroups prints the first top client IPs or request paths by total execution time with their most
+// expensive query, to attribute ad-hoc load to Grafana instances or users.
+func PrintRequestGroups(groups []*RequestGroupStats, by string, top int) {
+	title := "client IPs"
+	if by == "path" {
+		title = "request paths"
+	}
+	top = min(top, len(groups))
+	fmt.Printf("Top %d %s by total execution time:\n", top, title)
+	for i, g := range groups[:top] {
+		key := escapeTerminal(g.Key)
+		if g.Key == "" {
+			key = "<rule evaluations and entries without an HTTP request>"
+		}
+		fmt.Printf("%2d) n=%-6d queries=%-5d total=%.3fs avg=%.3fs samples=%-12d %s\n",
+			i+1, g.Executions, g.Queries, g.ExecTime, g.ExecTime/float64(g.Executions), g.Samples, key)
+		fmt.Printf("    top query: total=%.3fs %s\n", g.topExecTime, escapeTerminal(g.Top))
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// fileList is a flag that can be passed multiple times.
+type fileList []string
+
+func (l *fileList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *fileList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// inputLabel matches an input labeled with the name of its Prometheus server, e.g. prod-a=/var/log/query.log
+var inputLabel = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.-]*)=(.+)$`)
+
+// SplitInputLabel returns the label and the path of a labeled input, or an empty label and the name as is. A file
+// whose name merely looks labeled is taken as is.
+func SplitInputLabel(name string) (label, path string) {
+	m := inputLabel.FindStringSubmatch(name)
+	if m == nil {
+		return "", name
+	}
+	if _, err := os.Stat(name); err == nil {
+		return "", name
+	}
+	return m[1], m[2]
+}
+
+// InputGroup is the inputs of a Prometheus server, labeled with -f name=path.
+type InputGroup struct {
+	Instance string
+	Names    []string
+	// Files are Names expanded by ExpandInputs
+	Files []string
+}
+
+// GroupInputs groups the inputs by their label in the order the labels first appear. Either all or none of the
+// inputs must be labeled, unlabeled inputs make a single group with an empty label.
+func GroupInputs(names []string) ([]InputGroup, error) {
+	var groups []InputGroup
+	index := make(map[string]int)
+	for _, name := range names {
+		label, path := SplitInputLabel(name)
+		if len(groups) > 0 && (label == "") != (groups[0].Instance == "") {
+			return nil, fmt.Errorf("either all or none of the inputs must be labeled with name=path, got %s", name)
+		}
+		i, ok := index[label]
+		if !ok {
+			i = len(groups)
+			index[label] = i
+			groups = append(groups, InputGroup{Instance: label})
+		}
+		groups[i].Names = append(groups[i].Names, path)
+	}
+	return groups, nil
+}
+
+// ExpandInputs replaces directories with the files in them and glob patterns with the files they match.
+// "-" is kept as is and stands for stdin, and so are URLs of remote objects. Rotated logs in directories are ordered
+// oldest first, see sortRotated.
+func ExpandInputs(names []string) ([]string, error) {
+	var files []string
+	for _, name := range names {
+		if name == "-" || isRemote(name) {
+			files = append(files, name)
+			continue
+		}
+		if strings.ContainsAny(name, "*?[") {
+			matches, err := filepath.Glob(name)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", name)
+			}
+			files = append(files, matches...)
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, name)
+			continue
+		}
+		dirEntries, err := os.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		var dirFiles []string
+		for _, e := range dirEntries {
+			if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+				dirFiles = append(dirFiles, filepath.Join(name, e.Name()))
+			}
+		}
+		sortRotated(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+var (
+	// rotatedIndex matches logs rotated by index, e.g. query.log.1, where greater indexes are older
+	rotatedIndex = regexp.MustCompile(`^(.+)\.(\d+)$`)
+	// rotatedDate matches logs rotated by logrotate with dateext, e.g. query.log-20240131 or query.log-2024-01-31
+	rotatedDate = regexp.MustCompile(`^(.+)-(\d{8,10}|\d{4}-\d{2}-\d{2}(?:-\d+)?)$`)
+	// rotatedTimestamp matches logs rotated by lumberjack, e.g. query-2024-01-31T15-04-05.000.log
+	rotatedTimestamp = regexp.MustCompile(`^(.+)-(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}(?:\.\d+)?)(\.[^.]+)?$`)
+)
+
+// rotation is where a file is in the rotation of a log.
+type rotation struct {
+	// log is the path of the current file of the log
+	log string
+	// stamp is the time a file rotated by date was rotated at, in a format that sorts by time
+	stamp string
+	// index is the rotation index of a file rotated by index, greater for older files
+	index int
+	// current is set for the file being written to
+	current bool
+}
+
+// parseRotation returns the position of the file in the rotation of its log, ignoring a .gz suffix.
+func parseRotation(path string) rotation {
+	name := strings.TrimSuffix(path, ".gz")
+	if m := rotatedTimestamp.FindStringSubmatch(name); m != nil {
+		return rotation{log: m[1] + m[3], stamp: m[2]}
+	}
+	if m := rotatedDate.FindStringSubmatch(name); m != nil {
+		return rotation{log: m[1], stamp: m[2]}
+	}
+	if m := rotatedIndex.FindStringSubmatch(name); m != nil {
+		index, err := strconv.Atoi(m[2])
+		if err == nil {
+			return rotation{log: m[1], index: index}
+		}
+	}
+	return rotation{log: name, current: true}
+}
+
+// sortRotated sorts the files by log, e.g. query.log, and the files of each log from the oldest to the current one:
+// those rotated by date, e.g. query.log-20240131.gz, by date, then those rotated by index, e.g. query.log.2.gz and
+// query.log.1, by decreasing index, and then the log itself.
+func sortRotated(files []string) {
+	rotations := make(map[string]rotation, len(files))
+	for _, f := range files {
+		rotations[f] = parseRotation(f)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := rotations[files[i]], rotations[files[j]]
+		switch {
+		case a.log != b.log:
+			return a.log < b.log
+		case a.current != b.current:
+			return b.current
+		case (a.stamp != "") != (b.stamp != ""):
+			return a.stamp != ""
+		case a.stamp != b.stamp:
+			return a.stamp < b.stamp
+		case a.index != b.index:
+			return a.index > b.index
+		}
+		return files[i] < files[j]
+	})
+}
+
+// inputDigest hashes an input as it is read.
+type inputDigest struct {
+	name string
+	hash hash.Hash
+	size atomic.Int64
+	// lineEnd is the number of bytes read up to the end of the last complete line
+	lineEnd atomic.Int64
+	// id is the identity of a local file, set if identified
+	id         fileID
+	identified bool
+	compressed bool
+	// offset is where reading started and stored is the size of the file when it was opened
+	offset, stored int64
+}
+
+func (d *inputDigest) Write(p []byte) (int, error) {
+	n := d.size.Add(int64(len(p)))
+	if i := bytes.LastIndexByte(p, '\n'); i >= 0 {
+		d.lineEnd.Store(n - int64(len(p)) + int64(i) + 1)
+	}
+	return d.hash.Write(p)
+}
+
+// position returns how far the input was read. It is false if reading can't be resumed there: the input isn't a
+// local file, or it is compressed and wasn't read to the end.
+func (d *inputDigest) position() (FilePosition, bool) {
+	if !d.identified {
+		return FilePosition{}, false
+	}
+	offset := d.offset + d.lineEnd.Load()
+	if d.compressed {
+		if d.offset+d.size.Load() < d.stored {
+			return FilePosition{}, false
+		}
+		offset = d.stored
+	}
+	return FilePosition{Name: d.name, ID: d.id, Offset: offset}, true
+}
+
+// fileID identifies a file by its device and inode rather than by its name.
+type fileID struct {
+	Dev, Ino uint64
+}
+
+// FilePosition is the offset up to which a file was read. The file is found by its identity, so the position still
+// applies once log rotation renamed it.
+type FilePosition struct {
+	Name   string
+	ID     fileID
+	Offset int64
+}
+
+// resumePositions are the positions restored by -restore. OpenInputs starts reading the files found among them where
+// the snapshot left off, so the entries in the snapshot aren't read again.
+var resumePositions []FilePosition
+
+// InputPositions returns the positions up to which the local files opened by OpenInputs were read, and the restored
+// positions of files that were not read again, as their entries are still in the snapshot.
+func InputPositions() []FilePosition {
+	var positions []FilePosition
+	read := make(map[fileID]bool)
+	for _, d := range openedInputs {
+		if p, ok := d.position(); ok {
+			positions = append(positions, p)
+			read[p.ID] = true
+		}
+	}
+	for _, p := range resumePositions {
+		if !read[p.ID] {
+			positions = append(positions, p)
+		}
+	}
+	return positions
+}
+
+// openedInputs are the inputs opened by OpenInputs, in order, for the run metadata.
+var openedInputs []*inputDigest
+
+// OpenInputs opens the files and remote objects, decompressing those ending with .gz, and returns a reader of their lines.
+// A newline is inserted between files, so a missing newline at the end of a file doesn't join two entries. Remote
+// objects are requested with ctx.
+func OpenInputs(ctx context.Context, files []string) (io.Reader, func(), error) {
+	var readers []io.Reader
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	for _, name := range files {
+		if name == "-" {
+			slog.Info("Reading the query log", "file", "-")
+			d := &inputDigest{name: name, hash: sha256.New()}
+			openedInputs = append(openedInputs, d)
+			readers = append(readers, io.TeeReader(os.Stdin, d), strings.NewReader("\n"))
+			continue
+		}
+		slog.Info("Reading the query log", "file", name)
+		d := &inputDigest{name: name, hash: sha256.New()}
+		openedInputs = append(openedInputs, d)
+		r, c, err := openInput(ctx, name, d)
+		closers = append(closers, c...)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		readers = append(readers, r, strings.NewReader("\n"))
+	}
+	return io.MultiReader(readers...), closeAll, nil
+}
+
+// openInput opens a file or remote object, decompressing it if it ends with .gz, and, if d is not nil, hashes what
+// is read of it as it is stored. The closers are returned even with an error.
+func openInput(ctx context.Context, name string, d *inputDigest) (io.Reader, []io.Closer, error) {
+	var file io.ReadCloser
+	var err error
+	if isRemote(name) {
+		file, err = openRemote(ctx, name)
+	} else {
+		file, err = openLocal(name, d)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	closers := []io.Closer{file}
+	if d != nil && d.compressed && d.offset > 0 && d.offset == d.stored {
+		// the restored snapshot read the compressed file to the end, so there is nothing left to decompress
+		return strings.NewReader(""), closers, nil
+	}
+	var r io.Reader = file
+	if d != nil {
+		// compressed files are hashed as they are stored, so the digest matches sha256sum
+		r = io.TeeReader(file, d)
+	}
+	if strings.HasSuffix(remotePath(name), ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, closers, fmt.Errorf("%s: %w", name, err)
+		}
+		closers = append(closers, gz)
+		r = gz
+	}
+	return r, closers, nil
+}
+
+// openLocal opens a local file. With a digest, the file is identified and, if the restored snapshot read it up to an
+// offset, reading starts there. A compressed file is skipped by openInput only if it was read to the end.
+func openLocal(name string, d *inputDigest) (*os.File, error) {
+	file, err := os.Open(name)
+	if err != nil || d == nil {
+		return file, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	d.id, d.identified = identifyFile(info)
+	d.compressed = strings.HasSuffix(name, ".gz")
+	d.stored = info.Size()
+	if !d.identified {
+		return file, nil
+	}
+	for _, p := range resumePositions {
+		if p.ID != d.id {
+			continue
+		}
+		switch {
+		case d.compressed && p.Offset == d.stored:
+			d.offset = d.stored
+		case !d.compressed && p.Offset <= d.stored:
+			d.offset = p.Offset
+		}
+		break
+	}
+	if d.offset > 0 {
+		slog.Info("Resuming where the snapshot left off", "file", name, "offset", d.offset)
+		if _, err := file.Seek(d.offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
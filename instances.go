@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// ReadInstances reads the inputs of each Prometheus server and labels their entries with its name. cached reads them
+// through -cache-dir. The load statistics are summed over all servers.
+func ReadInstances(groups []InputGroup, cached bool, opts querystats.LoadOptions) (querystats.LogEntries, querystats.LoadStats, error) {
+	var entries querystats.LogEntries
+	var total querystats.LoadStats
+	for _, g := range groups {
+		instanceEntries, stats, err := readInstance(g, cached, opts)
+		if err != nil {
+			return nil, total, fmt.Errorf("%s: %w", g.Instance, err)
+		}
+		for _, e := range instanceEntries {
+			e.Instance = g.Instance
+		}
+		entries = append(entries, instanceEntries...)
+		total.Add(stats)
+	}
+	return entries, total, nil
+}
+
+func readInstance(g InputGroup, cached bool, opts querystats.LoadOptions) (querystats.LogEntries, querystats.LoadStats, error) {
+	if cached {
+		return ReadCachedLogEntries(g.Files, opts)
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	input, closeInput, err := OpenInputs(ctx, g.Files)
+	if err != nil {
+		return nil, querystats.LoadStats{}, err
+	}
+	defer closeInput()
+	input, stopProgress := StartProgress(g.Files, input)
+	defer stopProgress()
+	return querystats.ReadLogEntries(input, opts)
+}
+
+// InstanceEntries is the entries of a Prometheus server.
+type InstanceEntries struct {
+	Instance string
+	Entries  querystats.LogEntries
+}
+
+// SplitByInstance groups the entries by their instance, ordered by name. It returns nil if the inputs weren't labeled.
+func SplitByInstance(logs querystats.LogEntries) []InstanceEntries {
+	byInstance := make(map[string]querystats.LogEntries)
+	for _, e := range logs {
+		if e.Instance != "" {
+			byInstance[e.Instance] = append(byInstance[e.Instance], e)
+		}
+	}
+	result := make([]InstanceEntries, 0, len(byInstance))
+	for instance, entries := range byInstance {
+		result = append(result, InstanceEntries{instance, entries})
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Instance < result[j].Instance })
+	return result
+}
+
+// InstanceStats aggregates the entries of a Prometheus server.
+type InstanceStats struct {
+	Instance         string             `json:"instance"`
+	Entries          int                `json:"entries"`
+	DistinctQueries  int                `json:"distinctQueries"`
+	SumExecTotalTime float64            `json:"sumExecTotalTime"`
+	Percentiles      []ReportPercentile `json:"percentiles"`
+	Summaries        []ReportSummary    `json:"summaries"`
+	// TopQuery is the query with the highest total execution time on the server
+	TopQuery         string  `json:"topQuery"`
+	TopQueryExecTime float64 `json:"topQueryExecTime"`
+}
+
+// InstanceBreakdown computes the statistics of each Prometheus server. Percentiles of the given ranks are computed
+// over the entries of each server. It returns nil if the inputs weren't labeled.
+func InstanceBreakdown(logs querystats.LogEntries, ranks []int) ([]InstanceStats, error) {
+	var result []InstanceStats
+	for _, g := range SplitByInstance(logs) {
+		s := InstanceStats{Instance: g.Instance, Entries: len(g.Entries)}
+		sum := newSum()
+		queries := make(map[string]float64)
+		for _, log := range g.Entries {
+			sum.Add(log.Stats.Timings.ExecTotalTime)
+			queries[log.Params.Query] += log.Stats.Timings.ExecTotalTime
+		}
+		s.SumExecTotalTime = sum.Value()
+		s.DistinctQueries = len(queries)
+		for q, t := range queries {
+			if t > s.TopQueryExecTime || (t == s.TopQueryExecTime && q < s.TopQuery) || s.TopQuery == "" {
+				s.TopQuery, s.TopQueryExecTime = q, t
+			}
+		}
+		for _, m := range []Metric{MetricExecTotalTime, MetricTotalQueryableSamples, MetricPeakSamples} {
+			summary, percentiles, err := metricSummary(ranks, g.Entries, m)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range ranks {
+				s.Percentiles = append(s.Percentiles, ReportPercentile{m.Name, p, percentiles[p]})
+			}
+			s.Summaries = append(s.Summaries, ReportSummary{m.Name, summary})
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// PrintInstances prints the number of entries, the share of the execution time, the distribution of the metrics and
+// the most expensive query of each Prometheus server.
+func PrintInstances(instances []InstanceStats) {
+	var total float64
+	for _, s := range instances {
+		total += s.SumExecTotalTime
+	}
+	fmt.Println("Load by instance:")
+	for i, s := range instances {
+		share := 0.0
+		if total > 0 {
+			share = 100 * s.SumExecTotalTime / total
+		}
+		fmt.Printf("%2d) n=%-7d queries=%-5d total=%.3fs share=%5.1f%% %s\n",
+			i+1, s.Entries, s.DistinctQueries, s.SumExecTotalTime, share, escapeTerminal(s.Instance))
+		for _, summary := range s.Summaries {
+			m := markdownMetric(summary.Metric)
+			var percentiles []string
+			for _, p := range s.Percentiles {
+				if p.Metric == summary.Metric {
+					percentiles = append(percentiles, fmt.Sprintf("p%d=%s", p.Rank, m.Format(p.Value)))
+				}
+			}
+			fmt.Printf("    %s: %s %s\n", m.Title, strings.Join(percentiles, " "), formatSummary(summary.Summary, m))
+		}
+		fmt.Printf("    top query: total=%.3fs %s\n", s.TopQueryExecTime, queryWithID(s.TopQuery))
+	}
+}
+
+// queryInstances counts the executions of the query on each Prometheus server. It returns nil if the inputs weren't
+// labeled.
+func queryInstances(q *querystats.Query) map[string]int {
+	var counts map[string]int
+	for _, log := range q.Logs {
+		if log.Instance == "" {
+			continue
+		}
+		if counts == nil {
+			counts = make(map[string]int)
+		}
+		counts[log.Instance]++
+	}
+	return counts
+}
+
+// formatInstances renders the executions per Prometheus server as a CSV field, e.g. prod-a=10;prod-b=3.
+func formatInstances(counts map[string]int) string {
+	instances := make([]string, 0, len(counts))
+	for instance := range counts {
+		instances = append(instances, instance)
+	}
+	sort.Strings(instances)
+	for i, instance := range instances {
+		instances[i] = fmt.Sprintf("%s=%d", instance, counts[instance])
+	}
+	return strings.Join(instances, ";")
+}
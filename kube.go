@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"gopkg.in/yaml.v3"
+)
+
+// inClusterDir holds the credentials of the service account of a pod.
+const inClusterDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeSource holds the -kube* flags.
+type kubeSource struct {
+	// Pod is the pod to read the query log from, optionally prefixed by its namespace, e.g. monitoring/prometheus-0
+	Pod        string
+	Kubeconfig string
+	Context    string
+	Container  string
+	// Path is the query log file read with cat in the container, unless Logs is set
+	Path string
+	// Logs reads the output of the container instead, e.g. of a sidecar tailing the query log
+	Logs bool
+}
+
+var kubeSettings kubeSource
+
+func init() {
+	flag.StringVar(&kubeSettings.Pod, "kube", "", "read the query log from a Prometheus pod through the Kubernetes API instead of files, given as [namespace/]pod, e.g. monitoring/prometheus-k8s-0. The file of -kube-path is read like kubectl exec cat, which requires the create permission on pods/exec")
+	flag.StringVar(&kubeSettings.Kubeconfig, "kubeconfig", "", "path to the kubeconfig of -kube. Defaults to $KUBECONFIG, ~/.kube/config or the service account of the pod it runs in")
+	flag.StringVar(&kubeSettings.Context, "kube-context", "", "context of the kubeconfig to use instead of its current context")
+	flag.StringVar(&kubeSettings.Container, "kube-container", "", "container of the -kube pod, e.g. prometheus. Required if the pod has several containers")
+	flag.StringVar(&kubeSettings.Path, "kube-path", "/prometheus/query.log", "path of the query log in the container of -kube. Paths ending with .gz are decompressed")
+	flag.BoolVar(&kubeSettings.Logs, "kube-logs", false, "read the output of the container of -kube instead of -kube-path, e.g. of a sidecar tailing the query log. -from limits the output read")
+}
+
+// Enabled reports whether the query log is read from a pod.
+func (s kubeSource) Enabled() bool {
+	return s.Pod != ""
+}
+
+// Validate checks the flags.
+func (s kubeSource) Validate() error {
+	if !s.Enabled() {
+		if s.Context != "" || s.Container != "" || s.Logs {
+			return fmt.Errorf("-kube-context, -kube-container and -kube-logs require -kube")
+		}
+		return nil
+	}
+	if s.Pod == "/" || strings.HasSuffix(s.Pod, "/") || strings.Count(s.Pod, "/") > 1 {
+		return fmt.Errorf("-kube must be a pod name optionally prefixed by its namespace, e.g. monitoring/prometheus-0")
+	}
+	if !s.Logs && s.Path == "" {
+		return fmt.Errorf("-kube requires -kube-path or -kube-logs")
+	}
+	return nil
+}
+
+// Source names the query log in the pod, e.g. in artifacts and the run metadata.
+func (s kubeSource) Source() string {
+	if s.Logs && s.Container != "" {
+		return fmt.Sprintf("kube:%s logs of %s", s.Pod, s.Container)
+	} else if s.Logs {
+		return fmt.Sprintf("kube:%s logs", s.Pod)
+	}
+	return fmt.Sprintf("kube:%s:%s", s.Pod, s.Path)
+}
+
+// Open starts reading the query log from the pod. The query log is streamed as the reader is read.
+func (s kubeSource) Open(ctx context.Context, from *time.Time) (io.Reader, error) {
+	client, err := loadKubeClient(s.Kubeconfig, s.Context)
+	if err != nil {
+		return nil, err
+	}
+	namespace, pod, ok := strings.Cut(s.Pod, "/")
+	if !ok {
+		namespace, pod = client.namespace, s.Pod
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	var r io.Reader
+	if s.Logs {
+		slog.Info("Reading the query log from the output of a pod", "namespace", namespace, "pod", pod)
+		r, err = client.podLogs(ctx, namespace, pod, s.Container, from)
+	} else {
+		slog.Info("Reading the query log from a pod", "file", s.Path, "namespace", namespace, "pod", pod)
+		r, err = client.exec(ctx, namespace, pod, s.Container, []string{"cat", s.Path})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pod %s/%s: %w", namespace, pod, err)
+	}
+	d := &inputDigest{name: s.Source(), hash: sha256.New()}
+	openedInputs = append(openedInputs, d)
+	r = io.TeeReader(r, d)
+	if !s.Logs && strings.HasSuffix(s.Path, ".gz") {
+		if r, err = gzip.NewReader(r); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Path, err)
+		}
+	}
+	return r, nil
+}
+
+// kubeconfig is the part of a kubeconfig file needed to connect to a cluster.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string   `yaml:"name"`
+		User kubeUser `yaml:"user"`
+	} `yaml:"users"`
+	// dir resolves relative paths in the file
+	dir string
+}
+
+type kubeUser struct {
+	Token                 string `yaml:"token"`
+	TokenFile             string `yaml:"tokenFile"`
+	ClientCertificate     string `yaml:"client-certificate"`
+	ClientCertificateData string `yaml:"client-certificate-data"`
+	ClientKey             string `yaml:"client-key"`
+	ClientKeyData         string `yaml:"client-key-data"`
+	Username              string `yaml:"username"`
+	Password              string `yaml:"password"`
+	// Exec is a credential plugin, e.g. of EKS or GKE
+	Exec *struct {
+		APIVersion string   `yaml:"apiVersion"`
+		Command    string   `yaml:"command"`
+		Args       []string `yaml:"args"`
+		Env        []struct {
+			Name  string `yaml:"name"`
+			Value string `yaml:"value"`
+		} `yaml:"env"`
+	} `yaml:"exec"`
+}
+
+// kubeClient calls the Kubernetes API.
+type kubeClient struct {
+	server    string
+	tls       *tls.Config
+	http      *http.Client
+	header    http.Header
+	namespace string
+}
+
+// loadKubeClient connects to the cluster of the context of the kubeconfig, or of the current context if context is
+// empty. Without a kubeconfig, the service account of the pod it runs in is used.
+func loadKubeClient(path, context string) (*kubeClient, error) {
+	if path == "" {
+		path = os.Getenv("KUBECONFIG")
+	}
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".kube", "config")
+		}
+	}
+	// KUBECONFIG can list several files, the first one defining a name wins
+	var configs []*kubeconfig
+	for _, name := range filepath.SplitList(path) {
+		data, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		c := &kubeconfig{dir: filepath.Dir(name)}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		configs = append(configs, c)
+	}
+	if len(configs) == 0 {
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" && context == "" {
+			return inClusterKubeClient()
+		}
+		return nil, fmt.Errorf("no kubeconfig found, set -kubeconfig")
+	}
+	return newKubeClient(configs, context)
+}
+
+// newKubeClient connects to the cluster of the context, looked up in all configs.
+func newKubeClient(configs []*kubeconfig, context string) (*kubeClient, error) {
+	if context == "" {
+		for _, config := range configs {
+			if context = config.CurrentContext; context != "" {
+				break
+			}
+		}
+	}
+	if context == "" {
+		return nil, fmt.Errorf("the kubeconfig has no current context, set -kube-context")
+	}
+	var clusterName, userName, namespace string
+	found := false
+	for _, config := range configs {
+		for _, ctx := range config.Contexts {
+			if ctx.Name == context && !found {
+				clusterName, userName, namespace, found = ctx.Context.Cluster, ctx.Context.User, ctx.Context.Namespace, true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in the kubeconfig", context)
+	}
+
+	client := &kubeClient{tls: &tls.Config{}, header: make(http.Header), namespace: namespace}
+	found = false
+	for _, config := range configs {
+		for _, cluster := range config.Clusters {
+			if cluster.Name != clusterName || found {
+				continue
+			}
+			found = true
+			client.server = strings.TrimRight(cluster.Cluster.Server, "/")
+			client.tls.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+			ca, err := config.data(cluster.Cluster.CertificateAuthorityData, cluster.Cluster.CertificateAuthority)
+			if err != nil {
+				return nil, fmt.Errorf("certificate authority of cluster %q: %w", clusterName, err)
+			}
+			if ca != nil {
+				client.tls.RootCAs = x509.NewCertPool()
+				if !client.tls.RootCAs.AppendCertsFromPEM(ca) {
+					return nil, fmt.Errorf("invalid certificate authority of cluster %q", clusterName)
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("cluster %q not found in the kubeconfig", clusterName)
+	}
+	for _, config := range configs {
+		for _, user := range config.Users {
+			if user.Name == userName {
+				if err := client.authenticate(config, user.User); err != nil {
+					return nil, fmt.Errorf("user %q: %w", userName, err)
+				}
+				client.http = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: client.tls}}
+				return client, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("user %q not found in the kubeconfig", userName)
+}
+
+// data returns inline base64-encoded data or the content of the file, relative to the kubeconfig, or nil if neither
+// is set.
+func (c *kubeconfig) data(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return base64.StdEncoding.DecodeString(inline)
+	}
+	if file == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(c.dir, file)
+	}
+	return os.ReadFile(file)
+}
+
+// authenticate sets the credentials of the user.
+func (client *kubeClient) authenticate(c *kubeconfig, user kubeUser) error {
+	token := user.Token
+	if user.TokenFile != "" {
+		data, err := c.data("", user.TokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	cert, err := c.data(user.ClientCertificateData, user.ClientCertificate)
+	if err != nil {
+		return err
+	}
+	key, err := c.data(user.ClientKeyData, user.ClientKey)
+	if err != nil {
+		return err
+	}
+	if user.Exec != nil {
+		if token, cert, key, err = execCredential(user); err != nil {
+			return fmt.Errorf("credential plugin %s: %w", user.Exec.Command, err)
+		}
+	}
+	switch {
+	case token != "":
+		client.header.Set("Authorization", "Bearer "+token)
+	case user.Username != "":
+		client.header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username+":"+user.Password)))
+	}
+	if cert != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return err
+		}
+		client.tls.Certificates = []tls.Certificate{pair}
+	}
+	return nil
+}
+
+// execCredential runs the credential plugin of the user like kubectl does and returns the credentials it prints.
+func execCredential(user kubeUser) (token string, cert, key []byte, err error) {
+	apiVersion := user.Exec.APIVersion
+	if apiVersion == "" {
+		apiVersion = "client.authentication.k8s.io/v1beta1"
+	}
+	cmd := exec.Command(user.Exec.Command, user.Exec.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range user.Exec.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf(`KUBERNETES_EXEC_INFO={"apiVersion":%q,"kind":"ExecCredential","spec":{"interactive":false}}`, apiVersion))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var credential struct {
+		Status struct {
+			Token                 string `json:"token"`
+			ClientCertificateData string `json:"clientCertificateData"`
+			ClientKeyData         string `json:"clientKeyData"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out, &credential); err != nil {
+		return "", nil, nil, err
+	}
+	if s := credential.Status; s.ClientCertificateData != "" {
+		return s.Token, []byte(s.ClientCertificateData), []byte(s.ClientKeyData), nil
+	}
+	return credential.Status.Token, nil, nil, nil
+}
+
+// inClusterKubeClient connects with the service account of the pod it runs in.
+func inClusterKubeClient() (*kubeClient, error) {
+	token, err := os.ReadFile(filepath.Join(inClusterDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(inClusterDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	namespace, _ := os.ReadFile(filepath.Join(inClusterDir, "namespace"))
+	client := &kubeClient{
+		server:    "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")),
+		tls:       &tls.Config{RootCAs: x509.NewCertPool()},
+		header:    http.Header{"Authorization": {"Bearer " + strings.TrimSpace(string(token))}},
+		namespace: strings.TrimSpace(string(namespace)),
+	}
+	client.tls.RootCAs.AppendCertsFromPEM(ca)
+	client.http = &http.Client{Transport: &http.Transport{TLSClientConfig: client.tls}}
+	return client, nil
+}
+
+// podURL returns the URL of a subresource of the pod, e.g. log.
+func (client *kubeClient) podURL(namespace, pod, subresource string, params url.Values) string {
+	return fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/%s?%s", client.server, url.PathEscape(namespace), url.PathEscape(pod),
+		subresource, params.Encode())
+}
+
+// kubeStatus is the status the Kubernetes API returns on errors and exec ends with.
+type kubeStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// podLogs streams the output of the container since from, if set.
+func (client *kubeClient) podLogs(ctx context.Context, namespace, pod, container string, from *time.Time) (io.Reader, error) {
+	params := url.Values{}
+	if container != "" {
+		params.Set("container", container)
+	}
+	if from != nil {
+		params.Set("sinceTime", from.UTC().Format(time.RFC3339))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.podURL(namespace, pod, "log", params), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = client.header.Clone()
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		var status kubeStatus
+		if json.Unmarshal(body, &status) == nil && status.Message != "" {
+			return nil, errors.New(status.Message)
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return resp.Body, nil
+}
+
+// Channels of the v4.channel.k8s.io protocol of exec over WebSocket. Each message starts with its channel.
+const (
+	kubeStdout byte = 1
+	kubeStderr byte = 2
+	kubeError  byte = 3
+)
+
+// exec runs the command in the container, like kubectl exec, and streams its output. The command failing, e.g.
+// because the file doesn't exist, is returned as the error of reading its output. The connection is closed once ctx is
+// done, which ends the output with the error of ctx.
+func (client *kubeClient) exec(ctx context.Context, namespace, pod, container string, command []string) (io.Reader, error) {
+	params := url.Values{"command": command, "stdout": {"true"}, "stderr": {"true"}}
+	if container != "" {
+		params.Set("container", container)
+	}
+	location := client.podURL(namespace, pod, "exec", params)
+	location = "ws" + strings.TrimPrefix(location, "http")
+	config, err := websocket.NewConfig(location, client.server)
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = []string{"v4.channel.k8s.io"}
+	config.Header = client.header.Clone()
+	config.TlsConfig = client.tls
+	conn, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	go func() {
+		defer conn.Close()
+		defer stop()
+		var stderr bytes.Buffer
+		for {
+			var msg []byte
+			err := websocket.Message.Receive(conn, &msg)
+			if ctx.Err() != nil {
+				w.CloseWithError(ctx.Err())
+				return
+			} else if err == io.EOF {
+				w.Close()
+				return
+			} else if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			if len(msg) == 0 {
+				continue
+			}
+			switch msg[0] {
+			case kubeStdout:
+				if _, err := w.Write(msg[1:]); err != nil {
+					return
+				}
+			case kubeStderr:
+				stderr.Write(msg[1:])
+			case kubeError:
+				var status kubeStatus
+				if err := json.Unmarshal(msg[1:], &status); err != nil {
+					w.CloseWithError(err)
+					return
+				}
+				if status.Status != "Success" {
+					w.CloseWithError(fmt.Errorf("%s: %s", status.Message, bytes.TrimSpace(stderr.Bytes())))
+					return
+				}
+			}
+		}
+	}()
+	return r, nil
+}
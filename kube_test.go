@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadKubeClient(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.yaml")
+	second := filepath.Join(dir, "second.yaml")
+	files := map[string]string{
+		first: `current-context: prod
+contexts:
+- name: prod
+  context: {cluster: prod, user: admin, namespace: monitoring}
+users:
+- name: admin
+  user: {tokenFile: token}
+`,
+		second: `current-context: dev
+contexts:
+- name: prod
+  context: {cluster: other, user: other}
+clusters:
+- name: prod
+  cluster: {server: "https://prod:6443/"}
+users:
+- name: admin
+  user: {token: ignored}
+`,
+		filepath.Join(dir, "token"): "secret\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client, err := loadKubeClient(first+string(filepath.ListSeparator)+second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.server != "https://prod:6443" || client.namespace != "monitoring" {
+		t.Errorf("server %q, namespace %q, want https://prod:6443 and monitoring", client.server, client.namespace)
+	}
+	if got := client.header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the token of the first file", got)
+	}
+	if _, err := loadKubeClient(first, "dev"); err == nil || !strings.Contains(err.Error(), `context "dev" not found`) {
+		t.Errorf("loading an unknown context = %v, want a not found error", err)
+	}
+}
+
+func TestExecCredential(t *testing.T) {
+	plugin := filepath.Join(t.TempDir(), "plugin")
+	script := "#!/bin/sh\n" + `echo "{\"status\":{\"token\":\"$TOKEN-$(echo "$KUBERNETES_EXEC_INFO" | grep -c ExecCredential)\"}}"` + "\n"
+	if err := os.WriteFile(plugin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var user kubeUser
+	if err := yaml.Unmarshal([]byte("exec: {command: "+plugin+", env: [{name: TOKEN, value: secret}]}"), &user); err != nil {
+		t.Fatal(err)
+	}
+	token, cert, _, err := execCredential(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "secret-1" || cert != nil {
+		t.Errorf("token = %q, certificate %v, want secret-1 and none", token, cert)
+	}
+}
+
+func TestKubeExecCanceled(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		websocket.Message.Send(conn, append([]byte{kubeStdout}, "up\n"...))
+		// the command never ends, until the client closes the connection
+		io.Copy(io.Discard, conn)
+	}))
+	defer server.Close()
+	client := &kubeClient{server: server.URL, header: make(http.Header)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := client.exec(ctx, "default", "prometheus-0", "", []string{"cat", "query.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "up\n" {
+		t.Fatalf("Read = %q, %v, want the output of the command", buf[:n], err)
+	}
+	cancel()
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Errorf("reading after the cancellation = %v, want %v", err, context.Canceled)
+	}
+}
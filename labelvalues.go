@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// labelValuesPerLabel is the number of values listed for each label
+const labelValuesPerLabel = 5
+
+// MatchedLabelValues returns the literal label values selected by matchers of the query per label name.
+// Values of equality matchers and of regexp matchers that are alternations of literals, e.g. "a|b", are returned.
+func MatchedLabelValues(query string) (map[string][]string, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		for _, m := range vs.LabelMatchers {
+			if m.Name == labels.MetricName {
+				continue
+			}
+			switch m.Type {
+			case labels.MatchEqual:
+				if m.Value != "" {
+					result[m.Name] = append(result[m.Name], m.Value)
+				}
+			case labels.MatchRegexp:
+				result[m.Name] = append(result[m.Name], literalAlternatives(m.Value)...)
+			}
+		}
+		return nil
+	})
+	return result, nil
+}
+
+// literalAlternatives returns the alternatives of a regexp that is an alternation of literals, or nil.
+func literalAlternatives(expr string) []string {
+	if regexp.QuoteMeta(expr) == expr {
+		return []string{expr}
+	}
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	re = re.Simplify()
+	var subs []*syntax.Regexp
+	switch re.Op {
+	case syntax.OpAlternate:
+		subs = re.Sub
+	case syntax.OpLiteral:
+		subs = []*syntax.Regexp{re}
+	default:
+		return nil
+	}
+	var values []string
+	for _, sub := range subs {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		values = append(values, string(sub.Rune))
+	}
+	return values
+}
+
+type labelValueStats struct {
+	Value      string
+	Queries    int
+	Executions int
+	ExecTime   float64
+}
+
+type labelStats struct {
+	Label      string
+	Executions int
+	Values     map[string]*labelValueStats
+}
+
+// PrintLabelValues prints the labels selected by matchers with literal values in the most executions and their
+// most often selected values, revealing which entities, e.g. namespaces or instances, are actually looked at.
+// Values are taken from the raw queries, so they are reported even if queries are grouped with -normalize fingerprint.
+func PrintLabelValues(queries []*querystats.Query, top int) {
+	type rawQuery struct {
+		executions int
+		execTime   float64
+	}
+	raw := make(map[string]*rawQuery)
+	for _, q := range queries {
+		for _, log := range q.Logs {
+			r := raw[log.Params.Query]
+			if r == nil {
+				r = &rawQuery{}
+				raw[log.Params.Query] = r
+			}
+			r.executions++
+			r.execTime += log.Stats.Timings.ExecTotalTime
+		}
+	}
+
+	byLabel := make(map[string]*labelStats)
+	unparsable := 0
+	for query, r := range raw {
+		matched, err := MatchedLabelValues(query)
+		if err != nil {
+			unparsable++
+			continue
+		}
+		for label, values := range matched {
+			l := byLabel[label]
+			if l == nil {
+				l = &labelStats{Label: label, Values: make(map[string]*labelValueStats)}
+				byLabel[label] = l
+			}
+			l.Executions += r.executions
+			seen := make(map[string]bool, len(values))
+			for _, value := range values {
+				if seen[value] {
+					continue
+				}
+				seen[value] = true
+				v := l.Values[value]
+				if v == nil {
+					v = &labelValueStats{Value: value}
+					l.Values[value] = v
+				}
+				v.Queries++
+				v.Executions += r.executions
+				v.ExecTime += r.execTime
+			}
+		}
+	}
+
+	result := make([]*labelStats, 0, len(byLabel))
+	for _, l := range byLabel {
+		result = append(result, l)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Executions != result[j].Executions {
+			return result[i].Executions > result[j].Executions
+		}
+		return result[i].Label < result[j].Label
+	})
+	result = result[:min(top, len(result))]
+
+	fmt.Printf("Top %d labels selected by literal values in matchers, with their most queried values:\n", len(result))
+	for i, l := range result {
+		values := make([]*labelValueStats, 0, len(l.Values))
+		for _, v := range l.Values {
+			values = append(values, v)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if values[i].Executions != values[j].Executions {
+				return values[i].Executions > values[j].Executions
+			}
+			return values[i].Value < values[j].Value
+		})
+		fmt.Printf("%2d) n=%-7d values=%-5d %s\n", i+1, l.Executions, len(values), escapeTerminal(l.Label))
+		for _, v := range values[:min(labelValuesPerLabel, len(values))] {
+			fmt.Printf("      n=%-7d queries=%-4d exec=%.3fs %s\n", v.Executions, v.Queries, v.ExecTime, escapeTerminal(v.Value))
+		}
+	}
+	if unparsable > 0 {
+		fmt.Printf("%d queries could not be parsed and are not included\n", unparsable)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// QueryLimits are the limits of the Prometheus server that abort queries: --query.timeout and
+// --query.max-samples. Zero disables a limit.
+type QueryLimits struct {
+	Timeout    time.Duration
+	MaxSamples int
+	// Ratio is the share of a limit above which an execution is reported as approaching it
+	Ratio float64
+}
+
+var queryLimits QueryLimits
+
+func init() {
+	flag.DurationVar(&queryLimits.Timeout, "query-timeout", 0, "the --query.timeout of the Prometheus server. Reports queries whose execution time approaches it. 0 disables")
+	flag.IntVar(&queryLimits.MaxSamples, "max-samples", 0, "the --query.max-samples of the Prometheus server. Reports queries whose peak samples approach it. 0 disables")
+	flag.Float64Var(&queryLimits.Ratio, "limit-ratio", 0.8, "share of -query-timeout or -max-samples above which an execution is reported as approaching the limit")
+}
+
+func (l QueryLimits) Enabled() bool {
+	return l.Timeout > 0 || l.MaxSamples > 0
+}
+
+func (l QueryLimits) Validate() error {
+	if l.Timeout < 0 || l.MaxSamples < 0 {
+		return fmt.Errorf("-query-timeout and -max-samples must not be negative")
+	}
+	if l.Ratio <= 0 || l.Ratio > 1 {
+		return fmt.Errorf("-limit-ratio must be greater than 0 and at most 1")
+	}
+	return nil
+}
+
+// Usage returns the highest share of a limit used by the execution.
+func (l QueryLimits) Usage(e *querystats.LogEntry) float64 {
+	var usage float64
+	if l.Timeout > 0 {
+		usage = e.Stats.Timings.ExecTotalTime / l.Timeout.Seconds()
+	}
+	if l.MaxSamples > 0 {
+		usage = max(usage, float64(e.Stats.Samples.PeakSamples)/float64(l.MaxSamples))
+	}
+	return usage
+}
+
+// PrintLimitRisks prints queries with executions above the ratio of the query timeout or the sample limit,
+// ordered by the highest share of a limit any execution used. Such queries fail once their data grows a little.
+func PrintLimitRisks(queries []*querystats.Query, top int, limits QueryLimits) {
+	type row struct {
+		query *querystats.Query
+		// near is the number of executions above the ratio
+		near             int
+		maxExec, maxPeak float64
+		usage            float64
+	}
+	var rows []row
+	for _, q := range queries {
+		r := row{query: q}
+		for _, log := range q.Logs {
+			usage := limits.Usage(log)
+			if usage >= limits.Ratio {
+				r.near++
+			}
+			r.usage = max(r.usage, usage)
+			r.maxExec = max(r.maxExec, log.Stats.Timings.ExecTotalTime)
+			r.maxPeak = max(r.maxPeak, float64(log.Stats.Samples.PeakSamples))
+		}
+		if r.near > 0 {
+			rows = append(rows, r)
+		}
+	}
+	var names []string
+	if limits.Timeout > 0 {
+		names = append(names, fmt.Sprintf("-query-timeout=%s", limits.Timeout))
+	}
+	if limits.MaxSamples > 0 {
+		names = append(names, fmt.Sprintf("-max-samples=%d", limits.MaxSamples))
+	}
+	if len(rows) == 0 {
+		fmt.Printf("No queries above %.0f%% of %s\n", 100*limits.Ratio, joinOr(names))
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].usage > rows[j].usage })
+	top = min(top, len(rows))
+
+	fmt.Printf("Top %d of %d queries above %.0f%% of %s:\n", top, len(rows), 100*limits.Ratio, joinOr(names))
+	for i, r := range rows[:top] {
+		flag := " "
+		if r.usage >= 1 {
+			flag = "!"
+		}
+		fmt.Printf("%2d)%s n=%-6d near=%-5d max_usage=%5.1f%%", i+1, flag, len(r.query.Logs), r.near, 100*r.usage)
+		if limits.Timeout > 0 {
+			fmt.Printf(" max_exec=%.3fs", r.maxExec)
+		}
+		if limits.MaxSamples > 0 {
+			fmt.Printf(" max_peak=%.0f", r.maxPeak)
+		}
+		fmt.Printf(" %s", queryWithID(r.query.Query))
+		printRuleName(r.query)
+		fmt.Println()
+	}
+}
+
+// joinOr joins the names of limits with "or".
+func joinOr(names []string) string {
+	if len(names) == 2 {
+		return names[0] + " or " + names[1]
+	}
+	return names[0]
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// QueryLinks builds links to UIs where a query can be explored interactively.
+// Links are only built for the UIs whose base URL is set.
+type QueryLinks struct {
+	PrometheusURL string
+	GrafanaURL    string
+	// GrafanaDatasource is the UID of the Prometheus datasource used in Grafana Explore
+	GrafanaDatasource string
+}
+
+// Prometheus returns a link to the graph page of the Prometheus server pre-filled with the query,
+// ending at the time of its worst execution.
+func (l QueryLinks) Prometheus(q *querystats.Query) string {
+	if l.PrometheusURL == "" {
+		return ""
+	}
+	ts := q.MaxExecTotalTimeEntry.TS.UTC().Format(time.DateTime)
+	params := url.Values{
+		"g0.expr":         {q.Query},
+		"g0.tab":          {"0"},
+		"g0.range_input":  {"1h"},
+		"g0.end_input":    {ts},
+		"g0.moment_input": {ts},
+	}
+	return strings.TrimRight(l.PrometheusURL, "/") + "/graph?" + params.Encode()
+}
+
+// Grafana returns a link to Grafana Explore pre-filled with the query over the hour before its worst execution.
+func (l QueryLinks) Grafana(q *querystats.Query) string {
+	if l.GrafanaURL == "" {
+		return ""
+	}
+	to := q.MaxExecTotalTimeEntry.TS.UnixMilli()
+	type datasource struct {
+		Type string `json:"type"`
+		UID  string `json:"uid,omitempty"`
+	}
+	type query struct {
+		RefID      string     `json:"refId"`
+		Expr       string     `json:"expr"`
+		Datasource datasource `json:"datasource"`
+	}
+	type pane struct {
+		Datasource string            `json:"datasource,omitempty"`
+		Queries    []query           `json:"queries"`
+		Range      map[string]string `json:"range"`
+	}
+	panes, _ := json.Marshal(map[string]pane{"a": {
+		Datasource: l.GrafanaDatasource,
+		Queries:    []query{{"A", q.Query, datasource{"prometheus", l.GrafanaDatasource}}},
+		Range: map[string]string{
+			"from": strconv.FormatInt(to-time.Hour.Milliseconds(), 10),
+			"to":   strconv.FormatInt(to, 10),
+		},
+	}})
+	params := url.Values{"schemaVersion": {"1"}, "panes": {string(panes)}}
+	return strings.TrimRight(l.GrafanaURL, "/") + "/explore?" + params.Encode()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// lintRule is a PromQL anti-pattern the lint subcommand looks for.
+type lintRule struct {
+	name string
+	// why explains what makes the pattern expensive
+	why string
+	// check returns a description of each occurrence of the pattern in the node
+	check func(node parser.Node, opts lintOptions) []string
+}
+
+type lintOptions struct {
+	minSubqueryStep time.Duration
+}
+
+var lintRules = []lintRule{
+	{"name-regex", "selecting series by a regular expression on the metric name matches it against every metric name in the index", lintNameRegex},
+	{"unanchored-regex", "a regular expression starting with a wildcard can't use the index to narrow down the label values it is matched against", lintUnanchoredRegex},
+	{"counter-without-rate", "aggregating the raw value of a counter sums numbers that only ever grow and reset, rate() or increase() is almost always intended", lintCounterWithoutRate},
+	{"tiny-subquery-step", "a subquery with a small resolution evaluates its inner query at many steps for every step of the outer query", lintTinySubqueryStep},
+}
+
+func lintNameRegex(node parser.Node, _ lintOptions) []string {
+	vs, ok := node.(*parser.VectorSelector)
+	if !ok {
+		return nil
+	}
+	var found []string
+	for _, m := range vs.LabelMatchers {
+		if m.Name == labels.MetricName && (m.Type == labels.MatchRegexp || m.Type == labels.MatchNotRegexp) {
+			found = append(found, m.String())
+		}
+	}
+	return found
+}
+
+func lintUnanchoredRegex(node parser.Node, _ lintOptions) []string {
+	vs, ok := node.(*parser.VectorSelector)
+	if !ok {
+		return nil
+	}
+	var found []string
+	for _, m := range vs.LabelMatchers {
+		// the metric name is reported by name-regex
+		if m.Name == labels.MetricName || (m.Type != labels.MatchRegexp && m.Type != labels.MatchNotRegexp) {
+			continue
+		}
+		if strings.HasPrefix(m.Value, ".*") || strings.HasPrefix(m.Value, ".+") {
+			found = append(found, m.String())
+		}
+	}
+	return found
+}
+
+func lintCounterWithoutRate(node parser.Node, _ lintOptions) []string {
+	agg, ok := node.(*parser.AggregateExpr)
+	if !ok {
+		return nil
+	}
+	expr := agg.Expr
+	for {
+		paren, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.Expr
+	}
+	vs, ok := expr.(*parser.VectorSelector)
+	if !ok || !strings.HasSuffix(vs.Name, "_total") {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s(%s)", agg.Op, vs.Name)}
+}
+
+func lintTinySubqueryStep(node parser.Node, opts lintOptions) []string {
+	sq, ok := node.(*parser.SubqueryExpr)
+	// without a step the subquery uses the global evaluation interval, which isn't logged
+	if !ok || sq.Step <= 0 || sq.Step >= opts.minSubqueryStep {
+		return nil
+	}
+	return []string{fmt.Sprintf("[%s:%s], %d steps", sq.Range, sq.Step, int(sq.Range/sq.Step))}
+}
+
+// lintFinding is an anti-pattern found in a query.
+type lintFinding struct {
+	rule    *lintRule
+	query   *querystats.Query
+	details []string
+}
+
+// LintQueries parses every distinct query and returns the anti-patterns found in them and the number of queries
+// that failed to parse.
+func LintQueries(queries []*querystats.Query, opts lintOptions) ([]lintFinding, int) {
+	var findings []lintFinding
+	unparsable := 0
+	for _, q := range queries {
+		expr, err := parser.ParseExpr(q.Query)
+		if err != nil {
+			unparsable++
+			continue
+		}
+		for i := range lintRules {
+			rule := &lintRules[i]
+			var details []string
+			parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+				details = append(details, rule.check(node, opts)...)
+				return nil
+			})
+			if len(details) > 0 {
+				findings = append(findings, lintFinding{rule, q, details})
+			}
+		}
+	}
+	return findings, unparsable
+}
+
+// PrintLintReport prints the findings per rule, the most expensive queries first.
+func PrintLintReport(findings []lintFinding, queries []*querystats.Query, unparsable, top int) {
+	offending := make(map[*querystats.Query]bool)
+	for _, f := range findings {
+		offending[f.query] = true
+	}
+	fmt.Printf("PromQL lint (parser %s): %d findings in %d of %d queries", promqlParserVersion(), len(findings), len(offending), len(queries))
+	if unparsable > 0 {
+		fmt.Printf(", %d queries failed to parse", unparsable)
+	}
+	fmt.Println()
+
+	for i := range lintRules {
+		rule := &lintRules[i]
+		var rows []lintFinding
+		for _, f := range findings {
+			if f.rule == rule {
+				rows = append(rows, f)
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].query.SumExecTotalTime > rows[j].query.SumExecTotalTime })
+		fmt.Println()
+		fmt.Printf("%s: %d queries\n", rule.name, len(rows))
+		fmt.Printf("    %s\n", rule.why)
+		for j, f := range rows[:min(top, len(rows))] {
+			q := f.query
+			fmt.Printf("%2d) n=%-6d total=%.3fs avg=%.3fs max_peak=%-10d %s", j+1, len(q.Logs), q.SumExecTotalTime,
+				q.AvgExecTotalTime, q.MaxPeakSamplesEntry.Stats.Samples.PeakSamples, queryWithID(q.Query))
+			printRuleName(q)
+			fmt.Println()
+			fmt.Printf("    %s\n", escapeTerminal(strings.Join(f.details, ", ")))
+		}
+	}
+}
+
+// runLint implements the lint subcommand flagging PromQL anti-patterns in the logged queries, annotated with how
+// expensive the offending queries actually were.
+func runLint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	var from, to timeFlag
+	fs.Var(&from, "from", "load log entries after this time. Accepts the same formats as -from of analyze")
+	fs.Var(&to, "to", "load log entries until this time")
+	top := fs.Int("n", 10, "number of offending queries to display per rule")
+	match := fs.String("query-match", "", "lint only queries matching this regular expression")
+	minSubqueryStep := fs.Duration("min-subquery-step", time.Minute, "flag subqueries with an explicit resolution below this")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lint [flags] [file...]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Flags PromQL anti-patterns in the logged queries:")
+		for _, rule := range lintRules {
+			fmt.Fprintf(fs.Output(), "  %-20s %s\n", rule.name, rule.why)
+		}
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files. Reads stdin if none is given")
+		fs.PrintDefaults()
+	}
+	files := parseArgs(fs, args)
+	if *top <= 0 {
+		fatal("-n must be positive")
+	}
+	opts := querystats.LoadOptions{
+		From:            from.Time,
+		To:              to.Time,
+		MaxEntrySize:    querystats.DefaultMaxEntrySize,
+		PercentileRanks: queryPercentileRanks,
+		Jobs:            *jobs,
+		Log:             logLoad,
+	}
+	var err error
+	if *match != "" {
+		if opts.QueryMatch, err = regexp.Compile(*match); err != nil {
+			fatalf("Invalid -query-match value: %s", err)
+		}
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if files, err = ExpandInputs(files); err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	defer closeInput()
+	queries, _, err := querystats.LoadQueriesFromLog(input, opts)
+	if err != nil {
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	if len(queries) == 0 {
+		fatal("Loaded 0 queries")
+	}
+
+	findings, unparsable := LintQueries(queries, lintOptions{minSubqueryStep: *minSubqueryStep})
+	PrintLintReport(findings, queries, unparsable, *top)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// maxAcceptDelay is the longest wait before accepting again after failing to accept a connection, e.g. while out
+// of file descriptors. The wait starts at 5ms and doubles with each failure in a row, like in net/http.
+const maxAcceptDelay = time.Second
+
+// activationListener returns the listening socket passed by systemd socket activation, or nil if there is none.
+func activationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	return net.FileListener(os.NewFile(listenFDsStart, "LISTEN_FD_3"))
+}
+
+// listenAddr opens the listener of addr, which is a TCP address or a Unix socket path prefixed with "unix:".
+func listenAddr(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// analyzeConn reads a query log from the connection until the client closes its side for writing and
+// answers with the JSON report, or a JSON object with an error.
+func analyzeConn(conn net.Conn, opts querystats.LoadOptions, top, perc int, timeout time.Duration) error {
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	report, err := func() (*Report, error) {
+		queries, logs, err := querystats.LoadQueriesFromLog(conn, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(queries) == 0 {
+			return nil, fmt.Errorf("loaded 0 queries")
+		}
+		sort.Sort(querystats.ByTime{LogEntries: logs})
+		return BuildReport(queries, logs, querystats.LoadStats{}, top, []int{perc}, QueryLinks{})
+	}()
+	if err != nil {
+		// drain the rest of the log, so the client doesn't get a reset before reading the error
+		io.Copy(io.Discard, conn)
+		return json.NewEncoder(conn).Encode(map[string]string{"error": err.Error()})
+	}
+	return WriteJSONReport(conn, report)
+}
+
+// runListen implements the listen subcommand analyzing query logs sent over a socket.
+func runListen(args []string) {
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	addr := fs.String("listen", "127.0.0.1:9418", "TCP address to listen on, or the path of a Unix socket prefixed with 'unix:'. Ignored if a socket is passed by systemd socket activation")
+	once := fs.Bool("once", false, "exit after answering the first connection")
+	maxConns := fs.Int("max-connections", 4, "maximum number of connections analyzed at the same time, each holding its query log in memory. Further connections wait")
+	timeout := fs.Duration("timeout", 5*time.Minute, "maximum time to receive a query log and answer. 0 means no limit")
+	top := fs.Int("top", 10, "number of queries in the tables of the report")
+	perc := fs.Int("p", 95, "percentile rank")
+	normalize := fs.String("normalize", "", "comma-separated list of normalizations applied to queries before grouping: whitespace, case, matchers, fingerprint")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s listen [flags]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Reads a query log from each connection until the client stops sending and writes the JSON report back, e.g.")
+		fmt.Fprintln(fs.Output(), "  nc -N host 9418 < query.log > report.json")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *perc <= 0 || *perc > 100 {
+		fatal("The percentile rank does not make sense. Must be between 0 and 100")
+	}
+	if *maxConns <= 0 {
+		fatal("-max-connections must be positive")
+	}
+	normalizer, err := querystats.ParseNormalizer(*normalize)
+	if err != nil {
+		fatalf("Invalid -normalize value: %s", err)
+	}
+	opts := querystats.LoadOptions{
+		Normalizer:      normalizer,
+		MaxEntrySize:    querystats.DefaultMaxEntrySize,
+		PercentileRanks: queryPercentileRanks,
+		Log:             logLoad,
+	}
+
+	listener, err := activationListener()
+	if err != nil {
+		fatalf("Failed to use the activated socket: %s", err)
+	}
+	if listener == nil {
+		if listener, err = listenAddr(*addr); err != nil {
+			fatalf("Failed to listen: %s", err)
+		}
+	}
+	defer listener.Close()
+	slog.Info("Listening", "address", listener.Addr())
+
+	// slots limits the connections being analyzed, further ones wait in the backlog of the listener
+	slots := make(chan struct{}, *maxConns)
+	var delay time.Duration
+	for id := 1; ; id++ {
+		slots <- struct{}{}
+		conn, err := listener.Accept()
+		if err != nil {
+			<-slots
+			if errors.Is(err, net.ErrClosed) {
+				fatalf("Failed to accept a connection: %s", err)
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+			slog.Warn("Failed to accept a connection, retrying", "err", err, "delay", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		handle := func() {
+			defer func() { <-slots }()
+			start := time.Now()
+			if err := analyzeConn(conn, opts, *top, *perc, *timeout); err != nil {
+				slog.Warn("Failed to answer a connection", "connection", id, "err", err)
+				return
+			}
+			slog.Debug("Answered a connection", "connection", id, "remote", conn.RemoteAddr(), "duration", time.Since(start).Round(time.Millisecond))
+		}
+		if *once {
+			handle()
+			return
+		}
+		go handle()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logFlags configure the log written to stderr. Every command has them, so that the report on stdout can be piped
+// into other tools while progress and warnings go to a log collector or are silenced.
+type logFlags struct {
+	verbose bool
+	quiet   bool
+	format  string
+}
+
+// logRedraws is unset by -q and -log-format json, so that -progress auto doesn't redraw the progress in place on
+// stderr, between the messages of the log.
+var logRedraws = true
+
+// addLogFlags registers -v, -q and -log-format in fs.
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	f := &logFlags{}
+	fs.BoolVar(&f.verbose, "v", false, "also log debug messages, e.g. about caches and answered connections")
+	fs.BoolVar(&f.quiet, "q", false, "log only warnings and errors")
+	fs.StringVar(&f.format, "log-format", "text", "format of the log written to stderr: text, or json with a JSON object per message")
+	return f
+}
+
+// setup configures the default logger, which the log package writes through too. It exits on invalid flags like
+// the flag package.
+func (f *logFlags) setup(fs *flag.FlagSet) {
+	level := slog.LevelInfo
+	switch {
+	case f.verbose && f.quiet:
+		fmt.Fprintln(fs.Output(), "-v and -q can't be combined")
+		os.Exit(2)
+	case f.verbose:
+		level = slog.LevelDebug
+	case f.quiet:
+		level = slog.LevelWarn
+		logRedraws = false
+	}
+	switch f.format {
+	case "text":
+		// the default handler writes through the log package, keeping its familiar timestamps
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		logRedraws = false
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		fmt.Fprintf(fs.Output(), "invalid -log-format %q, must be text or json\n", f.format)
+		os.Exit(2)
+	}
+}
+
+// parseFlags parses the flags of fs, which has no positional arguments, with the flags of addLogFlags and sets up
+// logging.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	logging := addLogFlags(fs)
+	fs.Parse(args)
+	logging.setup(fs)
+}
+
+// onFatal are called by fatal and fatalf before exiting, e.g. to remove the temporary file of -out.
+var onFatal []func()
+
+// logLoad logs what loading the query log skipped or changed, passed as querystats.LoadOptions.Log.
+func logLoad(level slog.Level, msg string, args ...any) {
+	slog.Log(context.Background(), level, msg, args...)
+}
+
+// fatal logs its operands, formatted like fmt.Sprintln, at the error level and exits with status 1.
+func fatal(v ...any) {
+	slog.Error(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+	exitFatal()
+}
+
+// fatalf logs the message at the error level and exits with status 1.
+func fatalf(format string, v ...any) {
+	slog.Error(fmt.Sprintf(format, v...))
+	exitFatal()
+}
+
+func exitFatal() {
+	for _, fn := range onFatal {
+		fn()
+	}
+	os.Exit(1)
+}
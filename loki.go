@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultLokiRange is the range read from Loki when -from is not set, the same as Loki's own default.
+const defaultLokiRange = time.Hour
+
+// lokiConfig holds the -loki-* flags.
+type lokiConfig struct {
+	URL   string
+	Query string
+	OrgID string
+	// Limit is the number of lines requested per page. Loki rejects limits above its max_entries_limit_per_query
+	Limit int
+}
+
+var lokiSettings lokiConfig
+
+func init() {
+	flag.StringVar(&lokiSettings.URL, "loki-url", "", "base URL of Loki to read the query log from instead of files, e.g. http://loki:3100. Requires -loki-query. -from and -to select the range, which defaults to the last hour")
+	flag.StringVar(&lokiSettings.Query, "loki-query", "", `LogQL query selecting the query log lines in Loki, e.g. '{job="prometheus", filename="/prometheus/query.log"}'`)
+	flag.StringVar(&lokiSettings.OrgID, "loki-org-id", "", "tenant ID sent as the X-Scope-OrgID header to a multi-tenant Loki")
+	flag.IntVar(&lokiSettings.Limit, "loki-limit", 5000, "number of lines requested from Loki per page. Must not exceed Loki's max_entries_limit_per_query")
+}
+
+// Enabled reports whether the query log is read from Loki.
+func (c lokiConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// Validate checks the flags.
+func (c lokiConfig) Validate() error {
+	if !c.Enabled() {
+		if c.Query != "" {
+			return fmt.Errorf("-loki-query requires -loki-url")
+		}
+		return nil
+	}
+	if c.Query == "" {
+		return fmt.Errorf("-loki-url requires -loki-query")
+	}
+	if _, err := url.Parse(c.URL); err != nil {
+		return fmt.Errorf("-loki-url: %w", err)
+	}
+	if c.Limit <= 0 {
+		return fmt.Errorf("-loki-limit must be positive")
+	}
+	return nil
+}
+
+// Source names the query log in Loki, e.g. in artifacts and the run metadata.
+func (c lokiConfig) Source() string {
+	return c.URL + " " + c.Query
+}
+
+// lokiStreams is the data of a Loki query_range response of the streams result type.
+type lokiStreams struct {
+	ResultType string `json:"resultType"`
+	Result     []struct {
+		Values [][2]string `json:"values"`
+	} `json:"result"`
+}
+
+type lokiLine struct {
+	ts   int64
+	line string
+}
+
+// queryRange reads one page of at most limit lines from start, inclusive, until end, exclusive, ordered by time.
+func (c lokiConfig) queryRange(ctx context.Context, client *PromClient, start, end int64) ([]lokiLine, error) {
+	params := url.Values{}
+	params.Set("query", c.Query)
+	params.Set("start", strconv.FormatInt(start, 10))
+	params.Set("end", strconv.FormatInt(end, 10))
+	params.Set("limit", strconv.Itoa(c.Limit))
+	params.Set("direction", "forward")
+	var data lokiStreams
+	if err := client.Get(ctx, "/loki/api/v1/query_range", params, &data); err != nil {
+		return nil, err
+	}
+	if data.ResultType != "streams" {
+		return nil, fmt.Errorf("the LogQL query returned %s instead of log lines", data.ResultType)
+	}
+	var lines []lokiLine
+	for _, stream := range data.Result {
+		for _, v := range stream.Values {
+			ts, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q: %w", v[0], err)
+			}
+			lines = append(lines, lokiLine{ts, v[1]})
+		}
+	}
+	// pages are limited across all streams, which are merged by time
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].ts < lines[j].ts })
+	return lines, nil
+}
+
+// Read calls fn with each line of the query log in Loki between from and to, inclusive. It pages through the range
+// by starting each page at the time of the last line of the previous one, skipping the lines already read at that
+// time, so lines sharing a timestamp across pages are neither lost nor duplicated.
+func (c lokiConfig) Read(ctx context.Context, from, to time.Time, fn func(line string) error) error {
+	client := NewPromClient(c.URL)
+	if c.OrgID != "" {
+		client.Header = http.Header{"X-Scope-OrgID": {c.OrgID}}
+	}
+	start, end := from.UnixNano(), to.UnixNano()+1
+	// seen are the lines already read at the start of the page
+	seen := make(map[string]int)
+	for pages := 1; ; pages++ {
+		lines, err := c.queryRange(ctx, client, start, end)
+		if err != nil {
+			return err
+		}
+		for _, l := range lines {
+			if l.ts == start && seen[l.line] > 0 {
+				seen[l.line]--
+				continue
+			}
+			if err := fn(l.line); err != nil {
+				return err
+			}
+		}
+		if len(lines) < c.Limit {
+			slog.Debug("Read pages from Loki", "pages", pages)
+			return nil
+		}
+		last := lines[len(lines)-1].ts
+		if last == start {
+			return fmt.Errorf("more than %d lines at %s, increase -loki-limit", c.Limit, time.Unix(0, start).UTC().Format(time.RFC3339Nano))
+		}
+		clear(seen)
+		for _, l := range lines {
+			if l.ts == last {
+				seen[l.line]++
+			}
+		}
+		start = last
+	}
+}
+
+// Open returns a reader of the query log in Loki between from and to. from defaults to an hour before to and to
+// to now. Lines are fetched in the background as the reader is read.
+func (c lokiConfig) Open(ctx context.Context, from, to *time.Time) io.Reader {
+	end := now
+	if to != nil {
+		end = *to
+	}
+	start := end.Add(-defaultLokiRange)
+	if from != nil {
+		start = *from
+	}
+	slog.Info("Reading the query log from Loki", "url", c.URL, "from", start.Format(time.RFC3339), "to", end.Format(time.RFC3339))
+
+	d := &inputDigest{name: c.Source(), hash: sha256.New()}
+	openedInputs = append(openedInputs, d)
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(c.Read(ctx, start, end, func(line string) error {
+			_, err := io.WriteString(w, line+"\n")
+			return err
+		}))
+	}()
+	return io.TeeReader(r, d)
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"regexp"
+	"runtime"
 	"runtime/debug"
 	"sort"
+	"strings"
+	"syscall"
 	"time"
 	"slices"
-	"math"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
 )
 
 type timeFlag struct {
@@ -26,390 +32,1033 @@ func (t *timeFlag) String() string {
 	return t.Time.Format(time.RFC3339)
 }
 
+// Set accepts RFC3339 timestamps, dates such as 2024-05-01 (midnight UTC), "now" and durations relative to now such as -6h.
 func (t *timeFlag) Set(value string) error {
-	time, err := time.Parse(time.RFC3339, value)
+	if value == "now" {
+		t.Time = &now
+		return nil
+	}
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		ts := now.Add(d)
+		t.Time = &ts
+		return nil
+	}
+	layout := time.RFC3339
+	if len(value) == len(time.DateOnly) {
+		layout = time.DateOnly
+	}
+	ts, err := time.Parse(layout, value)
 	if err != nil {
 		return err
 	}
-	t.Time = &time
+	t.Time = &ts
 	return nil
 }
 
 var (
 	now = time.Now()
-	argFile = flag.String("f", "-", "path to the query log file. Pass '-' to read from stdin")
+	argFiles fileList
 	argFrom timeFlag
 	argTo timeFlag
+	argDataFrom timeFlag
+	argDataTo timeFlag
 	argTop = flag.Int("top", 10, "number of top queries to display")
 	argVer = flag.Bool("version", false, "show version")
-	argPerc = flag.Int("p", 95, "percentile rank")
+	argOutput = flag.String("o", "text", "output format: text, json, html, markdown, csv, tsv, arrow or artifact. json writes the whole analysis as a single JSON document. html writes it as a self-contained page with sortable tables and charts. markdown writes the summary and the top tables as GitHub-flavored Markdown tables, e.g. for pull requests and chat. csv and tsv write a row of statistics per distinct query. arrow writes all entries as an Arrow IPC (Feather) file to stdout. artifact writes a mergeable summary for the merge subcommand")
+	argNormalize = flag.String("normalize", "", "comma-separated list of normalizations applied to queries before grouping: whitespace, case, matchers, fingerprint. fingerprint parses queries and replaces literals and label values with placeholders, so queries differing only in injected values are grouped. Without -normalize queries are grouped by their raw text")
+	argStrict = flag.Bool("strict", false, "abort if a query fails validation instead of skipping its entries")
+	argWasmPlugin = flag.String("wasm-plugin", "", "path to a WebAssembly module converting log lines of another format to the Prometheus query log format")
+	argExplain = flag.Bool("explain-metrics", false, "append an explanation of the reported metrics to the text and html reports")
+	argSnapshot = flag.String("snapshot", "", "save the loaded entries to this file, so they can be restored with -restore")
+	argRestore = flag.String("restore", "", "restore entries from a snapshot file and read the query log from where the snapshot left off. Restored entries are subject to the same filters")
+	argIrregularity = flag.Float64("irregularity-threshold", 0.5, "flag rule queries whose execution interval regularity score (stdev of gaps / mean gap) is above this value")
+	argAlertmanager = flag.String("alertmanager-url", "", "Alertmanager base URL. Correlates spikes in alerting rule evaluation time with the active alerts starting to fire")
+	argAlertBucket = flag.Duration("alert-bucket", 5*time.Minute, "bucket size of the alerting rule evaluation time vs. alerts timeline, a whole number of seconds")
+	argColumns = flag.String("columns", "", "comma-separated list of columns shown in the top tables: n, avg, tavg (time-weighted average, see -time-weight-bucket), trate (time-weighted rate per second), max, sum, pNN (e.g. p95), t (time of the max), cost, trend, spark (see -sparklines), phase (the dominant timing phase), rule, id (for -query-id and the show and compare subcommands), query")
+	argKeepZeroTimings = flag.Bool("keep-zero-timings", false, "keep entries whose timings are all zero. They are excluded by default, so they don't dilute averages")
+	argMegaSelectors = flag.Int("mega-query-selectors", 100, "flag queries with more selectors than this as mega-queries in the query size report")
+	argMegaLength = flag.Int("mega-query-length", 10000, "flag queries longer than this as mega-queries in the query size report")
+	argServeStdio = flag.Bool("serve-stdio", false, "serve JSON-RPC 2.0 requests on stdin/stdout over the loaded entries instead of printing a report. Requires a query log file")
+	argValidateSyntax = flag.Bool("validate-syntax", false, "parse all queries with the PromQL parser and report those that fail")
+	argPrometheusURL = flag.String("prometheus-url", "", "base URL of the Prometheus server the query log comes from. Used to fetch label cardinality for -cardinality-hints, series counts for -series-cardinality and to link queries to its graph UI in reports")
+	argCardinalityHints = flag.Bool("cardinality-hints", false, "report labels matched by the top queries, with the number of their values if -prometheus-url is set")
+	argSeriesCardinality = flag.Bool("series-cardinality", false, "report how many series the selectors of the top queries match now on the Prometheus server of -prometheus-url, next to the samples they loaded")
+	argPrevious = flag.String("previous", "", "path to a report written earlier with -o json. Annotates rows of the top tables with the change since then: ▲/▼ and a percentage, or 'new'")
+	argMetricLoad = flag.Bool("metric-load", false, "report the metric names whose queries account for the most execution time and queryable samples. Metric names are extracted with the PromQL parser")
+	argRuleKinds = flag.Bool("rule-kinds", false, "report the load of alerting rules, recording rules and queries from the HTTP API. See -rules-dir")
+	argLabelValues = flag.Bool("label-values", false, "report the labels most often selected by literal values in matchers and their most queried values, e.g. the namespaces or instances users actually look at")
+	argMetricFamilies = flag.Bool("metric-families", false, "report the engine load per metric family. Metric names are rolled up by the prefix before the first underscore unless matched by -family-rollups")
+	argFamilyRollups = flag.String("family-rollups", "", "comma-separated list of metric name rollups for -metric-families: a pattern such as 'kube_*', or '<family>=<pattern>' such as 'cadvisor=container_*'. The first matching rollup wins")
+	argStream = flag.Bool("stream", false, "summarize the query log in a single pass with memory bounded by the number of distinct queries instead of loading all entries. Percentiles are estimated. Only the top tables are printed and -o must be text or artifact")
+	argSummation = flag.String("summation", "compensated", "how sums and averages over entries are computed: compensated, whose rounding error doesn't grow with the number of entries, or naive, which is slightly faster and matches tools summing naively")
+	argSpillAfter = flag.Int("spill-after", 0, "with -stream, compute the global percentiles exactly by sorting the values on disk, keeping at most this many of each metric in memory. 0 estimates them like the other percentiles")
+	argMaxEntrySize = flag.Int("max-entry-size", querystats.DefaultMaxEntrySize, "alias of -max-line-bytes")
+	argMaxQueryLength = flag.Int("max-query-length", 0, "truncate queries longer than this many bytes before grouping. Truncated queries end with '...'. 0 means no limit")
+	argMaxQueries = flag.Int("max-queries", 0, "keep at most this many distinct queries, in order of appearance. Entries of further queries are dropped. 0 means no limit")
+	argQueryMatch = flag.String("query-match", "", "analyze only entries whose query matches this regular expression, e.g. 'node_.*'. The expression is not anchored")
+	argQueryExclude = flag.String("query-exclude", "", "skip entries whose query matches this regular expression. The expression is not anchored")
+	argQueryID = flag.String("query-id", "", "comma-separated list of query ids, see the id column, to analyze only entries of. Queries differing only in literals share an id")
+	argHistoryFile = flag.String("history-file", "", "append a summary of this run to this file, one JSON object per line, and print the query load of the last -top runs")
+	argGroupBy = flag.String("group-by", "query", "what the text report aggregates entries by: query, rulegroup to print total and average evaluation time, samples and number of expressions per rule group instead of the query tables, client or path to print them per client IP or request path of the HTTP API, or instance to print the query tables per Prometheus server of inputs labeled with name=path")
+	argGrafanaURL = flag.String("grafana-url", "", "base URL of Grafana. Links queries to Grafana Explore in reports")
+	argGrafanaDatasource = flag.String("grafana-datasource", "", "UID of the Prometheus datasource used in Grafana Explore links. The default datasource is used if empty")
+	argRulesDir = flag.String("rules-dir", "", "directory with the Prometheus rule files. Used to tell alerting and recording rules apart, which is otherwise guessed from rule group names and query shapes, and for the evaluation intervals of rule groups")
+	argReport = flag.String("report", "", "comma-separated list of sections of the text report in the order they are printed. Tables are named <kind>-<metric>, e.g. avg-exec, max-samples, p99-exec or sum-cost, where kind is avg, max, min, sum, stddev, median or pNN and metric is exec, samples, peak, points, range, step, queue, prep, eval, sort or cost. percentile-<metric> prints the -p percentiles and the min, median, average, standard deviation and max over all entries, percentiles all of them. Append :asc or :desc to override -sort for a table. Defaults to the percentiles and the avg, max and sum tables of each metric")
+	argSort = flag.String("sort", "desc", "order of the top tables: desc ranks the highest values first, asc the lowest")
+	argSkipErrors = flag.Bool("skip-errors", false, "skip and count lines that can't be parsed instead of aborting, e.g. when the query log is mixed with other output")
+	argSkipNoise = flag.Bool("skip-noise", false, "skip and count lines that are not query log entries, e.g. startup logs and shell prompts when piping kubectl logs output mixed with the query log. Unlike -skip-errors, lines that look like query log entries but can't be parsed still abort")
+	argMaxErrors = flag.Int("max-errors", 0, "abort if -skip-errors skips more than this many lines. 0 means no limit")
+	argMetric = flag.String("metric", "exec,samples,peak", "comma-separated list of metrics the text report prints percentiles and top tables of when -report is not set: exec, samples, peak, points, cost or the execution phases queue (execQueueTime), prep (queryPreparationTime), eval (innerEvalTime) and sort (resultSortTime)")
+	argFormat = flag.String("format", "prometheus", "format of the query log: prometheus for --query.log-file, thanos for the slow query log of the Thanos query-frontend or mimir for the query stats of the Mimir query-frontend and the slow query log of Cortex, in logfmt or JSON, or victoriametrics for the slow query stats of VictoriaMetrics. Other lines of their logs are skipped")
+	argTUI = flag.Bool("tui", false, "browse the queries in an interactive terminal UI instead of printing a report: a table sortable by each column, filtered by substring or regular expression as you type, and the executions of the selected query")
+	argJobs = flag.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel. 1 decodes on a single goroutine")
+	argMinExecTime = flag.Duration("min-exec-time", 0, "load only entries that took at least this long, e.g. 1s, to analyze the expensive tail of a large log faster. All statistics then describe only these entries")
+	argMinSamples = flag.Int("min-samples", 0, "load only entries that read at least this many total queryable samples. All statistics then describe only these entries")
+	argType = flag.String("type", "", "analyze only instant or range queries. Range queries are the entries with a step")
+	argClientIP = flag.String("client-ip", "", "analyze only entries received over the HTTP API from these clients, a comma-separated list of IP addresses and CIDR prefixes, e.g. 10.0.0.5,10.8.0.0/16")
+	argPathMatch = flag.String("path-match", "", "analyze only entries received over the HTTP API whose request path matches this regular expression, e.g. 'query_range$'")
+	argSampleRate = flag.Float64("sample-rate", 1, "analyze each line of the query log with this probability, e.g. 0.1, skipping the others before they are parsed for a fast first pass over enormous logs. Averages and percentiles are estimates, counts and totals cover the sample only")
+	argSampleSize = flag.Int("sample-size", 0, "analyze a uniform random sample of at most this many entries accepted by the filters, by reservoir sampling. 0 analyzes all entries")
+	argChargeback = flag.String("chargeback", "", "path to a team mapping file with lines of the form '<rule_file|rule_group|namespace|tenant> <pattern> <team>', where the tenant is the label of an input labeled with name=path. Prints a chargeback report per team and day")
+	argChargebackCSV = flag.String("chargeback-csv", "", "write the chargeback report as CSV to this file. Requires -chargeback")
 )
 
 func init() {
-	flag.Var(&argFrom, "from", "load log entries afer this time. Accepts RFC3339 format, e.g. " + now.UTC().Format(time.RFC3339))
-	flag.Var(&argTo, "to", "load log entries until this time. Accepts RFC3339 format, e.g. " + now.UTC().Format(time.RFC3339))
+	flag.Var(&argFiles, "f", "path to a query log file, a directory of them or a glob pattern. Rotated logs in a directory, e.g. query.log.1 or query.log.2.gz, are read oldest first. Can be repeated to analyze several files together. s3://, gs:// and http(s):// URLs of remote objects are streamed. Files ending with .gz are decompressed. Pass '-' to read from stdin, which is the default. Files can also be passed as arguments. Prefix inputs with name= to label the Prometheus server they come from, e.g. prod-a=query.log, and get a breakdown per server")
+	flag.IntVar(argMaxEntrySize, "max-line-bytes", querystats.DefaultMaxEntrySize, "skip query log lines longer than this many bytes instead of failing, e.g. entries of enormous generated queries. Lines are read into reused buffers, so raising it costs memory only for the longest lines")
+	flag.DurationVar(&timeoutProxy, "timeout-proxy", 0, "count executions taking at least this long as probable timeouts, e.g. the server's --query.timeout. 0 disables")
+	flag.Var(&argFrom, "from", "load log entries afer this time. Accepts RFC3339 format, e.g. " + now.UTC().Format(time.RFC3339) + ", a date such as " + now.UTC().Format(time.DateOnly) + ", 'now' or a duration relative to now such as -6h")
+	flag.Var(&argTo, "to", "load log entries until this time. Accepts the same formats as -from")
+	flag.Var(&argDataFrom, "data-from", "load log entries of queries reading data after this time, i.e. whose end parameter is not before it. Accepts the same formats as -from")
+	flag.Var(&argDataTo, "data-to", "load log entries of queries reading data until this time, i.e. whose start parameter is not after it. Accepts the same formats as -from")
 }
 
-type LogEntry struct {
-	Params struct {
-		Query string `json:"query"`
-		Start *time.Time `json:"start"`
-		End   *time.Time `json:"end"`
-		Step  int	 `json:"step"`
-	} `json:"params"`
-	Stats struct {
-		Timings struct {
-			EvalTotalTime		 float64 `json:"evalTotalTime"`
-			ExecQueueTime		 float64 `json:"execQueueTime"`
-			ExecTotalTime		 float64 `json:"execTotalTime"`
-			InnerEvalTime		 float64 `json:"innerEvalTime"`
-			QueryPreparationTime float64 `json:"queryPreparationTime"`
-			ResultSortTime		 float64 `json:"resultSortTime"`
-		} `json:"timings"`
-		Samples struct {
-			TotalQueryableSamples int `json:"totalQueryableSamples"`
-			PeakSamples           int `json:"peakSamples"`
-		} `json:"samples,omitempty"`
-	} `json:"stats"`
-	RuleGroup *struct {
-		Name string `json:"name,omitempty"`
-		File string `json:"file,omitempty"`
-	} `json:"ruleGroup,omitempty"`
-	TS *time.Time `json:"ts"`
+func main() {
+	if err := registerCustomMetrics(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if len(os.Args) > 1 {
+		if cmd := lookupCommand(os.Args[1]); cmd != nil {
+			cmd.run(os.Args[2:])
+			return
+		}
+		if os.Args[1] == "help" {
+			printUsage()
+			return
+		}
+	}
+	runAnalyze(os.Args[1:])
 }
 
-type LogEntries []*LogEntry
+// runAnalyze implements the analyze subcommand, the default one. Files can be passed as arguments or with -f.
+func runAnalyze(args []string) {
+	argFiles = append(argFiles, parseArgs(flag.CommandLine, args)...)
+	// registered first, so it runs after the other deferred calls, e.g. closing the inputs
+	defer exitIfThresholdsBreached()
+
+	if *argVer {
+		if buildInfo, ok := debug.ReadBuildInfo(); ok {
+			fmt.Println(buildInfo.Main.Version)
+			fmt.Println(buildInfo)
+			os.Exit(0)
+		} else {
+			fmt.Println("Failed to get build info")
+			os.Exit(13)
+		}
+	}
 
-func (le LogEntries) Len() int { return len(le) }
-func (le LogEntries) Swap(i, j int) { le[i], le[j] = le[j], le[i] }
+	if *argMinExecTime < 0 || *argMinSamples < 0 {
+		fatal("-min-exec-time and -min-samples must not be negative")
+	}
 
-type ByTime struct {LogEntries}
+	var queryType querystats.QueryType
+	if *argType != "" {
+		var err error
+		if queryType, err = querystats.ParseQueryType(*argType); err != nil {
+			fatalf("Invalid -type: %s", err)
+		}
+	}
 
-func (le ByTime) Less(i, j int) bool {
-	return le.LogEntries[i].TS.Before(*le.LogEntries[j].TS)
-}
+	// -p ranks are validated when parsed
+	perc := globalPercentileRanks[0]
+	queryPercentileRanks = mergeRanks(queryPercentileRanks, globalPercentileRanks)
 
-func (le LogEntries) GetExecTotalTimeValues() []float64 {
-	vals := make([]float64, 0, len(le))
-	for _, log := range le {
-		vals = append(vals, log.Stats.Timings.ExecTotalTime)
+	if stableOutput {
+		validateStable()
+	}
+	heatmapLocation := displayLocation
+	var err error
+	if heatmapTZ != "" {
+		if heatmapLocation, err = loadLocation(heatmapTZ); err != nil {
+			fatalf("Invalid -heatmap-tz value: %s", err)
+		}
 	}
-	return vals
-}
 
-func (le LogEntries) GetTotalQueryableSamplesValues() []int {
-	vals := make([]int, 0, len(le))
-	for _, log := range le {
-		vals = append(vals, log.Stats.Samples.TotalQueryableSamples)
+	if err := ValidateDigestAccuracy(); err != nil {
+		fatal(err)
+	}
+	if ruleBudget <= 0 || ruleBudget > 1 {
+		fatal("-rule-budget must be between 0 and 1")
+	}
+	if slowForSamplesRatio <= 1 {
+		fatal("-slow-for-samples-ratio must be greater than 1")
 	}
-	return vals
-}
 
-func (le LogEntries) GetPeakSamplesValues() []int {
-	vals := make([]int, 0, len(le))
-	for _, log := range le {
-		vals = append(vals, log.Stats.Samples.PeakSamples)
+	if err := ValidateProgressMode(); err != nil {
+		fatal(err)
 	}
-	return vals
-}
 
-func avg[T int | float64](nums []T) float64 {
-	var sum T
-	for _, num := range nums {
-		sum += num
+	if err := ValidateColorMode(); err != nil {
+		fatal(err)
 	}
-	return float64(sum) / float64(len(nums))
-}
 
-func percentile[T int | float64](p int, nums []T) (T, error) {
-	if p <= 0 || p > 100 {
-		return 0, fmt.Errorf("percentile %d is out of range", p)
+	if err := anomalyOptions.Validate(); err != nil {
+		fatal(err)
 	}
-	if len(nums) == 0 {
-		return 0, fmt.Errorf("the slice is empty")
+
+	if err := queryLimits.Validate(); err != nil {
+		fatal(err)
 	}
 
-	slices.Sort(nums)
-	var k float64 = (float64(p)/100.0) * float64(len(nums))
-	var kth int = int(math.Ceil(k))
-	return nums[kth], nil
-}
+	if err := costModel.Validate(); err != nil {
+		fatalf("Invalid cost model: %s", err)
+	}
 
-type Query struct {
-	Query string
-	Logs []*LogEntry
-	AvgExecTotalTime float64
-	AvgTotalQueryableSamples float64
-	AvgPeakSamples float64
-	MaxExecTotalTimeEntry *LogEntry
-	MaxTotalQueryableSamplesEntry *LogEntry
-	MaxPeakSamplesEntry *LogEntry
-}
+	if err := emailSettings.Validate(); err != nil {
+		fatalf("Invalid email settings: %s", err)
+	}
 
-func NewQuery(query string, logs []*LogEntry) (*Query, error) {
-	if query == "" {
-		return nil, fmt.Errorf("a query cannot be empty")
+	decoder, ok := querystats.LookupDecoder(*argFormat)
+	if !ok {
+		fatalf("Unknown query log format %q, must be one of %s", *argFormat, strings.Join(querystats.DecoderNames(), ", "))
 	}
-	if len(logs) == 0 {
-		return nil, fmt.Errorf("a number of log entries must be greater than zero")
+
+	if err := lokiSettings.Validate(); err != nil {
+		fatalf("Invalid Loki settings: %s", err)
 	}
 
-	maxExecTotalTimeEntry := logs[0]
-	maxTotalQueryableSamplesEntry := logs[0]
-	maxPeakSamplesEntry := logs[0]
-	execTotalTimeVals := make([]float64, 0, len(logs))
-	totalQueryableSamplesVals := make([]int, 0, len(logs))
-	peakSamplesVals := make([]int, 0, len(logs))
-	for _, log := range logs {
-		execTotalTimeVals = append(execTotalTimeVals, log.Stats.Timings.ExecTotalTime)
-		totalQueryableSamplesVals = append(totalQueryableSamplesVals, log.Stats.Samples.TotalQueryableSamples)
-		peakSamplesVals = append(peakSamplesVals, log.Stats.Samples.PeakSamples)
-		if log.Stats.Timings.ExecTotalTime > maxExecTotalTimeEntry.Stats.Timings.ExecTotalTime {
-			maxExecTotalTimeEntry = log
-		}
-		if log.Stats.Samples.TotalQueryableSamples > maxTotalQueryableSamplesEntry.Stats.Samples.TotalQueryableSamples {
-			maxTotalQueryableSamplesEntry = log
+	if err := kubeSettings.Validate(); err != nil {
+		fatalf("Invalid Kubernetes settings: %s", err)
+	}
+	if kubeSettings.Enabled() && lokiSettings.Enabled() {
+		fatal("-kube and -loki-url can't be combined")
+	}
+
+	if _, ok := reportRenderers[*argOutput]; !ok && !slices.Contains(builtinOutputFormats, *argOutput) {
+		fatalf("Unknown output format %q", *argOutput)
+	}
+
+	if argTemplate != "" {
+		if *argOutput != "text" {
+			fatalf("-template replaces the report and can't be combined with -o %s", *argOutput)
 		}
-		if log.Stats.Samples.PeakSamples > maxPeakSamplesEntry.Stats.Samples.PeakSamples {
-			maxPeakSamplesEntry = log
+		var err error
+		if queryTemplate, err = ParseQueryTemplate(argTemplate); err != nil {
+			fatalf("Invalid -template: %s", err)
 		}
 	}
 
-	q := Query{
-		query,
-		logs,
-		avg(execTotalTimeVals),
-		avg(totalQueryableSamplesVals),
-		avg(peakSamplesVals),
-		maxExecTotalTimeEntry,
-		maxTotalQueryableSamplesEntry,
-		maxPeakSamplesEntry,
+	if *argSampleRate <= 0 || *argSampleRate > 1 || *argSampleSize < 0 {
+		fatal("-sample-rate must be greater than 0 and at most 1 and -sample-size must not be negative")
 	}
 
-	return &q, nil
-}
+	if *argMaxEntrySize <= 0 || *argMaxQueryLength < 0 || *argMaxQueries < 0 || *argMaxErrors < 0 {
+		fatal("-max-line-bytes must be positive, -max-query-length, -max-queries and -max-errors cannot be negative")
+	}
 
-type Queries []*Query
+	if maxConcurrency <= 0 {
+		fatal("-max-concurrency must be positive")
+	}
+	if stepMaxPoints <= 0 {
+		fatal("-step-max-points must be positive")
+	}
 
-func (q Queries) Len() int { return len(q) }
-func (q Queries) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+	if timeWeightBucket <= 0 {
+		fatal("-time-weight-bucket must be positive")
+	}
 
-type ByAvgExecTotalTime struct {Queries}
+	if *argAlertmanager != "" && (*argAlertBucket < time.Second || *argAlertBucket%time.Second != 0) {
+		fatal("-alert-bucket must be a whole number of seconds")
+	}
 
-func (q ByAvgExecTotalTime) Less(i, j int) bool {
-	return q.Queries[i].AvgExecTotalTime < q.Queries[j].AvgExecTotalTime
-}
+	if *argSeriesCardinality && *argPrometheusURL == "" {
+		fatal("-series-cardinality needs -prometheus-url")
+	}
 
-type ByMaxExecTotalTime struct {Queries}
+	switch *argGroupBy {
+	case "query", "rulegroup", "client", "path", "instance":
+	default:
+		fatalf("Unknown -group-by value %q", *argGroupBy)
+	}
 
-func (q ByMaxExecTotalTime) Less(i, j int) bool {
-	return q.Queries[i].MaxExecTotalTimeEntry.Stats.Timings.ExecTotalTime < q.Queries[j].MaxExecTotalTimeEntry.Stats.Timings.ExecTotalTime
-}
+	columns, err := ParseColumns(*argColumns)
+	if err != nil {
+		fatalf("Invalid -columns value: %s", err)
+	}
 
-type ByAvgTotalQueryableSamples struct {Queries}
+	if *argSort != "desc" && *argSort != "asc" {
+		fatalf("Invalid -sort value: %s. Must be desc or asc", *argSort)
+	}
+	metrics, err := ParseReportMetrics(*argMetric)
+	if err != nil {
+		fatalf("Invalid -metric value: %s", err)
+	}
+	sections := DefaultReportSections(metrics, *argSort == "asc")
+	if *argReport != "" {
+		if sections, err = ParseReportSections(*argReport, *argSort == "asc"); err != nil {
+			fatalf("Invalid -report value: %s", err)
+		}
+	}
 
-func (q ByAvgTotalQueryableSamples) Less(i, j int) bool {
-	return q.Queries[i].AvgTotalQueryableSamples < q.Queries[j].AvgTotalQueryableSamples
-}
+	var queryMatch, queryExclude *regexp.Regexp
+	if *argQueryMatch != "" {
+		if queryMatch, err = regexp.Compile(*argQueryMatch); err != nil {
+			fatalf("Invalid -query-match value: %s", err)
+		}
+	}
+	if *argQueryExclude != "" {
+		if queryExclude, err = regexp.Compile(*argQueryExclude); err != nil {
+			fatalf("Invalid -query-exclude value: %s", err)
+		}
+	}
 
-type ByMaxTotalQueryableSamples struct {Queries}
+	queryIDs, err := ParseQueryIDs(*argQueryID)
+	if err != nil {
+		fatalf("Invalid -query-id value: %s", err)
+	}
 
-func (q ByMaxTotalQueryableSamples) Less(i, j int) bool {
-	return q.Queries[i].MaxTotalQueryableSamplesEntry.Stats.Samples.TotalQueryableSamples < q.Queries[j].MaxTotalQueryableSamplesEntry.Stats.Samples.TotalQueryableSamples
-}
+	clientIPs, err := querystats.ParseClientIPs(*argClientIP)
+	if err != nil {
+		fatalf("Invalid -client-ip value: %s", err)
+	}
+	var pathMatch *regexp.Regexp
+	if *argPathMatch != "" {
+		if pathMatch, err = regexp.Compile(*argPathMatch); err != nil {
+			fatalf("Invalid -path-match value: %s", err)
+		}
+	}
 
-type ByAvgPeakSamples struct {Queries}
+	familyRollups, err := ParseFamilyRollups(*argFamilyRollups)
+	if err != nil {
+		fatalf("Invalid -family-rollups value: %s", err)
+	}
 
-func (q ByAvgPeakSamples) Less(i, j int) bool {
-	return q.Queries[i].AvgPeakSamples < q.Queries[j].AvgPeakSamples
-}
+	if summation, err = querystats.ParseSummation(*argSummation); err != nil {
+		fatalf("Invalid -summation value: %s", err)
+	}
 
-type ByMaxPeakSamples struct {Queries}
+	normalizer, err := querystats.ParseNormalizer(*argNormalize)
+	if err != nil {
+		fatalf("Invalid -normalize value: %s", err)
+	}
 
-func (q ByMaxPeakSamples) Less(i, j int) bool {
-	return q.Queries[i].MaxPeakSamplesEntry.Stats.Samples.PeakSamples < q.Queries[j].MaxPeakSamplesEntry.Stats.Samples.PeakSamples
-}
+	if *argPrevious != "" {
+		previousReport, err = LoadPreviousReport(*argPrevious)
+		if err != nil {
+			fatalf("Failed to load the previous report: %s", err)
+		}
+	}
 
-func LoadQueriesFromLog(file *os.File, from *time.Time, to *time.Time) ([]*Query, LogEntries, error) {
-	qMap := make(map[string][]*LogEntry)
-	logs := make([]*LogEntry, 0)
-	scanner := bufio.NewScanner(file)
-	for lineNum := 0; scanner.Scan(); lineNum++ {
-		line := scanner.Bytes()
-		var entry LogEntry
-		if err := json.Unmarshal(line, &entry); err != nil {
-			return nil, nil, fmt.Errorf("Failed to parse line %d: %w", lineNum, err)
+	if *argRulesDir != "" {
+		ruleIndex, ruleGroupIntervals, err = LoadRulesDir(*argRulesDir)
+		if err != nil {
+			fatalf("Failed to load the rule files: %s", err)
 		}
-		if entry.Params.Query == "" {
-			log.Printf("Failed to parse line %d: empty query", lineNum)
-			continue
+	}
+
+	var teamMapping TeamMapping
+	if *argChargeback != "" {
+		var err error
+		teamMapping, err = loadTeamMappingFile(*argChargeback)
+		if err != nil {
+			fatalf("Failed to load the team mapping file: %s", err)
 		}
-		if from != nil && entry.TS.Before(*from) {
-			continue
+	} else if *argChargebackCSV != "" {
+		fatal("-chargeback-csv requires -chargeback")
+	}
+
+	var networks ClientNetworks
+	if clientNetworks != "" {
+		networks, err = loadClientNetworksFile(clientNetworks)
+		if err != nil {
+			fatalf("Failed to load the client networks file: %s", err)
 		}
-		if to != nil && entry.TS.After(*to) {
-			continue
+		topTalkers = true
+	}
+	if clientPrefix < 0 || clientPrefix > 32 || clientPrefix6 < 0 || clientPrefix6 > 128 {
+		fatal("-client-prefix must be between 0 and 32 and -client-prefix6 between 0 and 128")
+	}
+	if clientBudgetPercentile <= 0 || clientBudgetPercentile > 100 {
+		fatal("-client-budget-percentile must be between 1 and 100")
+	}
+
+	var mapLine func([]byte) ([]byte, error)
+	if *argWasmPlugin != "" {
+		plugin, err := LoadWasmPlugin(context.Background(), *argWasmPlugin)
+		if err != nil {
+			fatalf("Failed to load the WebAssembly plugin: %s", err)
 		}
+		defer plugin.Close()
+		mapLine = plugin.MapLine
+	}
 
-		qMap[entry.Params.Query] = append(qMap[entry.Params.Query], &entry)
-		logs = append(logs, &entry)
+	if *argStream && (*argOutput != "text" && *argOutput != "artifact" || *argServeStdio || *argSnapshot != "" || *argRestore != "" || emailSettings.Enabled()) {
+		fatal("-stream supports only -o text and -o artifact and can't be combined with -serve-stdio, -snapshot, -restore or -email-to")
+	}
+	if *argSpillAfter < 0 || *argSpillAfter > 0 && (!*argStream || *argOutput != "text") {
+		fatal("-spill-after must not be negative and requires -stream with -o text")
+	}
+	if *argStream && thresholdsSet() {
+		fatal("-fail-if-* thresholds can't be combined with -stream, whose percentiles are estimated")
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, nil, err
+	if cacheDir != "" && (*argStream || *argWasmPlugin != "" || lokiSettings.Enabled() || kubeSettings.Enabled()) {
+		fatal("-cache-dir can't be combined with -stream, -wasm-plugin, -loki-url or -kube")
 	}
+	if outputFile != "" {
+		if *argServeStdio || *argTUI {
+			fatal("-out can't be combined with -serve-stdio or -tui")
+		}
+	}
+	// registered after exitIfThresholdsBreached, so the file is complete before a breach ends the run
+	defer startOutput(outputFile)()
 
-	queries := make([]*Query, 0, len(qMap))
-	for query, queryLogs := range qMap {
-		if q, err := NewQuery(query, queryLogs); err != nil {
-			return nil, nil, fmt.Errorf("Failed to create Query: %w", err)
-		} else {
-			queries = append(queries, q)
+	var snapshot querystats.LogEntries
+	if *argRestore != "" {
+		if lokiSettings.Enabled() || kubeSettings.Enabled() || cacheDir != "" {
+			fatal("-restore resumes reading the files where the snapshot left off and can't be combined with -loki-url, -kube or -cache-dir")
+		}
+		var err error
+		if snapshot, resumePositions, err = LoadSnapshotFile(*argRestore); err != nil {
+			fatalf("Failed to restore the snapshot: %s", err)
 		}
 	}
 
-	return queries, logs, nil
-}
+	// Ctrl-C stops reading instead of the process, so the entries read until then are still reported. A second
+	// Ctrl-C stops the process, e.g. if reporting takes too long.
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stopSignals()
+	}()
 
-func removeNL(str string) string {
-	re := regexp.MustCompile(`\n\s*`)
-	return re.ReplaceAllString(str, "")
-}
+	var input io.Reader
+	// cachedFiles are the files read through -cache-dir instead of input
+	var cachedFiles []string
+	// instances are the labeled inputs of several Prometheus servers, read instead of input
+	var instances []InputGroup
+	stopProgress := func() {}
+	if lokiSettings.Enabled() {
+		if len(argFiles) > 0 {
+			fatal("-loki-url reads the query log from Loki and can't be combined with files")
+		}
+		input = lokiSettings.Open(ctx, argFrom.Time, argTo.Time)
+		argFiles = fileList{lokiSettings.Source()}
+	} else if kubeSettings.Enabled() {
+		if len(argFiles) > 0 {
+			fatal("-kube reads the query log from a pod and can't be combined with files")
+		}
+		var err error
+		input, err = kubeSettings.Open(ctx, argFrom.Time)
+		if err != nil {
+			fatalf("Failed to read the query log from Kubernetes: %s", err)
+		}
+		argFiles = fileList{kubeSettings.Source()}
+	} else {
+		if len(argFiles) == 0 {
+			argFiles = fileList{"-"}
+		}
+		groups, err := GroupInputs(argFiles)
+		if err != nil {
+			fatal(err)
+		}
+		var files []string
+		for i := range groups {
+			if groups[i].Files, err = ExpandInputs(groups[i].Names); err != nil {
+				fatalf("Failed to read the query log file: %s", err)
+			}
+			files = append(files, groups[i].Files...)
+		}
 
-func main() {
-	flag.Parse()
+		if *argServeStdio && slices.Contains(files, "-") {
+			fatal("-serve-stdio reads requests from stdin, so the query log must be a file")
+		}
 
-	if *argVer {
-		if buildInfo, ok := debug.ReadBuildInfo(); ok {
-			fmt.Println(buildInfo.Main.Version)
-			fmt.Println(buildInfo)
-			os.Exit(0)
+		if cacheDir != "" {
+			if err := ValidateCacheInputs(files); err != nil {
+				fatal(err)
+			}
+		}
+		if groups[0].Instance != "" {
+			if *argStream {
+				fatal("-stream reads a single input and can't be combined with inputs labeled with name=path")
+			}
+			instances = groups
+		} else if cacheDir != "" {
+			cachedFiles = files
 		} else {
-			fmt.Println("Failed to get build info")
-			os.Exit(13)
+			var closeInput func()
+			input, closeInput, err = OpenInputs(ctx, files)
+			if err != nil {
+				fatalf("Failed to read the query log file: %s", err)
+			}
+			defer closeInput()
+			input, stopProgress = StartProgress(files, input)
 		}
 	}
 
-	if *argPerc <= 0 || *argPerc > 100 {
-		fmt.Println("The percentile rank does not make sense. Must be between 0 and 100")
-		os.Exit(1)
+	loadOpts := querystats.LoadOptions{
+		Context:         ctx,
+		From:            argFrom.Time,
+		To:              argTo.Time,
+		DataFrom:        argDataFrom.Time,
+		DataTo:          argDataTo.Time,
+		QueryMatch:      queryMatch,
+		QueryExclude:    queryExclude,
+		QueryFilter:     QueryIDFilter(queryIDs),
+		MinExecTime:     *argMinExecTime,
+		MinSamples:      *argMinSamples,
+		Type:            queryType,
+		ClientIPs:       clientIPs,
+		PathMatch:       pathMatch,
+		Normalizer:      normalizer,
+		MapLine:         mapLine,
+		KeepZeroTimings: *argKeepZeroTimings,
+		Strict:          *argStrict,
+		MaxEntrySize:    *argMaxEntrySize,
+		MaxQueryLength:  *argMaxQueryLength,
+		MaxQueries:      *argMaxQueries,
+		SkipErrors:      *argSkipErrors,
+		MaxErrors:       *argMaxErrors,
+		SkipNoise:       *argSkipNoise,
+		PercentileRanks: queryPercentileRanks,
+		Summation:       summation,
+		Jobs:            *argJobs,
+		SampleRate:      *argSampleRate,
+		SampleSize:      *argSampleSize,
+		SampleSeed:      sampleSeed,
+		Log:             logLoad,
 	}
-
-	input := os.Stdin
-	if *argFile != "-" {
-		log.Printf("Reading the query log from %s", *argFile)
-		var err error
-		input, err = os.Open(*argFile)
+	if decoder.Name != "prometheus" {
+		loadOpts.Decoder = &decoder
+	}
+	if *argStream {
+		hostname, _ := os.Hostname()
+		artifact, loadStats, err := StreamArtifact(input, loadOpts, hostname+":"+argFiles.String(), globalPercentileRanks, *argSpillAfter)
+		stopProgress()
+		stopSignals()
 		if err != nil {
-			log.Fatalf("Failed to read the query log file: %s", err)
+			fatalf("Failed to parse the query log file: %s", err)
+		}
+		if loadStats.Interrupted {
+			slog.Warn("Interrupted, reporting the entries read so far", "entries", artifact.Entries)
+		}
+		if artifact.Entries == 0 {
+			fatal("Loaded 0 queries")
+		}
+		run := NewRunMetadata(flag.CommandLine)
+		if run != nil {
+			artifact.Runs = []*RunMetadata{run}
 		}
-		defer input.Close()
+		slog.Info("Streamed the query log", "entries", artifact.Entries, "from", displayTime(artifact.From), "to", displayTime(artifact.To))
+		if *argOutput == "artifact" {
+			if err := WriteArtifact(os.Stdout, artifact); err != nil {
+				fatalf("Failed to write the artifact: %s", err)
+			}
+			return
+		}
+		if loadStats.Interrupted {
+			fmt.Printf("WARNING: loading was interrupted, the report is partial and covers only the %d entries read until then\n", artifact.Entries)
+			fmt.Println()
+		}
+		if loadStats.ZeroTimings > 0 && !*argKeepZeroTimings {
+			fmt.Printf("WARNING: %d entries have all timings equal to zero and are excluded from the statistics. Use -keep-zero-timings to include them\n", loadStats.ZeroTimings)
+			fmt.Println()
+		}
+		if loadStats.MalformedLines > 0 {
+			fmt.Printf("WARNING: %d malformed lines were skipped\n", loadStats.MalformedLines)
+			fmt.Println()
+		}
+		if loadStats.NoiseLines > 0 {
+			fmt.Printf("%d lines that are not query log entries were skipped\n", loadStats.NoiseLines)
+			fmt.Println()
+		}
+		if loadStats.SampledOut > 0 {
+			printSampleNote(artifact.Entries, loadStats.SampledOut)
+			fmt.Println()
+		}
+		PrintRunMetadata(os.Stdout, run, "")
+		PrintArtifact(artifact, *argTop, perc)
+		return
+	}
+
+	var restored querystats.LogEntries
+	if *argRestore != "" {
+		for _, entry := range snapshot {
+			if loadOpts.Accept(entry) {
+				restored = append(restored, entry)
+			}
+		}
+		slog.Info("Restored the snapshot", "entries", len(restored), "total", len(snapshot), "file", *argRestore)
+	}
+	var entries querystats.LogEntries
+	var loadStats querystats.LoadStats
+	if instances != nil {
+		entries, loadStats, err = ReadInstances(instances, cacheDir != "", loadOpts)
+	} else if cachedFiles != nil {
+		entries, loadStats, err = ReadCachedLogEntries(cachedFiles, loadOpts)
 	} else {
-		log.Print("Reading the query log from stdin")
+		entries, loadStats, err = querystats.ReadLogEntries(input, loadOpts)
+	}
+	stopProgress()
+	stopSignals()
+	if err != nil {
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	if loadStats.Interrupted {
+		slog.Warn("Interrupted, reporting the entries read so far", "entries", len(entries))
+	}
+	logFormat := querystats.DetectLogFormat(entries, loadStats)
+	if loadOpts.Decoder != nil {
+		logFormat.Family = decoder.Title
 	}
 
-	queries, logs, err := LoadQueriesFromLog(input, argFrom.Time, argTo.Time)
+	if *argServeStdio {
+		if err := ServeStdio(os.Stdin, os.Stdout, append(restored, entries...), loadOpts); err != nil {
+			fatalf("Failed to serve stdio: %s", err)
+		}
+		return
+	}
+	queries, logs, err := querystats.GroupQueries(append(restored, entries...), loadOpts)
 	if err != nil {
-		log.Fatalf("Failed to parse the query log file: %s", err)
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	if *argSnapshot != "" && loadStats.Interrupted {
+		slog.Warn("Not saving the snapshot, as the query log wasn't read to the end", "file", *argSnapshot)
+	} else if *argSnapshot != "" {
+		if err := SaveSnapshotFile(*argSnapshot, logs, InputPositions()); err != nil {
+			fatalf("Failed to save the snapshot: %s", err)
+		}
+		slog.Info("Saved the snapshot", "entries", len(logs), "file", *argSnapshot)
 	}
 	if len(queries) == 0 {
-		log.Fatalln("Loaded 0 queries")
+		fatal("Loaded 0 queries")
 	}
 
-	sort.Sort(ByTime{logs})
-	log.Printf("Loaded %d entries from [%v] to [%v]", len(logs), logs[0].TS, logs[len(logs)-1].TS)
+	if stableOutput {
+		logs = stabilize(queries)
+	} else {
+		sort.Sort(querystats.ByTime{LogEntries: logs})
+	}
+	slog.Info("Loaded the query log", "entries", len(logs), "from", displayTime(*logs[0].TS), "to", displayTime(*logs[len(logs)-1].TS))
+	thresholdsBreached = CheckThresholds(queries)
 
-	if *argTop > len(queries) {
-		*argTop = len(queries)
+	if *argTUI {
+		if err := RunTUI(queries, perc); err != nil {
+			fatalf("Failed to run the TUI: %s", err)
+		}
+		return
 	}
 
-	printAvgTable := func (title, unit string, getter func(q *Query) float64) {
-		fmt.Printf("Top %d queries by %s:\n", *argTop, title)
-		for i, query := range queries[:*argTop] {
-			fmt.Printf(
-				"%2d) n=%-6d %.3f%s %s",
-				i+1,
-				len(query.Logs),
-				getter(query),
-				unit,
-				removeNL(query.Query),
-			)
-			if query.Logs[0].RuleGroup != nil {
-				fmt.Printf(" | ruleName=\"%s\"", query.Logs[0].RuleGroup.Name)
+	var history []HistoryPoint
+	if *argHistoryFile != "" {
+		point, err := NewHistoryPoint(queries, logs, perc)
+		if err != nil {
+			fatalf("Failed to calculate percentile: %s", err)
+		}
+		if err := AppendHistoryPoint(*argHistoryFile, point); err != nil {
+			fatalf("Failed to append to the history file: %s", err)
+		}
+		if history, err = ReadHistoryFile(*argHistoryFile); err != nil {
+			fatalf("Failed to read the history file: %s", err)
+		}
+	}
+
+	if pushgatewaySettings.Enabled() {
+		if err := pushgatewaySettings.PushSummary(queries, logs, globalPercentileRanks); err != nil {
+			fatalf("Failed to push to the Pushgateway: %s", err)
+		}
+		slog.Info("Pushed the summary", "url", pushgatewaySettings.URL)
+	}
+
+	run := NewRunMetadata(flag.CommandLine)
+
+	if emailSettings.Enabled() {
+		report, err := BuildReport(queries, logs, loadStats, *argTop, globalPercentileRanks, QueryLinks{*argPrometheusURL, *argGrafanaURL, *argGrafanaDatasource})
+		if err != nil {
+			fatalf("Failed to build the report: %s", err)
+		}
+		report.Run = run
+		var html bytes.Buffer
+		if err := WriteHTMLReport(&html, report, logs, history, perc); err != nil {
+			fatalf("Failed to write the HTML report: %s", err)
+		}
+		if err := emailSettings.SendReportEmail(report, html.Bytes()); err != nil {
+			fatalf("Failed to email the report: %s", err)
+		}
+		slog.Info("Emailed the report", "to", emailSettings.To)
+	}
+
+	if queryTemplate != nil {
+		if err := WriteQueryTemplate(os.Stdout, queryTemplate, queries, perc, QueryLinks{*argPrometheusURL, *argGrafanaURL, *argGrafanaDatasource}); err != nil {
+			fatalf("Failed to execute -template: %s", err)
+		}
+		return
+	}
+
+	switch *argOutput {
+	case "csv", "tsv":
+		comma := ','
+		if *argOutput == "tsv" {
+			comma = '\t'
+		}
+		if err := WriteQueriesCSV(os.Stdout, queries, globalPercentileRanks, comma, run); err != nil {
+			fatalf("Failed to write the %s output: %s", *argOutput, err)
+		}
+		return
+	case "json", "html", "markdown":
+		report, err := BuildReport(queries, logs, loadStats, *argTop, globalPercentileRanks, QueryLinks{*argPrometheusURL, *argGrafanaURL, *argGrafanaDatasource})
+		if err != nil {
+			fatalf("Failed to build the report: %s", err)
+		}
+		if len(entries) > 0 {
+			report.LogFormat = logFormat.String()
+		}
+		report.Run = run
+		if *argOutput == "html" {
+			if err := WriteHTMLReport(os.Stdout, report, logs, history, perc); err != nil {
+				fatalf("Failed to write the HTML report: %s", err)
 			}
-			fmt.Println()
+			return
+		}
+		if *argOutput == "markdown" {
+			if err := WriteMarkdownReport(os.Stdout, report); err != nil {
+				fatalf("Failed to write the Markdown report: %s", err)
+			}
+			return
+		}
+		if err := WriteJSONReport(os.Stdout, report); err != nil {
+			fatalf("Failed to write the JSON report: %s", err)
+		}
+		return
+	case "arrow":
+		if err := WriteArrow(os.Stdout, logs, normalizer, costModel, run); err != nil {
+			fatalf("Failed to write the Arrow output: %s", err)
+		}
+		return
+	case "artifact":
+		hostname, _ := os.Hostname()
+		artifact := NewArtifact(queries, logs, hostname+":"+argFiles.String())
+		if run != nil {
+			artifact.Runs = []*RunMetadata{run}
+		}
+		if err := WriteArtifact(os.Stdout, artifact); err != nil {
+			fatalf("Failed to write the artifact: %s", err)
+		}
+		return
+	}
+	if renderer, ok := reportRenderers[*argOutput]; ok {
+		report, err := BuildReport(queries, logs, loadStats, *argTop, globalPercentileRanks, QueryLinks{*argPrometheusURL, *argGrafanaURL, *argGrafanaDatasource})
+		if err != nil {
+			fatalf("Failed to build the report: %s", err)
+		}
+		if len(entries) > 0 {
+			report.LogFormat = logFormat.String()
+		}
+		report.Run = run
+		if err := renderer.Render(os.Stdout, report); err != nil {
+			fatalf("Failed to write the %s output: %s", *argOutput, err)
+		}
+		return
+	}
+
+	PrintRunMetadata(os.Stdout, run, "")
+
+	// entries restored from a snapshot don't tell the format
+	if len(entries) > 0 {
+		fmt.Println()
+		fmt.Printf("Query log format: %s\n", logFormat)
+		if !logFormat.HasSamples() {
+			fmt.Println("Tables of samples are skipped, since their values would all be zero")
+			sections = WithoutSampleSections(sections)
 		}
 	}
 
-	printMaxTable := func (title, unit string, valueGetter func(q *Query) interface{}, tsGetter func(q *Query) *time.Time) {
-		fmt.Printf("Top %d queries by %s:\n", *argTop, title)
-		for i, query := range queries[:*argTop] {
-			valueOut := ""
-			switch value := valueGetter(query).(type) {
-			case int:
-				valueOut = fmt.Sprintf("%d", value)
-			case float64:
-				valueOut = fmt.Sprintf("%.3f", value)
-			default:
-				panic("unsupported type")
+	if loadStats.Interrupted {
+		fmt.Println()
+		fmt.Printf("WARNING: loading was interrupted, the report is partial and covers only the %d entries read until then\n", len(logs))
+	}
+	if loadStats.ZeroTimings > 0 {
+		fmt.Println()
+		if *argKeepZeroTimings {
+			fmt.Printf("WARNING: %d entries have all timings equal to zero and are included in the statistics\n", loadStats.ZeroTimings)
+		} else {
+			fmt.Printf("WARNING: %d entries have all timings equal to zero and are excluded from the statistics. Use -keep-zero-timings to include them\n", loadStats.ZeroTimings)
+		}
+	}
+	if loadStats.MalformedLines > 0 {
+		fmt.Println()
+		fmt.Printf("WARNING: %d malformed lines were skipped\n", loadStats.MalformedLines)
+	}
+	if loadStats.NoiseLines > 0 {
+		fmt.Println()
+		fmt.Printf("%d lines that are not query log entries were skipped\n", loadStats.NoiseLines)
+	}
+	if loadStats.SampledOut > 0 {
+		fmt.Println()
+		printSampleNote(len(logs), loadStats.SampledOut)
+	}
+
+	fmt.Println()
+	PrintSummary(logs, normalizer, len(entries) == 0 || logFormat.HasSamples())
+
+	if *argGroupBy == "rulegroup" {
+		fmt.Println()
+		PrintRuleGroups(GroupByRuleGroup(queries), *argTop)
+		if budgets := RuleGroupBudgets(queries, ruleGroupIntervals); len(budgets) > 0 {
+			fmt.Println()
+			PrintRuleGroupBudgets(budgets, *argTop, ruleBudget)
+		}
+		fmt.Println()
+		PrintDuplicateExpressions(FindDuplicateExpressions(queries, false), *argTop, false)
+		fmt.Println()
+		PrintDuplicateExpressions(FindDuplicateExpressions(queries, true), *argTop, true)
+		fmt.Println()
+		PrintFindings(Findings(queries, logs, *argIrregularity))
+		return
+	}
+	if *argGroupBy == "client" || *argGroupBy == "path" {
+		fmt.Println()
+		PrintRequestGroups(GroupByHTTPRequest(logs, *argGroupBy), *argGroupBy, *argTop)
+		return
+	}
+	if *argGroupBy == "instance" {
+		if instances == nil {
+			fatal("-group-by instance needs inputs labeled with name=path")
+		}
+		for _, instance := range SplitByInstance(logs) {
+			instanceQueries, instanceLogs, err := querystats.GroupQueries(instance.Entries, loadOpts)
+			if err != nil {
+				fatalf("Failed to parse the query log file: %s", err)
 			}
-			fmt.Printf(
-				"%2d) t=%s %s%s %s",
-				i+1,
-				tsGetter(query).Format(time.RFC3339),
-				valueOut,
-				unit,
-				removeNL(query.Query),
-			)
-			if query.Logs[0].RuleGroup != nil {
-				fmt.Printf(" | ruleName=\"%s\"", query.Logs[0].RuleGroup.Name)
+			if stableOutput {
+				instanceLogs = stabilize(instanceQueries)
 			}
 			fmt.Println()
+			fmt.Printf("=== %s: %d entries of %d distinct queries ===\n", escapeTerminal(instance.Instance), len(instanceLogs), len(instanceQueries))
+			for _, section := range sections {
+				fmt.Println()
+				if err := PrintReportSection(section, instanceQueries, instanceLogs, *argTop, globalPercentileRanks, columns); err != nil {
+					fatal(err)
+				}
+			}
 		}
+		return
 	}
 
-	if p, err := percentile(*argPerc, logs.GetExecTotalTimeValues()); err != nil {
-		log.Fatalf("Failed to calculate percentile: %s", err)
-	} else {
+	for _, section := range sections {
 		fmt.Println()
-		fmt.Printf("The %dth percentile of total execution time is %.3f seconds\n", *argPerc, p)
+		if err := PrintReportSection(section, queries, logs, *argTop, globalPercentileRanks, columns); err != nil {
+			fatal(err)
+		}
 	}
 
-	sort.Sort(sort.Reverse(ByAvgExecTotalTime{queries}))
 	fmt.Println()
-	printAvgTable("average execution time", "s", func(q *Query) float64 { return q.AvgExecTotalTime })
+	PrintCostScores(queries, logs, *argTop)
 
-	sort.Sort(sort.Reverse(ByMaxExecTotalTime{queries}))
 	fmt.Println()
-	printMaxTable("max execution time", "s", func(q *Query) interface{} { return q.MaxExecTotalTimeEntry.Stats.Timings.ExecTotalTime }, func(q *Query) *time.Time { return q.MaxExecTotalTimeEntry.TS })
+	PrintQueryRate(queries, logs, *argTop)
 
-	if p, err := percentile(*argPerc, logs.GetTotalQueryableSamplesValues()); err != nil {
-		log.Fatalf("Failed to calculate percentile: %s", err)
-	} else {
+	fmt.Println()
+	PrintPhaseBreakdown(queries, logs, *argTop)
+
+	concurrency := EstimateConcurrency(logs, perc, maxConcurrency)
+	fmt.Println()
+	PrintConcurrency(concurrency, perc, maxConcurrency, *argTop)
+
+	fmt.Println()
+	PrintQueueWait(queries, logs, concurrency, *argTop)
+
+	if printHistograms {
+		fmt.Println()
+		PrintHistogram(logs, MetricExecTotalTime)
+		fmt.Println()
+		PrintHistogram(logs, MetricPeakSamples)
+	}
+
+	if printHeatmap {
+		color := useColor()
+		fmt.Println()
+		PrintHeatmap(logs, MetricExecTotalTime, heatmapLocation, color)
+		fmt.Println()
+		PrintHeatmap(logs, MetricTotalQueryableSamples, heatmapLocation, color)
+	}
+
+	if *argRuleKinds {
 		fmt.Println()
-		fmt.Printf("The %dth percentile of total queryable samples is %d\n", *argPerc, p)
+		PrintRuleKinds(queries)
 	}
 
-	sort.Sort(sort.Reverse(ByAvgTotalQueryableSamples{queries}))
+	queryTypes, err := QueryTypeBreakdown(logs, globalPercentileRanks)
+	if err != nil {
+		fatalf("Failed to compute query type statistics: %s", err)
+	}
 	fmt.Println()
-	printAvgTable("average total queryable samples", "", func(q *Query) float64 { return q.AvgTotalQueryableSamples })
+	PrintQueryTypes(queryTypes)
 
-	sort.Sort(sort.Reverse(ByMaxTotalQueryableSamples{queries}))
+	instanceStats, err := InstanceBreakdown(logs, globalPercentileRanks)
+	if err != nil {
+		fatalf("Failed to compute instance statistics: %s", err)
+	}
+	if instanceStats != nil {
+		fmt.Println()
+		PrintInstances(instanceStats)
+	}
+
+	fmt.Println()
+	PrintDuplicateExpressions(FindDuplicateExpressions(queries, false), *argTop, false)
 	fmt.Println()
-	printMaxTable("max total queryable samples", "", func(q *Query) interface{} { return q.MaxTotalQueryableSamplesEntry.Stats.Samples.TotalQueryableSamples }, func(q *Query) *time.Time { return q.MaxTotalQueryableSamplesEntry.TS })
+	PrintDuplicateExpressions(FindDuplicateExpressions(queries, true), *argTop, true)
 
-	if p, err := percentile(*argPerc, logs.GetPeakSamplesValues()); err != nil {
-		log.Fatalf("Failed to calculate percentile: %s", err)
-	} else {
+	fmt.Println()
+	if err := PrintRangeReport(queries, logs, *argTop, globalPercentileRanks); err != nil {
+		fatal(err)
+	}
+
+	if failing := FindFailingQueries(queries); len(failing) > 0 {
 		fmt.Println()
-		fmt.Printf("The %dth percentile of peak samples is %d\n", *argPerc, p)
+		PrintFailingQueries(failing, *argTop)
+	}
+
+	if queryLimits.Enabled() {
+		fmt.Println()
+		PrintLimitRisks(queries, *argTop, queryLimits)
 	}
 
-	sort.Sort(sort.Reverse(ByAvgPeakSamples{queries}))
 	fmt.Println()
-	printAvgTable("average peak samples", "", func(q *Query) float64 { return q.AvgPeakSamples })
+	PrintAnomalies(FindAnomalies(queries, anomalyOptions), *argTop, anomalyOptions)
+
+	fmt.Println()
+	PrintQuerySizeReport(queries, *argTop, *argMegaSelectors, *argMegaLength)
+
+	if *argValidateSyntax {
+		fmt.Println()
+		PrintSyntaxReport(queries, *argTop)
+	}
+
+	if *argCardinalityHints {
+		var client *PromClient
+		if *argPrometheusURL != "" {
+			client = newLookupClient(*argPrometheusURL)
+		}
+		fmt.Println()
+		if err := PrintCardinalityHints(context.Background(), queries, *argTop, client); err != nil {
+			fatalf("Failed to get cardinality hints: %s", err)
+		}
+	}
+
+	if *argSeriesCardinality {
+		fmt.Println()
+		if err := PrintSeriesCardinality(context.Background(), queries, *argTop, newLookupClient(*argPrometheusURL)); err != nil {
+			fatalf("Failed to get series cardinality: %s", err)
+		}
+	}
+
+	if *argMetricLoad {
+		fmt.Println()
+		PrintMetricLoad(queries, *argTop)
+	}
+
+	if *argLabelValues {
+		fmt.Println()
+		PrintLabelValues(queries, *argTop)
+	}
+
+	if *argMetricFamilies {
+		fmt.Println()
+		PrintMetricFamilies(queries, *argTop, familyRollups)
+	}
+
+	if regularities := RuleQueryRegularities(queries); len(regularities) > 0 {
+		fmt.Println()
+		PrintIrregularRuleQueries(regularities, *argTop, *argIrregularity)
+	}
+
+	if budgets := RuleGroupBudgets(queries, ruleGroupIntervals); len(budgets) > 0 {
+		fmt.Println()
+		PrintRuleGroupBudgets(budgets, *argTop, ruleBudget)
+	}
+
+	if lowThroughput {
+		fmt.Println()
+		PrintLowThroughputQueries(queries, *argTop, lowThroughputRatio)
+	}
+
+	if correlation, ok := CorrelateSamples(logs); ok {
+		fmt.Println()
+		PrintSampleCorrelation(queries, correlation, *argTop, slowForSamplesRatio)
+	}
+
+	if *argAlertmanager != "" {
+		alerts, err := FetchAlerts(context.Background(), *argAlertmanager)
+		if err != nil {
+			fatalf("Failed to fetch alerts from Alertmanager: %s", err)
+		}
+		fmt.Println()
+		PrintAlertCorrelation(logs, alerts, *argAlertBucket)
+	}
+
+	if topTalkers {
+		talkers, unknown := TopTalkers(networks, logs, clientPrefix, clientPrefix6)
+		fmt.Println()
+		PrintTopTalkers(talkers, unknown, *argTop, costModel)
+	}
+
+	if clientBudgets {
+		budgets, unknown := ClientBudgets(networks, logs, clientPrefix, clientPrefix6, clientBudgetPercentile)
+		fmt.Println()
+		PrintClientBudgets(budgets, unknown, *argTop, clientBudgetPercentile)
+	}
+
+	if *argChargeback != "" {
+		rows := Chargeback(teamMapping, logs)
+		fmt.Println()
+		PrintChargeback(rows, costModel)
+		if *argChargebackCSV != "" {
+			out, err := os.Create(*argChargebackCSV)
+			if err != nil {
+				fatalf("Failed to create the chargeback CSV file: %s", err)
+			}
+			err = WriteChargebackCSV(out, rows, costModel)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				fatalf("Failed to write the chargeback CSV file: %s", err)
+			}
+		}
+	}
+
+	if len(history) > 1 {
+		fmt.Println()
+		PrintHistory(history, *argTop)
+	}
 
-	sort.Sort(sort.Reverse(ByMaxPeakSamples{queries}))
 	fmt.Println()
-	printMaxTable("max peak samples", "", func(q *Query) interface{} { return q.MaxPeakSamplesEntry.Stats.Samples.PeakSamples }, func(q *Query) *time.Time { return q.MaxPeakSamplesEntry.TS })
+	PrintFindings(Findings(queries, logs, *argIrregularity))
+
+	if printFixFirst {
+		fmt.Println()
+		PrintRemediations(Remediations(queries, logs), *argTop)
+	}
+
+	if *argExplain {
+		fmt.Println()
+		printMetricsGlossary(os.Stdout)
+	}
 }
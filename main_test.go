@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeFlag(t *testing.T) {
+	saved := now
+	defer func() { now = saved }()
+	now = time.Date(2025, 1, 28, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"now", now},
+		{"-6h", now.Add(-6 * time.Hour)},
+		{"+30m", now.Add(30 * time.Minute)},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-05-01T10:00:00+02:00", time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		var flag timeFlag
+		if err := flag.Set(test.value); err != nil {
+			t.Errorf("Set(%q) = %v", test.value, err)
+			continue
+		}
+		if !flag.Time.Equal(test.want) {
+			t.Errorf("Set(%q) = %s, want %s", test.value, flag.Time, test.want)
+		}
+	}
+	for _, value := range []string{"", "yesterday", "-6", "2024-5-01", "2024-05-01 10:00"} {
+		var flag timeFlag
+		if err := flag.Set(value); err == nil {
+			t.Errorf("Set(%q) = %s, want an error", value, flag.Time)
+		}
+	}
+	var unset timeFlag
+	if unset.String() != "" {
+		t.Errorf("String() of an unset flag = %q, want empty", unset.String())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maintenanceWindow is a period threshold notifications are suppressed in, e.g. a planned load test or backfill.
+type maintenanceWindow struct {
+	Start, End time.Time
+}
+
+// maintenanceWindows is a flag that can be passed multiple times, each a start and end time separated by a slash.
+type maintenanceWindows []maintenanceWindow
+
+func (w *maintenanceWindows) String() string {
+	windows := make([]string, 0, len(*w))
+	for _, m := range *w {
+		windows = append(windows, m.Start.Format(time.RFC3339)+"/"+m.End.Format(time.RFC3339))
+	}
+	return strings.Join(windows, ",")
+}
+
+// Set accepts start/end, each in the formats of -from, e.g. 2024-05-01T22:00:00Z/2024-05-02T02:00:00Z or now/+2h.
+func (w *maintenanceWindows) Set(value string) error {
+	startValue, endValue, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("expected start/end, got %q", value)
+	}
+	var start, end timeFlag
+	if err := start.Set(startValue); err != nil {
+		return err
+	}
+	if err := end.Set(endValue); err != nil {
+		return err
+	}
+	if !end.After(*start.Time) {
+		return fmt.Errorf("the end of %q is not after its start", value)
+	}
+	*w = append(*w, maintenanceWindow{*start.Time, *end.Time})
+	return nil
+}
+
+// Active returns whether t is in one of the windows.
+func (w maintenanceWindows) Active(t time.Time) bool {
+	for _, m := range w {
+		if !t.Before(m.Start) && t.Before(m.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// muteStatus is the body expected from -notify-mute-url.
+type muteStatus struct {
+	Muted bool `json:"muted"`
+}
+
+// checkMuted asks the mute endpoint whether notifications are muted, e.g. while a load test runs.
+func checkMuted(client *http.Client, url string) (bool, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return false, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	var status muteStatus
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&status); err != nil {
+		return false, err
+	}
+	return status.Muted, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// markdownMetric returns the metric of a report table or percentile by its name, for formatting its values.
+func markdownMetric(name string) Metric {
+	if m, ok := Metrics[name]; ok {
+		return m
+	}
+	return costMetric()
+}
+
+// markdownCode renders a query as an inline code span inside a table cell. Pipes are escaped even in code spans
+// of GitHub-flavored Markdown tables, and the fence is longer than any run of backticks in the query.
+func markdownCode(query string) string {
+	query = strings.ReplaceAll(fitQueryWidth(escapeTerminal(query)), "|", `\|`)
+	fence := "`"
+	for strings.Contains(query, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(query, "`") || strings.HasSuffix(query, "`") {
+		query = " " + query + " "
+	}
+	return fence + query + fence
+}
+
+// markdownURL escapes the characters that would end a link destination or a table cell.
+func markdownURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "|", "%7C", "<", "%3C", ">", "%3E").Replace(u)
+}
+
+// markdownLinks renders the links of a table row to the UIs of -prometheus-url and -grafana-url, if set.
+func markdownLinks(row ReportTableRow) string {
+	var links string
+	if row.PrometheusURL != "" {
+		links += " [Prometheus](" + markdownURL(row.PrometheusURL) + ")"
+	}
+	if row.GrafanaURL != "" {
+		links += " [Grafana](" + markdownURL(row.GrafanaURL) + ")"
+	}
+	return links
+}
+
+// markdownText escapes characters that Markdown would interpret in table cells, e.g. in rule group names.
+func markdownText(text string) string {
+	var b strings.Builder
+	for _, r := range escapeTerminal(text) {
+		if strings.ContainsRune("\\`*_[]<>|#", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// WriteMarkdownReport writes the summary and the top tables of the report as GitHub-flavored Markdown, e.g. to
+// paste into a pull request or post to chat.
+func WriteMarkdownReport(w io.Writer, r *Report) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "## Prometheus query log report\n\n")
+	fmt.Fprintf(bw, "%d entries of %d distinct queries from %s to %s.\n", r.Entries, r.DistinctQueries,
+		formatTime(r.From), formatTime(r.To))
+	if r.LogFormat != "" {
+		fmt.Fprintf(bw, "Query log format: %s.\n", r.LogFormat)
+	}
+	if r.Partial {
+		fmt.Fprintln(bw, "Loading was interrupted, the report is partial.")
+	}
+	if r.ZeroTimingEntries > 0 {
+		fmt.Fprintf(bw, "%d entries have all timings equal to zero.\n", r.ZeroTimingEntries)
+	}
+	if r.MalformedLines > 0 {
+		fmt.Fprintf(bw, "%d malformed lines were skipped.\n", r.MalformedLines)
+	}
+	if r.NoiseLines > 0 {
+		fmt.Fprintf(bw, "%d lines that are not query log entries were skipped.\n", r.NoiseLines)
+	}
+
+	if len(r.Percentiles) > 0 {
+		fmt.Fprintf(bw, "\n| metric | percentile | value |\n|---|---:|---:|\n")
+		for _, p := range r.Percentiles {
+			m := markdownMetric(p.Metric)
+			fmt.Fprintf(bw, "| %s | p%d | %s |\n", m.Title, p.Rank, m.Format(p.Value))
+		}
+	}
+
+	if len(r.Summaries) > 0 {
+		fmt.Fprintf(bw, "\n| metric | min | median | avg | stddev | max |\n|---|---:|---:|---:|---:|---:|\n")
+		for _, s := range r.Summaries {
+			m := markdownMetric(s.Metric)
+			fmt.Fprintf(bw, "| %s | %s | %s | %s | %s | %s |\n", m.Title, m.Format(s.Min), m.Format(s.Median),
+				strconv.FormatFloat(s.Avg, 'f', 3, 64)+m.Unit, strconv.FormatFloat(s.StdDev, 'f', 3, 64)+m.Unit, m.Format(s.Max))
+		}
+	}
+
+	if len(r.QueryTypes) > 0 {
+		fmt.Fprintf(bw, "\n| query type | n | total execution time | metric | percentiles | median | max |\n|---|---:|---:|---|---|---:|---:|\n")
+		for _, t := range r.QueryTypes {
+			for _, s := range t.Summaries {
+				m := markdownMetric(s.Metric)
+				var percentiles []string
+				for _, p := range t.Percentiles {
+					if p.Metric == s.Metric {
+						percentiles = append(percentiles, fmt.Sprintf("p%d=%s", p.Rank, m.Format(p.Value)))
+					}
+				}
+				fmt.Fprintf(bw, "| %s | %d | %s | %s | %s | %s | %s |\n", t.Type, t.Entries, MetricExecTotalTime.Format(t.SumExecTotalTime),
+					m.Title, strings.Join(percentiles, " "), m.Format(s.Median), m.Format(s.Max))
+			}
+		}
+	}
+
+	for _, t := range r.Tables {
+		m := markdownMetric(t.Metric)
+		fmt.Fprintf(bw, "\n### Top %d queries by %s\n\n", len(t.Rows), t.Title)
+		if len(t.Rows) == 0 {
+			fmt.Fprintf(bw, "No queries.\n")
+			continue
+		}
+		header := t.Kind
+		if t.Kind == "sum" {
+			header = "total"
+		}
+		if t.Kind == "max" {
+			fmt.Fprintf(bw, "| # | time | %s | query | rule group |\n|---:|---|---:|---|---|\n", header)
+		} else {
+			fmt.Fprintf(bw, "| # | n | %s | query | rule group |\n|---:|---:|---:|---|---|\n", header)
+		}
+		for i, row := range t.Rows {
+			first := fmt.Sprint(row.Count)
+			if t.Kind == "max" && row.TS != nil {
+				first = formatTime(*row.TS)
+			}
+			fmt.Fprintf(bw, "| %d | %s | %s | %s | %s |\n", i+1, first, m.Format(row.Value), markdownCode(row.Query)+markdownLinks(row), markdownText(row.RuleGroup))
+		}
+	}
+
+	if r.Run != nil {
+		fmt.Fprintf(bw, "\n<details><summary>Run metadata</summary>\n\n```\n")
+		for _, line := range r.Run.Lines() {
+			fmt.Fprintln(bw, strings.ReplaceAll(escapeTerminal(line), "```", "'''"))
+		}
+		fmt.Fprintf(bw, "```\n</details>\n")
+	}
+	return bw.Flush()
+}
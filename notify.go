@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// maxNotifiedQueries is the number of offending queries included in a notification.
+const maxNotifiedQueries = 5
+
+// Notifier posts a notification to a webhook when the entries of the window of tail breach its thresholds, again
+// every Repeat while they still do, and once more when they no longer do.
+type Notifier struct {
+	URL string
+	// P95ExecTime, if set, is the limit of the 95th percentile of execution time of the entries of the window
+	P95ExecTime *Threshold
+	// MaxPeakSamples, if set, is the limit of the peak samples of any entry of the window
+	MaxPeakSamples *Threshold
+	Repeat         time.Duration
+	// Maintenance are the windows notifications are suppressed in
+	Maintenance maintenanceWindows
+	// MuteURL, if set, is asked before posting whether notifications are muted
+	MuteURL string
+	client  *http.Client
+	// firing is set while the thresholds are breached, lastSent is when the last notification was posted
+	firing   bool
+	lastSent time.Time
+}
+
+func NewNotifier(url string, p95ExecTime, maxPeakSamples *Threshold, repeat time.Duration) *Notifier {
+	return &Notifier{
+		URL:            url,
+		P95ExecTime:    p95ExecTime,
+		MaxPeakSamples: maxPeakSamples,
+		Repeat:         repeat,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// notification is the body posted to the webhook. text makes it a message of a Slack or Mattermost incoming
+// webhook, the other fields are for other receivers.
+type notification struct {
+	Text               string          `json:"text"`
+	Status             string          `json:"status"`
+	Window             string          `json:"window"`
+	Entries            int             `json:"entries"`
+	P95ExecTimeSeconds float64         `json:"p95_exec_time_seconds"`
+	MaxPeakSamples     int             `json:"max_peak_samples"`
+	Breaches           []string        `json:"breaches"`
+	Queries            []notifiedQuery `json:"queries"`
+	Time               time.Time       `json:"time"`
+}
+
+type notifiedQuery struct {
+	ID                 string  `json:"id"`
+	Query              string  `json:"query"`
+	Executions         int     `json:"executions"`
+	MaxExecTimeSeconds float64 `json:"max_exec_time_seconds"`
+	MaxPeakSamples     int     `json:"max_peak_samples"`
+	RuleGroup          string  `json:"rule_group,omitempty"`
+}
+
+// Check compares the entries of the window with the thresholds and posts a notification if they started or
+// stopped breaching them, or still breach them Repeat after the previous notification. Failures to post are
+// logged, so following goes on.
+func (n *Notifier) Check(entries querystats.LogEntries, queries []*querystats.Query, window time.Duration) {
+	execTimes := make([]float64, 0, len(entries))
+	maxPeak := 0
+	for _, e := range entries {
+		execTimes = append(execTimes, e.Stats.Timings.ExecTotalTime)
+		maxPeak = max(maxPeak, e.Stats.Samples.PeakSamples)
+	}
+	p95, _ := querystats.Percentile(95, execTimes)
+
+	var breaches []string
+	if n.P95ExecTime.Exceeded(p95) {
+		breaches = append(breaches, fmt.Sprintf("p95 execution time %s exceeds %s", n.P95ExecTime.Metric.Format(p95), n.P95ExecTime.Metric.Format(n.P95ExecTime.Limit)))
+	}
+	peakBreached := n.MaxPeakSamples.Exceeded(float64(maxPeak))
+	if peakBreached {
+		breaches = append(breaches, fmt.Sprintf("peak samples %d exceed %s", maxPeak, n.MaxPeakSamples.Metric.Format(n.MaxPeakSamples.Limit)))
+	}
+
+	now := time.Now()
+	status := "firing"
+	switch {
+	case len(breaches) > 0 && (!n.firing || now.Sub(n.lastSent) >= n.Repeat):
+	case len(breaches) == 0 && n.firing:
+		status = "resolved"
+	default:
+		return
+	}
+	if n.muted(now) {
+		return
+	}
+	msg := notification{
+		Status:             status,
+		Window:             window.String(),
+		Entries:            len(entries),
+		P95ExecTimeSeconds: p95,
+		MaxPeakSamples:     maxPeak,
+		Breaches:           breaches,
+		Queries:            offendingQueries(queries, peakBreached),
+		Time:               now.UTC(),
+	}
+	msg.Text = msg.format()
+	if err := n.post(msg); err != nil {
+		slog.Warn("Failed to post the notification to -notify-url", "err", err)
+		return
+	}
+	n.firing, n.lastSent = len(breaches) > 0, now
+}
+
+// muted returns whether notifications are suppressed by a maintenance window or the mute endpoint. The state is left
+// unchanged, so breaches still lasting when they are no longer suppressed are notified then. Failing to ask the mute
+// endpoint doesn't suppress them, so breaches aren't missed.
+func (n *Notifier) muted(now time.Time) bool {
+	if n.Maintenance.Active(now) {
+		slog.Debug("Suppressed a notification in a maintenance window")
+		return true
+	}
+	if n.MuteURL == "" {
+		return false
+	}
+	muted, err := checkMuted(n.client, n.MuteURL)
+	if err != nil {
+		slog.Warn("Failed to ask -notify-mute-url, notifying anyway", "err", err)
+		return false
+	}
+	if muted {
+		slog.Debug("Suppressed a notification muted by -notify-mute-url")
+	}
+	return muted
+}
+
+// offendingQueries returns the queries with the longest execution, or with the most peak samples if byPeakSamples
+// is set.
+func offendingQueries(queries []*querystats.Query, byPeakSamples bool) []notifiedQuery {
+	rows := make([]notifiedQuery, 0, len(queries))
+	for _, q := range queries {
+		row := notifiedQuery{ID: QueryID(q.Query), Query: q.Query, Executions: len(q.Logs)}
+		for _, e := range q.Logs {
+			row.MaxExecTimeSeconds = max(row.MaxExecTimeSeconds, e.Stats.Timings.ExecTotalTime)
+			row.MaxPeakSamples = max(row.MaxPeakSamples, e.Stats.Samples.PeakSamples)
+		}
+		if rg := q.Logs[0].RuleGroup; rg != nil {
+			row.RuleGroup = rg.Name
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if byPeakSamples && rows[i].MaxPeakSamples != rows[j].MaxPeakSamples {
+			return rows[i].MaxPeakSamples > rows[j].MaxPeakSamples
+		}
+		if rows[i].MaxExecTimeSeconds != rows[j].MaxExecTimeSeconds {
+			return rows[i].MaxExecTimeSeconds > rows[j].MaxExecTimeSeconds
+		}
+		return rows[i].ID < rows[j].ID
+	})
+	return rows[:min(len(rows), maxNotifiedQueries)]
+}
+
+// format renders the notification as the text of a chat message.
+func (msg notification) format() string {
+	var b strings.Builder
+	if msg.Status == "resolved" {
+		fmt.Fprintf(&b, "Resolved: queries are back within the thresholds over the last %s (%d entries, p95 execution time %.3fs, peak samples %d)",
+			msg.Window, msg.Entries, msg.P95ExecTimeSeconds, msg.MaxPeakSamples)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Slow queries over the last %s (%d entries): %s\n", msg.Window, msg.Entries, strings.Join(msg.Breaches, ", "))
+	for i, q := range msg.Queries {
+		fmt.Fprintf(&b, "%d) max=%.3fs max_peak_samples=%d n=%d %s %s", i+1, q.MaxExecTimeSeconds, q.MaxPeakSamples, q.Executions,
+			q.ID, querystats.Truncate(q.Query, 300))
+		if q.RuleGroup != "" {
+			fmt.Fprintf(&b, " | ruleName=%q", q.RuleGroup)
+		}
+		b.WriteByte('\n')
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (n *Notifier) post(msg notification) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected response status %s: %s", resp.Status, bytes.TrimSpace(text))
+	}
+	return nil
+}
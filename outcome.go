@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// timeoutProxy, if positive, makes executions taking at least this long count as probable timeouts,
+// since the Prometheus query log does not record failures itself.
+var timeoutProxy time.Duration
+
+// FailingQuery is a query with failed executions.
+type FailingQuery struct {
+	Query            *querystats.Query
+	Errors, Timeouts int
+	// Rate is the share of the executions that failed
+	Rate float64
+}
+
+// FindFailingQueries returns the queries with failed executions ordered by their failure rate.
+func FindFailingQueries(queries []*querystats.Query) []FailingQuery {
+	var rows []FailingQuery
+	for _, q := range queries {
+		errors, timeouts := q.Failures(timeoutProxy)
+		if errors+timeouts > 0 {
+			rows = append(rows, FailingQuery{q, errors, timeouts, float64(errors+timeouts) / float64(len(q.Logs))})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Rate != rows[j].Rate {
+			return rows[i].Rate > rows[j].Rate
+		}
+		return rows[i].Query.AvgExecTotalTime > rows[j].Query.AvgExecTotalTime
+	})
+	return rows
+}
+
+// PrintFailingQueries prints the top failing queries.
+func PrintFailingQueries(rows []FailingQuery, top int) {
+	top = min(top, len(rows))
+
+	fmt.Printf("Top %d queries by failure rate:\n", top)
+	for i, r := range rows[:top] {
+		fmt.Printf(
+			"%2d) n=%-6d failed=%5.1f%% errors=%-5d timeouts=%-5d %.3fs %s",
+			i+1,
+			len(r.Query.Logs),
+			100*r.Rate,
+			r.Errors,
+			r.Timeouts,
+			r.Query.AvgExecTotalTime,
+			queryWithID(r.Query.Query),
+		)
+		printRuleName(r.Query)
+		fmt.Println()
+		if msg, n := r.Query.CommonError(); msg != "" {
+			fmt.Printf("    most common error (%d): %s\n", n, escapeTerminal(msg))
+		}
+	}
+}
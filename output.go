@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputFile is the path -out writes the output of analyze to instead of stdout.
+var outputFile string
+
+func init() {
+	flag.StringVar(&outputFile, "out", "", "write the output to this file instead of stdout, in any format of -o, compressed with gzip if the name ends with .gz. The output goes to a temporary file renamed once it is complete, so a failed run, e.g. of a cron job, never leaves a partial file behind")
+}
+
+// addOutputFlag adds -out to the flags of a subcommand writing a report to stdout.
+func addOutputFlag(fs *flag.FlagSet) *string {
+	return fs.String("out", "", "write the output to this file instead of stdout, compressed with gzip if the name ends with .gz. The file is only written once the output is complete")
+}
+
+// startOutput redirects stdout to the file of -out, if set, and returns the function writing the file once the
+// output is complete, to be deferred.
+func startOutput(name string) func() {
+	if name == "" {
+		return func() {}
+	}
+	commitOutput, err := RedirectOutput(name)
+	if err != nil {
+		fatalf("Failed to create the output file: %s", err)
+	}
+	return func() {
+		if err := commitOutput(); err != nil {
+			fatalf("Failed to write the output file: %s", err)
+		}
+	}
+}
+
+// RedirectOutput makes everything printed to os.Stdout go to a temporary file next to name, compressed with gzip if
+// name ends with .gz, and returns the function restoring stdout and renaming the file to name. If the run fails
+// with fatal before, the temporary file is removed.
+func RedirectOutput(name string) (func() error, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	stdout := os.Stdout
+	onFatal = append(onFatal, func() {
+		os.Stdout = stdout
+		tmp.Close()
+		os.Remove(tmp.Name())
+	})
+	commit := func(err error) error {
+		os.Stdout = stdout
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			// CreateTemp creates files readable only by the owner, unlike the shell redirection -out replaces
+			err = os.Chmod(tmp.Name(), 0o644)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), name)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+		return err
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		os.Stdout = tmp
+		return func() error { return commit(nil) }, nil
+	}
+
+	// the output is written to stdout in many ways, so it is compressed from a pipe taking the place of stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	gz := gzip.NewWriter(tmp)
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(gz, r)
+		r.Close()
+		copied <- err
+	}()
+	os.Stdout = w
+	return func() error {
+		w.Close()
+		err := <-copied
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		return commit(err)
+	}, nil
+}
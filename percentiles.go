@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// percentileRanks is a comma-separated list of percentile ranks on the command line.
+type percentileRanks []int
+
+func (r *percentileRanks) String() string {
+	ranks := make([]string, 0, len(*r))
+	for _, p := range *r {
+		ranks = append(ranks, strconv.Itoa(p))
+	}
+	return strings.Join(ranks, ",")
+}
+
+func (r *percentileRanks) Set(value string) error {
+	var ranks percentileRanks
+	for _, s := range strings.Split(value, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("percentile rank %d must be between 1 and 100", p)
+		}
+		ranks = append(ranks, p)
+	}
+	*r = ranks
+	return nil
+}
+
+// queryPercentileRanks are the ranks of the percentiles computed for each distinct query over its executions.
+var queryPercentileRanks = percentileRanks{50, 95, 99}
+
+// globalPercentileRanks are the ranks of the percentiles computed over all entries. The first one is reported
+// where there is room for a single percentile, e.g. the html charts and the concurrency estimate.
+var globalPercentileRanks = percentileRanks{95}
+
+func init() {
+	flag.Var(&queryPercentileRanks, "query-percentiles", "comma-separated list of percentile ranks of execution time and total queryable samples computed per distinct query. Select top tables by them with -report, e.g. p99-exec")
+	flag.Var(&globalPercentileRanks, "p", "comma-separated list of percentile ranks computed over all entries, e.g. 50,90,95,99, in one pass. They are also computed per query, in addition to -query-percentiles. The first rank is used where a single percentile is reported, e.g. in the html charts")
+}
+
+// mergeRanks returns the ranks of a followed by those of b that aren't in a.
+func mergeRanks(a, b percentileRanks) percentileRanks {
+	merged := slices.Clone(a)
+	for _, p := range b {
+		if !slices.Contains(merged, p) {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+// metricPercentile returns the p-th percentile of the metric over all entries.
+func metricPercentile(p int, logs querystats.LogEntries, metric Metric) (float64, error) {
+	percentiles, err := metricPercentiles([]int{p}, logs, metric)
+	if err != nil {
+		return 0, err
+	}
+	return percentiles[p], nil
+}
+
+// metricPercentiles returns the percentiles of the given ranks of the metric over all entries, sorting the values
+// once for all of them. With -estimate-percentiles they are estimated with a digest instead.
+func metricPercentiles(ranks []int, logs querystats.LogEntries, metric Metric) (map[int]float64, error) {
+	if estimatePercentiles {
+		if len(logs) == 0 {
+			return nil, fmt.Errorf("the slice is empty")
+		}
+		d := metricDigest(logs, metric)
+		percentiles := make(map[int]float64, len(ranks))
+		for _, p := range ranks {
+			percentiles[p] = d.Quantile(p)
+		}
+		return percentiles, nil
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("the slice is empty")
+	}
+	return querystats.PercentilesOf(ranks, metric.Values(&querystats.Query{Logs: logs})), nil
+}
+
+// metricSummary returns the distribution of the metric over all entries and its percentiles of the given ranks.
+// The values are sorted once for the median and all percentiles.
+func metricSummary(ranks []int, logs querystats.LogEntries, metric Metric) (querystats.Summary, map[int]float64, error) {
+	percentiles, err := metricPercentiles(append(slices.Clone(ranks), 50), logs, metric)
+	if err != nil {
+		return querystats.Summary{}, nil, err
+	}
+	s := querystats.Summary{Min: math.Inf(1), Median: percentiles[50], Max: math.Inf(-1)}
+	sum := newSum()
+	for _, log := range logs {
+		v := metric.Value(log)
+		s.Min, s.Max = min(s.Min, v), max(s.Max, v)
+		sum.Add(v)
+	}
+	s.Avg = sum.Value() / float64(len(logs))
+	deviations := newSum()
+	for _, log := range logs {
+		d := metric.Value(log) - s.Avg
+		deviations.Add(d * d)
+	}
+	s.StdDev = math.Sqrt(deviations.Value() / float64(len(logs)))
+	return s, percentiles, nil
+}
+
+// formatSummary renders the summary of the metric on one line.
+func formatSummary(s querystats.Summary, m Metric) string {
+	return fmt.Sprintf("min=%s median=%s avg=%s stddev=%s max=%s", m.Format(s.Min), m.Format(s.Median),
+		strconv.FormatFloat(s.Avg, 'f', 3, 64)+m.Unit, strconv.FormatFloat(s.StdDev, 'f', 3, 64)+m.Unit, m.Format(s.Max))
+}
+
+// queryPercentile returns the p-th percentile of the metric over executions of the query. Percentiles of
+// -query-percentiles ranks are computed once when the query is created.
+func queryPercentile(q *querystats.Query, m Metric, p int) float64 {
+	switch m.Name {
+	case MetricExecTotalTime.Name:
+		if v, ok := q.ExecTotalTimePercentiles[p]; ok {
+			return v
+		}
+	case MetricTotalQueryableSamples.Name:
+		if v, ok := q.TotalQueryableSamplesPercentiles[p]; ok {
+			return v
+		}
+	}
+	v, _ := querystats.Percentile(p, m.Values(q))
+	return v
+}
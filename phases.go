@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// phaseShares returns the share of each of PhaseMetrics in the execution time of the entries and the share
+// not accounted for by any phase.
+func phaseShares(logs querystats.LogEntries) ([]float64, float64) {
+	sums := make([]querystats.KahanSum, len(PhaseMetrics))
+	for i := range sums {
+		sums[i] = newSum()
+	}
+	total := newSum()
+	for _, log := range logs {
+		total.Add(log.Stats.Timings.ExecTotalTime)
+		for i, m := range PhaseMetrics {
+			sums[i].Add(m.Value(log))
+		}
+	}
+	shares := make([]float64, len(PhaseMetrics))
+	if total.Value() == 0 {
+		return shares, 0
+	}
+	other := 1.0
+	for i := range sums {
+		shares[i] = sums[i].Value() / total.Value()
+		other -= shares[i]
+	}
+	return shares, max(other, 0)
+}
+
+// phaseNames are the short names of PhaseMetrics, as in -report items
+var phaseNames = []string{"queue", "prep", "eval", "sort"}
+
+// phaseAdvice is what to tune when each of PhaseMetrics dominates the execution time of queries
+var phaseAdvice = []string{
+	"the engine is saturated, raise --query.max-concurrency or spread the load",
+	"selecting series dominates, narrow the matchers or drop regular expressions",
+	"evaluation dominates, rewrite the query or precompute it with a recording rule",
+	"sorting results dominates, shrink the results, e.g. with topk or aggregation",
+}
+
+// dominantPhase returns the index in PhaseMetrics of the phase with the largest share of the execution time of the
+// entries, or -1 if no phase took any time.
+func dominantPhase(logs querystats.LogEntries) int {
+	shares, _ := phaseShares(logs)
+	dominant := -1
+	for i, share := range shares {
+		if share > 0 && (dominant < 0 || share > shares[dominant]) {
+			dominant = i
+		}
+	}
+	return dominant
+}
+
+// dominantPhaseName returns the short name of the dominant phase of the query, or "" if no phase took any time.
+func dominantPhaseName(q *querystats.Query) string {
+	if i := dominantPhase(q.Logs); i >= 0 {
+		return phaseNames[i]
+	}
+	return ""
+}
+
+func formatPhaseShares(shares []float64, other float64) string {
+	fields := make([]string, 0, len(shares)+1)
+	for i := range PhaseMetrics {
+		fields = append(fields, fmt.Sprintf("%s=%.1f%%", phaseNames[i], 100*shares[i]))
+	}
+	return strings.Join(append(fields, fmt.Sprintf("other=%.1f%%", 100*other)), " ")
+}
+
+// PrintPhaseBreakdown prints how the execution time of all entries and of the queries with the highest average
+// execution time splits into queueing, preparation, evaluation and sorting, and how many queries each phase
+// dominates with what to tune about them.
+func PrintPhaseBreakdown(queries []*querystats.Query, logs querystats.LogEntries, top int) {
+	fmt.Printf("Execution time by phase: %s\n", formatPhaseShares(phaseShares(logs)))
+
+	counts := make([]int, len(PhaseMetrics))
+	execTimes := make([]float64, len(PhaseMetrics))
+	var total float64
+	for _, q := range queries {
+		total += q.SumExecTotalTime
+		if i := dominantPhase(q.Logs); i >= 0 {
+			counts[i]++
+			execTimes[i] += q.SumExecTotalTime
+		}
+	}
+	fmt.Println("Queries by dominant phase:")
+	for i := range PhaseMetrics {
+		if counts[i] == 0 {
+			continue
+		}
+		share := 0.0
+		if total > 0 {
+			share = 100 * execTimes[i] / total
+		}
+		fmt.Printf("    %-5s queries=%-5d total=%.3fs share=%5.1f%% %s\n", phaseNames[i], counts[i], execTimes[i], share, phaseAdvice[i])
+	}
+
+	sorted := make([]*querystats.Query, len(queries))
+	copy(sorted, queries)
+	sort.Sort(sort.Reverse(querystats.ByAvgExecTotalTime{Queries: sorted}))
+	sorted = sorted[:min(top, len(sorted))]
+	fmt.Printf("Top %d queries by average execution time broken down by phase:\n", len(sorted))
+	for i, q := range sorted {
+		fmt.Printf("%2d) avg=%.3fs %s dominant=%-5s %s", i+1, q.AvgExecTotalTime, formatPhaseShares(phaseShares(q.Logs)),
+			dominantPhaseName(q), queryWithID(q.Query))
+		printRuleName(q)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,118 @@
+package querystats
+
+import (
+	"errors"
+	"io"
+)
+
+// DecodedLine is the outcome of decoding a non-empty line of the query log before any filter is applied.
+type DecodedLine struct {
+	Num int
+	// Entry is the decoded entry, or nil if the line is malformed, noise or has an empty query
+	Entry *LogEntry
+	// Err is the error of a malformed line
+	Err            string
+	Noise          bool
+	EmptyQuery     bool
+	WithoutSamples bool
+}
+
+// DecodedLog is a query log decoded by DecodeLogLines. It can be stored, e.g. with encoding/gob, and replayed by
+// ReplayLogEntries with other filters without decoding the lines again.
+type DecodedLog struct {
+	Lines            []DecodedLine
+	OversizedEntries int
+	// Interrupted is set if decoding stopped early because LoadOptions.Context was done. Such a log is partial and
+	// shouldn't be stored
+	Interrupted bool
+}
+
+// DecodeLogLines decodes every line of the query log. Only the options affecting how lines are decoded are used:
+// Decoder, MaxEntrySize, SkipNoise, Jobs and Context. Malformed lines are recorded rather than failing.
+func DecodeLogLines(r io.Reader, opts LoadOptions) (*DecodedLog, error) {
+	if opts.MapLine != nil {
+		return nil, errors.New("lines mapped by MapLine can't be decoded ahead of the filters")
+	}
+	opts = LoadOptions{Decoder: opts.Decoder, MaxEntrySize: opts.MaxEntrySize, SkipNoise: opts.SkipNoise, Jobs: opts.Jobs, Context: opts.Context}
+	decoded := &DecodedLog{}
+	decode := opts.decodeFunc()
+	decodeLine := func(l *scannedLine) {
+		line := l.line
+		l.line = nil
+		if entry := opts.parseLine(decode, line, l); entry != nil {
+			l.entry = entry
+			l.withoutSamples = !hasSamples(entry, line)
+		}
+	}
+	record := func(l *scannedLine) error {
+		if l.oversized {
+			decoded.OversizedEntries++
+			return nil
+		}
+		d := DecodedLine{Num: l.num, Entry: l.entry, Noise: l.noise, EmptyQuery: l.emptyQuery, WithoutSamples: l.withoutSamples}
+		if l.err != nil {
+			d.Err = l.err.Error()
+		}
+		decoded.Lines = append(decoded.Lines, d)
+		return nil
+	}
+	// counted separately from the oversized envelope lines of record, which may run on another goroutine
+	var oversized int
+	scanner := opts.newScanner(r, &oversized)
+	if opts.Jobs > 1 {
+		scanParallel(scanner, opts, decodeLine, record)
+	} else {
+		readLines(scanner, opts, func(l *scannedLine) bool {
+			decodeLine(l)
+			return record(l) == nil
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	decoded.OversizedEntries += oversized
+	decoded.Interrupted = scanner.Interrupted()
+	return decoded, nil
+}
+
+// ReplayLogEntries returns the entries of the decoded logs, in order, accepted by the filters in opts, as
+// ReadLogEntries would return them from the lines of the logs. Decoded logs are replayed once: queries longer than
+// MaxQueryLength are truncated in place.
+func ReplayLogEntries(logs []*DecodedLog, opts LoadOptions) (LogEntries, LoadStats, error) {
+	if opts.SampleRate > 0 && opts.SampleRate < 1 {
+		opts.lineRand = opts.newSampleRand(0)
+	}
+	entries := make(LogEntries, 0)
+	sink := newEntrySink(opts, func(entry *LogEntry) {
+		entries = append(entries, entry)
+	})
+	// offset numbers the lines of all logs as one, like the lines of inputs read one after another
+	offset := 0
+	for _, decoded := range logs {
+		sink.stats.OversizedEntries += decoded.OversizedEntries
+		sink.stats.Interrupted = sink.stats.Interrupted || decoded.Interrupted
+		for _, d := range decoded.Lines {
+			l := &scannedLine{num: offset + d.Num}
+			switch {
+			case opts.lineRand != nil && opts.lineRand.Float64() >= opts.SampleRate:
+				l.sampledOut = true
+			case d.Err != "":
+				l.err = errors.New(d.Err)
+			case d.Noise:
+				l.noise = true
+			case d.EmptyQuery:
+				l.emptyQuery = true
+			case d.Entry != nil && opts.keepEntry(d.Entry, l):
+				l.withoutSamples = d.WithoutSamples
+			}
+			if err := sink.apply(l); err != nil {
+				return nil, sink.stats, err
+			}
+		}
+		if n := len(decoded.Lines); n > 0 {
+			offset += decoded.Lines[n-1].Num + 1
+		}
+	}
+	sink.finish()
+	return entries, sink.stats, nil
+}
@@ -0,0 +1,274 @@
+package querystats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSkipLine is returned by decoders for lines that aren't query entries of their format, e.g. other messages
+// of the component writing the log. Such lines are counted as LoadStats.NoiseLines.
+var ErrSkipLine = errors.New("not a query log entry")
+
+// Decoder parses lines of a query log format into the common LogEntry model.
+type Decoder struct {
+	// Name selects the decoder, e.g. with the -format flag
+	Name string
+	// Title names the format in reports
+	Title string
+	// Decode must be safe for concurrent use, as lines are decoded in parallel with LoadOptions.Jobs. It must not
+	// retain line, whose buffer is reused for later lines
+	Decode func(line []byte, entry *LogEntry) error
+}
+
+var decoders = make(map[string]Decoder)
+
+// RegisterDecoder makes the decoder available to LookupDecoder, replacing any decoder of the same name.
+func RegisterDecoder(d Decoder) {
+	decoders[d.Name] = d
+}
+
+// LookupDecoder returns the decoder of the given name.
+func LookupDecoder(name string) (Decoder, bool) {
+	d, ok := decoders[name]
+	return d, ok
+}
+
+// DecoderNames returns the names of the registered decoders in alphabetical order.
+func DecoderNames() []string {
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterDecoder(Decoder{"prometheus", "Prometheus", decodePrometheus})
+	RegisterDecoder(Decoder{"thanos", "Thanos query-frontend slow query log", decodeThanos})
+	RegisterDecoder(Decoder{"mimir", "Mimir or Cortex query-frontend query stats", decodeMimir})
+	RegisterDecoder(Decoder{"victoriametrics", "VictoriaMetrics slow query stats", decodeVictoriaMetrics})
+}
+
+// decodePrometheus parses a line of the query log written with Prometheus' --query.log-file.
+func decodePrometheus(line []byte, entry *LogEntry) error {
+	return json.Unmarshal(line, entry)
+}
+
+// decodeThanos parses the slow query log of the Thanos query-frontend, enabled with
+// --query-frontend.log-queries-longer-than, in logfmt or JSON. Only the response time is logged.
+func decodeThanos(line []byte, entry *LogEntry) error {
+	fields, err := parseLogFields(line)
+	if err != nil {
+		return err
+	}
+	if fields["msg"] != "slow query detected" {
+		return ErrSkipLine
+	}
+	return decodeFrontendEntry(fields, "time_taken", entry)
+}
+
+// decodeMimir parses the query stats of the Mimir query-frontend, enabled with -query-frontend.query-stats-enabled,
+// and the slow query log of Cortex, in logfmt or JSON.
+func decodeMimir(line []byte, entry *LogEntry) error {
+	fields, err := parseLogFields(line)
+	if err != nil {
+		return err
+	}
+	switch fields["msg"] {
+	case "query stats":
+		if err := decodeFrontendEntry(fields, "response_time", entry); err != nil {
+			return err
+		}
+	case "slow query detected":
+		return decodeFrontendEntry(fields, "time_taken", entry)
+	default:
+		return ErrSkipLine
+	}
+	t := &entry.Stats.Timings
+	if t.ExecQueueTime, err = parseSeconds(fields["queue_time_seconds"]); err != nil {
+		return fmt.Errorf("queue_time_seconds: %w", err)
+	}
+	// the wall time is summed over the shards and splits of the query, so it can exceed the response time
+	if t.EvalTotalTime, err = parseSeconds(fields["query_wall_time_seconds"]); err != nil {
+		return fmt.Errorf("query_wall_time_seconds: %w", err)
+	}
+	if v := fields["samples_processed"]; v != "" {
+		if entry.Stats.Samples.TotalQueryableSamples, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("samples_processed: %w", err)
+		}
+	}
+	switch fields["status"] {
+	case "", "success":
+	default:
+		entry.Error = fields["status"]
+		if fields["err"] != "" {
+			entry.Error = fields["err"]
+		}
+	}
+	return nil
+}
+
+// vmQueryStatsPrefix starts the messages of the query stats VictoriaMetrics logs with -search.logSlowQueryStats.
+const vmQueryStatsPrefix = "vm_slow_query_stats "
+
+// decodeVictoriaMetrics parses the query stats vmselect or single-node VictoriaMetrics log for queries slower than
+// -search.logSlowQueryStats, in the default text format or with -loggerFormat=json. The stats are logfmt in the
+// message, with times and durations in milliseconds.
+func decodeVictoriaMetrics(line []byte, entry *LogEntry) error {
+	var ts, msg string
+	if isJSONObject(line) {
+		var l struct {
+			TS  string `json:"ts"`
+			Msg string `json:"msg"`
+		}
+		if err := json.Unmarshal(line, &l); err != nil {
+			return err
+		}
+		ts, msg = l.TS, l.Msg
+	} else {
+		// the time, level, caller and message are separated by tabs
+		parts := strings.SplitN(string(line), "\t", 4)
+		if len(parts) < 4 {
+			return ErrSkipLine
+		}
+		ts, msg = parts[0], parts[3]
+	}
+	stats, ok := strings.CutPrefix(msg, vmQueryStatsPrefix)
+	if !ok {
+		return ErrSkipLine
+	}
+	fields, err := parseLogfmt(stats)
+	if err != nil {
+		return err
+	}
+	entry.Params.Query = fields["query"]
+	if entry.Params.Query == "" {
+		return ErrSkipLine
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return fmt.Errorf("ts: %w", err)
+	}
+	entry.TS = &t
+
+	ms := make(map[string]int64, 4)
+	for _, key := range []string{"start_ms", "end_ms", "step_ms", "execution_duration_ms"} {
+		if v := fields[key]; v != "" {
+			if ms[key], err = strconv.ParseInt(v, 10, 64); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	entry.Stats.Timings.ExecTotalTime = float64(ms["execution_duration_ms"]) / 1e3
+	start, end := time.UnixMilli(ms["start_ms"]).UTC(), time.UnixMilli(ms["end_ms"]).UTC()
+	if fields["type"] == "instant" {
+		// instant queries are evaluated at the end
+		start = end
+	} else {
+		entry.Params.Step = int(ms["step_ms"] / 1e3)
+	}
+	entry.Params.Start, entry.Params.End = &start, &end
+	if v := fields["samples_fetched"]; v != "" {
+		if entry.Stats.Samples.TotalQueryableSamples, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("samples_fetched: %w", err)
+		}
+	}
+	return nil
+}
+
+// httpRequest is the type of LogEntry.HTTPRequest.
+type httpRequest = struct {
+	ClientIP string `json:"clientIP,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+// decodeFrontendEntry fills the entry from the fields common to query-frontend logs: the ts of the log line and
+// the param_* parameters of the request. durationKey is the field of the response time. Requests without a
+// PromQL query, e.g. of label values, are skipped.
+func decodeFrontendEntry(fields map[string]string, durationKey string, entry *LogEntry) error {
+	entry.Params.Query = fields["param_query"]
+	if entry.Params.Query == "" {
+		return ErrSkipLine
+	}
+	ts, err := time.Parse(time.RFC3339Nano, fields["ts"])
+	if err != nil {
+		return fmt.Errorf("ts: %w", err)
+	}
+	entry.TS = &ts
+	if entry.Stats.Timings.ExecTotalTime, err = parseSeconds(fields[durationKey]); err != nil {
+		return fmt.Errorf("%s: %w", durationKey, err)
+	}
+
+	if v := fields["param_time"]; v != "" {
+		// instant query
+		t, err := parseAPITime(v)
+		if err != nil {
+			return fmt.Errorf("param_time: %w", err)
+		}
+		entry.Params.Start, entry.Params.End = &t, &t
+	} else if fields["param_start"] != "" || fields["param_end"] != "" {
+		start, err := parseAPITime(fields["param_start"])
+		if err != nil {
+			return fmt.Errorf("param_start: %w", err)
+		}
+		end, err := parseAPITime(fields["param_end"])
+		if err != nil {
+			return fmt.Errorf("param_end: %w", err)
+		}
+		entry.Params.Start, entry.Params.End = &start, &end
+		step, err := parseSeconds(fields["param_step"])
+		if err != nil {
+			return fmt.Errorf("param_step: %w", err)
+		}
+		entry.Params.Step = int(step)
+	} else {
+		// instant queries default to the time of the request
+		entry.Params.Start, entry.Params.End = &ts, &ts
+	}
+
+	if fields["method"] != "" || fields["path"] != "" || fields["remote_addr"] != "" {
+		clientIP := fields["remote_addr"]
+		if host, _, err := net.SplitHostPort(clientIP); err == nil {
+			clientIP = host
+		}
+		entry.HTTPRequest = &httpRequest{ClientIP: clientIP, Method: fields["method"], Path: fields["path"]}
+	}
+	if v := fields["status_code"]; v != "" {
+		if entry.Status, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("status_code: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseSeconds parses a duration such as 1.5s or 250ms, or a number of seconds. An empty value is zero.
+func parseSeconds(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		return v, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return d.Seconds(), nil
+}
+
+// parseAPITime parses a time parameter of the Prometheus HTTP API: a Unix timestamp in seconds or RFC 3339.
+func parseAPITime(value string) (time.Time, error) {
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		sec, frac := int64(v), v-float64(int64(v))
+		return time.Unix(sec, int64(frac*1e9)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
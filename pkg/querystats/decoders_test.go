@@ -0,0 +1,185 @@
+package querystats
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func decode(t *testing.T, name, line string) (*LogEntry, error) {
+	t.Helper()
+	d, ok := LookupDecoder(name)
+	if !ok {
+		t.Fatalf("decoder %q is not registered", name)
+	}
+	var entry LogEntry
+	err := d.Decode([]byte(line), &entry)
+	return &entry, err
+}
+
+func mustTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	ts, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func TestDecodePrometheus(t *testing.T) {
+	line := `{"params":{"query":"rate(up[5m])","start":"2025-01-28T00:00:00Z","end":"2025-01-28T01:00:00Z","step":30},` +
+		`"ruleGroup":{"name":"node.rules","file":"/etc/prometheus/node.yml"},` +
+		`"stats":{"timings":{"execTotalTime":1.5,"execQueueTime":0.25},"samples":{"totalQueryableSamples":1200,"peakSamples":40}},` +
+		`"ts":"2025-01-28T01:00:01Z"}`
+	entry, err := decode(t, "prometheus", line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Params.Query != "rate(up[5m])" || entry.Params.Step != 30 {
+		t.Errorf("params = %+v", entry.Params)
+	}
+	if entry.RuleGroup == nil || entry.RuleGroup.Name != "node.rules" {
+		t.Errorf("rule group = %+v", entry.RuleGroup)
+	}
+	if entry.Stats.Timings.ExecTotalTime != 1.5 || entry.Stats.Timings.ExecQueueTime != 0.25 {
+		t.Errorf("timings = %+v", entry.Stats.Timings)
+	}
+	if entry.Stats.Samples.TotalQueryableSamples != 1200 || entry.Stats.Samples.PeakSamples != 40 {
+		t.Errorf("samples = %+v", entry.Stats.Samples)
+	}
+	if entry.Type() != QueryTypeRange || entry.Range() != time.Hour {
+		t.Errorf("type = %v, range = %s", entry.Type(), entry.Range())
+	}
+	if _, err := decode(t, "prometheus", `{"params":`); err == nil {
+		t.Error("a truncated line was decoded")
+	}
+}
+
+func TestDecodeThanos(t *testing.T) {
+	line := `level=info ts=2025-01-28T00:00:05.5Z caller=roundtrip.go:1 msg="slow query detected" time_taken=2.5s ` +
+		`param_query="sum(rate(http_requests_total{job=\"api\"}[5m]))" param_start=1738022400 param_end=2025-01-28T01:00:00Z ` +
+		`param_step=60 method=GET path=/api/v1/query_range remote_addr=10.0.0.1:52000 status_code=200`
+	entry, err := decode(t, "thanos", line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Params.Query != `sum(rate(http_requests_total{job="api"}[5m]))` {
+		t.Errorf("query = %q", entry.Params.Query)
+	}
+	if !entry.TS.Equal(mustTime(t, "2025-01-28T00:00:05.5Z")) {
+		t.Errorf("ts = %s", entry.TS)
+	}
+	if entry.Stats.Timings.ExecTotalTime != 2.5 {
+		t.Errorf("exec time = %g", entry.Stats.Timings.ExecTotalTime)
+	}
+	if !entry.Params.Start.Equal(mustTime(t, "2025-01-28T00:00:00Z")) || !entry.Params.End.Equal(mustTime(t, "2025-01-28T01:00:00Z")) || entry.Params.Step != 60 {
+		t.Errorf("params = %s %s %d", entry.Params.Start, entry.Params.End, entry.Params.Step)
+	}
+	if entry.HTTPRequest == nil || entry.HTTPRequest.ClientIP != "10.0.0.1" || entry.HTTPRequest.Path != "/api/v1/query_range" {
+		t.Errorf("http request = %+v", entry.HTTPRequest)
+	}
+	if entry.Status != 200 {
+		t.Errorf("status = %d", entry.Status)
+	}
+
+	// instant queries without a time are evaluated at the time of the request
+	entry, err = decode(t, "thanos", `{"ts":"2025-01-28T00:00:05Z","msg":"slow query detected","time_taken":"800ms","param_query":"up"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Stats.Timings.ExecTotalTime != 0.8 || !entry.Params.Start.Equal(*entry.TS) || entry.Type() != QueryTypeInstant {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	for _, line := range []string{
+		`level=info ts=2025-01-28T00:00:05Z msg="starting query frontend"`,
+		`level=info ts=2025-01-28T00:00:05Z msg="slow query detected" time_taken=1s param_match[]=up`,
+	} {
+		if _, err := decode(t, "thanos", line); !errors.Is(err, ErrSkipLine) {
+			t.Errorf("decoding %q returned %v, want ErrSkipLine", line, err)
+		}
+	}
+}
+
+func TestDecodeMimir(t *testing.T) {
+	line := `ts=2025-01-28T00:00:05Z caller=handler.go:1 level=info msg="query stats" method=GET path=/prometheus/api/v1/query ` +
+		`response_time=1.2s query_wall_time_seconds=3.5 queue_time_seconds=0.1 samples_processed=5000 status=failed ` +
+		`err="query timed out" param_query=up param_time=1738022405`
+	entry, err := decode(t, "mimir", line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timings := entry.Stats.Timings
+	if timings.ExecTotalTime != 1.2 || timings.EvalTotalTime != 3.5 || timings.ExecQueueTime != 0.1 {
+		t.Errorf("timings = %+v", timings)
+	}
+	if entry.Stats.Samples.TotalQueryableSamples != 5000 {
+		t.Errorf("samples = %d", entry.Stats.Samples.TotalQueryableSamples)
+	}
+	if entry.Error != "query timed out" {
+		t.Errorf("error = %q", entry.Error)
+	}
+	if !entry.Params.Start.Equal(mustTime(t, "2025-01-28T00:00:05Z")) || entry.Type() != QueryTypeInstant {
+		t.Errorf("params = %+v", entry.Params)
+	}
+
+	if _, err := decode(t, "mimir", `ts=2025-01-28T00:00:05Z msg="query stats" response_time=1s param_query=up samples_processed=x`); err == nil {
+		t.Error("an invalid samples_processed was decoded")
+	}
+	if _, err := decode(t, "mimir", `ts=2025-01-28T00:00:05Z msg="other"`); !errors.Is(err, ErrSkipLine) {
+		t.Errorf("decoding another message returned %v, want ErrSkipLine", err)
+	}
+}
+
+func TestDecodeVictoriaMetrics(t *testing.T) {
+	text := "2025-03-01T10:00:00.123Z\twarn\tVictoriaMetrics/app/vmselect/promql/exec.go:120\tvm_slow_query_stats type=range " +
+		`query="sum(rate(http_requests_total{job=\"api\"}[5m]))" start_ms=1740819600000 end_ms=1740823200000 step_ms=30000 ` +
+		"execution_duration_ms=1532 samples_fetched=240000"
+	entry, err := decode(t, "victoriametrics", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Params.Query != `sum(rate(http_requests_total{job="api"}[5m]))` {
+		t.Errorf("query = %q", entry.Params.Query)
+	}
+	if entry.Stats.Timings.ExecTotalTime != 1.532 || entry.Stats.Samples.TotalQueryableSamples != 240000 {
+		t.Errorf("stats = %+v", entry.Stats)
+	}
+	if entry.Params.Step != 30 || entry.Range() != time.Hour || !entry.TS.Equal(mustTime(t, "2025-03-01T10:00:00.123Z")) {
+		t.Errorf("params = %+v, ts = %s", entry.Params, entry.TS)
+	}
+
+	// instant queries are evaluated at the end
+	json := `{"ts":"2025-03-01T10:00:02Z","level":"warn","caller":"x","msg":"vm_slow_query_stats type=instant query=\"up\" start_ms=1740823000000 end_ms=1740823200000 step_ms=0 execution_duration_ms=12"}`
+	if entry, err = decode(t, "victoriametrics", json); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Type() != QueryTypeInstant || !entry.Params.Start.Equal(*entry.Params.End) || entry.Stats.Timings.ExecTotalTime != 0.012 {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	for _, line := range []string{
+		"2025-03-01T10:00:01.000Z\tinfo\tVictoriaMetrics/lib/x.go:1\tstarting",
+		"not a log line",
+		`{"ts":"2025-03-01T10:00:02Z","msg":"vm_slow_query_stats type=instant"}`,
+	} {
+		if _, err := decode(t, "victoriametrics", line); !errors.Is(err, ErrSkipLine) {
+			t.Errorf("decoding %q returned %v, want ErrSkipLine", line, err)
+		}
+	}
+}
+
+func TestDecoderNames(t *testing.T) {
+	names := DecoderNames()
+	want := []string{"mimir", "prometheus", "thanos", "victoriametrics"}
+	for _, name := range want {
+		if _, ok := LookupDecoder(name); !ok {
+			t.Errorf("decoder %q is not registered", name)
+		}
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i-1] >= names[i] {
+			t.Errorf("DecoderNames() = %v is not sorted", names)
+		}
+	}
+}
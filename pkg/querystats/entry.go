@@ -0,0 +1,176 @@
+// Package querystats parses the Prometheus query log and aggregates the statistics of its entries per query.
+//
+// ReadLogEntries and ScanLogEntries parse a query log, e.g. a file written by Prometheus with
+// --query.log-file, and GroupQueries aggregates the entries into Queries. LoadQueriesFromLog does both.
+package querystats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogEntry is a line of the query log.
+type LogEntry struct {
+	Params struct {
+		Query string     `json:"query"`
+		Start *time.Time `json:"start"`
+		End   *time.Time `json:"end"`
+		Step  int        `json:"step"`
+	} `json:"params"`
+	Stats struct {
+		Timings struct {
+			EvalTotalTime        float64 `json:"evalTotalTime"`
+			ExecQueueTime        float64 `json:"execQueueTime"`
+			ExecTotalTime        float64 `json:"execTotalTime"`
+			InnerEvalTime        float64 `json:"innerEvalTime"`
+			QueryPreparationTime float64 `json:"queryPreparationTime"`
+			ResultSortTime       float64 `json:"resultSortTime"`
+		} `json:"timings"`
+		Samples struct {
+			TotalQueryableSamples int `json:"totalQueryableSamples"`
+			PeakSamples           int `json:"peakSamples"`
+		} `json:"samples,omitempty"`
+	} `json:"stats"`
+	RuleGroup *struct {
+		Name string `json:"name,omitempty"`
+		File string `json:"file,omitempty"`
+	} `json:"ruleGroup,omitempty"`
+	// HTTPRequest is set for queries received over the HTTP API
+	HTTPRequest *struct {
+		ClientIP string `json:"clientIP,omitempty"`
+		Method   string `json:"method,omitempty"`
+		Path     string `json:"path,omitempty"`
+	} `json:"httpRequest,omitempty"`
+	// Error and Status are not written by all Prometheus versions, but some versions and log
+	// wrappers record why a query failed or the HTTP status of the response
+	Error  string     `json:"error,omitempty"`
+	Status int        `json:"status,omitempty"`
+	TS     *time.Time `json:"ts"`
+	// Instance is not written by Prometheus, it labels the entries of the Prometheus server an input came from when
+	// the logs of several servers are analyzed together
+	Instance string `json:"instance,omitempty"`
+	// Extra holds values of a bespoke log schema a registered decoder keeps for metrics of MetricExtractors
+	Extra map[string]float64 `json:"extra,omitempty"`
+}
+
+type LogEntries []*LogEntry
+
+func (le LogEntries) Len() int      { return len(le) }
+func (le LogEntries) Swap(i, j int) { le[i], le[j] = le[j], le[i] }
+
+type ByTime struct{ LogEntries }
+
+func (le ByTime) Less(i, j int) bool {
+	return le.LogEntries[i].TS.Before(*le.LogEntries[j].TS)
+}
+
+func (le LogEntries) GetExecTotalTimeValues() []float64 {
+	vals := make([]float64, 0, len(le))
+	for _, log := range le {
+		vals = append(vals, log.Stats.Timings.ExecTotalTime)
+	}
+	return vals
+}
+
+func (le LogEntries) GetTotalQueryableSamplesValues() []int {
+	vals := make([]int, 0, len(le))
+	for _, log := range le {
+		vals = append(vals, log.Stats.Samples.TotalQueryableSamples)
+	}
+	return vals
+}
+
+func (le LogEntries) GetPeakSamplesValues() []int {
+	vals := make([]int, 0, len(le))
+	for _, log := range le {
+		vals = append(vals, log.Stats.Samples.PeakSamples)
+	}
+	return vals
+}
+
+// HasZeroTimings reports whether all timings of the entry are zero.
+func (e *LogEntry) HasZeroTimings() bool {
+	t := e.Stats.Timings
+	return t.EvalTotalTime == 0 && t.ExecQueueTime == 0 && t.ExecTotalTime == 0 &&
+		t.InnerEvalTime == 0 && t.QueryPreparationTime == 0 && t.ResultSortTime == 0
+}
+
+// Range returns the time range of a range query, end - start. It is zero for instant queries and entries
+// without a valid range.
+func (e *LogEntry) Range() time.Duration {
+	if e.Params.Step <= 0 || e.Params.Start == nil || e.Params.End == nil || e.Params.End.Before(*e.Params.Start) {
+		return 0
+	}
+	return e.Params.End.Sub(*e.Params.Start)
+}
+
+// Points returns the estimated number of evaluation points of the query: (end - start) / step + 1 for
+// range queries and 1 for instant queries. The query log records the step in seconds.
+func (e *LogEntry) Points() int {
+	if e.Params.Step <= 0 || e.Params.Start == nil || e.Params.End == nil || e.Params.End.Before(*e.Params.Start) {
+		return 1
+	}
+	return int(e.Params.End.Sub(*e.Params.Start).Seconds())/e.Params.Step + 1
+}
+
+// QueryType tells instant queries, evaluated at a single time, from range queries, evaluated at every step
+// between start and end. Rule evaluations are instant queries.
+type QueryType string
+
+const (
+	QueryTypeInstant QueryType = "instant"
+	QueryTypeRange   QueryType = "range"
+)
+
+// QueryTypes are all query types.
+var QueryTypes = []QueryType{QueryTypeInstant, QueryTypeRange}
+
+// ParseQueryType parses instant or range.
+func ParseQueryType(name string) (QueryType, error) {
+	for _, t := range QueryTypes {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown query type %q, must be instant or range", name)
+}
+
+// Type returns the type of the query. The query log records a step only for range queries.
+func (e *LogEntry) Type() QueryType {
+	if e.Params.Step > 0 {
+		return QueryTypeRange
+	}
+	return QueryTypeInstant
+}
+
+// Outcome of a query execution as far as it can be inferred from the log entry.
+type Outcome int
+
+const (
+	OutcomeOK Outcome = iota
+	OutcomeError
+	OutcomeTimeout
+)
+
+var outcomeNames = map[Outcome]string{OutcomeOK: "ok", OutcomeError: "error", OutcomeTimeout: "timeout"}
+
+func (o Outcome) String() string {
+	return outcomeNames[o]
+}
+
+// Outcome infers the result of the execution from the error message or response status
+// recorded by newer Prometheus versions or log wrappers. Since the query log does not record
+// failures itself, executions taking at least timeoutProxy count as timeouts if it is positive.
+func (e *LogEntry) Outcome(timeoutProxy time.Duration) Outcome {
+	msg := strings.ToLower(e.Error)
+	switch {
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "timed out") || strings.Contains(msg, "deadline exceeded") || e.Status == 503 || e.Status == 504:
+		return OutcomeTimeout
+	case e.Error != "" || e.Status >= 400:
+		return OutcomeError
+	case timeoutProxy > 0 && e.Stats.Timings.ExecTotalTime >= timeoutProxy.Seconds():
+		return OutcomeTimeout
+	}
+	return OutcomeOK
+}
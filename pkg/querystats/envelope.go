@@ -0,0 +1,56 @@
+package querystats
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Envelopes of log shippers that query log lines scraped off nodes are often wrapped in.
+const (
+	// dockerEnvelope is a line of Docker's json-file log driver: {"log":"<line>\n","stream":"stdout","time":"..."}
+	dockerEnvelope = "Docker json-file"
+	// journaldEnvelope is a line of journalctl -o json: {"MESSAGE":"<line>","__REALTIME_TIMESTAMP":"...",...}
+	journaldEnvelope = "journald JSON"
+)
+
+// envelope holds the fields telling the envelopes apart and the wrapped line.
+type envelope struct {
+	Log      *string         `json:"log"`
+	Stream   string          `json:"stream"`
+	Message  json.RawMessage `json:"MESSAGE"`
+	Realtime string          `json:"__REALTIME_TIMESTAMP"`
+}
+
+// unwrapEnvelope returns the line wrapped in a Docker json-file or journald envelope and the kind of the envelope.
+// ok is false if the line isn't wrapped. Lines of Docker keep their trailing newline, its absence marks a line
+// Docker split because it was longer than 16 KiB.
+func unwrapEnvelope(line []byte) (inner []byte, kind string, ok bool) {
+	// the keys can't appear unescaped in query log entries, so most lines are told apart without decoding them
+	if !isJSONObject(line) || !bytes.Contains(line, []byte(`"log"`)) && !bytes.Contains(line, []byte(`"MESSAGE"`)) {
+		return nil, "", false
+	}
+	var e envelope
+	if json.Unmarshal(line, &e) != nil {
+		return nil, "", false
+	}
+	switch {
+	case e.Log != nil && e.Stream != "":
+		return []byte(*e.Log), dockerEnvelope, true
+	case len(e.Message) > 0 && e.Realtime != "":
+		// journald stores messages that aren't valid UTF-8 as arrays of bytes
+		var msg string
+		if json.Unmarshal(e.Message, &msg) == nil {
+			return []byte(msg), journaldEnvelope, true
+		}
+		var b []int
+		if json.Unmarshal(e.Message, &b) != nil {
+			return nil, "", false
+		}
+		inner = make([]byte, len(b))
+		for i, c := range b {
+			inner[i] = byte(c)
+		}
+		return inner, journaldEnvelope, true
+	}
+	return nil, "", false
+}
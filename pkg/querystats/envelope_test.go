@@ -0,0 +1,103 @@
+package querystats
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const envelopeEntry = `{"params":{"query":"up","start":"2025-01-28T00:00:00Z","end":"2025-01-28T00:00:00Z","step":0},` +
+	`"stats":{"timings":{"execTotalTime":0.5},"samples":{"totalQueryableSamples":10,"peakSamples":1}},"ts":"2025-01-28T00:00:00Z"}`
+
+// dockerLine wraps the piece of a line like Docker's json-file log driver.
+func dockerLine(t *testing.T, piece string) string {
+	t.Helper()
+	b, err := json.Marshal(map[string]string{"log": piece, "stream": "stdout", "time": "2025-01-28T00:00:00.000000001Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestUnwrapEnvelope(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantInner string
+		wantKind  string
+		wantOK    bool
+	}{
+		{"docker", dockerLine(t, envelopeEntry+"\n"), envelopeEntry + "\n", dockerEnvelope, true},
+		{"journald", `{"MESSAGE":"hello","__REALTIME_TIMESTAMP":"1738022400000000","_PID":"1"}`, "hello", journaldEnvelope, true},
+		{"journald bytes", `{"MESSAGE":[104,105,255],"__REALTIME_TIMESTAMP":"1738022400000000"}`, "hi\xff", journaldEnvelope, true},
+		{"query log entry", envelopeEntry, "", "", false},
+		{"log without stream", `{"log":"x"}`, "", "", false},
+		{"message without timestamp", `{"MESSAGE":"x"}`, "", "", false},
+		{"not json", `level=info msg="log"`, "", "", false},
+		{"invalid json", `{"log": "x", `, "", "", false},
+	}
+	for _, tt := range tests {
+		inner, kind, ok := unwrapEnvelope([]byte(tt.line))
+		if string(inner) != tt.wantInner || kind != tt.wantKind || ok != tt.wantOK {
+			t.Errorf("%s: unwrapEnvelope = %q, %q, %v, want %q, %q, %v", tt.name, inner, kind, ok, tt.wantInner, tt.wantKind, tt.wantOK)
+		}
+	}
+}
+
+func TestReadLogEntriesEnvelopes(t *testing.T) {
+	// Docker splits lines longer than 16 KiB into pieces, only the last of which ends with a newline
+	split := len(envelopeEntry) / 2
+	log := strings.Join([]string{
+		dockerLine(t, envelopeEntry+"\n"),
+		dockerLine(t, envelopeEntry[:split]),
+		dockerLine(t, envelopeEntry[split:]+"\n"),
+	}, "\n")
+	for _, jobs := range []int{1, 4} {
+		// Log isn't safe for concurrent use, as it is only called from the calling goroutine
+		var messages []string
+		entries, _, err := ReadLogEntries(strings.NewReader(log), LoadOptions{Jobs: jobs, Log: func(_ slog.Level, msg string, _ ...any) {
+			messages = append(messages, msg)
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("jobs=%d: read %d entries, want 2", jobs, len(entries))
+		}
+		for _, e := range entries {
+			if e.Params.Query != "up" || e.Stats.Timings.ExecTotalTime != 0.5 {
+				t.Errorf("jobs=%d: entry = %+v", jobs, e)
+			}
+		}
+		if len(messages) != 1 || !strings.Contains(messages[0], "envelopes") {
+			t.Errorf("jobs=%d: logged %q, want the envelope once", jobs, messages)
+		}
+	}
+
+	journald, err := json.Marshal(map[string]string{"MESSAGE": envelopeEntry, "__REALTIME_TIMESTAMP": "1738022400000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := ReadLogEntries(strings.NewReader(string(journald)+"\n"+envelopeEntry+"\n"), LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("read %d entries from journald and plain lines, want 2", len(entries))
+	}
+}
+
+func TestReadLogEntriesOversizedEnvelope(t *testing.T) {
+	// the pieces of a split line are joined up to MaxEntrySize, longer lines are skipped
+	long := strings.Replace(envelopeEntry, `"query":"up"`, `"query":"`+strings.Repeat("a", 1000)+`"`, 1)
+	split := len(long) / 2
+	log := dockerLine(t, long[:split]) + "\n" + dockerLine(t, long[split:]+"\n") + "\n" + dockerLine(t, "{}\n")
+	entries, stats, err := ReadLogEntries(strings.NewReader(log), LoadOptions{MaxEntrySize: split + 200, SkipNoise: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 || stats.OversizedEntries != 1 {
+		t.Errorf("read %d entries and %d oversized, want 0 and 1", len(entries), stats.OversizedEntries)
+	}
+}
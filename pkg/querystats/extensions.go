@@ -0,0 +1,58 @@
+package querystats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EntryDecoder parses lines of a bespoke query log schema into the common LogEntry model. Values the model has no
+// field for can be kept in LogEntry.Extra for a MetricExtractor. Implementations are compiled in and registered
+// with RegisterEntryDecoder from an init function.
+type EntryDecoder interface {
+	// Decode must be safe for concurrent use and must not retain line, see Decoder.Decode
+	Decode(line []byte, entry *LogEntry) error
+}
+
+// RegisterEntryDecoder makes the decoder available under the name, e.g. to the -format flag, like RegisterDecoder.
+func RegisterEntryDecoder(name, title string, d EntryDecoder) {
+	RegisterDecoder(Decoder{Name: name, Title: title, Decode: d.Decode})
+}
+
+// MetricExtractor derives a value from each entry, e.g. samples per second of execution time or a value of
+// LogEntry.Extra.
+type MetricExtractor interface {
+	Extract(entry *LogEntry) float64
+}
+
+// CustomMetric is a metric compiled in besides the built-in ones, available wherever metrics are selected by name.
+type CustomMetric struct {
+	// Name selects the metric, e.g. in -report items such as max-<name>. It can't contain '-' or ','
+	Name  string
+	Title string
+	Unit  string
+	// Int is set for metrics that are counts
+	Int       bool
+	Extractor MetricExtractor
+}
+
+var customMetrics = make(map[string]CustomMetric)
+
+// RegisterMetric makes the metric available, replacing any custom metric of the same name. It panics on invalid
+// names, as metrics are registered from init functions.
+func RegisterMetric(m CustomMetric) {
+	if m.Name == "" || strings.ContainsAny(m.Name, "-,") {
+		panic(fmt.Sprintf("invalid metric name %q", m.Name))
+	}
+	customMetrics[m.Name] = m
+}
+
+// CustomMetrics returns the registered metrics in alphabetical order.
+func CustomMetrics() []CustomMetric {
+	metrics := make([]CustomMetric, 0, len(customMetrics))
+	for _, m := range customMetrics {
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics
+}
@@ -0,0 +1,75 @@
+package querystats
+
+import (
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const fingerprintPlaceholder = "?"
+
+// fingerprintCacheSize bounds the number of queries whose fingerprints are cached. The cache is cleared when it is
+// full, which only happens with more distinct queries than a log usually has.
+const fingerprintCacheSize = 1 << 16
+
+type fingerprintResult struct {
+	fingerprint string
+	err         error
+}
+
+// fingerprintCache holds the fingerprints of the queries seen so far, as the same queries are executed over and over
+// and parsing them again for every entry dominates grouping by fingerprint.
+var fingerprintCache = struct {
+	sync.Mutex
+	results map[string]fingerprintResult
+}{results: make(map[string]fingerprintResult)}
+
+// Fingerprint returns the query with its literals replaced, so that queries differing only in injected values,
+// e.g. Grafana variables, share a fingerprint. Number literals become 0, string literals and label values
+// become "?", and offset and @ modifiers are removed. Metric names, label names, functions, aggregation
+// labels and range durations are kept. Results are cached, so it is cheap to call for every entry.
+func Fingerprint(query string) (string, error) {
+	fingerprintCache.Lock()
+	r, ok := fingerprintCache.results[query]
+	fingerprintCache.Unlock()
+	if ok {
+		return r.fingerprint, r.err
+	}
+	r.fingerprint, r.err = fingerprint(query)
+	fingerprintCache.Lock()
+	if len(fingerprintCache.results) >= fingerprintCacheSize {
+		clear(fingerprintCache.results)
+	}
+	fingerprintCache.results[query] = r
+	fingerprintCache.Unlock()
+	return r.fingerprint, r.err
+}
+
+func fingerprint(query string) (string, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", err
+	}
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.NumberLiteral:
+			n.Val = 0
+		case *parser.StringLiteral:
+			n.Val = fingerprintPlaceholder
+		case *parser.VectorSelector:
+			for i, m := range n.LabelMatchers {
+				if m.Name == labels.MetricName && n.Name != "" {
+					continue
+				}
+				// the matcher is only printed, so its regexp doesn't have to be compiled
+				n.LabelMatchers[i] = &labels.Matcher{Type: m.Type, Name: m.Name, Value: fingerprintPlaceholder}
+			}
+			n.OriginalOffset, n.Timestamp, n.StartOrEnd = 0, nil, 0
+		case *parser.SubqueryExpr:
+			n.OriginalOffset, n.Timestamp, n.StartOrEnd = 0, nil, 0
+		}
+		return nil
+	})
+	return expr.String(), nil
+}
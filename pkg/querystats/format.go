@@ -0,0 +1,62 @@
+package querystats
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LogFormat is the Prometheus version family that wrote a query log, detected from the fields of its entries.
+type LogFormat struct {
+	// Family is a range of Prometheus versions, e.g. "2.35 or later"
+	Family string
+	// Samples is the share of entries with sample statistics
+	Samples float64
+}
+
+// HasSamples reports whether any entry has sample statistics. Sample metrics of logs without them are all zero.
+func (f LogFormat) HasSamples() bool {
+	return f.Samples > 0
+}
+
+func (f LogFormat) String() string {
+	switch {
+	case f.Samples == 0:
+		return f.Family + ", without sample statistics"
+	case f.Samples < 1:
+		return fmt.Sprintf("%s, %.0f%% of entries have sample statistics", f.Family, 100*f.Samples)
+	}
+	return f.Family
+}
+
+// DetectLogFormat detects the format of the query log the entries were read from. stats must be
+// returned by the read of the entries.
+func DetectLogFormat(entries LogEntries, stats LoadStats) LogFormat {
+	if len(entries) == 0 {
+		return LogFormat{Family: "unknown"}
+	}
+	samples := 1 - float64(min(stats.WithoutSamples, len(entries)))/float64(len(entries))
+	switch {
+	case samples == 1:
+		return LogFormat{"Prometheus 2.35 or later", samples}
+	case samples == 0:
+		return LogFormat{"Prometheus 2.16 to 2.34", samples}
+	}
+	return LogFormat{"Prometheus 2.16 to 2.34 upgraded to 2.35 or later", samples}
+}
+
+// hasSamples reports whether the entry parsed from line has sample statistics. Only entries with zero samples
+// are parsed again.
+func hasSamples(entry *LogEntry, line []byte) bool {
+	if entry.Stats.Samples.TotalQueryableSamples != 0 || entry.Stats.Samples.PeakSamples != 0 {
+		return true
+	}
+	var probe struct {
+		Stats struct {
+			Samples json.RawMessage `json:"samples"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal(line, &probe); err != nil {
+		return false
+	}
+	return len(probe.Stats.Samples) > 0 && string(probe.Stats.Samples) != "null"
+}
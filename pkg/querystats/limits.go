@@ -0,0 +1,17 @@
+package querystats
+
+// maxLoggedMalformedLines is the number of lines skipped with LoadOptions.SkipErrors that are logged individually.
+const maxLoggedMalformedLines = 10
+
+// DefaultMaxEntrySize is the length of the longest line parsed unless LoadOptions.MaxEntrySize is set.
+const DefaultMaxEntrySize = 1 << 20
+
+// limitQuery truncates the query of the entry to opts.MaxQueryLength bytes. Truncated queries end with "..."
+// and it reports whether the query was truncated.
+func (opts LoadOptions) limitQuery(entry *LogEntry) bool {
+	if opts.MaxQueryLength <= 0 || len(entry.Params.Query) <= opts.MaxQueryLength {
+		return false
+	}
+	entry.Params.Query = Truncate(entry.Params.Query, opts.MaxQueryLength)
+	return true
+}
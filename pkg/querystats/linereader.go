@@ -0,0 +1,147 @@
+package querystats
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+)
+
+// lineReaderBufferSize is the size of the buffer lines are read into. Longer lines are assembled in a second buffer,
+// which is reused for the following long lines.
+const lineReaderBufferSize = 64 * 1024
+
+// interruptCheckLines is how many lines are read between checks whether the context of a lineReader is done, as
+// checking takes a lock.
+const interruptCheckLines = 1024
+
+// lineReader reads lines like bufio.Scanner with bufio.ScanLines, but returns them from its buffers without copying
+// and skips lines longer than max bytes instead of failing, so a single pathological entry can't stop loading or
+// exhaust memory. Skipped lines are counted in oversized. A line is only valid until the next call to Scan.
+type lineReader struct {
+	r         *bufio.Reader
+	max       int
+	oversized *int
+	// long holds the pieces of a line that doesn't fit the buffer of r
+	long []byte
+	line []byte
+	err  error
+	// ctx, if set, stops reading when it is done, which sets interrupted
+	ctx         context.Context
+	lines       int
+	interrupted bool
+}
+
+func newLineReader(r io.Reader, max int, oversized *int) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, min(lineReaderBufferSize, max+1)), max: max, oversized: oversized}
+}
+
+// Scan advances to the next line, which is then available through Bytes. It returns false at the end of the input
+// or on an error, see Err.
+func (lr *lineReader) Scan() bool {
+	if lr.ctx != nil && lr.lines%interruptCheckLines == 0 && lr.ctx.Err() != nil {
+		lr.interrupted = true
+		return false
+	}
+	lr.lines++
+	discarding := false
+	for lr.err == nil {
+		lr.long = lr.long[:0]
+		piece, err := lr.r.ReadSlice('\n')
+		for errors.Is(err, bufio.ErrBufferFull) {
+			// keep at most one byte more than max, enough to tell that the line is too long
+			if !discarding {
+				lr.long = append(lr.long, piece[:min(len(piece), lr.max+1-len(lr.long))]...)
+				discarding = len(lr.long) > lr.max
+			}
+			piece, err = lr.r.ReadSlice('\n')
+		}
+		if err != nil {
+			if lr.ctx != nil && lr.ctx.Err() != nil {
+				// reads fail once the context is done, e.g. of a canceled request, which ends the input early
+				lr.interrupted = true
+				err = io.EOF
+			}
+			lr.err = err
+			if err != io.EOF || len(piece) == 0 && len(lr.long) == 0 && !discarding {
+				return false
+			}
+		}
+		line := piece
+		if len(lr.long) > 0 && !discarding {
+			lr.long = append(lr.long, piece...)
+			line = lr.long
+		}
+		if n := len(line); n > 0 && line[n-1] == '\n' {
+			line = line[:n-1]
+		}
+		if discarding || len(line) > lr.max {
+			*lr.oversized++
+			discarding = false
+			continue
+		}
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		lr.line = line
+		return true
+	}
+	return false
+}
+
+// Bytes returns the current line without its line ending.
+func (lr *lineReader) Bytes() []byte {
+	return lr.line
+}
+
+// Interrupted reports whether reading stopped because the context was done, before the end of the input.
+func (lr *lineReader) Interrupted() bool {
+	return lr.interrupted
+}
+
+// Err returns the first error other than io.EOF that stopped reading.
+func (lr *lineReader) Err() error {
+	if lr.err == io.EOF {
+		return nil
+	}
+	return lr.err
+}
+
+// contextReader reads from r until ctx is done, which ends the input even while a read blocks, e.g. on a terminal or
+// a pipe. The blocked read is left behind and its result discarded.
+type contextReader struct {
+	ctx     context.Context
+	r       io.Reader
+	buf     []byte
+	results chan readResult
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func newContextReader(ctx context.Context, r io.Reader) *contextReader {
+	return &contextReader{ctx: ctx, r: r, results: make(chan readResult, 1)}
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if cr.ctx.Err() != nil {
+		return 0, cr.ctx.Err()
+	}
+	if len(cr.buf) < len(p) {
+		cr.buf = make([]byte, len(p))
+	}
+	// the buffer is private, since an abandoned read may still write to it
+	buf := cr.buf[:len(p)]
+	go func() {
+		n, err := cr.r.Read(buf)
+		cr.results <- readResult{n, err}
+	}()
+	select {
+	case res := <-cr.results:
+		return copy(p, buf[:res.n]), res.err
+	case <-cr.ctx.Done():
+		return 0, cr.ctx.Err()
+	}
+}
@@ -0,0 +1,608 @@
+package querystats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LoadOptions filter and limit the entries loaded from the query log.
+type LoadOptions struct {
+	From *time.Time
+	To   *time.Time
+	// DataFrom and DataTo keep only entries whose query's [start, end] window overlaps [DataFrom, DataTo]
+	DataFrom *time.Time
+	DataTo   *time.Time
+	// QueryMatch and QueryExclude, if set, keep only entries whose query matches QueryMatch and doesn't match QueryExclude
+	QueryMatch   *regexp.Regexp
+	QueryExclude *regexp.Regexp
+	// QueryFilter, if set, keeps only entries whose query it returns true for, e.g. queries with given ids. It is
+	// called from several goroutines if Jobs is greater than 1
+	QueryFilter func(query string) bool
+	// MinExecTime and MinSamples, if set, keep only entries that took at least MinExecTime or read at least
+	// MinSamples total queryable samples, so the analysis covers only the expensive tail
+	MinExecTime time.Duration
+	MinSamples  int
+	// Type, if set, keeps only entries of instant or range queries
+	Type QueryType
+	// ClientIPs and PathMatch, if set, keep only entries received over the HTTP API from a client in one of
+	// ClientIPs and for a request path matching PathMatch, e.g. to attribute load to a Grafana instance
+	ClientIPs  []netip.Prefix
+	PathMatch  *regexp.Regexp
+	Normalizer Normalizer
+	// MapLine, if set, converts each line to the Prometheus query log format before parsing.
+	// Returning nil skips the line. It is called from a single goroutine even if Jobs is greater than 1, the one
+	// reading the lines, which isn't the calling goroutine then
+	MapLine func(line []byte) ([]byte, error)
+	// Decoder, if set, parses lines of another query log format, e.g. of the Thanos query-frontend, instead of
+	// the Prometheus query log. It is applied after MapLine
+	Decoder *Decoder
+	// Jobs is the number of goroutines decoding lines in parallel. Entries are still passed on in the order of
+	// the lines. 0 or 1 decodes on the calling goroutine
+	Jobs int
+	// KeepZeroTimings keeps entries whose timings are all zero. They are usually produced by
+	// misconfigured logging or old Prometheus versions and would dilute averages
+	KeepZeroTimings bool
+	// Strict makes loading fail on the first group of entries rejected by NewQuery instead of skipping it
+	Strict bool
+	// Log, if set, is called with what loading skipped or changed, e.g. malformed lines, as a message and slog
+	// key-value pairs. It is called from the calling goroutine only, even if Jobs is greater than 1
+	Log func(level slog.Level, msg string, args ...any)
+	// MaxEntrySize is the length in bytes of the longest line that is parsed. Longer lines are skipped.
+	// 0 selects DefaultMaxEntrySize
+	MaxEntrySize int
+	// MaxQueryLength truncates longer queries. 0 means no limit
+	MaxQueryLength int
+	// MaxQueries is the number of distinct queries kept, in order of appearance. Entries of further queries are
+	// dropped as they are read, so they don't take up memory. 0 means no limit
+	MaxQueries int
+	// SkipErrors counts and logs lines that can't be parsed instead of failing, e.g. in logs mixed with
+	// other output. Loading still fails when there are more than MaxErrors of them, unless it is 0
+	SkipErrors bool
+	MaxErrors  int
+	// SkipNoise counts and skips lines that aren't query log entries: lines not starting with a JSON object, such
+	// as startup logs or shell prompts in piped kubectl logs output, and JSON objects without a query, such as
+	// structured application logs. They are counted separately from malformed lines
+	SkipNoise bool
+	// PercentileRanks are the ranks of the percentiles GroupQueries computes for each query
+	PercentileRanks []int
+	// Summation is how GroupQueries sums and averages the executions of each query. The zero value is
+	// CompensatedSummation
+	Summation Summation
+	// SampleRate, if below 1, keeps each line with this probability before it is decoded, a fast first pass over
+	// enormous logs. SampleSize, if positive, keeps a uniform random sample of at most this many accepted entries
+	// by reservoir sampling, in the order of the log. SampleSeed seeds both, 0 picks a random seed
+	SampleRate float64
+	SampleSize int
+	SampleSeed uint64
+	// Context, if set, stops reading when it is done, e.g. on Ctrl-C. The entries read until then are kept and
+	// LoadStats.Interrupted is set, so a partial analysis can be reported
+	Context context.Context
+	// lineRand draws the lines kept by SampleRate
+	lineRand *rand.Rand
+}
+
+// log passes the message to Log, if set.
+func (opts LoadOptions) log(level slog.Level, msg string, args ...any) {
+	if opts.Log != nil {
+		opts.Log(level, msg, args...)
+	}
+}
+
+// newSampleRand returns the random source of sampling, seeded by SampleSeed plus stream to tell sources apart.
+func (opts LoadOptions) newSampleRand(stream uint64) *rand.Rand {
+	seed := opts.SampleSeed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return rand.New(rand.NewPCG(seed, stream))
+}
+
+// Accept reports whether the entry passes the filters.
+func (opts LoadOptions) Accept(entry *LogEntry) bool {
+	if opts.From != nil && (entry.TS == nil || entry.TS.Before(*opts.From)) {
+		return false
+	}
+	if opts.To != nil && (entry.TS == nil || entry.TS.After(*opts.To)) {
+		return false
+	}
+	if opts.DataFrom != nil && (entry.Params.End == nil || entry.Params.End.Before(*opts.DataFrom)) {
+		return false
+	}
+	if opts.DataTo != nil && (entry.Params.Start == nil || entry.Params.Start.After(*opts.DataTo)) {
+		return false
+	}
+	if opts.QueryMatch != nil && !opts.QueryMatch.MatchString(entry.Params.Query) {
+		return false
+	}
+	if opts.QueryExclude != nil && opts.QueryExclude.MatchString(entry.Params.Query) {
+		return false
+	}
+	if opts.QueryFilter != nil && !opts.QueryFilter(entry.Params.Query) {
+		return false
+	}
+	if opts.MinExecTime > 0 && entry.Stats.Timings.ExecTotalTime < opts.MinExecTime.Seconds() {
+		return false
+	}
+	if opts.MinSamples > 0 && entry.Stats.Samples.TotalQueryableSamples < opts.MinSamples {
+		return false
+	}
+	if opts.Type != "" && entry.Type() != opts.Type {
+		return false
+	}
+	if len(opts.ClientIPs) > 0 && (entry.HTTPRequest == nil || !containsClientIP(opts.ClientIPs, entry.HTTPRequest.ClientIP)) {
+		return false
+	}
+	if opts.PathMatch != nil && (entry.HTTPRequest == nil || !opts.PathMatch.MatchString(entry.HTTPRequest.Path)) {
+		return false
+	}
+	return true
+}
+
+// containsClientIP reports whether the client IP is in one of the prefixes.
+func containsClientIP(prefixes []netip.Prefix, clientIP string) bool {
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseClientIPs parses a comma-separated list of IP addresses and CIDR prefixes, e.g. 10.0.0.5,10.8.0.0/16.
+func ParseClientIPs(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if strings.Contains(field, "/") {
+			p, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// LoadStats counts entries dropped or flagged while reading the query log.
+type LoadStats struct {
+	ZeroTimings      int
+	OversizedEntries int
+	TruncatedQueries int
+	// MalformedLines are lines skipped because of LoadOptions.SkipErrors
+	MalformedLines int
+	// NoiseLines are lines skipped because of LoadOptions.SkipNoise or because the decoder of another format
+	// skipped them
+	NoiseLines int
+	// WithoutSamples are loaded entries without sample statistics, which Prometheus writes since 2.35
+	WithoutSamples int
+	// SampledOut are lines skipped by LoadOptions.SampleRate and accepted entries left out of the sample of
+	// LoadOptions.SampleSize
+	SampledOut int
+	// DroppedEntries are accepted entries of queries beyond LoadOptions.MaxQueries
+	DroppedEntries int
+	// Interrupted is set if reading stopped early because LoadOptions.Context was done
+	Interrupted bool
+}
+
+// Add adds the counts of o, e.g. of another input read separately.
+func (s *LoadStats) Add(o LoadStats) {
+	s.ZeroTimings += o.ZeroTimings
+	s.OversizedEntries += o.OversizedEntries
+	s.TruncatedQueries += o.TruncatedQueries
+	s.MalformedLines += o.MalformedLines
+	s.NoiseLines += o.NoiseLines
+	s.WithoutSamples += o.WithoutSamples
+	s.SampledOut += o.SampledOut
+	s.DroppedEntries += o.DroppedEntries
+	s.Interrupted = s.Interrupted || o.Interrupted
+}
+
+// ReadLogEntries parses the query log and returns the entries accepted by the filters in opts.
+func ReadLogEntries(r io.Reader, opts LoadOptions) (LogEntries, LoadStats, error) {
+	logs := make([]*LogEntry, 0)
+	stats, err := ScanLogEntries(r, opts, func(entry *LogEntry) {
+		logs = append(logs, entry)
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+	return logs, stats, nil
+}
+
+// ScanLogEntries parses the query log and calls fn with each entry accepted by the filters in opts
+// without keeping the entries in memory.
+func ScanLogEntries(r io.Reader, opts LoadOptions, fn func(entry *LogEntry)) (LoadStats, error) {
+	decode := opts.decodeFunc()
+	if opts.SampleRate > 0 && opts.SampleRate < 1 {
+		opts.lineRand = opts.newSampleRand(0)
+	}
+	sink := newEntrySink(opts, fn)
+	// the reader goroutine of scanParallel counts the lines it skips, while sink counts oversized envelope lines,
+	// so the counts are added up once reading is done
+	var oversized int
+	scanner := opts.newScanner(r, &oversized)
+
+	var err error
+	if opts.Jobs > 1 {
+		err = scanParallel(scanner, opts, func(l *scannedLine) { opts.decodeLine(decode, l) }, sink.apply)
+	} else {
+		readLines(scanner, opts, func(l *scannedLine) bool {
+			opts.decodeLine(decode, l)
+			err = sink.apply(l)
+			return err == nil
+		})
+	}
+	sink.stats.Interrupted = scanner.Interrupted()
+	sink.stats.OversizedEntries += oversized
+	if err != nil {
+		return sink.stats, err
+	}
+	sink.finish()
+	return sink.stats, scanner.Err()
+}
+
+// decodeFunc returns the function decoding lines of the format of the query log.
+func (opts LoadOptions) decodeFunc() func(line []byte, entry *LogEntry) error {
+	if opts.Decoder != nil {
+		return opts.Decoder.Decode
+	}
+	return decodePrometheus
+}
+
+// maxEntrySize returns MaxEntrySize or its default.
+func (opts LoadOptions) maxEntrySize() int {
+	if opts.MaxEntrySize <= 0 {
+		return DefaultMaxEntrySize
+	}
+	return opts.MaxEntrySize
+}
+
+// newScanner returns a reader of the lines of r counting lines longer than MaxEntrySize in oversized, which belongs
+// to the goroutine reading until it is done.
+// Reading stops when opts.Context is done, even while a read of r blocks, e.g. on stdin.
+func (opts LoadOptions) newScanner(r io.Reader, oversized *int) *lineReader {
+	if opts.Context != nil && opts.Context.Done() != nil {
+		r = newContextReader(opts.Context, r)
+	}
+	lr := newLineReader(r, opts.maxEntrySize(), oversized)
+	lr.ctx = opts.Context
+	return lr
+}
+
+// entrySink counts the outcomes of lines and passes their entries on, sampled by LoadOptions.SampleSize.
+type entrySink struct {
+	opts  LoadOptions
+	fn    func(entry *LogEntry)
+	stats LoadStats
+	// reservoir is the sample of SampleSize entries with the numbers of their lines to restore their order
+	reservoir     []sampledEntry
+	reservoirRand *rand.Rand
+	accepted      int
+	// queries are the normalized queries seen, if LoadOptions.MaxQueries is set
+	queries map[string]bool
+}
+
+type sampledEntry struct {
+	num   int
+	entry *LogEntry
+}
+
+func newEntrySink(opts LoadOptions, fn func(entry *LogEntry)) *entrySink {
+	s := &entrySink{opts: opts, fn: fn}
+	if opts.SampleSize > 0 {
+		s.reservoirRand = opts.newSampleRand(1)
+	}
+	if opts.MaxQueries > 0 {
+		s.queries = make(map[string]bool)
+	}
+	return s
+}
+
+func (s *entrySink) malformed(err error) error {
+	if !s.opts.SkipErrors {
+		return err
+	}
+	s.stats.MalformedLines++
+	if s.opts.MaxErrors > 0 && s.stats.MalformedLines > s.opts.MaxErrors {
+		return fmt.Errorf("more than %d malformed lines, the last: %w", s.opts.MaxErrors, err)
+	}
+	if s.stats.MalformedLines <= maxLoggedMalformedLines {
+		s.opts.log(slog.LevelWarn, "Skipping a malformed line", "err", err)
+	}
+	return nil
+}
+
+// apply counts the outcome of a line and passes its entry on. Outcomes are applied in the order of the
+// lines, so the stats, the malformed lines logged and the first error are the same with any number of jobs
+func (s *entrySink) apply(l *scannedLine) error {
+	if l.envelope != "" {
+		s.opts.log(slog.LevelInfo, "Unwrapping query log lines from envelopes", "envelope", l.envelope)
+	}
+	switch {
+	case l.err != nil:
+		return s.malformed(l.err)
+	case l.noise:
+		s.stats.NoiseLines++
+	case l.sampledOut:
+		s.stats.SampledOut++
+	case l.oversized:
+		s.stats.OversizedEntries++
+	case l.emptyQuery:
+		s.opts.log(slog.LevelWarn, "Failed to parse a line: empty query", "line", l.num)
+	}
+	if l.zeroTimings {
+		s.stats.ZeroTimings++
+	}
+	if l.entry == nil {
+		return nil
+	}
+	if s.queries != nil {
+		key := s.opts.Normalizer.Normalize(l.entry.Params.Query)
+		if !s.queries[key] {
+			if len(s.queries) >= s.opts.MaxQueries {
+				s.stats.DroppedEntries++
+				return nil
+			}
+			s.queries[key] = true
+		}
+	}
+	if l.truncated {
+		s.stats.TruncatedQueries++
+	}
+	if l.withoutSamples {
+		s.stats.WithoutSamples++
+	}
+	if s.opts.SampleSize <= 0 {
+		s.fn(l.entry)
+		return nil
+	}
+	// Algorithm R: the n-th accepted entry replaces a random one of the sample with probability SampleSize/n
+	s.accepted++
+	if len(s.reservoir) < s.opts.SampleSize {
+		s.reservoir = append(s.reservoir, sampledEntry{l.num, l.entry})
+		return nil
+	}
+	s.stats.SampledOut++
+	if i := s.reservoirRand.IntN(s.accepted); i < s.opts.SampleSize {
+		s.reservoir[i] = sampledEntry{l.num, l.entry}
+	}
+	return nil
+}
+
+// finish passes on the sampled entries in the order of their lines and logs the lines skipped.
+func (s *entrySink) finish() {
+	sort.Slice(s.reservoir, func(i, j int) bool { return s.reservoir[i].num < s.reservoir[j].num })
+	for _, e := range s.reservoir {
+		s.fn(e.entry)
+	}
+
+	if s.stats.OversizedEntries > 0 {
+		s.opts.log(slog.LevelWarn, "Skipped entries longer than the maximum line size", "entries", s.stats.OversizedEntries, "bytes", s.opts.maxEntrySize())
+	}
+	if s.stats.TruncatedQueries > 0 {
+		s.opts.log(slog.LevelInfo, "Truncated queries longer than the maximum query length", "queries", s.stats.TruncatedQueries, "bytes", s.opts.MaxQueryLength)
+	}
+	if s.stats.MalformedLines > 0 {
+		s.opts.log(slog.LevelWarn, "Skipped malformed lines", "lines", s.stats.MalformedLines)
+	}
+	if s.stats.NoiseLines > 0 {
+		s.opts.log(slog.LevelInfo, "Skipped lines that are not query log entries", "lines", s.stats.NoiseLines)
+	}
+	if s.stats.DroppedEntries > 0 {
+		s.opts.log(slog.LevelWarn, "Dropped entries of queries beyond the limit of distinct queries", "entries", s.stats.DroppedEntries, "limit", s.opts.MaxQueries)
+	}
+}
+
+// scannedLine is a line of the query log and the outcome of decoding it.
+type scannedLine struct {
+	num int
+	// line is the line to decode. It is nil if the outcome was decided while reading, e.g. for noise
+	line  []byte
+	entry *LogEntry
+	// err is set for malformed lines
+	err                                                       error
+	noise, emptyQuery, zeroTimings, truncated, withoutSamples bool
+	// sampledOut is set for lines skipped by LoadOptions.SampleRate
+	sampledOut bool
+	// oversized is set for lines joined from the pieces of an envelope that are longer than LoadOptions.MaxEntrySize
+	oversized bool
+	// envelope is the kind of envelope first unwrapped since the previous line, which is logged once the line is
+	// applied, so LoadOptions.Log isn't called from the goroutine reading the lines
+	envelope string
+}
+
+// readLines calls fn with each non-empty line, unwrapped from a Docker json-file or journald envelope and mapped by
+// opts.MapLine, until fn returns false. Lines are only valid until fn returns.
+func readLines(scanner *lineReader, opts LoadOptions, fn func(l *scannedLine) bool) {
+	maxEntrySize := opts.maxEntrySize()
+	// envelope is the kind of envelope first unwrapped, until it is passed on with the next line
+	var envelope string
+	readLine := func(lineNum int, line []byte) bool {
+		l := &scannedLine{num: lineNum, line: line}
+		if len(bytes.TrimSpace(l.line)) == 0 {
+			return true
+		}
+		if len(l.line) > maxEntrySize {
+			// only lines joined from the pieces of an envelope can be longer, the scanner skips the others
+			l.line, l.oversized = nil, true
+		} else if opts.lineRand != nil && opts.lineRand.Float64() >= opts.SampleRate {
+			l.line, l.sampledOut = nil, true
+		} else if opts.SkipNoise && opts.MapLine == nil && opts.Decoder == nil && !isJSONObject(l.line) {
+			l.line, l.noise = nil, true
+		} else if opts.MapLine != nil {
+			line, err := opts.MapLine(l.line)
+			switch {
+			case err != nil:
+				l.line, l.err = nil, fmt.Errorf("failed to map line %d: %w", lineNum, err)
+			case line == nil:
+				return true
+			default:
+				l.line = line
+			}
+		}
+		l.envelope, envelope = envelope, ""
+		return fn(l)
+	}
+
+	// partial holds the pieces of a line Docker split, at most one byte more than maxEntrySize
+	var partial []byte
+	unwrapped := make(map[string]bool)
+	lineNum := 0
+	for ; scanner.Scan(); lineNum++ {
+		line := scanner.Bytes()
+		if inner, kind, ok := unwrapEnvelope(line); ok {
+			if !unwrapped[kind] {
+				unwrapped[kind] = true
+				envelope = kind
+			}
+			if kind == dockerEnvelope && !bytes.HasSuffix(inner, []byte("\n")) {
+				partial = append(partial, inner[:min(len(inner), maxEntrySize+1-len(partial))]...)
+				continue
+			}
+			if partial != nil {
+				inner = append(partial, inner[:min(len(inner), maxEntrySize+1-len(partial))]...)
+				partial = nil
+			}
+			line = bytes.TrimRight(inner, "\r\n")
+		}
+		if !readLine(lineNum, line) {
+			return
+		}
+	}
+	if partial != nil {
+		readLine(lineNum, partial)
+	}
+}
+
+// decodeLine decodes the line and records why it is skipped, if it is.
+func (opts LoadOptions) decodeLine(decode func(line []byte, entry *LogEntry) error, l *scannedLine) {
+	if l.line == nil {
+		return
+	}
+	line := l.line
+	l.line = nil
+	if entry := opts.parseLine(decode, line, l); entry != nil && opts.keepEntry(entry, l) {
+		l.withoutSamples = !hasSamples(entry, line)
+	}
+}
+
+// parseLine decodes the line and returns its entry, or nil and records why it is skipped.
+func (opts LoadOptions) parseLine(decode func(line []byte, entry *LogEntry) error, line []byte, l *scannedLine) *LogEntry {
+	var entry LogEntry
+	if err := decode(line, &entry); err != nil {
+		if errors.Is(err, ErrSkipLine) {
+			l.noise = true
+			return nil
+		}
+		l.err = fmt.Errorf("failed to parse line %d: %w", l.num, err)
+		return nil
+	}
+	if entry.Params.Query == "" {
+		if opts.SkipNoise {
+			l.noise = true
+		} else {
+			l.emptyQuery = true
+		}
+		return nil
+	}
+	return &entry
+}
+
+// keepEntry applies the filters, the exclusion of zero timings and MaxQueryLength to the decoded entry of the line
+// and reports whether it is kept as the entry of the line.
+func (opts LoadOptions) keepEntry(entry *LogEntry, l *scannedLine) bool {
+	if !opts.Accept(entry) {
+		return false
+	}
+	if entry.HasZeroTimings() {
+		l.zeroTimings = true
+		if !opts.KeepZeroTimings {
+			return false
+		}
+	}
+	l.truncated = opts.limitQuery(entry)
+	l.entry = entry
+	return true
+}
+
+// isJSONObject reports whether the line starts like a JSON object.
+func isJSONObject(line []byte) bool {
+	line = bytes.TrimLeft(line, " \t")
+	return len(line) > 0 && line[0] == '{'
+}
+
+// GroupQueries groups the entries by their normalized query. Entries of queries beyond opts.MaxQueries, e.g. of
+// inputs read separately, are dropped. Entries of queries rejected by NewQuery are left out of the returned entries unless opts.Strict is set, in which case an error is returned.
+func GroupQueries(entries LogEntries, opts LoadOptions) ([]*Query, LogEntries, error) {
+	qMap := make(map[string][]*LogEntry)
+	droppedEntries := 0
+	for _, entry := range entries {
+		key := opts.Normalizer.Normalize(entry.Params.Query)
+		if _, ok := qMap[key]; !ok && opts.MaxQueries > 0 && len(qMap) >= opts.MaxQueries {
+			droppedEntries++
+			continue
+		}
+		qMap[key] = append(qMap[key], entry)
+	}
+	if droppedEntries > 0 {
+		opts.log(slog.LevelWarn, "Dropped entries of queries beyond the limit of distinct queries", "entries", droppedEntries, "limit", opts.MaxQueries)
+	}
+
+	queries := make([]*Query, 0, len(qMap))
+	logs := make([]*LogEntry, 0, len(entries))
+	skippedQueries, skippedEntries := 0, 0
+	for _, queryLogs := range qMap {
+		q, err := newQuery(queryLogs[0].Params.Query, queryLogs, opts.PercentileRanks, opts.Summation)
+		if err != nil {
+			if opts.Strict {
+				return nil, nil, fmt.Errorf("failed to create Query: %w", err)
+			}
+			opts.log(slog.LevelWarn, "Skipping the entries of a query", "entries", len(queryLogs), "query", queryLogs[0].Params.Query, "err", err)
+			skippedQueries++
+			skippedEntries += len(queryLogs)
+			continue
+		}
+		queries = append(queries, q)
+		logs = append(logs, queryLogs...)
+	}
+	if skippedQueries > 0 {
+		opts.log(slog.LevelWarn, "Skipped the entries of queries rejected by NewQuery", "queries", skippedQueries, "entries", skippedEntries)
+	}
+
+	return queries, logs, nil
+}
+
+// LoadQueriesFromLog reads the query log and groups its entries.
+func LoadQueriesFromLog(r io.Reader, opts LoadOptions) ([]*Query, LogEntries, error) {
+	logs, _, err := ReadLogEntries(r, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return GroupQueries(logs, opts)
+}
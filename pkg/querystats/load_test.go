@@ -0,0 +1,86 @@
+package querystats
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// logOf returns a query log with an entry of each query.
+func logOf(queries ...string) string {
+	var b strings.Builder
+	for _, q := range queries {
+		b.WriteString(strings.Replace(envelopeEntry, `"query":"up"`, `"query":"`+q+`"`, 1))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestReadLogEntriesMaxQueries(t *testing.T) {
+	log := logOf("a", "b", "a", "c", "b", "d")
+	entries, stats, err := ReadLogEntries(strings.NewReader(log), LoadOptions{MaxQueries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Params.Query)
+	}
+	if strings.Join(got, ",") != "a,b,a,b" {
+		t.Errorf("queries = %v, want [a b a b]", got)
+	}
+	if stats.DroppedEntries != 2 {
+		t.Errorf("DroppedEntries = %d, want 2", stats.DroppedEntries)
+	}
+}
+
+func TestReadLogEntriesOversizedParallel(t *testing.T) {
+	// lines too long to read and envelopes too long once joined are counted on different goroutines
+	long := strings.Replace(envelopeEntry, `"query":"up"`, `"query":"`+strings.Repeat("a", 1000)+`"`, 1)
+	split := len(long) / 2
+	var b strings.Builder
+	for range 2000 {
+		b.WriteString(long + "\n")
+		b.WriteString(dockerLine(t, long[:split]) + "\n" + dockerLine(t, long[split:]+"\n") + "\n")
+		b.WriteString(envelopeEntry + "\n")
+	}
+	for _, jobs := range []int{1, 4} {
+		entries, stats, err := ReadLogEntries(strings.NewReader(b.String()), LoadOptions{MaxEntrySize: split + 200, Jobs: jobs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2000 || stats.OversizedEntries != 4000 {
+			t.Errorf("jobs=%d: read %d entries and %d oversized, want 2000 and 4000", jobs, len(entries), stats.OversizedEntries)
+		}
+	}
+}
+
+// blockingReader reads like r, then cancels the context and blocks until unblock is closed.
+type blockingReader struct {
+	r       io.Reader
+	cancel  func()
+	unblock chan struct{}
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	if n, err := b.r.Read(p); err != io.EOF {
+		return n, err
+	}
+	b.cancel()
+	<-b.unblock
+	return 0, io.EOF
+}
+
+func TestReadLogEntriesInterruptedWhileBlocked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &blockingReader{strings.NewReader(logOf("a", "b")), cancel, make(chan struct{})}
+	defer close(r.unblock)
+	entries, stats, err := ReadLogEntries(r, LoadOptions{Context: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Interrupted || len(entries) != 2 {
+		t.Errorf("read %d entries, interrupted %v, want 2 entries, interrupted", len(entries), stats.Interrupted)
+	}
+}
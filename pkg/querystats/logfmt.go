@@ -0,0 +1,82 @@
+package querystats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseLogfmt parses a logfmt line such as `level=info msg="slow query" time_taken=1.5s` into its fields.
+// Keys without a value are set to an empty string.
+func parseLogfmt(line string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return fields, nil
+		}
+		end := strings.IndexAny(line, "= \t")
+		if end < 0 {
+			end = len(line)
+		}
+		key := line[:end]
+		if key == "" {
+			return nil, fmt.Errorf("missing key at %q", line)
+		}
+		line = line[end:]
+		if !strings.HasPrefix(line, "=") {
+			fields[key] = ""
+			continue
+		}
+		line = line[1:]
+		if strings.HasPrefix(line, `"`) {
+			// find the closing quote, skipping escaped ones
+			i := 1
+			for i < len(line) && line[i] != '"' {
+				if line[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated value of %s", key)
+			}
+			value, err := strconv.Unquote(line[:i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+			fields[key] = value
+			line = line[i+1:]
+			continue
+		}
+		end = strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		fields[key] = line[:end]
+		line = line[end:]
+	}
+}
+
+// parseLogFields parses a line of a go-kit style log written in either logfmt or JSON into its fields.
+// Values of JSON fields that aren't strings are kept in their JSON encoding.
+func parseLogFields(line []byte) (map[string]string, error) {
+	if !isJSONObject(line) {
+		return parseLogfmt(string(line))
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(raw))
+	for key, value := range raw {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			fields[key] = s
+			continue
+		}
+		fields[key] = string(value)
+	}
+	return fields, nil
+}
@@ -0,0 +1,162 @@
+package querystats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Normalizer rewrites query text before grouping so that trivially different
+// spellings of the same query are aggregated together. It works on the raw text
+// and does not require the query to be valid PromQL, except for Fingerprint.
+type Normalizer struct {
+	Whitespace bool
+	Case       bool
+	Matchers   bool
+	// Fingerprint groups valid queries by their Fingerprint. Other normalizations apply to invalid queries only
+	Fingerprint bool
+}
+
+func ParseNormalizer(value string) (Normalizer, error) {
+	var n Normalizer
+	if value == "" {
+		return n, nil
+	}
+	for _, opt := range strings.Split(value, ",") {
+		switch strings.TrimSpace(opt) {
+		case "whitespace":
+			n.Whitespace = true
+		case "case":
+			n.Case = true
+		case "matchers":
+			n.Matchers = true
+		case "fingerprint":
+			n.Fingerprint = true
+		default:
+			return n, fmt.Errorf("unknown normalization %q", opt)
+		}
+	}
+	return n, nil
+}
+
+func (n Normalizer) Enabled() bool {
+	return n.Whitespace || n.Case || n.Matchers || n.Fingerprint
+}
+
+// Normalize returns the grouping key for the query.
+func (n Normalizer) Normalize(query string) string {
+	if !n.Enabled() {
+		return query
+	}
+	if n.Fingerprint {
+		if fp, err := Fingerprint(query); err == nil {
+			return fp
+		}
+	}
+	var b strings.Builder
+	b.Grow(len(query))
+	var matchers []string
+	var matcher strings.Builder
+	out := &b
+	pendingSpace := false
+	prev := rune(0)
+	for i := 0; i < len(query); {
+		r, lit := NextToken(query[i:])
+		i += len(lit)
+
+		if lit[0] == '"' || lit[0] == '\'' || lit[0] == '`' {
+			// string literals are kept verbatim
+		} else if unicode.IsSpace(r) {
+			if !n.Whitespace {
+				out.WriteString(lit)
+			} else {
+				pendingSpace = true
+			}
+			continue
+		} else if n.Case {
+			lit = strings.ToLower(lit)
+		}
+
+		if n.Whitespace && pendingSpace {
+			if !isPunct(prev) && !isPunct(r) && out.Len() > 0 {
+				out.WriteByte(' ')
+			}
+			pendingSpace = false
+		}
+
+		switch {
+		case n.Matchers && r == '{' && out == &b:
+			b.WriteString(lit)
+			out = &matcher
+		case n.Matchers && r == ',' && out == &matcher:
+			matchers = append(matchers, strings.TrimSpace(matcher.String()))
+			matcher.Reset()
+		case n.Matchers && r == '}' && out == &matcher:
+			if m := strings.TrimSpace(matcher.String()); m != "" {
+				matchers = append(matchers, m)
+			}
+			sort.Strings(matchers)
+			b.WriteString(strings.Join(matchers, ","))
+			b.WriteString(lit)
+			matchers = matchers[:0]
+			matcher.Reset()
+			out = &b
+		default:
+			out.WriteString(lit)
+		}
+		prev = r
+	}
+	if out == &matcher {
+		// unbalanced braces, keep the rest as is
+		b.WriteString(strings.Join(matchers, ","))
+		if len(matchers) > 0 && matcher.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(matcher.String())
+	}
+	return b.String()
+}
+
+// NextToken returns the first rune of str and the text of the token starting at it.
+// A token is either a quoted string literal, a run of whitespace, a run of
+// identifier characters or a single other character.
+func NextToken(str string) (rune, string) {
+	first, size := utf8.DecodeRuneInString(str)
+	switch {
+	case first == '"' || first == '\'' || first == '`':
+		for i := 1; i < len(str); i++ {
+			if str[i] == '\\' && first != '`' {
+				i++
+				continue
+			}
+			if rune(str[i]) == first {
+				return first, str[:i+1]
+			}
+		}
+		return first, str
+	case unicode.IsSpace(first):
+		end := strings.IndexFunc(str, func(r rune) bool { return !unicode.IsSpace(r) })
+		if end < 0 {
+			end = len(str)
+		}
+		return first, str[:end]
+	case isIdent(first):
+		end := strings.IndexFunc(str, func(r rune) bool { return !isIdent(r) })
+		if end < 0 {
+			end = len(str)
+		}
+		return first, str[:end]
+	default:
+		return first, str[:size]
+	}
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || r == ':' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isPunct(r rune) bool {
+	return r == 0 || strings.ContainsRune("(){}[],=!~<>+-*/%^", r)
+}
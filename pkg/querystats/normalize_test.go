@@ -0,0 +1,83 @@
+package querystats
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		normalize string
+		query     string
+		want      string
+	}{
+		{"", "sum( up )", "sum( up )"},
+		{"whitespace", "sum by (job) (\n  rate(up[5m])\n)", "sum by(job)(rate(up[5m]))"},
+		{"whitespace", "a  and   b", "a and b"},
+		{"case", `SUM(rate(UP{job="API"}[5m]))`, `sum(rate(up{job="API"}[5m]))`},
+		{"matchers", `up{job="a",env="prod"}`, `up{env="prod",job="a"}`},
+		{"matchers", `up{ job="a" , env="prod" }`, `up{env="prod",job="a"}`},
+		{"whitespace,case,matchers", "SUM(up{ job=\"a\",\n env=\"b\" })", `sum(up{env="b",job="a"})`},
+		// string literals are kept verbatim
+		{"whitespace,case", `up{job="A  B"}`, `up{job="A  B"}`},
+		// unbalanced braces keep the rest of the query
+		{"matchers", `up{job="a",env="b"`, `up{job="a",env="b"`},
+	}
+	for _, tt := range tests {
+		n, err := ParseNormalizer(tt.normalize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := n.Normalize(tt.query); got != tt.want {
+			t.Errorf("Normalize(%q) with %q = %q, want %q", tt.query, tt.normalize, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	n := Normalizer{Fingerprint: true}
+	a := n.Normalize(`rate(http_requests_total{job="a"}[5m])`)
+	b := n.Normalize(`rate(http_requests_total{job="b"}[5m])`)
+	if a != b {
+		t.Errorf("queries differing only in literals have different fingerprints %q and %q", a, b)
+	}
+	if c := n.Normalize(`rate(http_requests_total{job="a"}[1h])`); c == a {
+		t.Errorf("queries with different ranges have the same fingerprint %q", c)
+	}
+	// invalid queries fall back to the other normalizations
+	n.Whitespace = true
+	if got := n.Normalize("sum(  up"); got != "sum(up" {
+		t.Errorf("Normalize of an invalid query = %q, want %q", got, "sum(up")
+	}
+}
+
+func TestParseNormalizer(t *testing.T) {
+	n, err := ParseNormalizer("whitespace, matchers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Whitespace || !n.Matchers || n.Case || n.Fingerprint {
+		t.Errorf("ParseNormalizer = %+v", n)
+	}
+	if _, err := ParseNormalizer("whitespace,unknown"); err == nil {
+		t.Error("an unknown normalization was accepted")
+	}
+	if n, _ := ParseNormalizer(""); n.Enabled() {
+		t.Error("an empty value enables normalization")
+	}
+}
+
+func TestFingerprintCached(t *testing.T) {
+	query := `rate(http_requests_total{job="api"}[5m]) > 10`
+	want, err := fingerprint(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if got, err := Fingerprint(query); err != nil || got != want {
+			t.Errorf("Fingerprint = %q, %v, want %q", got, err, want)
+		}
+	}
+	for range 2 {
+		if _, err := Fingerprint("rate("); err == nil {
+			t.Error("Fingerprint of an invalid query succeeded")
+		}
+	}
+}
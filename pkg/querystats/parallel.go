@@ -0,0 +1,116 @@
+package querystats
+
+import "sync"
+
+// parallelBatchSize is the number of lines a job decodes at a time. Batches spread the cost of handing lines
+// between goroutines over many lines.
+const parallelBatchSize = 256
+
+// batchArenaSize is the size of the buffers the lines of a batch are copied into.
+const batchArenaSize = 256 * 1024
+
+// arenaPool recycles the buffers of batches once their lines are decoded and applied.
+var arenaPool = sync.Pool{New: func() any { return make([]byte, 0, batchArenaSize) }}
+
+// lineBatch is a batch of lines decoded by a single job. done is closed once all of them are decoded.
+type lineBatch struct {
+	lines []*scannedLine
+	// arena holds the lines of the batch, copied out of the buffers of the reader, so that the batch costs an
+	// allocation per buffer rather than per line
+	arena []byte
+	done  chan struct{}
+}
+
+func newLineBatch() *lineBatch {
+	return &lineBatch{lines: make([]*scannedLine, 0, parallelBatchSize), arena: arenaPool.Get().([]byte), done: make(chan struct{})}
+}
+
+// keep copies the line into the arena of the batch.
+func (b *lineBatch) keep(line []byte) []byte {
+	if cap(b.arena)-len(b.arena) < len(line) {
+		// lines copied before keep the full arena alive until the batch is released
+		b.arena = make([]byte, 0, max(batchArenaSize, len(line)))
+	}
+	start := len(b.arena)
+	b.arena = append(b.arena, line...)
+	return b.arena[start:len(b.arena):len(b.arena)]
+}
+
+// release returns the arena of the batch to the pool. Decoded lines are dropped, entries don't refer to them.
+func (b *lineBatch) release() {
+	if cap(b.arena) == batchArenaSize {
+		arenaPool.Put(b.arena[:0])
+	}
+	b.arena = nil
+}
+
+// scanParallel reads lines on one goroutine, decodes batches of them on opts.Jobs goroutines and applies the
+// outcomes on the calling goroutine in the order of the lines. It stops at the first error returned by apply.
+func scanParallel(scanner *lineReader, opts LoadOptions, decodeLine func(l *scannedLine), apply func(l *scannedLine) error) error {
+	work := make(chan *lineBatch, opts.Jobs)
+	// ordered receives the batches in the order they were read, so they are applied in order although jobs
+	// finish them out of order
+	ordered := make(chan *lineBatch, 2*opts.Jobs)
+	stop := make(chan struct{})
+	readerDone := make(chan struct{})
+
+	go func() {
+		defer close(readerDone)
+		defer close(ordered)
+		defer close(work)
+		batch := newLineBatch()
+		send := func() bool {
+			select {
+			case work <- batch:
+			case <-stop:
+				return false
+			}
+			select {
+			case ordered <- batch:
+			case <-stop:
+				return false
+			}
+			batch = newLineBatch()
+			return true
+		}
+		readLines(scanner, opts, func(l *scannedLine) bool {
+			if l.line != nil {
+				l.line = batch.keep(l.line)
+			}
+			batch.lines = append(batch.lines, l)
+			return len(batch.lines) < parallelBatchSize || send()
+		})
+		if len(batch.lines) > 0 {
+			send()
+		}
+	}()
+
+	for range opts.Jobs {
+		go func() {
+			for batch := range work {
+				for _, l := range batch.lines {
+					decodeLine(l)
+				}
+				close(batch.done)
+			}
+		}()
+	}
+
+	var err error
+	for batch := range ordered {
+		<-batch.done
+		for _, l := range batch.lines {
+			if err = apply(l); err != nil {
+				break
+			}
+		}
+		batch.release()
+		if err != nil {
+			break
+		}
+	}
+	close(stop)
+	// the scanner belongs to the reader until it returns, which is right after the line it is reading
+	<-readerDone
+	return err
+}
@@ -0,0 +1,205 @@
+package querystats
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Query aggregates all executions of a distinct query.
+type Query struct {
+	Query                         string
+	Logs                          []*LogEntry
+	AvgExecTotalTime              float64
+	AvgTotalQueryableSamples      float64
+	AvgPeakSamples                float64
+	SumExecTotalTime              float64
+	SumTotalQueryableSamples      int
+	MaxExecTotalTimeEntry         *LogEntry
+	MaxTotalQueryableSamplesEntry *LogEntry
+	MaxPeakSamplesEntry           *LogEntry
+	// ExecTotalTimePercentiles and TotalQueryableSamplesPercentiles map the ranks passed to NewQuery
+	// to the percentiles over executions of the query
+	ExecTotalTimePercentiles         map[int]float64
+	TotalQueryableSamplesPercentiles map[int]float64
+}
+
+// NewQuery aggregates the executions of the query and computes the percentiles of the given ranks. Sums and
+// averages are compensated.
+func NewQuery(query string, logs []*LogEntry, percentileRanks []int) (*Query, error) {
+	return newQuery(query, logs, percentileRanks, CompensatedSummation)
+}
+
+// newQuery is NewQuery summing with the summation.
+func newQuery(query string, logs []*LogEntry, percentileRanks []int, summation Summation) (*Query, error) {
+	if query == "" {
+		return nil, fmt.Errorf("a query cannot be empty")
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("a number of log entries must be greater than zero")
+	}
+	for i, log := range logs {
+		if log.TS == nil {
+			return nil, fmt.Errorf("log entry %d has no timestamp", i)
+		}
+	}
+
+	maxExecTotalTimeEntry := logs[0]
+	maxTotalQueryableSamplesEntry := logs[0]
+	maxPeakSamplesEntry := logs[0]
+	execTotalTimeVals := make([]float64, 0, len(logs))
+	totalQueryableSamplesVals := make([]int, 0, len(logs))
+	peakSamplesVals := make([]int, 0, len(logs))
+	sumExecTotalTime := KahanSum{Summation: summation}
+	var sumTotalQueryableSamples int
+	for _, log := range logs {
+		sumExecTotalTime.Add(log.Stats.Timings.ExecTotalTime)
+		sumTotalQueryableSamples += log.Stats.Samples.TotalQueryableSamples
+		execTotalTimeVals = append(execTotalTimeVals, log.Stats.Timings.ExecTotalTime)
+		totalQueryableSamplesVals = append(totalQueryableSamplesVals, log.Stats.Samples.TotalQueryableSamples)
+		peakSamplesVals = append(peakSamplesVals, log.Stats.Samples.PeakSamples)
+		if log.Stats.Timings.ExecTotalTime > maxExecTotalTimeEntry.Stats.Timings.ExecTotalTime {
+			maxExecTotalTimeEntry = log
+		}
+		if log.Stats.Samples.TotalQueryableSamples > maxTotalQueryableSamplesEntry.Stats.Samples.TotalQueryableSamples {
+			maxTotalQueryableSamplesEntry = log
+		}
+		if log.Stats.Samples.PeakSamples > maxPeakSamplesEntry.Stats.Samples.PeakSamples {
+			maxPeakSamplesEntry = log
+		}
+	}
+
+	q := Query{
+		query,
+		logs,
+		AvgWith(summation, execTotalTimeVals),
+		AvgWith(summation, totalQueryableSamplesVals),
+		AvgWith(summation, peakSamplesVals),
+		sumExecTotalTime.Value(),
+		sumTotalQueryableSamples,
+		maxExecTotalTimeEntry,
+		maxTotalQueryableSamplesEntry,
+		maxPeakSamplesEntry,
+		PercentilesOf(percentileRanks, execTotalTimeVals),
+		PercentilesOf(percentileRanks, totalQueryableSamplesVals),
+	}
+
+	return &q, nil
+}
+
+type Queries []*Query
+
+func (q Queries) Len() int      { return len(q) }
+func (q Queries) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+type ByAvgExecTotalTime struct{ Queries }
+
+func (q ByAvgExecTotalTime) Less(i, j int) bool {
+	return q.Queries[i].AvgExecTotalTime < q.Queries[j].AvgExecTotalTime
+}
+
+type ByMaxExecTotalTime struct{ Queries }
+
+func (q ByMaxExecTotalTime) Less(i, j int) bool {
+	return q.Queries[i].MaxExecTotalTimeEntry.Stats.Timings.ExecTotalTime < q.Queries[j].MaxExecTotalTimeEntry.Stats.Timings.ExecTotalTime
+}
+
+type ByAvgTotalQueryableSamples struct{ Queries }
+
+func (q ByAvgTotalQueryableSamples) Less(i, j int) bool {
+	return q.Queries[i].AvgTotalQueryableSamples < q.Queries[j].AvgTotalQueryableSamples
+}
+
+type ByMaxTotalQueryableSamples struct{ Queries }
+
+func (q ByMaxTotalQueryableSamples) Less(i, j int) bool {
+	return q.Queries[i].MaxTotalQueryableSamplesEntry.Stats.Samples.TotalQueryableSamples < q.Queries[j].MaxTotalQueryableSamplesEntry.Stats.Samples.TotalQueryableSamples
+}
+
+type ByAvgPeakSamples struct{ Queries }
+
+func (q ByAvgPeakSamples) Less(i, j int) bool {
+	return q.Queries[i].AvgPeakSamples < q.Queries[j].AvgPeakSamples
+}
+
+type ByMaxPeakSamples struct{ Queries }
+
+func (q ByMaxPeakSamples) Less(i, j int) bool {
+	return q.Queries[i].MaxPeakSamplesEntry.Stats.Samples.PeakSamples < q.Queries[j].MaxPeakSamplesEntry.Stats.Samples.PeakSamples
+}
+
+// Failures returns the number of executions of the query that failed or timed out. See LogEntry.Outcome.
+func (q *Query) Failures(timeoutProxy time.Duration) (errors, timeouts int) {
+	for _, log := range q.Logs {
+		switch log.Outcome(timeoutProxy) {
+		case OutcomeError:
+			errors++
+		case OutcomeTimeout:
+			timeouts++
+		}
+	}
+	return errors, timeouts
+}
+
+// CommonError returns the most frequent error message of the executions of the query and how many executions
+// failed with it, or an empty message if none logged an error.
+func (q *Query) CommonError() (msg string, n int) {
+	counts := make(map[string]int)
+	for _, log := range q.Logs {
+		if log.Error == "" {
+			continue
+		}
+		counts[log.Error]++
+		if c := counts[log.Error]; c > n || (c == n && log.Error < msg) {
+			msg, n = log.Error, c
+		}
+	}
+	return msg, n
+}
+
+// PointsPerSecond returns the evaluation throughput of the query: all its points divided by its total
+// execution time. ok is false if the query took no time.
+func (q *Query) PointsPerSecond() (float64, bool) {
+	if q.SumExecTotalTime <= 0 {
+		return 0, false
+	}
+	points := 0
+	for _, log := range q.Logs {
+		points += log.Points()
+	}
+	return float64(points) / q.SumExecTotalTime, true
+}
+
+// minRegularityExecutions is the minimum number of executions needed to judge regularity.
+const minRegularityExecutions = 3
+
+// IntervalRegularity returns the mean gap between consecutive executions of the query and
+// the regularity score, which is the coefficient of variation of the gaps (stdev / mean).
+// A query executed at a perfectly fixed interval has a score of 0. ok is false if the
+// query has too few executions.
+func (q *Query) IntervalRegularity() (meanGap time.Duration, score float64, ok bool) {
+	if len(q.Logs) < minRegularityExecutions {
+		return 0, 0, false
+	}
+	times := make([]time.Time, 0, len(q.Logs))
+	for _, log := range q.Logs {
+		times = append(times, *log.TS)
+	}
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+
+	gaps := make([]float64, 0, len(times)-1)
+	for i := 1; i < len(times); i++ {
+		gaps = append(gaps, times[i].Sub(times[i-1]).Seconds())
+	}
+	mean := Avg(gaps)
+	if mean == 0 {
+		return 0, 0, false
+	}
+	var variance float64
+	for _, gap := range gaps {
+		variance += (gap - mean) * (gap - mean)
+	}
+	variance /= float64(len(gaps))
+	return time.Duration(mean * float64(time.Second)), math.Sqrt(variance) / mean, true
+}
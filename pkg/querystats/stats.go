@@ -0,0 +1,170 @@
+package querystats
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Summation is how KahanSum adds values up.
+type Summation int
+
+const (
+	// CompensatedSummation keeps the rounding error of a sum from growing with the number of values
+	CompensatedSummation Summation = iota
+	// NaiveSummation adds values one after another, which is slightly faster and gives the same results as tools
+	// summing naively, but loses precision over millions of values
+	NaiveSummation
+)
+
+// ParseSummation parses the name of a summation: compensated or naive.
+func ParseSummation(name string) (Summation, error) {
+	switch name {
+	case "compensated":
+		return CompensatedSummation, nil
+	case "naive":
+		return NaiveSummation, nil
+	}
+	return 0, fmt.Errorf("unknown summation %q, must be compensated or naive", name)
+}
+
+// KahanSum sums float64 values with Neumaier's variant of Kahan summation. The rounding error of a naive sum
+// grows with the number of values, which adds up when summing e.g. execution times of millions of entries,
+// while the error of a compensated sum does not. The zero value is an empty compensated sum.
+type KahanSum struct {
+	Sum float64
+	// Compensation accumulates the low-order bits lost in Sum
+	Compensation float64
+	// Summation is how values are added. With NaiveSummation, Compensation stays zero
+	Summation Summation
+}
+
+func (k *KahanSum) Add(v float64) {
+	if k.Summation == NaiveSummation {
+		k.Sum += v
+		return
+	}
+	t := k.Sum + v
+	if math.Abs(k.Sum) >= math.Abs(v) {
+		k.Compensation += (k.Sum - t) + v
+	} else {
+		k.Compensation += (v - t) + k.Sum
+	}
+	k.Sum = t
+}
+
+// Value returns the compensated sum.
+func (k KahanSum) Value() float64 {
+	return k.Sum + k.Compensation
+}
+
+// Avg returns the arithmetic mean of nums, summed with a compensated sum.
+func Avg[T int | float64](nums []T) float64 {
+	return AvgWith(CompensatedSummation, nums)
+}
+
+// AvgWith returns the arithmetic mean of nums, summed with the summation.
+func AvgWith[T int | float64](summation Summation, nums []T) float64 {
+	sum := KahanSum{Summation: summation}
+	for _, num := range nums {
+		sum.Add(float64(num))
+	}
+	return sum.Value() / float64(len(nums))
+}
+
+// Percentile returns the p-th percentile of nums using the nearest-rank method. nums are sorted in place.
+func Percentile[T int | float64](p int, nums []T) (T, error) {
+	if p <= 0 || p > 100 {
+		return 0, fmt.Errorf("percentile %d is out of range", p)
+	}
+	if len(nums) == 0 {
+		return 0, fmt.Errorf("the slice is empty")
+	}
+
+	slices.Sort(nums)
+	// nearest-rank method
+	var rank int = int(math.Ceil((float64(p) / 100.0) * float64(len(nums))))
+	return nums[max(rank, 1)-1], nil
+}
+
+// PercentilesOf returns the percentiles of vals of the given ranks. vals are sorted in place, once for all ranks.
+func PercentilesOf[T int | float64](ranks []int, vals []T) map[int]float64 {
+	result := make(map[int]float64, len(ranks))
+	if len(vals) == 0 {
+		return result
+	}
+	slices.Sort(vals)
+	for _, p := range ranks {
+		if p <= 0 || p > 100 {
+			continue
+		}
+		rank := int(math.Ceil((float64(p) / 100.0) * float64(len(vals))))
+		result[p] = float64(vals[max(rank, 1)-1])
+	}
+	return result
+}
+
+// StdDev returns the population standard deviation of nums.
+func StdDev[T int | float64](nums []T) float64 {
+	if len(nums) == 0 {
+		return 0
+	}
+	mean := Avg(nums)
+	var sum KahanSum
+	for _, num := range nums {
+		d := float64(num) - mean
+		sum.Add(d * d)
+	}
+	return math.Sqrt(sum.Value() / float64(len(nums)))
+}
+
+// MAD returns the median absolute deviation of nums and their median, using the nearest-rank median of
+// Percentile. Unlike the standard deviation, it is not inflated by the outliers it is used to detect. nums are
+// sorted in place.
+func MAD[T int | float64](nums []T) (mad, median float64) {
+	if len(nums) == 0 {
+		return 0, 0
+	}
+	m, _ := Percentile(50, nums)
+	median = float64(m)
+	deviations := make([]float64, len(nums))
+	for i, num := range nums {
+		deviations[i] = math.Abs(float64(num) - median)
+	}
+	mad, _ = Percentile(50, deviations)
+	return mad, median
+}
+
+// Summary describes the distribution of a set of values.
+type Summary struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Avg    float64 `json:"avg"`
+	StdDev float64 `json:"stddev"`
+	Max    float64 `json:"max"`
+}
+
+// Summarize returns the summary of nums, which must not be empty. nums are sorted in place.
+func Summarize[T int | float64](nums []T) Summary {
+	s := Summary{Avg: Avg(nums), StdDev: StdDev(nums)}
+	median, _ := Percentile(50, nums)
+	s.Min, s.Median, s.Max = float64(nums[0]), float64(median), float64(nums[len(nums)-1])
+	return s
+}
+
+// Truncate shortens str to at most n bytes, appending "..." if it was cut. It cuts between grapheme clusters,
+// so UTF-8 sequences and characters with combining marks are not split.
+func Truncate(str string, n int) string {
+	if len(str) <= n {
+		return str
+	}
+	cut := 0
+	for cut < len(str) {
+		size, _ := nextGrapheme(str[cut:])
+		if cut+size > n {
+			break
+		}
+		cut += size
+	}
+	return str[:cut] + "..."
+}
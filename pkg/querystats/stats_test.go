@@ -0,0 +1,126 @@
+package querystats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKahanSum(t *testing.T) {
+	// 1 + 1e-16 rounds back to 1 in a naive sum, so adding it ten million times loses all of it
+	var sum KahanSum
+	naive := 0.0
+	sum.Add(1)
+	naive += 1
+	for range 10_000_000 {
+		sum.Add(1e-16)
+		naive += 1e-16
+	}
+	want := 1 + 1e-9
+	if got := sum.Value(); math.Abs(got-want) > 1e-15 {
+		t.Errorf("KahanSum = %.17g, want %.17g", got, want)
+	}
+	if naive != 1 {
+		t.Fatalf("the naive sum is %.17g, the test no longer shows the rounding error", naive)
+	}
+}
+
+func TestKahanSumCancellation(t *testing.T) {
+	var sum KahanSum
+	for _, v := range []float64{1, 1e100, 1, -1e100} {
+		sum.Add(v)
+	}
+	if got := sum.Value(); got != 2 {
+		t.Errorf("KahanSum = %g, want 2", got)
+	}
+	if got := (KahanSum{}).Value(); got != 0 {
+		t.Errorf("empty KahanSum = %g, want 0", got)
+	}
+}
+
+func TestKahanSumNaive(t *testing.T) {
+	sum := KahanSum{Summation: NaiveSummation}
+	sum.Add(1)
+	for range 1000 {
+		sum.Add(1e-16)
+	}
+	if got := sum.Value(); got != 1 {
+		t.Errorf("naive KahanSum = %.17g, want 1 like a naive sum", got)
+	}
+	vals := []float64{0.1, 0.2, 0.3}
+	if got, want := AvgWith(NaiveSummation, vals), (vals[0]+vals[1]+vals[2])/3; got != want {
+		t.Errorf("naive AvgWith = %.17g, want %.17g", got, want)
+	}
+}
+
+func TestParseSummation(t *testing.T) {
+	for name, want := range map[string]Summation{"compensated": CompensatedSummation, "naive": NaiveSummation} {
+		if got, err := ParseSummation(name); err != nil || got != want {
+			t.Errorf("ParseSummation(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseSummation("pairwise"); err == nil {
+		t.Error("ParseSummation(\"pairwise\") succeeded")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		p    int
+		nums []float64
+		want float64
+	}{
+		{50, []float64{3, 1, 2}, 2},
+		{100, []float64{3, 1, 2}, 3},
+		{1, []float64{3, 1, 2}, 1},
+		// nearest rank: ceil(0.95 * 10) = 10th value, not an interpolation
+		{95, []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 10},
+		{90, []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 9},
+		{50, []float64{1, 2, 3, 4}, 2},
+		{99, []float64{42}, 42},
+	}
+	for _, tt := range tests {
+		got, err := Percentile(tt.p, tt.nums)
+		if err != nil {
+			t.Errorf("Percentile(%d) returned %v", tt.p, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Percentile(%d, %v) = %g, want %g", tt.p, tt.nums, got, tt.want)
+		}
+	}
+}
+
+func TestPercentileErrors(t *testing.T) {
+	for _, p := range []int{0, -1, 101} {
+		if _, err := Percentile(p, []int{1, 2}); err == nil {
+			t.Errorf("Percentile(%d) returned no error", p)
+		}
+	}
+	if _, err := Percentile(50, []int{}); err == nil {
+		t.Error("Percentile of an empty slice returned no error")
+	}
+}
+
+func TestPercentilesOf(t *testing.T) {
+	vals := []int{10, 1, 9, 2, 8, 3, 7, 4, 6, 5}
+	got := PercentilesOf([]int{50, 90, 100, 0, 101}, vals)
+	want := map[int]float64{50: 5, 90: 9, 100: 10}
+	if len(got) != len(want) {
+		t.Fatalf("PercentilesOf = %v, want %v", got, want)
+	}
+	for p, v := range want {
+		if got[p] != v {
+			t.Errorf("PercentilesOf()[%d] = %g, want %g", p, got[p], v)
+		}
+	}
+	// the ranks agree with Percentile
+	for p := 1; p <= 100; p++ {
+		single, _ := Percentile(p, vals)
+		if all := PercentilesOf([]int{p}, vals); all[p] != float64(single) {
+			t.Errorf("PercentilesOf()[%d] = %g, Percentile = %d", p, all[p], single)
+		}
+	}
+	if got := PercentilesOf([]int{50}, []float64{}); len(got) != 0 {
+		t.Errorf("PercentilesOf of an empty slice = %v, want none", got)
+	}
+}
@@ -0,0 +1,100 @@
+package querystats
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/width"
+)
+
+const (
+	zeroWidthJoiner        = '\u200d'
+	emojiPresentation      = '\ufe0f'
+	firstRegionalIndicator = '\U0001f1e6'
+	lastRegionalIndicator  = '\U0001f1ff'
+	firstEmojiModifier     = '\U0001f3fb'
+	lastEmojiModifier      = '\U0001f3ff'
+)
+
+func isRegionalIndicator(r rune) bool {
+	return r >= firstRegionalIndicator && r <= lastRegionalIndicator
+}
+
+// extendsGrapheme reports whether r continues the grapheme cluster before it instead of starting a new one.
+func extendsGrapheme(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		r == zeroWidthJoiner || r == emojiPresentation ||
+		(r >= firstEmojiModifier && r <= lastEmojiModifier)
+}
+
+// nextGrapheme returns the size in bytes and the display width of the grapheme cluster at the start of str.
+// It approximates extended grapheme clusters of UAX #29 with combining marks, emoji ZWJ sequences, variation
+// selectors, skin tone modifiers and flags, which is what occurs in label values in practice.
+func nextGrapheme(str string) (size, columns int) {
+	r, size := utf8.DecodeRuneInString(str)
+	columns = runeWidth(r)
+	prev := r
+	for size < len(str) {
+		next, n := utf8.DecodeRuneInString(str[size:])
+		switch {
+		case prev == zeroWidthJoiner, extendsGrapheme(next):
+			if next == emojiPresentation {
+				columns = 2
+			}
+		case isRegionalIndicator(r) && isRegionalIndicator(next) && size == utf8.RuneLen(r):
+			// a pair of regional indicators is a flag
+			columns = 2
+		default:
+			return size, columns
+		}
+		prev = next
+		size += n
+	}
+	return size, columns
+}
+
+// runeWidth returns the number of terminal columns of a rune starting a grapheme cluster.
+func runeWidth(r rune) int {
+	switch {
+	case unicode.IsControl(r) || extendsGrapheme(r):
+		return 0
+	case r == utf8.RuneError:
+		return 1
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// DisplayWidth returns the number of terminal columns str takes, counting wide characters such as CJK and emoji
+// as two columns and combining marks as none.
+func DisplayWidth(str string) int {
+	columns := 0
+	for len(str) > 0 {
+		size, w := nextGrapheme(str)
+		columns += w
+		str = str[size:]
+	}
+	return columns
+}
+
+// TruncateWidth shortens str to at most n terminal columns, appending "..." if it was cut. It cuts only between
+// grapheme clusters, so neither UTF-8 sequences nor characters with combining marks or emoji are split.
+func TruncateWidth(str string, n int) string {
+	if DisplayWidth(str) <= n {
+		return str
+	}
+	budget := max(n-len("..."), 0)
+	cut, columns := 0, 0
+	for cut < len(str) {
+		size, w := nextGrapheme(str[cut:])
+		if columns+w > budget {
+			break
+		}
+		cut += size
+		columns += w
+	}
+	return str[:cut] + "..."
+}
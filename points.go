@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// lowThroughputRatio is the share of the median throughput below which a query is flagged.
+var lowThroughputRatio float64
+
+// lowThroughput enables the report of low-throughput queries.
+var lowThroughput bool
+
+func init() {
+	flag.BoolVar(&lowThroughput, "low-throughput", false, "report the queries evaluating the fewest points per second relative to queries of the same type, see -low-throughput-ratio")
+	flag.Float64Var(&lowThroughputRatio, "low-throughput-ratio", 0.1, "flag queries evaluating fewer points per second than this share of the median of queries of the same type, instant or range. Low throughput usually means slow storage rather than heavy math")
+}
+
+var MetricPoints = Metric{"points", "evaluation points", "", true, func(e *querystats.LogEntry) float64 { return float64(e.Points()) }}
+
+// PrintLowThroughputQueries prints queries ordered by their evaluation throughput relative to the median
+// throughput of queries of the same type, instant or range, flagging those below ratio of it. Instant queries
+// evaluate a single point, so they are not compared with range queries.
+func PrintLowThroughputQueries(queries []*querystats.Query, top int, ratio float64) {
+	type row struct {
+		query      *querystats.Query
+		throughput float64
+		relative   float64
+	}
+	var rows []row
+	medians := make(map[bool][]float64)
+	for _, q := range queries {
+		if pps, ok := q.PointsPerSecond(); ok {
+			rows = append(rows, row{query: q, throughput: pps})
+			isRange := q.Logs[0].Params.Step > 0
+			medians[isRange] = append(medians[isRange], pps)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	median := make(map[bool]float64, len(medians))
+	for isRange, throughputs := range medians {
+		median[isRange], _ = querystats.Percentile(50, throughputs)
+	}
+	low := 0
+	for i := range rows {
+		rows[i].relative = rows[i].throughput / median[rows[i].query.Logs[0].Params.Step > 0]
+		if rows[i].relative < ratio {
+			low++
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].relative < rows[j].relative })
+	rows = rows[:min(top, len(rows))]
+
+	fmt.Printf("Top %d queries by lowest evaluation throughput relative to the median of instant or range queries (%d below %.0f%%):\n",
+		len(rows), low, ratio*100)
+	for i, r := range rows {
+		flag := " "
+		if r.relative < ratio {
+			flag = "!"
+		}
+		fmt.Printf("%2d)%s points/s=%-10.1f of_median=%5.1f%% n=%-6d avg=%.3fs %s",
+			i+1, flag, r.throughput, r.relative*100, len(r.query.Logs), r.query.AvgExecTotalTime, queryWithID(r.query.Query))
+		printRuleName(r.query)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+)
+
+const (
+	// progressRedrawInterval is how often the progress line is redrawn on a terminal
+	progressRedrawInterval = 200 * time.Millisecond
+	// progressLogInterval is how often progress is logged if stderr isn't a terminal
+	progressLogInterval = 10 * time.Second
+)
+
+var progressMode string
+
+func init() {
+	flag.StringVar(&progressMode, "progress", "auto", "report the progress of reading the query log on stderr with the bytes read of the size of the files, lines, rate and ETA: auto, redrawn in place if stderr is a terminal and neither -q nor -log-format json is set, always, also logged every 10s otherwise, or never")
+}
+
+// ValidateProgressMode checks the -progress flag.
+func ValidateProgressMode() error {
+	switch progressMode {
+	case "auto", "always", "never":
+		return nil
+	}
+	return fmt.Errorf("-progress must be auto, always or never")
+}
+
+// lineCounter counts the lines read through it.
+type lineCounter struct {
+	r     io.Reader
+	lines *atomic.Int64
+}
+
+func (c lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.lines.Add(int64(bytes.Count(p[:n], []byte("\n"))))
+	return n, err
+}
+
+// progress reports how far reading the inputs opened by OpenInputs got.
+type progress struct {
+	inputs []*inputDigest
+	// total is the size of the inputs in bytes, or 0 if the size of some is unknown, e.g. of stdin
+	total    int64
+	lines    atomic.Int64
+	start    time.Time
+	terminal bool
+}
+
+// StartProgress reports the progress of reading the files by -progress until the returned function is called, and
+// returns the reader of input to read them through. Compressed files count by their compressed size.
+func StartProgress(files []string, input io.Reader) (io.Reader, func()) {
+	terminal := term.IsTerminal(os.Stderr.Fd())
+	if progressMode == "never" || (progressMode == "auto" && (!terminal || !logRedraws)) {
+		return input, func() {}
+	}
+	p := &progress{inputs: openedInputs[len(openedInputs)-len(files):], start: time.Now(), terminal: terminal}
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if name == "-" || isRemote(name) || err != nil {
+			p.total = 0
+			break
+		}
+		p.total += info.Size()
+	}
+	if p.total > 0 {
+		// files resumed from a snapshot are read from where it left off
+		for _, d := range p.inputs {
+			p.total -= d.offset
+		}
+	}
+
+	interval := progressLogInterval
+	if terminal {
+		interval = progressRedrawInterval
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				if terminal {
+					// clear the progress line before the report is printed
+					fmt.Fprint(os.Stderr, "\r\x1b[K")
+				}
+				return
+			case <-ticker.C:
+				if terminal {
+					fmt.Fprintf(os.Stderr, "\r\x1b[K%s", p)
+				} else {
+					slog.Info(p.String())
+				}
+			}
+		}
+	}()
+	return lineCounter{input, &p.lines}, func() {
+		close(stop)
+		<-done
+	}
+}
+
+// String renders the bytes read of the total, the lines read, the rate and the estimated time left.
+func (p *progress) String() string {
+	var read int64
+	for _, d := range p.inputs {
+		read += d.size.Load()
+	}
+	elapsed := time.Since(p.start)
+	rate := float64(read) / elapsed.Seconds()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Read %s", formatBytes(float64(read)))
+	if p.total > 0 {
+		fmt.Fprintf(&b, " of %s (%.1f%%)", formatBytes(float64(p.total)), 100*float64(read)/float64(p.total))
+	}
+	fmt.Fprintf(&b, ", %d lines, %s/s", p.lines.Load(), formatBytes(rate))
+	if p.total > 0 && rate > 0 {
+		eta := time.Duration(float64(p.total-read) / rate * float64(time.Second))
+		fmt.Fprintf(&b, ", ETA %s", eta.Round(time.Second))
+	}
+	return b.String()
+}
+
+// formatBytes formats a number of bytes with a binary unit, e.g. 1.5 GiB.
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PromClient is a minimal client of the Prometheus HTTP API.
+type PromClient struct {
+	BaseURL string
+	Client  *http.Client
+	// Header is added to every request, e.g. the tenant ID of Loki
+	Header http.Header
+}
+
+func NewPromClient(baseURL string) *PromClient {
+	return &PromClient{BaseURL: strings.TrimRight(baseURL, "/"), Client: http.DefaultClient}
+}
+
+// lookupTimeout bounds each request of the lookups of analyze, e.g. of series and label cardinality, so an
+// unresponsive Prometheus doesn't hang the report.
+const lookupTimeout = 30 * time.Second
+
+// newLookupClient returns a client of the Prometheus at baseURL whose requests time out after lookupTimeout.
+func newLookupClient(baseURL string) *PromClient {
+	c := NewPromClient(baseURL)
+	c.Client = &http.Client{Timeout: lookupTimeout}
+	return c
+}
+
+type promResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// Get calls an API endpoint, e.g. "/api/v1/labels", and decodes the data field of the response into result.
+func (c *PromClient) Get(ctx context.Context, path string, params url.Values, result any) error {
+	u := c.BaseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var pr promResponse
+	if err := json.Unmarshal(body, &pr); err != nil {
+		// Loki and proxies answer errors in plain text
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s failed: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+		}
+		return fmt.Errorf("failed to decode the response of %s (%s): %w", path, resp.Status, err)
+	}
+	if pr.Status != "success" {
+		return fmt.Errorf("%s failed: %s: %s", path, pr.ErrorType, pr.Error)
+	}
+	return json.Unmarshal(pr.Data, result)
+}
+
+// LabelValues returns the values of the label among series matching the selector, or among all series if it is empty.
+func (c *PromClient) LabelValues(ctx context.Context, label, selector string) ([]string, error) {
+	params := url.Values{}
+	if selector != "" {
+		params.Set("match[]", selector)
+	}
+	var values []string
+	err := c.Get(ctx, "/api/v1/label/"+url.PathEscape(label)+"/values", params, &values)
+	return values, err
+}
+
+// CountSeries returns the number of series matching the selector between start and end. Servers supporting the limit
+// parameter of the series API, Prometheus 2.51 and later, return at most limit series if it is positive.
+func (c *PromClient) CountSeries(ctx context.Context, selector string, start, end time.Time, limit int) (int, error) {
+	params := url.Values{}
+	params.Set("match[]", selector)
+	params.Set("start", start.UTC().Format(time.RFC3339))
+	params.Set("end", end.UTC().Format(time.RFC3339))
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	var series []json.RawMessage
+	err := c.Get(ctx, "/api/v1/series", params, &series)
+	return len(series), err
+}
+
+// EvalStats are the statistics of a query evaluated with stats=all, named like those of the query log.
+type EvalStats struct {
+	Timings struct {
+		ExecTotalTime float64 `json:"execTotalTime"`
+	} `json:"timings"`
+	Samples struct {
+		TotalQueryableSamples int `json:"totalQueryableSamples"`
+		PeakSamples           int `json:"peakSamples"`
+	} `json:"samples"`
+}
+
+// Query evaluates the query at end, or from start to end every step seconds if step is positive, and returns the
+// statistics of the evaluation. They are nil for servers before Prometheus 2.35, which don't return them.
+func (c *PromClient) Query(ctx context.Context, query string, start, end time.Time, step int) (*EvalStats, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("stats", "all")
+	path := "/api/v1/query"
+	if step > 0 {
+		path = "/api/v1/query_range"
+		params.Set("start", start.UTC().Format(time.RFC3339Nano))
+		params.Set("end", end.UTC().Format(time.RFC3339Nano))
+		params.Set("step", strconv.Itoa(step))
+	} else {
+		params.Set("time", end.UTC().Format(time.RFC3339Nano))
+	}
+	var data struct {
+		Stats *EvalStats `json:"stats"`
+	}
+	err := c.Get(ctx, path, params, &data)
+	return data.Stats, err
+}
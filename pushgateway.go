@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushgatewayConfig is where the summary of each analysis is pushed, so batch runs show up in Prometheus.
+type pushgatewayConfig struct {
+	URL      string
+	Job      string
+	Instance string
+}
+
+var pushgatewaySettings pushgatewayConfig
+
+func init() {
+	flag.StringVar(&pushgatewaySettings.URL, "pushgateway-url", "", "URL of a Pushgateway to push the summary of the analysis to as prom_query_stats_analysis_* metrics, e.g. http://pushgateway:9091")
+	flag.StringVar(&pushgatewaySettings.Job, "pushgateway-job", "prom-query-stats", "job label of the metrics pushed to -pushgateway-url")
+	flag.StringVar(&pushgatewaySettings.Instance, "pushgateway-instance", "", "instance label of the metrics pushed to -pushgateway-url, e.g. the analyzed Prometheus. Defaults to the hostname")
+}
+
+func (c pushgatewayConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// PushSummary pushes the percentiles, totals and the most expensive query of the analysis to the Pushgateway,
+// replacing the metrics of the previous run of the same job and instance. logs must be sorted by time.
+func (c pushgatewayConfig) PushSummary(queries []*querystats.Query, logs querystats.LogEntries, ranks []int) error {
+	instance := c.Instance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	registry := prometheus.NewRegistry()
+	gauge := func(name, help string, labels ...string) *prometheus.GaugeVec {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "prom_query_stats_analysis_" + name, Help: help}, labels)
+		registry.MustRegister(g)
+		return g
+	}
+
+	gauge("entries", "Number of query log entries analyzed.").WithLabelValues().Set(float64(len(logs)))
+	gauge("queries", "Number of distinct queries analyzed.").WithLabelValues().Set(float64(len(queries)))
+	gauge("window_start_timestamp_seconds", "Time of the first analyzed entry.").WithLabelValues().Set(float64(logs[0].TS.UnixMilli()) / 1e3)
+	gauge("window_end_timestamp_seconds", "Time of the last analyzed entry.").WithLabelValues().Set(float64(logs[len(logs)-1].TS.UnixMilli()) / 1e3)
+	gauge("last_run_timestamp_seconds", "Time the analysis was pushed.").WithLabelValues().Set(float64(time.Now().UnixMilli()) / 1e3)
+
+	score, totalExec, totalSamples := costScorer(queries)
+	gauge("exec_time_seconds_total", "Total execution time of the analyzed entries.").WithLabelValues().Set(totalExec)
+	gauge("queryable_samples_total", "Total queryable samples of the analyzed entries.").WithLabelValues().Set(totalSamples)
+
+	for _, m := range []struct {
+		metric Metric
+		name   string
+		help   string
+	}{
+		{MetricExecTotalTime, "exec_time_seconds", "Percentiles of the execution time of the analyzed entries."},
+		{MetricTotalQueryableSamples, "queryable_samples", "Percentiles of the total queryable samples of the analyzed entries."},
+		{MetricPeakSamples, "peak_samples", "Percentiles of the peak samples of the analyzed entries."},
+	} {
+		percentiles, err := metricPercentiles(ranks, logs, m.metric)
+		if err != nil {
+			return fmt.Errorf("failed to calculate percentile: %w", err)
+		}
+		g := gauge(m.name, m.help, "quantile")
+		for _, p := range ranks {
+			g.WithLabelValues(strconv.FormatFloat(float64(p)/100, 'f', -1, 64)).Set(percentiles[p])
+		}
+	}
+
+	top := queries[0]
+	for _, q := range queries[1:] {
+		if score(q) > score(top) {
+			top = q
+		}
+	}
+	gauge("top_query_cost_score", "Cost score of the most expensive query: the average of its share of the total execution time and of the total queryable samples.", "id").
+		WithLabelValues(QueryID(top.Query)).Set(score(top) / 100)
+	if costModel.Enabled() {
+		gauge("top_query_cost", "Estimated cost of the most expensive query by cost score, in the unit of -cost-per-second and -cost-per-msamples.", "id").
+			WithLabelValues(QueryID(top.Query)).Set(costModel.QueryCost(top))
+	}
+
+	return push.New(c.URL, c.Job).Grouping("instance", instance).Gatherer(registry).Push()
+}
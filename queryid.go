@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// queryIDPattern matches the ids returned by QueryID.
+var queryIDPattern = regexp.MustCompile(`^[0-9a-f]{12}$`)
+
+// QueryID returns a short stable identifier of the query derived from its fingerprint, so that
+// variants differing only in literals share it. Queries that can't be parsed are identified by their text.
+// The id depends only on the query, not on -normalize or the log it was read from, so it can be used to track a
+// query across runs, reports and tickets. It changes only if the fingerprint of the query does.
+func QueryID(query string) string {
+	if fp, err := querystats.Fingerprint(query); err == nil {
+		query = fp
+	}
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:6])
+}
+
+// queryWithID renders a query in a row of a report preceded by its id.
+func queryWithID(query string) string {
+	return QueryID(query) + " " + escapeTerminal(query)
+}
+
+// ParseQueryIDs parses a comma-separated list of query ids, see QueryID.
+func ParseQueryIDs(s string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(s, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !queryIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("%q is not a query id of 12 hexadecimal digits", id)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// QueryIDFilter returns a LoadOptions.QueryFilter keeping the queries with one of the ids, or nil if there are none.
+// Ids are computed once per distinct query, since that parses the query.
+func QueryIDFilter(ids map[string]bool) func(query string) bool {
+	if len(ids) == 0 {
+		return nil
+	}
+	var cache sync.Map
+	return func(query string) bool {
+		keep, ok := cache.Load(query)
+		if !ok {
+			keep, _ = cache.LoadOrStore(query, ids[QueryID(query)])
+		}
+		return keep.(bool)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var promqlKeywords = map[string]bool{
+	"and": true, "or": true, "unless": true, "by": true, "without": true, "on": true, "ignoring": true,
+	"group_left": true, "group_right": true, "offset": true, "bool": true, "atan2": true,
+	"inf": true, "nan": true,
+}
+
+var promqlLabelListKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+}
+
+// CountSelectors estimates the number of vector selectors in the query without fully parsing it.
+// It counts metric names that are not function calls as well as label matcher blocks
+// that are not attached to a metric name, e.g. {__name__=~"foo.*"}.
+func CountSelectors(query string) int {
+	var tokens []string
+	for i := 0; i < len(query); {
+		r, lit := querystats.NextToken(query[i:])
+		i += len(lit)
+		if !unicode.IsSpace(r) {
+			tokens = append(tokens, lit)
+		}
+	}
+
+	count := 0
+	braceDepth, bracketDepth := 0, 0
+	labelList := false
+	for i, tok := range tokens {
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		prev := ""
+		if i > 0 {
+			prev = tokens[i-1]
+		}
+		switch tok {
+		case "{":
+			if braceDepth == 0 && bracketDepth == 0 && !isMetricName(prev) {
+				count++
+			}
+			braceDepth++
+			continue
+		case "}":
+			braceDepth--
+			continue
+		case "[":
+			bracketDepth++
+			continue
+		case "]":
+			bracketDepth--
+			continue
+		case ")":
+			labelList = false
+			continue
+		}
+		if braceDepth > 0 || bracketDepth > 0 || labelList {
+			continue
+		}
+		lower := strings.ToLower(tok)
+		if promqlLabelListKeywords[lower] && next == "(" {
+			labelList = true
+			continue
+		}
+		// aggregations can have their grouping clause before the parameters, e.g. sum by (job) (...)
+		nextLower := strings.ToLower(next)
+		if nextLower == "by" || nextLower == "without" {
+			continue
+		}
+		if isMetricName(tok) && !promqlKeywords[lower] && next != "(" {
+			count++
+		}
+	}
+	return count
+}
+
+func isMetricName(tok string) bool {
+	r, _ := utf8.DecodeRuneInString(tok)
+	return r == '_' || r == ':' || unicode.IsLetter(r)
+}
+
+type sizeBucket struct {
+	Label string
+	Upper int
+}
+
+var (
+	queryLengthBuckets   = []sizeBucket{{"<100", 100}, {"100-999", 1000}, {"1k-9.9k", 10000}, {">=10k", -1}}
+	querySelectorBuckets = []sizeBucket{{"0-1", 2}, {"2-5", 6}, {"6-20", 21}, {"21-100", 101}, {">100", -1}}
+)
+
+func bucketIndex(buckets []sizeBucket, v int) int {
+	for i, b := range buckets {
+		if b.Upper < 0 || v < b.Upper {
+			return i
+		}
+	}
+	return len(buckets) - 1
+}
+
+func printSizeHistogram(title string, buckets []sizeBucket, queries []*querystats.Query, value func(q *querystats.Query) int) {
+	counts := make([]int, len(buckets))
+	entries := make([]int, len(buckets))
+	for _, q := range queries {
+		i := bucketIndex(buckets, value(q))
+		counts[i]++
+		entries[i] += len(q.Logs)
+	}
+	fmt.Printf("Distribution of %s:\n", title)
+	for i, b := range buckets {
+		fmt.Printf("  %-8s queries=%-6d entries=%d\n", b.Label, counts[i], entries[i])
+	}
+}
+
+// PrintQuerySizeReport prints the distribution of query text length and of the number of selectors
+// per distinct query and lists the largest queries. Queries with more selectors than maxSelectors or
+// longer than maxLength are flagged as machine-generated mega-queries.
+func PrintQuerySizeReport(queries []*querystats.Query, top int, maxSelectors, maxLength int) {
+	selectors := make(map[*querystats.Query]int, len(queries))
+	for _, q := range queries {
+		selectors[q] = CountSelectors(q.Query)
+	}
+	printSizeHistogram("query length (characters)", queryLengthBuckets, queries, func(q *querystats.Query) int { return len(q.Query) })
+	printSizeHistogram("selectors per query", querySelectorBuckets, queries, func(q *querystats.Query) int { return selectors[q] })
+
+	sorted := make([]*querystats.Query, len(queries))
+	copy(sorted, queries)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i].Query) > len(sorted[j].Query) })
+	mega := 0
+	for _, q := range sorted {
+		if selectors[q] > maxSelectors || len(q.Query) > maxLength {
+			mega++
+		}
+	}
+	top = min(top, len(sorted))
+
+	fmt.Printf("Top %d queries by length (%d mega-queries with more than %d selectors or %d characters):\n", top, mega, maxSelectors, maxLength)
+	for i, q := range sorted[:top] {
+		flag := " "
+		if selectors[q] > maxSelectors || len(q.Query) > maxLength {
+			flag = "!"
+		}
+		fmt.Printf(
+			"%2d)%s len=%-6d selectors=%-4d n=%-6d %.3fs %s\n",
+			i+1,
+			flag,
+			len(q.Query),
+			selectors[q],
+			len(q.Logs),
+			q.AvgExecTotalTime,
+			querystats.TruncateWidth(queryWithID(q.Query), 200),
+		)
+	}
+}
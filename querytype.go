@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// QueryTypeStats are the statistics of the entries of instant or of range queries, which perform very differently:
+// range queries evaluate the expression at every step.
+type QueryTypeStats struct {
+	Type             querystats.QueryType `json:"type"`
+	Entries          int                  `json:"entries"`
+	SumExecTotalTime float64              `json:"sumExecTotalTime"`
+	Percentiles      []ReportPercentile   `json:"percentiles"`
+	Summaries        []ReportSummary      `json:"summaries"`
+}
+
+// QueryTypeBreakdown computes the statistics of each query type with entries. Percentiles of the given ranks are
+// computed over the entries of each type.
+func QueryTypeBreakdown(logs querystats.LogEntries, ranks []int) ([]QueryTypeStats, error) {
+	byType := make(map[querystats.QueryType]querystats.LogEntries)
+	for _, log := range logs {
+		byType[log.Type()] = append(byType[log.Type()], log)
+	}
+	var result []QueryTypeStats
+	for _, t := range querystats.QueryTypes {
+		entries := byType[t]
+		if len(entries) == 0 {
+			continue
+		}
+		s := QueryTypeStats{Type: t, Entries: len(entries)}
+		sum := newSum()
+		for _, log := range entries {
+			sum.Add(log.Stats.Timings.ExecTotalTime)
+		}
+		s.SumExecTotalTime = sum.Value()
+		for _, m := range []Metric{MetricExecTotalTime, MetricTotalQueryableSamples, MetricPeakSamples} {
+			summary, percentiles, err := metricSummary(ranks, entries, m)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range ranks {
+				s.Percentiles = append(s.Percentiles, ReportPercentile{m.Name, p, percentiles[p]})
+			}
+			s.Summaries = append(s.Summaries, ReportSummary{m.Name, summary})
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// PrintQueryTypes prints the number of entries, the share of the execution time and the distribution of the metrics
+// of each query type.
+func PrintQueryTypes(types []QueryTypeStats) {
+	var total float64
+	for _, t := range types {
+		total += t.SumExecTotalTime
+	}
+	fmt.Println("Load by query type:")
+	for i, t := range types {
+		share := 0.0
+		if total > 0 {
+			share = 100 * t.SumExecTotalTime / total
+		}
+		fmt.Printf("%2d) n=%-7d total=%.3fs share=%5.1f%% %s queries\n", i+1, t.Entries, t.SumExecTotalTime, share, t.Type)
+		for _, s := range t.Summaries {
+			m := markdownMetric(s.Metric)
+			var percentiles []string
+			for _, p := range t.Percentiles {
+				if p.Metric == s.Metric {
+					percentiles = append(percentiles, fmt.Sprintf("p%d=%s", p.Rank, m.Format(p.Value)))
+				}
+			}
+			fmt.Printf("    %s: %s %s\n", m.Title, strings.Join(percentiles, " "), formatSummary(s.Summary, m))
+		}
+	}
+}
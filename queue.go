@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// queueNegligibleShare is the share of the execution time spent queued below which raising
+// --query.max-concurrency isn't worth it
+const queueNegligibleShare = 0.01
+
+// QueueWait is the time queries spent waiting for a free slot of --query.max-concurrency.
+type QueueWait struct {
+	Queued, Total float64
+	// QueuedExecutions is the number of executions that waited at all, of Executions
+	QueuedExecutions, Executions int
+	// MaxQueued is the longest wait of a single execution
+	MaxQueued float64
+}
+
+// queueWait sums the queue time of the entries.
+func queueWait(logs querystats.LogEntries) QueueWait {
+	var w QueueWait
+	queued, total := newSum(), newSum()
+	for _, log := range logs {
+		t := log.Stats.Timings
+		queued.Add(t.ExecQueueTime)
+		total.Add(t.ExecTotalTime)
+		if t.ExecQueueTime > 0 {
+			w.QueuedExecutions++
+		}
+		w.MaxQueued = max(w.MaxQueued, t.ExecQueueTime)
+	}
+	w.Queued, w.Total, w.Executions = queued.Value(), total.Value(), len(logs)
+	return w
+}
+
+// Ratio is the share of the execution time spent queued.
+func (w QueueWait) Ratio() float64 {
+	if w.Total <= 0 {
+		return 0
+	}
+	return w.Queued / w.Total
+}
+
+// PrintQueueWait prints the time lost to queuing with whether raising --query.max-concurrency would help, given the
+// estimated concurrency, and the top queries by the share of their execution time spent queued.
+func PrintQueueWait(queries []*querystats.Query, logs querystats.LogEntries, concurrency ConcurrencyStats, top int) {
+	total := queueWait(logs)
+	fmt.Printf("Time lost to queuing: %.3fs of %.3fs execution time (%.1f%%), %d of %d executions queued, the longest for %.3fs\n",
+		total.Queued, total.Total, 100*total.Ratio(), total.QueuedExecutions, total.Executions, total.MaxQueued)
+	switch {
+	case total.Ratio() < queueNegligibleShare:
+		fmt.Println("Raising --query.max-concurrency would not help, queries hardly wait for a free slot")
+	case concurrency.Max < maxConcurrency:
+		fmt.Printf("Raising --query.max-concurrency could save up to %.3fs, but the estimated concurrency never reached -max-concurrency=%d, check that it matches the server\n",
+			total.Queued, maxConcurrency)
+	default:
+		fmt.Printf("Raising --query.max-concurrency above %d could save up to %.3fs if the server has CPU and memory to spare, otherwise reduce the load of the top queries\n",
+			maxConcurrency, total.Queued)
+	}
+	if total.Queued == 0 {
+		return
+	}
+
+	type queryWait struct {
+		q *querystats.Query
+		w QueueWait
+	}
+	var waits []queryWait
+	for _, q := range queries {
+		if w := queueWait(q.Logs); w.Queued > 0 {
+			waits = append(waits, queryWait{q, w})
+		}
+	}
+	sort.SliceStable(waits, func(i, j int) bool {
+		if waits[i].w.Ratio() != waits[j].w.Ratio() {
+			return waits[i].w.Ratio() > waits[j].w.Ratio()
+		}
+		return waits[i].w.Queued > waits[j].w.Queued
+	})
+	fmt.Println()
+	fmt.Printf("Top %d queries by queue wait ratio:\n", min(top, len(waits)))
+	for i, qw := range waits[:min(top, len(waits))] {
+		fmt.Printf("%2d) ratio=%5.1f%% queued=%.3fs of %.3fs share=%5.1f%% n=%-6d %s",
+			i+1, 100*qw.w.Ratio(), qw.w.Queued, qw.w.Total, 100*qw.w.Queued/total.Queued, qw.w.Executions, queryWithID(qw.q.Query))
+		printRuleName(qw.q)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var (
+	MetricRange = Metric{"range", "query range", "s", true, func(e *querystats.LogEntry) float64 { return e.Range().Seconds() }}
+	MetricStep  = Metric{"step", "step", "s", true, func(e *querystats.LogEntry) float64 { return float64(e.Params.Step) }}
+)
+
+// stepMaxPoints is the number of evaluation points above which the step of a range query is flagged as too small
+// for its range.
+var stepMaxPoints int
+
+func init() {
+	flag.IntVar(&stepMaxPoints, "step-max-points", 2000, "flag range queries evaluating more points than this, i.e. whose step is small for their range, such as 15s over 30 days from a zoomed out dashboard. Graphs rarely have more pixels. Prometheus rejects queries above 11000 points")
+}
+
+// rangeBuckets are the upper bounds of the query range buckets, from the default ranges of dashboards up to the
+// retention of most servers.
+var rangeBuckets = []time.Duration{15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// formatRangeBucket renders a bucket bound as e.g. 15m, 6h or 7d.
+func formatRangeBucket(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+}
+
+// PrintRangeReport prints the distribution of the range and the step of range queries, the entries per range
+// bucket, the queries with the longest ranges and those whose step is too small for the range. Long ranges, e.g. of dashboards opened over weeks, select many
+// samples at once and are a common cause of peak sample blowups.
+func PrintRangeReport(queries []*querystats.Query, logs querystats.LogEntries, top int, ranks []int) error {
+	var rangeLogs querystats.LogEntries
+	for _, log := range logs {
+		if log.Type() == querystats.QueryTypeRange {
+			rangeLogs = append(rangeLogs, log)
+		}
+	}
+	if len(rangeLogs) == 0 {
+		fmt.Println("No range queries")
+		return nil
+	}
+
+	fmt.Printf("Range queries: %d of %d entries\n", len(rangeLogs), len(logs))
+	for _, m := range []Metric{MetricRange, MetricStep} {
+		summary, percentiles, err := metricSummary(ranks, rangeLogs, m)
+		if err != nil {
+			return fmt.Errorf("failed to calculate the distribution of %s: %w", m.Title, err)
+		}
+		fmt.Printf("Distribution of %s:", m.Title)
+		for _, p := range ranks {
+			fmt.Printf(" p%d=%s", p, m.Format(percentiles[p]))
+		}
+		fmt.Printf(" %s\n", formatSummary(summary, m))
+	}
+
+	counts := make([]int, len(rangeBuckets)+1)
+	peaks := make([]float64, len(rangeBuckets)+1)
+	for _, log := range rangeLogs {
+		i := 0
+		for i < len(rangeBuckets) && log.Range() > rangeBuckets[i] {
+			i++
+		}
+		counts[i]++
+		peaks[i] += float64(log.Stats.Samples.PeakSamples)
+	}
+	fmt.Println()
+	fmt.Println("Range queries by query range:")
+	for i, n := range counts {
+		bucket := "<=" + formatRangeBucket(rangeBuckets[min(i, len(rangeBuckets)-1)])
+		if i == len(rangeBuckets) {
+			bucket = ">" + formatRangeBucket(rangeBuckets[i-1])
+		}
+		avgPeak := 0.0
+		if n > 0 {
+			avgPeak = peaks[i] / float64(n)
+		}
+		fmt.Printf("%-5s n=%-7d share=%5.1f%% avg_peak_samples=%.0f\n", bucket, n, 100*float64(n)/float64(len(rangeLogs)), avgPeak)
+	}
+
+	var rangeQueries []*querystats.Query
+	for _, q := range queries {
+		if Aggregate(q, MetricRange, TableMax) > 0 {
+			rangeQueries = append(rangeQueries, q)
+		}
+	}
+	SortQueries(rangeQueries, MetricRange, TableMax)
+	fmt.Println()
+	PrintTable(rangeQueries, top, MetricRange, TableMax, nil)
+
+	fmt.Println()
+	PrintStepRisks(FindStepRisks(queries, stepMaxPoints), top, stepMaxPoints, len(rangeLogs))
+	if retention > 0 {
+		fmt.Println()
+		PrintRetentionMisses(FindRetentionMisses(queries, time.Duration(retention)), top, time.Duration(retention), rangeLogs)
+	}
+	return nil
+}
+
+// StepRisk is a query executed as range queries with more evaluation points than the limit.
+type StepRisk struct {
+	Query *querystats.Query
+	// Executions is the number of executions above the limit
+	Executions int
+	// Worst is the execution with the most evaluation points
+	Worst *querystats.LogEntry
+}
+
+// FindStepRisks returns the queries with range executions evaluating more than maxPoints points, ordered by the
+// points of their worst execution.
+func FindStepRisks(queries []*querystats.Query, maxPoints int) []StepRisk {
+	var risks []StepRisk
+	for _, q := range queries {
+		risk := StepRisk{Query: q}
+		for _, log := range q.Logs {
+			if log.Type() != querystats.QueryTypeRange || log.Points() <= maxPoints {
+				continue
+			}
+			risk.Executions++
+			if risk.Worst == nil || log.Points() > risk.Worst.Points() {
+				risk.Worst = log
+			}
+		}
+		if risk.Worst != nil {
+			risks = append(risks, risk)
+		}
+	}
+	sort.SliceStable(risks, func(i, j int) bool {
+		if risks[i].Worst.Points() != risks[j].Worst.Points() {
+			return risks[i].Worst.Points() > risks[j].Worst.Points()
+		}
+		return risks[i].Executions > risks[j].Executions
+	})
+	return risks
+}
+
+// PrintStepRisks prints the queries whose step is too small for their range with the range and step of their worst
+// execution and the smallest step keeping it within maxPoints points.
+func PrintStepRisks(risks []StepRisk, top, maxPoints, rangeEntries int) {
+	executions := 0
+	for _, r := range risks {
+		executions += r.Executions
+	}
+	if len(risks) == 0 {
+		fmt.Printf("No range queries evaluate more than %d points, see -step-max-points\n", maxPoints)
+		return
+	}
+	fmt.Printf("Top %d of %d queries whose step is too small for the range, evaluating more than %d points in %d of %d range entries:\n",
+		min(top, len(risks)), len(risks), maxPoints, executions, rangeEntries)
+	for i, r := range risks[:min(top, len(risks))] {
+		queryRange := r.Worst.Range()
+		step := time.Duration(r.Worst.Params.Step) * time.Second
+		// the step is logged in seconds
+		minStep := ((queryRange/time.Duration(maxPoints) + time.Second - 1) / time.Second) * time.Second
+		fmt.Printf("%2d) points=%-8d range=%-8s step=%-6s min_step=%-9s n=%-6d avg=%.3fs %s",
+			i+1, r.Worst.Points(), formatPromDuration(queryRange), formatPromDuration(step), formatPromDuration(minStep),
+			r.Executions, r.Query.AvgExecTotalTime, queryWithID(r.Query.Query))
+		printRuleName(r.Query)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// RuleQueryRegularity is the regularity of the execution interval of a rule query.
+type RuleQueryRegularity struct {
+	Query   *querystats.Query
+	MeanGap time.Duration
+	Score   float64
+}
+
+// RuleQueryRegularities returns the rule queries with enough executions to score, ordered by their regularity score.
+// Rule groups are evaluated at a fixed interval, so a high score usually means that evaluations are delayed by rule
+// group contention or slow evaluation.
+func RuleQueryRegularities(queries []*querystats.Query) []RuleQueryRegularity {
+	var rows []RuleQueryRegularity
+	for _, q := range queries {
+		if q.Logs[0].RuleGroup == nil {
+			continue
+		}
+		if meanGap, score, ok := q.IntervalRegularity(); ok {
+			rows = append(rows, RuleQueryRegularity{q, meanGap, score})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Score > rows[j].Score })
+	return rows
+}
+
+// PrintIrregularRuleQueries prints the top rule queries by regularity score, flagging those above threshold.
+func PrintIrregularRuleQueries(rows []RuleQueryRegularity, top int, threshold float64) {
+	irregular := 0
+	for _, r := range rows {
+		if r.Score > threshold {
+			irregular++
+		}
+	}
+	if len(rows) > top {
+		rows = rows[:top]
+	}
+
+	fmt.Printf("Top %d rule queries by execution interval irregularity (%d above %.2f):\n", len(rows), irregular, threshold)
+	for i, r := range rows {
+		flag := " "
+		if r.Score > threshold {
+			flag = "!"
+		}
+		fmt.Printf(
+			"%2d)%s score=%-6.2f interval=%-8s n=%-6d %s",
+			i+1,
+			flag,
+			r.Score,
+			r.MeanGap.Round(time.Second),
+			len(r.Query.Logs),
+			queryWithID(r.Query.Query),
+		)
+		printRuleName(r.Query)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const (
+	// recordingRuleExecutionsPerHour is the rate of executions from the HTTP API above which a query is worth
+	// precomputing with a recording rule
+	recordingRuleExecutionsPerHour = 12
+	// recordingRuleInterval is the evaluation interval a recording rule replacing a query is assumed to have
+	recordingRuleInterval = time.Minute
+	// minRefreshInterval is the median gap between executions of a dashboard query below which its refresh
+	// interval is considered too short
+	minRefreshInterval = 30 * time.Second
+	// suggestedRefreshInterval is the refresh interval the savings of lengthening it are estimated for
+	suggestedRefreshInterval = time.Minute
+	// remediationQueueShare is the share of queue time in the execution time of a query worth mentioning
+	remediationQueueShare = 0.2
+	// minTrendExecutions is the number of executions needed in each half of the window to estimate growth
+	minTrendExecutions = 5
+)
+
+var printFixFirst bool
+
+func init() {
+	flag.BoolVar(&printFixFirst, "fix-first", false, "print a prioritized list of actions, e.g. adding a recording rule, lengthening a refresh interval or splitting a rule group, with the fingerprints they cover and estimated savings")
+}
+
+// Remediation is a concrete action reducing the load of the queries with the given fingerprint ids.
+type Remediation struct {
+	Action string
+	Reason string
+	// IDs are the QueryIDs of the fingerprints the action covers
+	IDs []string
+	// Savings is the estimated engine time saved over the analyzed window in seconds
+	Savings float64
+	// Growth is the relative change of the engine time of the covered queries from the first to the second half
+	// of the window, weighting their priority
+	Growth float64
+}
+
+// priority orders remediations. Savings of growing queries are weighted up, so they are fixed before they get worse.
+func (r Remediation) priority() float64 {
+	return r.Savings * (1 + max(r.Growth, 0))
+}
+
+// fingerprintGroup is the executions of all queries sharing a fingerprint.
+type fingerprintGroup struct {
+	id      string
+	query   string
+	logs    querystats.LogEntries
+	rule    bool
+	sumExec float64
+	queue   float64
+	points  int
+}
+
+func groupByFingerprint(queries []*querystats.Query) []*fingerprintGroup {
+	groups := make(map[string]*fingerprintGroup)
+	for _, q := range queries {
+		rule := q.Logs[0].RuleGroup != nil
+		id := QueryID(q.Query)
+		key := fmt.Sprint(id, rule)
+		g := groups[key]
+		if g == nil {
+			g = &fingerprintGroup{id: id, query: q.Query, rule: rule}
+			groups[key] = g
+		}
+		for _, log := range q.Logs {
+			g.logs = append(g.logs, log)
+			g.sumExec += log.Stats.Timings.ExecTotalTime
+			g.queue += log.Stats.Timings.ExecQueueTime
+			g.points += log.Points()
+		}
+	}
+	result := make([]*fingerprintGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].id < result[j].id })
+	return result
+}
+
+// growth returns the relative change of the engine time of logs from the first to the second half of [from, to].
+func growth(logs querystats.LogEntries, from, to time.Time) float64 {
+	mid := from.Add(to.Sub(from) / 2)
+	var first, second float64
+	var nFirst, nSecond int
+	for _, log := range logs {
+		if log.TS.Before(mid) {
+			first += log.Stats.Timings.ExecTotalTime
+			nFirst++
+		} else {
+			second += log.Stats.Timings.ExecTotalTime
+			nSecond++
+		}
+	}
+	if nFirst < minTrendExecutions || nSecond < minTrendExecutions || first == 0 {
+		return 0
+	}
+	return second/first - 1
+}
+
+// medianGap returns the median gap between consecutive executions.
+func medianGap(logs querystats.LogEntries) (time.Duration, bool) {
+	if len(logs) < 3 {
+		return 0, false
+	}
+	times := make([]time.Time, 0, len(logs))
+	for _, log := range logs {
+		times = append(times, *log.TS)
+	}
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+	gaps := make([]time.Duration, 0, len(times)-1)
+	for i := 1; i < len(times); i++ {
+		gaps = append(gaps, times[i].Sub(times[i-1]))
+	}
+	slices.Sort(gaps)
+	return gaps[len(gaps)/2], true
+}
+
+// shardLabels returns the grouping labels of the outermost aggregation of the query, by which it could be split
+// into several smaller rules, e.g. one per namespace.
+func shardLabels(query string) []string {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil
+	}
+	for {
+		switch e := expr.(type) {
+		case *parser.ParenExpr:
+			expr = e.Expr
+			continue
+		case *parser.AggregateExpr:
+			if e.Without || e.Op == parser.TOPK || e.Op == parser.BOTTOMK || e.Op == parser.QUANTILE {
+				return nil
+			}
+			return e.Grouping
+		}
+		return nil
+	}
+}
+
+// Remediations combines frequency, latency, samples, queue share, growth and shardability of the queries into
+// concrete actions ordered by priority. logs must be sorted by time.
+func Remediations(queries []*querystats.Query, logs querystats.LogEntries) []Remediation {
+	if len(logs) == 0 {
+		return nil
+	}
+	from, to := *logs[0].TS, *logs[len(logs)-1].TS
+	hours := to.Sub(from).Hours()
+	var result []Remediation
+
+	for _, g := range groupByFingerprint(queries) {
+		if g.rule || g.sumExec == 0 {
+			continue
+		}
+		var details []string
+		var samples float64
+		for _, log := range g.logs {
+			samples += float64(log.Stats.Samples.TotalQueryableSamples)
+		}
+		details = append(details, fmt.Sprintf("avg %.3fs and %.0f samples", g.sumExec/float64(len(g.logs)), samples/float64(len(g.logs))))
+		if g.queue/g.sumExec >= remediationQueueShare {
+			details = append(details, fmt.Sprintf("%.0f%% of it queued", 100*g.queue/g.sumExec))
+		}
+		trend := growth(g.logs, from, to)
+		if trend > 0 {
+			details = append(details, fmt.Sprintf("load growing %.0f%%", 100*trend))
+		}
+
+		if gap, ok := medianGap(g.logs); ok && gap < minRefreshInterval {
+			savings := g.sumExec * (1 - gap.Seconds()/suggestedRefreshInterval.Seconds())
+			result = append(result, Remediation{
+				Action:  fmt.Sprintf("Lengthen the refresh interval of the dashboards running %s to %s", g.id, suggestedRefreshInterval),
+				Reason:  strings.Join(append([]string{fmt.Sprintf("executed every %s", gap.Round(time.Second))}, details...), ", "),
+				IDs:     []string{g.id},
+				Savings: savings,
+				Growth:  trend,
+			})
+			continue
+		}
+
+		if hours > 0 && float64(len(g.logs))/hours >= recordingRuleExecutionsPerHour && g.points > 0 {
+			// a recording rule evaluates one point per interval instead of all points of all executions
+			evaluations := to.Sub(from).Seconds() / recordingRuleInterval.Seconds()
+			savings := g.sumExec - evaluations*g.sumExec/float64(g.points)
+			if savings <= 0 {
+				continue
+			}
+			reason := append([]string{fmt.Sprintf("%.0f executions per hour from the HTTP API", float64(len(g.logs))/hours)}, details...)
+			if labels := shardLabels(g.query); len(labels) > 0 {
+				reason = append(reason, fmt.Sprintf("shardable by %s", strings.Join(labels, ", ")))
+			}
+			result = append(result, Remediation{
+				Action:  fmt.Sprintf("Add a recording rule for %s", g.id),
+				Reason:  strings.Join(reason, ", "),
+				IDs:     []string{g.id},
+				Savings: savings,
+				Growth:  trend,
+			})
+		}
+	}
+
+	for _, rg := range GroupByRuleGroup(queries) {
+		if rg.Name == "" {
+			continue
+		}
+		var groupQueries []*querystats.Query
+		var groupLogs querystats.LogEntries
+		for _, q := range queries {
+			if q.Logs[0].RuleGroup != nil && q.Logs[0].RuleGroup.Name == rg.Name && q.Logs[0].RuleGroup.File == rg.File {
+				groupQueries = append(groupQueries, q)
+				groupLogs = append(groupLogs, q.Logs...)
+			}
+		}
+		overloaded, _ := ruleGroupRisks(groupQueries)
+		if len(overloaded) == 0 || len(groupQueries) < 2 {
+			continue
+		}
+		var ids []string
+		for _, q := range groupQueries {
+			if id := QueryID(q.Query); !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		trend := growth(groupLogs, from, to)
+		result = append(result, Remediation{
+			Action: fmt.Sprintf("Split the rule group %q", rg.Name),
+			Reason: fmt.Sprintf("its %d rules take more than %.0f%% of the evaluation interval or overran it, risking missed evaluations",
+				len(groupQueries), 100*ruleBudget),
+			IDs: ids,
+			// splitting doesn't save engine time, but the time over the budget is what is at stake
+			Savings: rg.ExecTime * (1 - ruleBudget),
+			Growth:  trend,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].priority() > result[j].priority() })
+	return result
+}
+
+// PrintRemediations prints the first top remediations.
+func PrintRemediations(remediations []Remediation, top int) {
+	fmt.Printf("What to fix first, top %d of %d actions by estimated savings:\n", min(top, len(remediations)), len(remediations))
+	for i, r := range remediations[:min(top, len(remediations))] {
+		fmt.Printf("%2d) %s: estimated savings ~%.3fs. %s\n", i+1, r.Action, r.Savings, escapeTerminal(r.Reason))
+		fmt.Printf("    covers %s\n", strings.Join(r.IDs, " "))
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsReadOnlyScope is the OAuth scope requested to download objects from Google Cloud Storage.
+const gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// remoteSchemes are the URL schemes of query logs read over the network instead of from files.
+var remoteSchemes = []string{"s3://", "gs://", "http://", "https://"}
+
+// isRemote reports whether the input is a URL of an object in S3, Google Cloud Storage or on a web server.
+func isRemote(name string) bool {
+	for _, scheme := range remoteSchemes {
+		if strings.HasPrefix(name, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// remotePath returns the path of the object the URL points to without the query, which tells whether it is
+// compressed. Other inputs are returned as is.
+func remotePath(name string) string {
+	if !isRemote(name) {
+		return name
+	}
+	u, err := url.Parse(name)
+	if err != nil {
+		return name
+	}
+	return u.Path
+}
+
+// openRemote starts downloading the object at the URL. The body is streamed as it is read.
+func openRemote(ctx context.Context, name string) (io.ReadCloser, error) {
+	u, err := url.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3":
+		return openS3(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "gs":
+		return openGCS(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+	}
+	return openHTTP(ctx, http.DefaultClient, name)
+}
+
+func openHTTP(ctx context.Context, client *http.Client, u string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// openS3 downloads an object from S3 with the credentials and region of the AWS SDK's environment variables and
+// shared config. AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL select an S3-compatible store such as MinIO.
+func openS3(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	config := aws.Config{}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{Config: config, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		region, err := s3manager.GetBucketRegion(ctx, sess, bucket, "us-east-1")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the region of bucket %s: %w", bucket, err)
+		}
+		sess.Config.Region = aws.String(region)
+	}
+	out, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// openGCS downloads an object from Google Cloud Storage with the application default credentials, or
+// anonymously if there are none, which works for public objects.
+func openGCS(ctx context.Context, bucket, object string) (io.ReadCloser, error) {
+	client, err := google.DefaultClient(ctx, gcsReadOnlyScope)
+	if err != nil {
+		slog.Warn("No Google Cloud credentials, reading anonymously", "url", "gs://"+bucket+"/"+object, "err", err)
+		client = http.DefaultClient
+	}
+	u := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media", url.PathEscape(bucket), url.PathEscape(object))
+	return openHTTP(ctx, client, u)
+}
+
+// redactURL hides the credentials and the query of remote inputs, e.g. the signature of a pre-signed URL, so they
+// don't leak into reports. Inputs labeled with name= are redacted after the label.
+func redactURL(name string) string {
+	if label, path := SplitInputLabel(name); label != "" {
+		return label + "=" + redactURL(path)
+	}
+	if !isRemote(name) {
+		return name
+	}
+	return redactCredentials(name)
+}
+
+// redactCredentials hides the user information and the query of a URL with any scheme or none.
+func redactCredentials(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+	return u.String()
+}
+
+// isURLFlag reports whether arg is the name of a flag taking a URL, e.g. -prometheus-url or --loki-url.
+func isURLFlag(arg string) bool {
+	return strings.HasPrefix(arg, "-") && strings.HasSuffix(arg, "-url")
+}
+
+// redactArgs redacts the command line arguments with redactURL, and the values of flags taking a URL with
+// redactCredentials, given either as -flag=value or as the next argument.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	urlValue := false
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case urlValue:
+			redacted[i] = redactCredentials(arg)
+		case strings.HasPrefix(arg, "-") && hasValue && isURLFlag(name):
+			redacted[i] = name + "=" + redactCredentials(value)
+		case strings.HasPrefix(arg, "-") && hasValue:
+			redacted[i] = name + "=" + redactURL(value)
+		default:
+			redacted[i] = redactURL(arg)
+		}
+		urlValue = !urlValue && !hasValue && isURLFlag(arg)
+	}
+	return redacted
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// ReplayResult compares the logged executions of a query with its re-executions.
+type ReplayResult struct {
+	ID        string               `json:"id"`
+	Query     string               `json:"query"`
+	RuleGroup string               `json:"ruleGroup,omitempty"`
+	Type      querystats.QueryType `json:"type"`
+	// Range and Step are those of the latest logged execution, which is replayed
+	Range float64 `json:"rangeSeconds,omitempty"`
+	Step  int     `json:"step,omitempty"`
+	// LoggedExecTotalTime and LoggedTotalQueryableSamples are the medians of the logged executions
+	LoggedExecTotalTime         float64 `json:"loggedExecTotalTime"`
+	LoggedTotalQueryableSamples float64 `json:"loggedTotalQueryableSamples"`
+	// ExecTotalTime and TotalQueryableSamples are the medians of the replays as reported by the server, or the wall
+	// time of the requests if it doesn't report statistics
+	ExecTotalTime         float64 `json:"execTotalTime"`
+	TotalQueryableSamples float64 `json:"totalQueryableSamples"`
+	WallTime              float64 `json:"wallTime"`
+	ServerStats           bool    `json:"serverStats"`
+	Runs                  int     `json:"runs"`
+	Errors                int     `json:"errors"`
+	// Error is the last failure of the replays
+	Error string `json:"error,omitempty"`
+}
+
+// replayOptions control how the queries are re-executed.
+type replayOptions struct {
+	runs        int
+	concurrency int
+	timeout     time.Duration
+	// now shifts the time range of a replay to end as long before now as the logged range ended before its entry
+	now bool
+}
+
+// replayRun is a re-execution of a query.
+type replayRun struct {
+	wall  time.Duration
+	stats *EvalStats
+	err   error
+}
+
+// Replay re-executes each query runs times with the parameters of its latest logged execution, with at most
+// concurrency requests in flight, and compares the median execution time and samples with the logged ones.
+func Replay(ctx context.Context, client *PromClient, queries []*querystats.Query, opts replayOptions) []*ReplayResult {
+	runs := make([][]replayRun, len(queries))
+	for i := range queries {
+		runs[i] = make([]replayRun, opts.runs)
+	}
+	jobs := make(chan [2]int)
+	var wg sync.WaitGroup
+	for range opts.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				runs[job[0]][job[1]] = replayQuery(ctx, client, latestExecution(queries[job[0]]), opts)
+			}
+		}()
+	}
+	for run := range opts.runs {
+		for i := range queries {
+			jobs <- [2]int{i, run}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	results := make([]*ReplayResult, 0, len(queries))
+	for i, q := range queries {
+		latest := latestExecution(q)
+		r := &ReplayResult{
+			ID:                          QueryID(q.Query),
+			Query:                       latest.Params.Query,
+			Type:                        latest.Type(),
+			Range:                       latest.Range().Seconds(),
+			Step:                        latest.Params.Step,
+			LoggedExecTotalTime:         queryPercentile(q, MetricExecTotalTime, 50),
+			LoggedTotalQueryableSamples: queryPercentile(q, MetricTotalQueryableSamples, 50),
+			Runs:                        opts.runs,
+		}
+		if rg := latest.RuleGroup; rg != nil {
+			r.RuleGroup = rg.Name
+		}
+		var wall, execTime, samples []float64
+		for _, run := range runs[i] {
+			if run.err != nil {
+				r.Errors++
+				r.Error = run.err.Error()
+				continue
+			}
+			wall = append(wall, run.wall.Seconds())
+			if run.stats != nil {
+				execTime = append(execTime, run.stats.Timings.ExecTotalTime)
+				samples = append(samples, float64(run.stats.Samples.TotalQueryableSamples))
+			}
+		}
+		r.WallTime, _ = querystats.Percentile(50, wall)
+		r.ExecTotalTime = r.WallTime
+		if len(execTime) > 0 && len(execTime) == len(wall) {
+			r.ServerStats = true
+			r.ExecTotalTime, _ = querystats.Percentile(50, execTime)
+			r.TotalQueryableSamples, _ = querystats.Percentile(50, samples)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// latestExecution returns the most recent logged execution of the query.
+func latestExecution(q *querystats.Query) *querystats.LogEntry {
+	latest := q.Logs[0]
+	for _, e := range q.Logs[1:] {
+		if e.TS.After(*latest.TS) {
+			latest = e
+		}
+	}
+	return latest
+}
+
+func replayQuery(ctx context.Context, client *PromClient, e *querystats.LogEntry, opts replayOptions) replayRun {
+	end := *e.TS
+	if e.Params.End != nil {
+		end = *e.Params.End
+	}
+	start := end.Add(-e.Range())
+	if opts.now {
+		shift := time.Since(*e.TS)
+		start, end = start.Add(shift), end.Add(shift)
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	began := time.Now()
+	stats, err := client.Query(ctx, e.Params.Query, start, end, e.Params.Step)
+	return replayRun{wall: time.Since(began), stats: stats, err: err}
+}
+
+// PrintReplay prints the logged and replayed median execution time and samples of each query.
+func PrintReplay(results []*ReplayResult, url string, opts replayOptions) {
+	at := "their logged time"
+	if opts.now {
+		at = "now"
+	}
+	fmt.Printf("Replayed %d queries %d times each against %s at %s, %d at a time:\n", len(results), opts.runs, escapeTerminal(url), at, opts.concurrency)
+	faster, slower := 0, 0
+	for i, r := range results {
+		switch {
+		case r.Errors == r.Runs:
+			fmt.Printf("%2d) %-7s exec=%.3fs->failed", i+1, r.Type, r.LoggedExecTotalTime)
+		case r.ServerStats:
+			fmt.Printf("%2d) %-7s exec=%.3fs->%.3fs %-8s", i+1, r.Type, r.LoggedExecTotalTime, r.ExecTotalTime, formatTrend(r.LoggedExecTotalTime, r.ExecTotalTime))
+			fmt.Printf(" samples=%.0f->%.0f %-8s", r.LoggedTotalQueryableSamples, r.TotalQueryableSamples,
+				formatTrend(r.LoggedTotalQueryableSamples, r.TotalQueryableSamples))
+		default:
+			// without statistics of the server, the wall time includes the network and the encoding of the result
+			fmt.Printf("%2d) %-7s exec=%.3fs->%.3fs %-8s wall time", i+1, r.Type, r.LoggedExecTotalTime, r.ExecTotalTime, formatTrend(r.LoggedExecTotalTime, r.ExecTotalTime))
+		}
+		// the id is that of the group of the replayed variant, which differ with -normalize
+		fmt.Printf(" %s %s", r.ID, escapeTerminal(r.Query))
+		printRuleGroupName(r.RuleGroup)
+		fmt.Println()
+		if r.Errors > 0 {
+			fmt.Printf("    %d of %d replays failed, the last: %s\n", r.Errors, r.Runs, escapeTerminal(r.Error))
+		}
+		if r.Errors < r.Runs {
+			if r.ExecTotalTime < r.LoggedExecTotalTime {
+				faster++
+			} else if r.ExecTotalTime > r.LoggedExecTotalTime {
+				slower++
+			}
+		}
+	}
+	fmt.Println()
+	fmt.Printf("%d queries got faster and %d slower than logged\n", faster, slower)
+}
+
+// runReplay implements the replay subcommand re-executing the top queries against a Prometheus server, e.g. to check
+// that an optimization helped.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var from, to timeFlag
+	fs.Var(&from, "from", "load log entries after this time. Accepts the same formats as -from of analyze")
+	fs.Var(&to, "to", "load log entries until this time")
+	promURL := fs.String("prometheus-url", "", "base URL of the Prometheus server to replay the queries against")
+	by := fs.String("by", "sum-exec", "the table of -report of analyze selecting the queries, e.g. p95-exec or max-samples")
+	top := fs.Int("n", 10, "number of queries to replay")
+	runs := fs.Int("runs", 3, "number of times each query is replayed. Medians of the runs are compared")
+	concurrency := fs.Int("concurrency", 1, "number of queries executed at the same time. Higher values load the server and skew latencies")
+	timeout := fs.Duration("timeout", 2*time.Minute, "timeout of each execution")
+	at := fs.String("at", "now", "when the queries are evaluated: now, shifting their time ranges to end as recently as logged, or logged to evaluate them over the same data, if still retained")
+	output := fs.String("o", "text", "output format: text or json")
+	normalize := fs.String("normalize", "", "comma-separated list of normalizations applied to queries before grouping: whitespace, case, matchers, fingerprint. The latest variant of each group is replayed")
+	match := fs.String("query-match", "", "replay only queries matching this regular expression")
+	queryID := fs.String("query-id", "", "comma-separated list of query ids, see the id column, to replay only")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay -prometheus-url URL [flags] [file...]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Re-executes the top queries with the range and step of their latest logged execution and compares the latencies")
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files. Reads stdin if none is given")
+		fs.PrintDefaults()
+	}
+	files := parseArgs(fs, args)
+	if *promURL == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *top <= 0 || *runs <= 0 || *concurrency <= 0 {
+		fatal("-n, -runs and -concurrency must be positive")
+	}
+	if *timeout <= 0 {
+		fatal("-timeout must be positive")
+	}
+	if *at != "now" && *at != "logged" {
+		fatal("-at must be now or logged")
+	}
+	if *output != "text" && *output != "json" {
+		fatal("-o must be text or json")
+	}
+	sections, err := ParseReportSections(*by, false)
+	if err != nil || len(sections) != 1 || sections[0].Percentile {
+		fatalf("Invalid -by value %q, must be a table of -report, e.g. avg-exec or max-samples", *by)
+	}
+	normalizer, err := querystats.ParseNormalizer(*normalize)
+	if err != nil {
+		fatalf("Invalid -normalize value: %s", err)
+	}
+	opts := querystats.LoadOptions{
+		From:            from.Time,
+		To:              to.Time,
+		Normalizer:      normalizer,
+		MaxEntrySize:    querystats.DefaultMaxEntrySize,
+		PercentileRanks: queryPercentileRanks,
+		Jobs:            *jobs,
+		Log:             logLoad,
+	}
+	if *match != "" {
+		if opts.QueryMatch, err = regexp.Compile(*match); err != nil {
+			fatalf("Invalid -query-match value: %s", err)
+		}
+	}
+	queryIDs, err := ParseQueryIDs(*queryID)
+	if err != nil {
+		fatalf("Invalid -query-id value: %s", err)
+	}
+	opts.QueryFilter = QueryIDFilter(queryIDs)
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if files, err = ExpandInputs(files); err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	defer closeInput()
+	queries, _, err := querystats.LoadQueriesFromLog(input, opts)
+	if err != nil {
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	if len(queries) == 0 {
+		fatal("Loaded 0 queries")
+	}
+
+	s := sections[0]
+	SortQueries(queries, s.Metric, s.Kind)
+	if s.Ascending {
+		slices.Reverse(queries)
+	}
+	queries = queries[:min(*top, len(queries))]
+	replayOpts := replayOptions{runs: *runs, concurrency: *concurrency, timeout: *timeout, now: *at == "now"}
+	slog.Info("Replaying queries", "queries", len(queries), "url", *promURL)
+	results := Replay(context.Background(), NewPromClient(*promURL), queries, replayOpts)
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(results); err != nil {
+			fatalf("Failed to write the JSON output: %s", err)
+		}
+		return
+	}
+	PrintReplay(results, *promURL, replayOpts)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// Report is the structured form of the analysis used by machine-readable output formats.
+type Report struct {
+	From              time.Time `json:"from"`
+	To                time.Time `json:"to"`
+	Entries           int       `json:"entries"`
+	DistinctQueries   int       `json:"distinctQueries"`
+	ZeroTimingEntries int       `json:"zeroTimingEntries"`
+	MalformedLines    int       `json:"malformedLines,omitempty"`
+	NoiseLines        int       `json:"noiseLines,omitempty"`
+	SampledOut        int       `json:"sampledOut,omitempty"`
+	// Partial is set if loading was interrupted, so the report covers only the entries read until then
+	Partial     bool               `json:"partial,omitempty"`
+	Run         *RunMetadata       `json:"run,omitempty"`
+	LogFormat   string             `json:"logFormat,omitempty"`
+	Percentiles []ReportPercentile `json:"percentiles"`
+	Summaries   []ReportSummary    `json:"summaries"`
+	QueryTypes  []QueryTypeStats   `json:"queryTypes"`
+	Instances   []InstanceStats    `json:"instances,omitempty"`
+	Tables      []ReportTable      `json:"tables"`
+	Queries     []*QueryStats      `json:"queries"`
+}
+
+type ReportPercentile struct {
+	Metric string  `json:"metric"`
+	Rank   int     `json:"rank"`
+	Value  float64 `json:"value"`
+}
+
+// ReportSummary is the distribution of a metric over all entries.
+type ReportSummary struct {
+	Metric string `json:"metric"`
+	querystats.Summary
+}
+
+type ReportTable struct {
+	Title  string           `json:"title"`
+	Metric string           `json:"metric"`
+	Kind   string           `json:"kind"`
+	Rows   []ReportTableRow `json:"rows"`
+}
+
+type ReportTableRow struct {
+	Query     string  `json:"query"`
+	RuleGroup string  `json:"ruleGroup,omitempty"`
+	Count     int     `json:"count"`
+	Value     float64 `json:"value"`
+	// TS is the time of the execution with the max value in max tables
+	TS *time.Time `json:"ts,omitempty"`
+	// PrometheusURL and GrafanaURL link to the query like those of QueryStats
+	PrometheusURL string `json:"prometheusUrl,omitempty"`
+	GrafanaURL    string `json:"grafanaUrl,omitempty"`
+}
+
+// QueryStats are the statistics of a single distinct query.
+type QueryStats struct {
+	// ID is the QueryID accepted by the compare subcommand
+	ID        string    `json:"id"`
+	Query     string    `json:"query"`
+	RuleGroup string    `json:"ruleGroup,omitempty"`
+	RuleFile  string    `json:"ruleFile,omitempty"`
+	RuleKind  RuleKind  `json:"ruleKind,omitempty"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// ExecutionsPerSecond and MeanInterval are how often the query was executed between FirstSeen and LastSeen.
+	// They are zero for queries executed once
+	ExecutionsPerSecond  float64 `json:"executionsPerSecond"`
+	MeanInterval         float64 `json:"meanIntervalSeconds"`
+	AvgExecTotalTime     float64 `json:"avgExecTotalTime"`
+	TimeWeightedExecTime float64 `json:"timeWeightedAvgExecTotalTime"`
+	// TimeWeightedExecutionsPerSecond and TimeWeightedExecTimePerSecond are the rates of executions and execution
+	// time while the query was executed, see -time-weight-bucket
+	TimeWeightedExecutionsPerSecond float64 `json:"timeWeightedExecutionsPerSecond"`
+	TimeWeightedExecTimePerSecond   float64 `json:"timeWeightedExecTotalTimePerSecond"`
+	MinExecTotalTime                float64 `json:"minExecTotalTime"`
+	MedianExecTotalTime             float64 `json:"medianExecTotalTime"`
+	StdDevExecTotalTime             float64 `json:"stddevExecTotalTime"`
+	MaxExecTotalTime                float64 `json:"maxExecTotalTime"`
+	PercentileExecTotalTime         float64 `json:"percentileExecTotalTime"`
+	// ExecTotalTimePercentiles and TotalQueryableSamplesPercentiles map the -query-percentiles ranks, e.g. "p99",
+	// to the percentiles over executions of the query
+	ExecTotalTimePercentiles         map[string]float64 `json:"execTotalTimePercentiles,omitempty"`
+	TotalQueryableSamplesPercentiles map[string]float64 `json:"totalQueryableSamplesPercentiles,omitempty"`
+	SumExecTotalTime                 float64            `json:"sumExecTotalTime"`
+	AvgTotalQueryableSamples         float64            `json:"avgTotalQueryableSamples"`
+	MinTotalQueryableSamples         int                `json:"minTotalQueryableSamples"`
+	MedianTotalQueryableSamples      int                `json:"medianTotalQueryableSamples"`
+	StdDevTotalQueryableSamples      float64            `json:"stddevTotalQueryableSamples"`
+	MaxTotalQueryableSamples         int                `json:"maxTotalQueryableSamples"`
+	SumTotalQueryableSamples         int                `json:"sumTotalQueryableSamples"`
+	AvgPeakSamples                   float64            `json:"avgPeakSamples"`
+	MaxPeakSamples                   int                `json:"maxPeakSamples"`
+	// SumPoints is the estimated number of evaluation points of all executions and PointsPerSecond
+	// the evaluation throughput
+	SumPoints       int      `json:"sumPoints"`
+	PointsPerSecond float64  `json:"pointsPerSecond"`
+	Errors          int      `json:"errors"`
+	Timeouts        int      `json:"timeouts"`
+	ErrorRate       float64  `json:"errorRate"`
+	Cost            *float64 `json:"cost,omitempty"`
+	// DominantPhase is the timing phase taking most of the execution time: queue, prep, eval or sort
+	DominantPhase string `json:"dominantPhase,omitempty"`
+	// Instances counts the executions on each Prometheus server of labeled inputs
+	Instances map[string]int `json:"instances,omitempty"`
+	// PrometheusURL links to the Prometheus UI at the time of the worst execution
+	PrometheusURL string `json:"prometheusUrl,omitempty"`
+	// GrafanaURL links to Grafana Explore over the hour before the worst execution
+	GrafanaURL string `json:"grafanaUrl,omitempty"`
+	// Examples are raw entries sampled with -sample-entries
+	Examples []*querystats.LogEntry `json:"examples,omitempty"`
+}
+
+// NewQueryStats computes the statistics of the query. perc is the rank of the reported percentile.
+func NewQueryStats(q *querystats.Query, perc int, links QueryLinks) *QueryStats {
+	s := &QueryStats{
+		ID:                       QueryID(q.Query),
+		Query:                    q.Query,
+		Count:                    len(q.Logs),
+		FirstSeen:                *q.Logs[0].TS,
+		LastSeen:                 *q.Logs[0].TS,
+		AvgExecTotalTime:         q.AvgExecTotalTime,
+		MaxExecTotalTime:         q.MaxExecTotalTimeEntry.Stats.Timings.ExecTotalTime,
+		SumExecTotalTime:         q.SumExecTotalTime,
+		AvgTotalQueryableSamples: q.AvgTotalQueryableSamples,
+		MaxTotalQueryableSamples: q.MaxTotalQueryableSamplesEntry.Stats.Samples.TotalQueryableSamples,
+		SumTotalQueryableSamples: q.SumTotalQueryableSamples,
+		AvgPeakSamples:           q.AvgPeakSamples,
+		MaxPeakSamples:           q.MaxPeakSamplesEntry.Stats.Samples.PeakSamples,
+	}
+	if rg := q.Logs[0].RuleGroup; rg != nil {
+		s.RuleGroup, s.RuleFile = rg.Name, rg.File
+		s.RuleKind = ruleIndex.Classify(q.Logs[0])
+	}
+	for _, log := range q.Logs {
+		if log.TS.Before(s.FirstSeen) {
+			s.FirstSeen = *log.TS
+		}
+		if log.TS.After(s.LastSeen) {
+			s.LastSeen = *log.TS
+		}
+	}
+	if perSecond, meanInterval, ok := QueryRate(q); ok {
+		s.ExecutionsPerSecond, s.MeanInterval = perSecond, meanInterval.Seconds()
+	}
+	s.TimeWeightedExecTime = TimeWeightedAvg(q, MetricExecTotalTime, timeWeightBucket)
+	s.TimeWeightedExecutionsPerSecond = TimeWeightedRate(q, timeWeightBucket)
+	s.TimeWeightedExecTimePerSecond = TimeWeightedMetricRate(q, MetricExecTotalTime, timeWeightBucket)
+	execTime := querystats.Summarize(MetricExecTotalTime.Values(q))
+	s.MinExecTotalTime, s.MedianExecTotalTime, s.StdDevExecTotalTime = execTime.Min, execTime.Median, execTime.StdDev
+	samples := querystats.Summarize(MetricTotalQueryableSamples.Values(q))
+	s.MinTotalQueryableSamples, s.MedianTotalQueryableSamples, s.StdDevTotalQueryableSamples = int(samples.Min), int(samples.Median), samples.StdDev
+	s.PercentileExecTotalTime = queryPercentile(q, MetricExecTotalTime, perc)
+	if len(queryPercentileRanks) > 0 {
+		s.ExecTotalTimePercentiles = make(map[string]float64, len(queryPercentileRanks))
+		s.TotalQueryableSamplesPercentiles = make(map[string]float64, len(queryPercentileRanks))
+		for _, p := range queryPercentileRanks {
+			name := PercentileTable(p).Name()
+			s.ExecTotalTimePercentiles[name] = queryPercentile(q, MetricExecTotalTime, p)
+			s.TotalQueryableSamplesPercentiles[name] = queryPercentile(q, MetricTotalQueryableSamples, p)
+		}
+	}
+	for _, log := range q.Logs {
+		s.SumPoints += log.Points()
+	}
+	s.PointsPerSecond, _ = q.PointsPerSecond()
+	s.Errors, s.Timeouts = q.Failures(timeoutProxy)
+	s.ErrorRate = float64(s.Errors+s.Timeouts) / float64(len(q.Logs))
+	if costModel.Enabled() {
+		cost := costModel.QueryCost(q)
+		s.Cost = &cost
+	}
+	s.DominantPhase = dominantPhaseName(q)
+	s.Instances = queryInstances(q)
+	s.PrometheusURL = links.Prometheus(q)
+	s.GrafanaURL = links.Grafana(q)
+	s.Examples = SampleEntries(q, sampleSize, sampleSeed)
+	return s
+}
+
+func newReportTable(queries []*querystats.Query, top int, metric Metric, kind TableKind, links QueryLinks) ReportTable {
+	t := ReportTable{
+		Title:  tableTitle(metric, kind),
+		Metric: metric.Name,
+		Kind:   kind.Name(),
+		Rows:   []ReportTableRow{},
+	}
+	SortQueries(queries, metric, kind)
+	for _, q := range queries[:min(top, len(queries))] {
+		row := ReportTableRow{Query: q.Query, Count: len(q.Logs), Value: Aggregate(q, metric, kind),
+			PrometheusURL: links.Prometheus(q), GrafanaURL: links.Grafana(q)}
+		if q.Logs[0].RuleGroup != nil {
+			row.RuleGroup = q.Logs[0].RuleGroup.Name
+		}
+		if kind == TableMax {
+			row.TS = metric.MaxEntry(q).TS
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+// BuildReport assembles the report of the loaded queries. logs must be sorted by time. Percentiles of all ranks are
+// computed over all entries, the first rank is also the percentile reported per query.
+func BuildReport(queries []*querystats.Query, logs querystats.LogEntries, loadStats querystats.LoadStats, top int, ranks []int, links QueryLinks) (*Report, error) {
+	perc := ranks[0]
+	r := &Report{
+		From:              *logs[0].TS,
+		To:                *logs[len(logs)-1].TS,
+		Entries:           len(logs),
+		DistinctQueries:   len(queries),
+		ZeroTimingEntries: loadStats.ZeroTimings,
+		MalformedLines:    loadStats.MalformedLines,
+		NoiseLines:        loadStats.NoiseLines,
+		SampledOut:        loadStats.SampledOut,
+		Partial:           loadStats.Interrupted,
+	}
+	metrics := []Metric{MetricExecTotalTime, MetricTotalQueryableSamples, MetricPeakSamples}
+	for _, m := range metrics {
+		summary, percentiles, err := metricSummary(ranks, logs, m)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ranks {
+			r.Percentiles = append(r.Percentiles, ReportPercentile{m.Name, p, percentiles[p]})
+		}
+		r.Summaries = append(r.Summaries, ReportSummary{m.Name, summary})
+	}
+	types, err := QueryTypeBreakdown(logs, ranks)
+	if err != nil {
+		return nil, err
+	}
+	r.QueryTypes = types
+	if r.Instances, err = InstanceBreakdown(logs, ranks); err != nil {
+		return nil, err
+	}
+
+	sorted := slices.Clone(queries)
+	for _, m := range metrics {
+		r.Tables = append(r.Tables, newReportTable(sorted, top, m, TableAvg, links), newReportTable(sorted, top, m, TableMax, links))
+	}
+	for _, m := range metrics[:2] {
+		r.Tables = append(r.Tables, newReportTable(sorted, top, m, TableSum, links))
+		for _, p := range queryPercentileRanks {
+			r.Tables = append(r.Tables, newReportTable(sorted, top, m, PercentileTable(p), links))
+		}
+	}
+	if costModel.Enabled() {
+		r.Tables = append(r.Tables, newReportTable(sorted, top, costMetric(), TableSum, links))
+	}
+
+	SortQueries(sorted, MetricExecTotalTime, TableSum)
+	for _, q := range sorted {
+		r.Queries = append(r.Queries, NewQueryStats(q, perc, links))
+	}
+	return r, nil
+}
+
+func WriteJSONReport(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+)
+
+func readReportFile(name string) (*Report, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var r Report
+	if err := json.NewDecoder(file).Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func printQueryStatsRow(i int, s *QueryStats) {
+	fmt.Printf("%2d) n=%-6d total=%.3fs avg=%.3fs %s", i+1, s.Count, s.SumExecTotalTime, s.AvgExecTotalTime, queryWithID(s.Query))
+	printRuleGroupName(s.RuleGroup)
+	fmt.Println()
+}
+
+// PrintReportDiff prints queries added and removed between two JSON reports, the queries
+// whose total execution time changed the most and those whose average execution time or samples
+// grew by more than the regression ratio, e.g. 0.2 for 20%.
+func PrintReportDiff(a, b *Report, top int, regression float64) {
+	fmt.Printf("Old: %d entries from [%s] to [%s]\n", a.Entries, formatTime(a.From), formatTime(a.To))
+	fmt.Printf("New: %d entries from [%s] to [%s]\n", b.Entries, formatTime(b.From), formatTime(b.To))
+
+	fmt.Println()
+	for _, pb := range b.Percentiles {
+		for _, pa := range a.Percentiles {
+			if pa.Metric == pb.Metric && pa.Rank == pb.Rank {
+				fmt.Printf("p%d %s: %.3f -> %.3f %s\n", pb.Rank, pb.Metric, pa.Value, pb.Value, formatTrend(pa.Value, pb.Value))
+			}
+		}
+	}
+
+	old := make(map[string]*QueryStats, len(a.Queries))
+	for _, s := range a.Queries {
+		old[reportKey(s.Query, s.RuleGroup)] = s
+	}
+	var added []*QueryStats
+	type change struct{ a, b *QueryStats }
+	var changed []change
+	for _, s := range b.Queries {
+		key := reportKey(s.Query, s.RuleGroup)
+		if prev, ok := old[key]; ok {
+			changed = append(changed, change{prev, s})
+			delete(old, key)
+		} else {
+			added = append(added, s)
+		}
+	}
+	removed := make([]*QueryStats, 0, len(old))
+	for _, s := range old {
+		removed = append(removed, s)
+	}
+	byTotal := func(queries []*QueryStats) {
+		sort.Slice(queries, func(i, j int) bool { return queries[i].SumExecTotalTime > queries[j].SumExecTotalTime })
+	}
+	byTotal(added)
+	byTotal(removed)
+	sort.Slice(changed, func(i, j int) bool {
+		return math.Abs(changed[i].b.SumExecTotalTime-changed[i].a.SumExecTotalTime) >
+			math.Abs(changed[j].b.SumExecTotalTime-changed[j].a.SumExecTotalTime)
+	})
+
+	fmt.Println()
+	fmt.Printf("%d queries added, top %d by total execution time:\n", len(added), min(top, len(added)))
+	for i, s := range added[:min(top, len(added))] {
+		printQueryStatsRow(i, s)
+	}
+	fmt.Println()
+	fmt.Printf("%d queries removed, top %d by total execution time:\n", len(removed), min(top, len(removed)))
+	for i, s := range removed[:min(top, len(removed))] {
+		printQueryStatsRow(i, s)
+	}
+	fmt.Println()
+	fmt.Printf("Top %d of %d common queries by change of total execution time:\n", min(top, len(changed)), len(changed))
+	for i, c := range changed[:min(top, len(changed))] {
+		fmt.Printf("%2d) n=%d->%d total=%.3fs->%.3fs %s avg=%.3fs->%.3fs %s samples=%d->%d %s %s",
+			i+1, c.a.Count, c.b.Count,
+			c.a.SumExecTotalTime, c.b.SumExecTotalTime, formatTrend(c.a.SumExecTotalTime, c.b.SumExecTotalTime),
+			c.a.AvgExecTotalTime, c.b.AvgExecTotalTime, formatTrend(c.a.AvgExecTotalTime, c.b.AvgExecTotalTime),
+			c.a.SumTotalQueryableSamples, c.b.SumTotalQueryableSamples,
+			formatTrend(float64(c.a.SumTotalQueryableSamples), float64(c.b.SumTotalQueryableSamples)),
+			queryWithID(c.b.Query))
+		printRuleGroupName(c.b.RuleGroup)
+		fmt.Println()
+	}
+
+	regressed := make([]change, 0, len(changed))
+	growth := func(c change) float64 {
+		return max(ratio(c.a.AvgExecTotalTime, c.b.AvgExecTotalTime), ratio(c.a.AvgTotalQueryableSamples, c.b.AvgTotalQueryableSamples))
+	}
+	for _, c := range changed {
+		if growth(c) > 1+regression {
+			regressed = append(regressed, c)
+		}
+	}
+	sort.SliceStable(regressed, func(i, j int) bool { return growth(regressed[i]) > growth(regressed[j]) })
+	fmt.Println()
+	fmt.Printf("%d queries regressed by more than %.0f%% in average execution time or samples, top %d:\n",
+		len(regressed), regression*100, min(top, len(regressed)))
+	for i, c := range regressed[:min(top, len(regressed))] {
+		fmt.Printf("%2d) avg=%.3fs->%.3fs %s avg_samples=%.0f->%.0f %s %s", i+1,
+			c.a.AvgExecTotalTime, c.b.AvgExecTotalTime, formatTrend(c.a.AvgExecTotalTime, c.b.AvgExecTotalTime),
+			c.a.AvgTotalQueryableSamples, c.b.AvgTotalQueryableSamples, formatTrend(c.a.AvgTotalQueryableSamples, c.b.AvgTotalQueryableSamples),
+			queryWithID(c.b.Query))
+		printRuleGroupName(c.b.RuleGroup)
+		fmt.Println()
+	}
+}
+
+// ratio returns b/a. Growth from zero is infinite.
+func ratio(a, b float64) float64 {
+	if a == 0 {
+		if b == 0 {
+			return 1
+		}
+		return math.Inf(1)
+	}
+	return b / a
+}
+
+// runReportDiff implements the report-diff subcommand comparing two reports written with -o json,
+// which works after the raw logs are gone.
+func runReportDiff(args []string) {
+	fs := flag.NewFlagSet("report-diff", flag.ExitOnError)
+	top := fs.Int("top", 10, "number of queries to display in each section")
+	regression := fs.Float64("regression", 0.2, "report queries whose average execution time or samples grew by more than this ratio")
+	queryID := fs.String("query-id", "", "comma-separated list of query ids, see the id column, to compare only the queries of")
+	fs.Var(locationFlag{}, "tz", tzUsage)
+	out := addOutputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s report-diff [flags] old.json new.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a, err := readReportFile(fs.Arg(0))
+	if err != nil {
+		fatalf("Failed to read report %s: %s", fs.Arg(0), err)
+	}
+	b, err := readReportFile(fs.Arg(1))
+	if err != nil {
+		fatalf("Failed to read report %s: %s", fs.Arg(1), err)
+	}
+	queryIDs, err := ParseQueryIDs(*queryID)
+	if err != nil {
+		fatalf("Invalid -query-id value: %s", err)
+	}
+	if filter := QueryIDFilter(queryIDs); filter != nil {
+		for _, r := range []*Report{a, b} {
+			r.Queries = slices.DeleteFunc(r.Queries, func(s *QueryStats) bool { return !filter(s.Query) })
+		}
+	}
+	defer startOutput(*out)()
+	PrintReportDiff(a, b, *top, *regression)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/common/model"
+)
+
+// retention is the --storage.tsdb.retention.time of the server, 0 if unknown.
+var retention model.Duration
+
+func init() {
+	flag.Var(&retention, "retention", "retention of the server, e.g. 15d as set with --storage.tsdb.retention.time. Flags range queries starting before the retention window at the time they were executed, as the server spends effort on them only to return partial data")
+}
+
+// RetentionMiss is a query executed as range queries starting before the retention window.
+type RetentionMiss struct {
+	Query *querystats.Query
+	// Executions is the number of executions starting before the retention window, which took ExecTime seconds and
+	// loaded Samples samples
+	Executions int
+	ExecTime   float64
+	Samples    int
+	// Beyond is how long before the retention window the earliest of them started
+	Beyond time.Duration
+}
+
+// FindRetentionMisses returns the queries with range executions starting more than retention before they were
+// executed, ordered by the execution time spent on such executions.
+func FindRetentionMisses(queries []*querystats.Query, retention time.Duration) []RetentionMiss {
+	var misses []RetentionMiss
+	for _, q := range queries {
+		miss := RetentionMiss{Query: q}
+		for _, log := range q.Logs {
+			if log.Type() != querystats.QueryTypeRange || log.TS == nil {
+				continue
+			}
+			beyond := log.TS.Add(-retention).Sub(*log.Params.Start)
+			if beyond <= 0 {
+				continue
+			}
+			miss.Executions++
+			miss.ExecTime += log.Stats.Timings.ExecTotalTime
+			miss.Samples += log.Stats.Samples.TotalQueryableSamples
+			miss.Beyond = max(miss.Beyond, beyond)
+		}
+		if miss.Executions > 0 {
+			misses = append(misses, miss)
+		}
+	}
+	sort.SliceStable(misses, func(i, j int) bool { return misses[i].ExecTime > misses[j].ExecTime })
+	return misses
+}
+
+// PrintRetentionMisses prints the queries starting before the retention window with the time and, if a cost model is
+// set, the cost spent on them. Their share is of the execution time of all range entries.
+func PrintRetentionMisses(misses []RetentionMiss, top int, retention time.Duration, rangeLogs querystats.LogEntries) {
+	if len(misses) == 0 {
+		fmt.Printf("No range queries start before the retention of %s\n", model.Duration(retention))
+		return
+	}
+	var total, wasted float64
+	executions := 0
+	for _, log := range rangeLogs {
+		total += log.Stats.Timings.ExecTotalTime
+	}
+	for _, m := range misses {
+		wasted += m.ExecTime
+		executions += m.Executions
+	}
+	fmt.Printf("Top %d of %d queries starting before the retention of %s in %d of %d range entries, returning partial data in %.3fs of execution time:\n",
+		min(top, len(misses)), len(misses), model.Duration(retention), executions, len(rangeLogs), wasted)
+	for i, m := range misses[:min(top, len(misses))] {
+		share := 0.0
+		if total > 0 {
+			share = m.ExecTime / total
+		}
+		fmt.Printf("%2d) n=%-6d exec=%.3fs share=%5.1f%% beyond=%-8s", i+1, m.Executions, m.ExecTime, 100*share, model.Duration(m.Beyond.Round(time.Second)))
+		if costModel.Enabled() {
+			fmt.Printf(" cost=%.4f", costModel.Cost(m.ExecTime, m.Samples))
+		}
+		fmt.Printf(" %s", queryWithID(m.Query.Query))
+		printRuleName(m.Query)
+		fmt.Println()
+	}
+}
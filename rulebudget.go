@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var (
+	evaluationInterval time.Duration
+	ruleBudget         float64
+)
+
+func init() {
+	flag.DurationVar(&evaluationInterval, "evaluation-interval", time.Minute, "the global evaluation_interval of the Prometheus server, the interval of rule groups of -rules-dir that don't set one")
+	flag.Float64Var(&ruleBudget, "rule-budget", 0.5, "share of its evaluation interval a rule group may take on average before it is flagged at risk of missed evaluations. Groups that overran their interval are always flagged")
+}
+
+// RuleGroupBudget is how much of its evaluation interval a rule group consumes. The rules of a group are evaluated
+// one after another, so an evaluation takes the sum of the execution times of its rules, and Prometheus skips the
+// next evaluation while one still runs.
+type RuleGroupBudget struct {
+	Name string
+	File string
+	// Interval is read from -rules-dir if the group is found there, otherwise it is estimated as the median gap
+	// between evaluations in the log
+	Interval          time.Duration
+	IntervalFromRules bool
+	Evaluations       int
+	// AvgEvalTime and MaxEvalTime are the average and the longest evaluation of the group in seconds
+	AvgEvalTime float64
+	MaxEvalTime float64
+	// Missed is the number of evaluations estimated missing from gaps longer than the interval
+	Missed int
+}
+
+// AvgShare is the share of the interval an evaluation takes on average.
+func (b *RuleGroupBudget) AvgShare() float64 {
+	return b.AvgEvalTime / b.Interval.Seconds()
+}
+
+// MaxShare is the share of the interval the longest evaluation took.
+func (b *RuleGroupBudget) MaxShare() float64 {
+	return b.MaxEvalTime / b.Interval.Seconds()
+}
+
+// AtRisk reports whether the group takes more than the budget on average or overran its interval, which risks
+// missed evaluations. Evaluations already missed are told by Missed, since gaps also come from restarts or an
+// overloaded server rather than the group itself.
+func (b *RuleGroupBudget) AtRisk(budget float64) bool {
+	return b.AvgShare() > budget || b.MaxShare() >= 1
+}
+
+// RuleGroupBudgets computes the evaluation budget of each rule group, ordered by average share of the interval.
+// Entries of a group are split into evaluations at the first rule evaluated again. Groups evaluated fewer than
+// twice whose interval isn't known from the rule files are left out.
+func RuleGroupBudgets(queries []*querystats.Query, intervals RuleGroupIntervals) []*RuleGroupBudget {
+	type key struct{ name, file string }
+	entries := make(map[key]querystats.LogEntries)
+	for _, q := range queries {
+		if rg := q.Logs[0].RuleGroup; rg != nil {
+			k := key{rg.Name, rg.File}
+			entries[k] = append(entries[k], q.Logs...)
+		}
+	}
+
+	var budgets []*RuleGroupBudget
+	for k, logs := range entries {
+		slices.SortFunc(logs, func(a, b *querystats.LogEntry) int {
+			if c := a.TS.Compare(*b.TS); c != 0 {
+				return c
+			}
+			return strings.Compare(a.Params.Query, b.Params.Query)
+		})
+		var starts []time.Time
+		var evalTimes []float64
+		seen := make(map[string]bool)
+		for _, e := range logs {
+			if len(starts) == 0 || seen[e.Params.Query] {
+				starts = append(starts, *e.TS)
+				evalTimes = append(evalTimes, 0)
+				clear(seen)
+			}
+			seen[e.Params.Query] = true
+			evalTimes[len(evalTimes)-1] += e.Stats.Timings.ExecTotalTime
+		}
+		gaps := make([]time.Duration, 0, len(starts))
+		for i := 1; i < len(starts); i++ {
+			gaps = append(gaps, starts[i].Sub(starts[i-1]))
+		}
+
+		b := &RuleGroupBudget{Name: k.name, File: k.file, Evaluations: len(starts)}
+		if interval, ok := intervals[k.name]; ok {
+			b.Interval, b.IntervalFromRules = interval, true
+			if interval == 0 {
+				b.Interval = evaluationInterval
+			}
+		} else if len(gaps) > 0 {
+			slices.Sort(gaps)
+			b.Interval = gaps[len(gaps)/2]
+		}
+		if b.Interval <= 0 {
+			continue
+		}
+		for _, gap := range gaps {
+			// an evaluation is missed if the next one starts more than half an interval late
+			if missed := int(math.Round(gap.Seconds()/b.Interval.Seconds())) - 1; missed > 0 {
+				b.Missed += missed
+			}
+		}
+		b.AvgEvalTime = querystats.AvgWith(summation, evalTimes)
+		b.MaxEvalTime = slices.Max(evalTimes)
+		budgets = append(budgets, b)
+	}
+	sort.Slice(budgets, func(i, j int) bool {
+		if budgets[i].AvgShare() != budgets[j].AvgShare() {
+			return budgets[i].AvgShare() > budgets[j].AvgShare()
+		}
+		return budgets[i].Name < budgets[j].Name
+	})
+	return budgets
+}
+
+// PrintRuleGroupBudgets prints the first top rule groups by the share of their evaluation interval they consume.
+// Groups at risk of missed evaluations are marked with "!", and groups that missed evaluations otherwise with "?".
+func PrintRuleGroupBudgets(budgets []*RuleGroupBudget, top int, budget float64) {
+	atRisk, missing := 0, 0
+	for _, b := range budgets {
+		if b.AtRisk(budget) {
+			atRisk++
+		}
+		if b.Missed > 0 {
+			missing++
+		}
+	}
+	top = min(top, len(budgets))
+	fmt.Printf("Top %d rule groups by share of the evaluation interval (%d at risk of missed evaluations, budget %.0f%%, %d missed evaluations):\n", top, atRisk, 100*budget, missing)
+	for i, b := range budgets[:top] {
+		flag := " "
+		if b.AtRisk(budget) {
+			flag = "!"
+		} else if b.Missed > 0 {
+			flag = "?"
+		}
+		source := "estimated"
+		if b.IntervalFromRules {
+			source = "rules"
+		}
+		fmt.Printf("%2d)%s avg=%5.1f%% max=%5.1f%% interval=%s (%s) evals=%-6d avg_eval=%.3fs max_eval=%.3fs missed=%-4d ruleName=\"%s\" file=\"%s\"\n",
+			i+1, flag, 100*b.AvgShare(), 100*b.MaxShare(), b.Interval, source, b.Evaluations, b.AvgEvalTime, b.MaxEvalTime,
+			b.Missed, escapeTerminal(b.Name), escapeTerminal(b.File))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// RuleGroupStats aggregates all executions of the queries of a rule group.
+type RuleGroupStats struct {
+	Name        string
+	File        string
+	Executions  int
+	Expressions int
+	// Alerting is the number of expressions of alerting rules
+	Alerting int
+	ExecTime float64
+	Samples  int
+}
+
+// GroupByRuleGroup aggregates queries by their rule group, ordered by total execution time.
+// Queries without a rule group, i.e. from the HTTP API, are aggregated in a group with an empty name.
+func GroupByRuleGroup(queries []*querystats.Query) []*RuleGroupStats {
+	type key struct{ name, file string }
+	groups := make(map[key]*RuleGroupStats)
+	for _, q := range queries {
+		var k key
+		if rg := q.Logs[0].RuleGroup; rg != nil {
+			k = key{rg.Name, rg.File}
+		}
+		g := groups[k]
+		if g == nil {
+			g = &RuleGroupStats{Name: k.name, File: k.file}
+			groups[k] = g
+		}
+		g.Executions += len(q.Logs)
+		g.Expressions++
+		if ruleIndex.Classify(q.Logs[0]) == RuleKindAlerting {
+			g.Alerting++
+		}
+		g.ExecTime += q.SumExecTotalTime
+		g.Samples += q.SumTotalQueryableSamples
+	}
+
+	result := make([]*RuleGroupStats, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ExecTime != result[j].ExecTime {
+			return result[i].ExecTime > result[j].ExecTime
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// PrintRuleGroups prints the first top rule groups by total execution time.
+func PrintRuleGroups(groups []*RuleGroupStats, top int) {
+	top = min(top, len(groups))
+	fmt.Printf("Top %d rule groups by total evaluation time:\n", top)
+	for i, g := range groups[:top] {
+		name := fmt.Sprintf("ruleName=\"%s\" file=\"%s\"", escapeTerminal(g.Name), escapeTerminal(g.File))
+		if g.Name == "" && g.File == "" {
+			name = "<queries from the HTTP API>"
+		}
+		fmt.Printf("%2d) n=%-6d exprs=%-4d alerting=%-4d total=%.3fs avg=%.3fs samples=%-12d %s\n",
+			i+1, g.Executions, g.Expressions, g.Alerting, g.ExecTime, g.ExecTime/float64(g.Executions), g.Samples, name)
+	}
+}
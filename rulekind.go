@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleKind tells alerting and recording rules apart. It is empty for queries from the HTTP API.
+type RuleKind string
+
+const (
+	RuleKindAlerting  RuleKind = "alerting"
+	RuleKindRecording RuleKind = "recording"
+)
+
+// RuleIndex maps rule groups and expressions found in rule files to the kind of the rule.
+type RuleIndex map[string]RuleKind
+
+// ruleIndex is loaded from -rules-dir. Without it rules are classified by heuristics only.
+var ruleIndex RuleIndex
+
+// RuleGroupIntervals maps the names of rule groups found in rule files to their evaluation interval, or 0 if the
+// group uses the global evaluation interval.
+type RuleGroupIntervals map[string]time.Duration
+
+// ruleGroupIntervals is loaded from -rules-dir. Without it the intervals of rule groups are estimated from the log.
+var ruleGroupIntervals RuleGroupIntervals
+
+func ruleIndexKey(group, expr string) string {
+	// Prometheus logs the expression as printed by its parser, so both sides are normalized by it
+	if e, err := parser.ParseExpr(expr); err == nil {
+		expr = e.String()
+	}
+	return group + "\x00" + expr
+}
+
+type ruleFile struct {
+	Groups []struct {
+		Name     string `yaml:"name"`
+		Interval string `yaml:"interval"`
+		Rules    []struct {
+			Record string `yaml:"record"`
+			Alert  string `yaml:"alert"`
+			Expr   string `yaml:"expr"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// LoadRulesDir indexes the rules and the intervals of the rule groups of all .yml and .yaml files in the directory
+// and its subdirectories.
+func LoadRulesDir(dir string) (RuleIndex, RuleGroupIntervals, error) {
+	index := make(RuleIndex)
+	intervals := make(RuleGroupIntervals)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(path); ext != ".yml" && ext != ".yaml" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var rf ruleFile
+		if err := yaml.Unmarshal(data, &rf); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, g := range rf.Groups {
+			var interval model.Duration
+			if g.Interval != "" {
+				if interval, err = model.ParseDuration(g.Interval); err != nil {
+					return fmt.Errorf("%s: interval of group %q: %w", path, g.Name, err)
+				}
+			}
+			intervals[g.Name] = time.Duration(interval)
+			for _, r := range g.Rules {
+				kind := RuleKindRecording
+				if r.Alert != "" {
+					kind = RuleKindAlerting
+				}
+				index[ruleIndexKey(g.Name, r.Expr)] = kind
+			}
+		}
+		return nil
+	})
+	return index, intervals, err
+}
+
+// Classify returns the kind of the rule the entry was evaluated for. Rules missing from the index are
+// classified by the name of their group, e.g. "kube.alerts" or "node.recording", and then by the shape of
+// the query: alerting rules usually end with a comparison filtering the series that fire.
+func (idx RuleIndex) Classify(entry *querystats.LogEntry) RuleKind {
+	if entry.RuleGroup == nil {
+		return ""
+	}
+	if kind, ok := idx[ruleIndexKey(entry.RuleGroup.Name, entry.Params.Query)]; ok {
+		return kind
+	}
+	name := strings.ToLower(entry.RuleGroup.Name)
+	switch {
+	case strings.Contains(name, "alert"):
+		return RuleKindAlerting
+	case strings.Contains(name, "record"):
+		return RuleKindRecording
+	}
+	expr, err := parser.ParseExpr(entry.Params.Query)
+	if err != nil {
+		return RuleKindRecording
+	}
+	for {
+		paren, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.Expr
+	}
+	if b, ok := expr.(*parser.BinaryExpr); ok && b.Op.IsComparisonOperator() && !b.ReturnBool {
+		return RuleKindAlerting
+	}
+	return RuleKindRecording
+}
+
+// PrintRuleKinds prints the load of alerting rules, recording rules and queries from the HTTP API.
+func PrintRuleKinds(queries []*querystats.Query) {
+	type kindStats struct {
+		kind              RuleKind
+		executions, exprs int
+		execTime, cost    float64
+		samples           int
+	}
+	stats := make(map[RuleKind]*kindStats)
+	for _, q := range queries {
+		kind := ruleIndex.Classify(q.Logs[0])
+		s := stats[kind]
+		if s == nil {
+			s = &kindStats{kind: kind}
+			stats[kind] = s
+		}
+		s.executions += len(q.Logs)
+		s.exprs++
+		s.execTime += q.SumExecTotalTime
+		s.samples += q.SumTotalQueryableSamples
+		if costModel.Enabled() {
+			s.cost += costModel.QueryCost(q)
+		}
+	}
+	result := make([]*kindStats, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].execTime > result[j].execTime })
+
+	fmt.Println("Load by query origin:")
+	for i, s := range result {
+		origin := string(s.kind) + " rules"
+		if s.kind == "" {
+			origin = "HTTP API"
+		}
+		fmt.Printf("%2d) n=%-7d exprs=%-5d total=%.3fs samples=%-12d", i+1, s.executions, s.exprs, s.execTime, s.samples)
+		if costModel.Enabled() {
+			fmt.Printf(" cost=%-10.2f", s.cost)
+		}
+		fmt.Printf(" %s\n", origin)
+	}
+}
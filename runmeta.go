@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+var embedRunMetadata bool
+
+func init() {
+	flag.BoolVar(&embedRunMetadata, "run-metadata", true, "embed the run metadata (version, flags, input digests and time generated) in the output: a header of the text report and of csv and tsv as '#' comment lines, a field of json and artifact, the footer of html and the schema metadata of arrow")
+}
+
+// RunMetadata describes how an output was produced, so an archived report can be traced back to its inputs
+// and re-run identically.
+type RunMetadata struct {
+	Version   string    `json:"version"`
+	Generated time.Time `json:"generated"`
+	// Args are the command line arguments
+	Args []string `json:"args"`
+	// Flags are the values of the flags that were set, with relative times such as -6h resolved
+	Flags  map[string]string `json:"flags,omitempty"`
+	Inputs []InputDigest     `json:"inputs,omitempty"`
+}
+
+// InputDigest identifies an input by the SHA-256 digest of its bytes as read, before decompression.
+type InputDigest struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// toolVersion returns the module version, or the VCS revision for local builds.
+func toolVersion() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := buildInfo.Main.Version
+	// pseudo-versions already contain the revision, local builds don't
+	if version == "" || version == "(devel)" {
+		for _, s := range buildInfo.Settings {
+			if s.Key == "vcs.revision" {
+				version += " " + s.Value
+			}
+		}
+	}
+	return version
+}
+
+// NewRunMetadata describes the current run of a subcommand parsed by fs. The inputs must be read completely,
+// so their digests are final.
+func NewRunMetadata(fs *flag.FlagSet) *RunMetadata {
+	if !embedRunMetadata {
+		return nil
+	}
+	m := &RunMetadata{
+		Version:   toolVersion(),
+		Generated: now.UTC(),
+		Flags:     make(map[string]string),
+	}
+	m.Args = redactArgs(os.Args[1:])
+	fs.Visit(func(f *flag.Flag) {
+		if isURLFlag("-" + f.Name) {
+			m.Flags[f.Name] = redactCredentials(f.Value.String())
+		} else {
+			m.Flags[f.Name] = redactURL(f.Value.String())
+		}
+	})
+	for _, d := range openedInputs {
+		m.Inputs = append(m.Inputs, InputDigest{Name: redactURL(d.name), Size: d.size.Load(), SHA256: hex.EncodeToString(d.hash.Sum(nil))})
+	}
+	return m
+}
+
+// Lines returns the metadata as human-readable lines.
+func (m *RunMetadata) Lines() []string {
+	lines := []string{
+		fmt.Sprintf("Generated by prom-query-stats %s at %s", m.Version, formatTime(m.Generated)),
+		"Arguments: " + quoteArgs(m.Args),
+	}
+	for _, in := range m.Inputs {
+		lines = append(lines, fmt.Sprintf("Input: %s size=%d sha256=%s", in.Name, in.Size, in.SHA256))
+	}
+	return lines
+}
+
+// quoteArgs joins the arguments, quoting those a shell would split or expand.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`*?[]{}()<>|&;#~!") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// PrintRunMetadata writes the metadata as lines starting with prefix, e.g. "# " for comments in CSV.
+func PrintRunMetadata(w io.Writer, m *RunMetadata, prefix string) {
+	if m == nil {
+		return
+	}
+	for _, line := range m.Lines() {
+		fmt.Fprintln(w, prefix+escapeTerminal(line))
+	}
+}
+
+// JSON returns the metadata encoded as JSON, e.g. for the schema metadata of Arrow files.
+func (m *RunMetadata) JSON() string {
+	data, _ := json.Marshal(m)
+	return string(data)
+}
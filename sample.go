@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var (
+	// sampleSize is the number of raw entries sampled per query in the JSON report
+	sampleSize int
+	sampleSeed uint64
+)
+
+func init() {
+	flag.IntVar(&sampleSize, "sample-entries", 3, "number of raw log entries sampled per query in the JSON report. Slower executions are more likely to be sampled. 0 disables sampling")
+	flag.Uint64Var(&sampleSeed, "sample-seed", 1, "seed of -sample-entries, -sample-rate and -sample-size. The same seed and log produce the same samples")
+}
+
+// printSampleNote notes that the statistics are estimated from a sample of the log.
+func printSampleNote(entries, sampledOut int) {
+	fmt.Printf("NOTE: the statistics are estimated from a sample of %d entries, %d lines and entries were left out by -sample-rate and -sample-size. Counts and totals cover the sample only\n", entries, sampledOut)
+}
+
+// SampleEntries returns up to n executions of the query sampled without replacement with probability
+// proportional to their execution time, ordered by time. The sample only depends on the seed and the
+// executions of the query, not on the other queries of the log.
+func SampleEntries(q *querystats.Query, n int, seed uint64) []*querystats.LogEntry {
+	if n <= 0 {
+		return nil
+	}
+	if len(q.Logs) <= n {
+		sample := append([]*querystats.LogEntry(nil), q.Logs...)
+		sort.Slice(sample, func(i, j int) bool { return sample[i].TS.Before(*sample[j].TS) })
+		return sample
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(q.Query))
+	rng := rand.New(rand.NewPCG(seed, h.Sum64()))
+	// Efraimidis-Spirakis: the n entries with the largest u^(1/w) are a weighted sample without replacement. Its
+	// logarithm log(u)/w orders them the same without underflowing to 0 for small weights, which would make ties.
+	// Zero timings get a tiny weight, so they are only picked when there are not enough other entries
+	type keyed struct {
+		entry *querystats.LogEntry
+		key   float64
+	}
+	keys := make([]keyed, len(q.Logs))
+	for i, log := range q.Logs {
+		w := max(log.Stats.Timings.ExecTotalTime, 1e-9)
+		// u is in (0, 1], so its logarithm is finite
+		u := 1 - rng.Float64()
+		keys[i] = keyed{log, math.Log(u) / w}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].key > keys[j].key })
+
+	sample := make([]*querystats.LogEntry, 0, n)
+	for _, k := range keys[:n] {
+		sample = append(sample, k.entry)
+	}
+	sort.Slice(sample, func(i, j int) bool { return sample[i].TS.Before(*sample[j].TS) })
+	return sample
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// ReportSection is a part of the text report selected with -report: a top table or a percentile over all entries.
+type ReportSection struct {
+	// Percentile prints the percentile of the metric over all entries instead of a table
+	Percentile bool
+	Metric     Metric
+	Kind       TableKind
+	// Ascending ranks the queries with the lowest values first
+	Ascending bool
+}
+
+func costMetric() Metric {
+	return Metric{"cost", "estimated cost", "", false, costModel.EntryCost}
+}
+
+// reportMetrics maps the metric part of -report items to metrics.
+var reportMetrics = map[string]func() Metric{
+	"exec":    func() Metric { return MetricExecTotalTime },
+	"samples": func() Metric { return MetricTotalQueryableSamples },
+	"peak":    func() Metric { return MetricPeakSamples },
+	"points":  func() Metric { return MetricPoints },
+	"range":   func() Metric { return MetricRange },
+	"step":    func() Metric { return MetricStep },
+	"queue":   func() Metric { return MetricQueueTime },
+	"prep":    func() Metric { return MetricPreparationTime },
+	"eval":    func() Metric { return MetricInnerEvalTime },
+	"sort":    func() Metric { return MetricResultSortTime },
+	"cost":    costMetric,
+}
+
+// ParseReportMetrics parses a comma-separated list of the metric names of -report items, e.g. exec,queue.
+func ParseReportMetrics(value string) ([]Metric, error) {
+	var metrics []Metric
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		metric, ok := reportMetrics[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q, must be exec, samples, peak, points, range, step, queue, prep, eval, sort or cost", name)
+		}
+		if name == "cost" && !costModel.Enabled() {
+			return nil, fmt.Errorf("cost requires -cost-per-second or -cost-per-msamples")
+		}
+		metrics = append(metrics, metric())
+	}
+	return metrics, nil
+}
+
+// DefaultReportSections returns the sections of the text report over the metrics when -report is not set. Tables by
+// the -query-percentiles of each query are only printed when selected with -report, e.g. p99-exec.
+func DefaultReportSections(metrics []Metric, ascending bool) []ReportSection {
+	var sections []ReportSection
+	for _, m := range metrics {
+		sections = append(sections,
+			ReportSection{Percentile: true, Metric: m},
+			ReportSection{Metric: m, Kind: TableAvg, Ascending: ascending},
+			ReportSection{Metric: m, Kind: TableMax, Ascending: ascending},
+		)
+		// peaks of separate executions don't add up
+		if m.Name == MetricPeakSamples.Name {
+			continue
+		}
+		sections = append(sections, ReportSection{Metric: m, Kind: TableSum, Ascending: ascending})
+	}
+	if costModel.Enabled() {
+		sections = append(sections, ReportSection{Metric: costMetric(), Kind: TableSum, Ascending: ascending})
+	}
+	return sections
+}
+
+// ParseReportSections parses a comma-separated list of sections. Tables are named <kind>-<metric>, e.g. avg-exec,
+// max-samples, stddev-exec, sum-cost or p99-queue, where the metric is exec, samples, peak, points, range, step, queue, prep, eval, sort or cost. percentile-<metric> selects
+// the percentile over all entries and percentiles all of them. A ':asc' or ':desc' suffix overrides the order
+// of a table, which is descending unless ascending is set.
+func ParseReportSections(value string, ascending bool) ([]ReportSection, error) {
+	var sections []ReportSection
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		name, order, hasOrder := strings.Cut(item, ":")
+		asc := ascending
+		if hasOrder {
+			switch order {
+			case "asc":
+				asc = true
+			case "desc":
+				asc = false
+			default:
+				return nil, fmt.Errorf("unknown order %q of %q, must be asc or desc", order, item)
+			}
+		}
+
+		if name == "percentiles" {
+			for _, m := range []Metric{MetricExecTotalTime, MetricTotalQueryableSamples, MetricPeakSamples} {
+				sections = append(sections, ReportSection{Percentile: true, Metric: m})
+			}
+			continue
+		}
+		kindName, metricName, ok := strings.Cut(name, "-")
+		if !ok {
+			return nil, fmt.Errorf("unknown section %q", item)
+		}
+		metric, ok := reportMetrics[metricName]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q in %q, must be exec, samples, peak, points, range, step, queue, prep, eval, sort or cost", metricName, item)
+		}
+		if metricName == "cost" && !costModel.Enabled() {
+			return nil, fmt.Errorf("%q requires -cost-per-second or -cost-per-msamples", item)
+		}
+		if kindName == "percentile" {
+			sections = append(sections, ReportSection{Percentile: true, Metric: metric()})
+			continue
+		}
+		kind, err := ParseTableKind(kindName)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		sections = append(sections, ReportSection{Metric: metric(), Kind: kind, Ascending: asc})
+	}
+	return sections, nil
+}
+
+// WithoutSampleSections returns the sections not about sample statistics, for query logs that don't have them.
+func WithoutSampleSections(sections []ReportSection) []ReportSection {
+	return slices.DeleteFunc(slices.Clone(sections), func(s ReportSection) bool {
+		return s.Metric.Name == MetricTotalQueryableSamples.Name || s.Metric.Name == MetricPeakSamples.Name
+	})
+}
+
+// PrintReportSection prints the section. ranks are the ranks of percentile sections, the contributors to the
+// percentile of execution time are listed for the first one.
+func PrintReportSection(s ReportSection, queries []*querystats.Query, logs querystats.LogEntries, top int, ranks []int, columns []string) error {
+	if s.Percentile {
+		summary, percentiles, err := metricSummary(ranks, logs, s.Metric)
+		if err != nil {
+			return fmt.Errorf("failed to calculate percentile: %w", err)
+		}
+		for _, perc := range ranks {
+			p := percentiles[perc]
+			switch {
+			case s.Metric.Name == MetricExecTotalTime.Name:
+				fmt.Printf("The %dth percentile of total execution time is %.3f seconds\n", perc, p)
+			case s.Metric.Int:
+				fmt.Printf("The %dth percentile of %s is %d\n", perc, s.Metric.Title, int(p))
+			default:
+				fmt.Printf("The %dth percentile of %s is %s\n", perc, s.Metric.Title, s.Metric.Format(p))
+			}
+		}
+		fmt.Printf("Distribution of %s: %s\n", s.Metric.Title, formatSummary(summary, s.Metric))
+		if s.Metric.Name != MetricExecTotalTime.Name {
+			return nil
+		}
+		threshold := percentiles[ranks[0]]
+		if contributors := FindPercentileContributors(queries, s.Metric, threshold); len(contributors) > 0 {
+			fmt.Println()
+			PrintPercentileContributors(contributors, top, s.Metric, threshold, ranks[0])
+		}
+		return nil
+	}
+
+	SortQueries(queries, s.Metric, s.Kind)
+	if s.Ascending {
+		slices.Reverse(queries)
+		printTable("Bottom", queries, top, s.Metric, s.Kind, columns)
+		return nil
+	}
+	PrintTable(queries, top, s.Metric, s.Kind, columns)
+	return nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const exporterStateVersion = 1
+
+var (
+	durationBuckets    = prometheus.ExponentialBuckets(0.001, 4, 9)
+	peakSamplesBuckets = prometheus.ExponentialBuckets(100, 10, 8)
+)
+
+// histogramState is a histogram with non-cumulative bucket counts. The last count is the +Inf bucket.
+type histogramState struct {
+	Counts []uint64
+	// Sum and SumCompensation make up a querystats.KahanSum, so the sum of a long running exporter stays accurate
+	Sum             float64
+	SumCompensation float64
+}
+
+func newHistogramState(buckets []float64) histogramState {
+	return histogramState{Counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogramState) Observe(buckets []float64, v float64) {
+	i := 0
+	for i < len(buckets) && v > buckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	sum := querystats.KahanSum{Sum: h.Sum, Compensation: h.SumCompensation}
+	sum.Add(v)
+	h.Sum, h.SumCompensation = sum.Sum, sum.Compensation
+}
+
+func (h *histogramState) metric(desc *prometheus.Desc, buckets []float64, labelValues ...string) prometheus.Metric {
+	cumulative := make(map[float64]uint64, len(buckets))
+	var count uint64
+	for i, c := range h.Counts {
+		count += c
+		if i < len(buckets) {
+			cumulative[buckets[i]] = count
+		}
+	}
+	return prometheus.MustNewConstHistogram(desc, count, h.Sum+h.SumCompensation, cumulative, labelValues...)
+}
+
+type ruleGroupStats struct {
+	Duration         histogramState
+	PeakSamples      histogramState
+	QueryableSamples float64
+	Outcomes         map[querystats.Outcome]uint64
+}
+
+// exporterState is the cumulative statistics exposed by the serve subcommand. It is plain data,
+// so it can be saved to the state file and restored on start, and counters survive restarts.
+type exporterState struct {
+	Version int
+	// RuleGroups is keyed by the rule group name. Queries from the HTTP API have an empty name
+	RuleGroups map[string]*ruleGroupStats
+	// Position is the offset in the query log up to which entries were counted, in the file identified by File
+	Position int64
+	File     fileID
+	// Entries are the entries of the -api-window when the state was saved, so the REST API doesn't start over
+	Entries querystats.LogEntries
+}
+
+func newExporterState() *exporterState {
+	return &exporterState{Version: exporterStateVersion, RuleGroups: make(map[string]*ruleGroupStats)}
+}
+
+func (s *exporterState) Add(entry *querystats.LogEntry) {
+	name := ""
+	if entry.RuleGroup != nil {
+		name = entry.RuleGroup.Name
+	}
+	g := s.RuleGroups[name]
+	if g == nil {
+		g = &ruleGroupStats{
+			Duration:    newHistogramState(durationBuckets),
+			PeakSamples: newHistogramState(peakSamplesBuckets),
+			Outcomes:    make(map[querystats.Outcome]uint64),
+		}
+		s.RuleGroups[name] = g
+	}
+	g.Duration.Observe(durationBuckets, entry.Stats.Timings.ExecTotalTime)
+	g.PeakSamples.Observe(peakSamplesBuckets, float64(entry.Stats.Samples.PeakSamples))
+	g.QueryableSamples += float64(entry.Stats.Samples.TotalQueryableSamples)
+	g.Outcomes[entry.Outcome(timeoutProxy)]++
+}
+
+func loadExporterState(name string) (*exporterState, error) {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return newExporterState(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var s exporterState
+	if err := gob.NewDecoder(file).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Version != exporterStateVersion {
+		return nil, fmt.Errorf("unsupported state version %d", s.Version)
+	}
+	for _, g := range s.RuleGroups {
+		if len(g.Duration.Counts) != len(durationBuckets)+1 || len(g.PeakSamples.Counts) != len(peakSamplesBuckets)+1 {
+			return nil, fmt.Errorf("the state has different histogram buckets")
+		}
+	}
+	return &s, nil
+}
+
+var (
+	descDuration = prometheus.NewDesc("prom_query_stats_query_duration_seconds",
+		"Total execution time of queries in the query log.", []string{"rule_group"}, nil)
+	descPeakSamples = prometheus.NewDesc("prom_query_stats_query_peak_samples",
+		"Peak number of samples held in memory by queries in the query log.", []string{"rule_group"}, nil)
+	descQueryableSamples = prometheus.NewDesc("prom_query_stats_queryable_samples_total",
+		"Number of samples loaded by queries in the query log.", []string{"rule_group"}, nil)
+	descQueries = prometheus.NewDesc("prom_query_stats_queries_total",
+		"Number of queries in the query log by inferred outcome.", []string{"rule_group", "outcome"}, nil)
+)
+
+// Exporter tails the query log and exposes the statistics as Prometheus metrics. The entries of the last window are
+// kept for the REST API.
+type Exporter struct {
+	mu    sync.Mutex
+	state *exporterState
+	// window is the age of the entries kept in entries, 0 keeps none
+	window  time.Duration
+	entries querystats.LogEntries
+	// instance labels the entries, see -f name=path
+	instance string
+}
+
+func (e *Exporter) add(entry *querystats.LogEntry) {
+	e.state.Add(entry)
+	if e.window > 0 {
+		entry.Instance = e.instance
+		e.entries = append(e.entries, entry)
+	}
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descDuration
+	ch <- descPeakSamples
+	ch <- descQueryableSamples
+	ch <- descQueries
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name, g := range e.state.RuleGroups {
+		ch <- g.Duration.metric(descDuration, durationBuckets, name)
+		ch <- g.PeakSamples.metric(descPeakSamples, peakSamplesBuckets, name)
+		ch <- prometheus.MustNewConstMetric(descQueryableSamples, prometheus.CounterValue, g.QueryableSamples, name)
+		for outcome, n := range g.Outcomes {
+			ch <- prometheus.MustNewConstMetric(descQueries, prometheus.CounterValue, float64(n), name, outcome.String())
+		}
+	}
+}
+
+// Run reads entries appended to the query log until ctx is done.
+func (e *Exporter) Run(ctx context.Context, path string, opts querystats.LoadOptions, interval time.Duration) error {
+	follower := NewFollower(path)
+	defer follower.Close()
+	e.mu.Lock()
+	follower.Resume(e.state.Position, e.state.File)
+	e.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		lines, err := follower.Read()
+		if err != nil {
+			return err
+		}
+		e.mu.Lock()
+		for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			if _, err := querystats.ScanLogEntries(bytes.NewReader(line), opts, e.add); err != nil {
+				slog.Warn("Skipping a line", "err", err)
+			}
+		}
+		e.state.Position = follower.Position()
+		if id, ok := follower.File(); ok {
+			e.state.File = id
+		}
+		if e.window > 0 {
+			e.entries = entriesSince(e.entries, time.Now().Add(-e.window))
+		}
+		e.mu.Unlock()
+
+		// a backlog is read in chunks without waiting for the ticker
+		if follower.More() && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// SaveState writes the state, including the entries of the window, atomically to the file.
+func (e *Exporter) SaveState(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.state.Entries = e.entries
+	defer func() { e.state.Entries = nil }()
+	return writeFileAtomic(name, func(w io.Writer) error { return gob.NewEncoder(w).Encode(e.state) })
+}
+
+// runServe implements the serve subcommand, a Prometheus exporter of query log statistics.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	file := fs.String("f", "", "path to the query log file. Prefix it with name= to add an instance label with the name to the metrics, e.g. prod-a=query.log")
+	listen := fs.String("listen", ":9417", "address to expose metrics on")
+	interval := fs.Duration("interval", 5*time.Second, "how often to check the query log for new entries")
+	stateFile := fs.String("state-file", "", "file the statistics are saved to on shutdown and on POST /admin/snapshot, and restored from on start")
+	apiWindow := fs.Duration("api-window", 0, "age of the entries the REST API under /api/ reports on, e.g. 1h. They are kept in memory and saved to -state-file. The API is disabled unless set")
+	ranks := percentileRanks{95, 99}
+	fs.Var(&ranks, "p", "comma-separated list of percentile ranks of /api/summary. The first rank is also the percentile reported per query by /api/queries")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s serve [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *file == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *interval <= 0 {
+		fatal("-interval must be positive")
+	}
+	if *apiWindow < 0 {
+		fatal("-api-window must not be negative")
+	}
+
+	instance, path := SplitInputLabel(*file)
+	exporter := &Exporter{state: newExporterState(), window: *apiWindow, instance: instance}
+	if *stateFile != "" {
+		state, err := loadExporterState(*stateFile)
+		if err != nil {
+			fatalf("Failed to restore the state: %s", err)
+		}
+		if *apiWindow > 0 {
+			exporter.entries = entriesSince(state.Entries, time.Now().Add(-*apiWindow))
+		}
+		state.Entries = nil
+		exporter.state = state
+		slog.Info("Restored the statistics of rule groups", "groups", len(state.RuleGroups), "entries", len(exporter.entries), "file", *stateFile)
+	}
+
+	registry := prometheus.NewRegistry()
+	if instance != "" {
+		// scraped with honor_labels: true, the label is kept, otherwise Prometheus renames it to exported_instance
+		prometheus.WrapRegistererWith(prometheus.Labels{"instance": instance}, registry).MustRegister(exporter)
+	} else {
+		registry.MustRegister(exporter)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("POST /admin/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if *stateFile == "" {
+			http.Error(w, "-state-file is not set", http.StatusBadRequest)
+			return
+		}
+		if err := exporter.SaveState(*stateFile); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "Saved the state to %s\n", *stateFile)
+	})
+	if *apiWindow > 0 {
+		exporter.registerAPI(mux, ranks)
+	}
+	server := &http.Server{Addr: *listen, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		slog.Info("Listening", "address", *listen)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			fatalf("Failed to serve: %s", err)
+		}
+	}()
+
+	slog.Info("Following the query log", "file", path)
+	if err := exporter.Run(ctx, path, querystats.LoadOptions{Log: logLoad}, *interval); err != nil {
+		fatalf("Failed to follow the query log: %s", err)
+	}
+	server.Shutdown(context.Background())
+	if *stateFile != "" {
+		if err := exporter.SaveState(*stateFile); err != nil {
+			fatalf("Failed to save the state: %s", err)
+		}
+		slog.Info("Saved the state", "file", *stateFile)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// runOnce makes the exporter read what is in the query log and returns.
+func runOnce(t *testing.T, e *Exporter, path string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.Run(ctx, path, querystats.LoadOptions{}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExporterStateResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "query.log")
+	stateFile := filepath.Join(dir, "state")
+	if err := os.WriteFile(path, []byte(logOf("up", "up")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the entries of the log are from 2025, the window keeps them
+	window := 100 * 365 * 24 * time.Hour
+	e := &Exporter{state: newExporterState(), window: window}
+	runOnce(t, e, path)
+	if err := e.SaveState(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	if e.state.Entries != nil {
+		t.Errorf("SaveState kept %d entries in the state", len(e.state.Entries))
+	}
+
+	state, err := loadExporterState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Entries) != 2 {
+		t.Errorf("restored %d entries, want 2", len(state.Entries))
+	}
+	restored := &Exporter{state: state, window: window, entries: state.Entries}
+	state.Entries = nil
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(logOf("up")); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	runOnce(t, restored, path)
+
+	g := restored.state.RuleGroups[""]
+	if g == nil {
+		t.Fatal("no statistics of queries from the HTTP API")
+	}
+	// the entries counted before the restart are not counted again
+	var count uint64
+	for _, n := range g.Outcomes {
+		count += n
+	}
+	if count != 3 || g.QueryableSamples != 30 || len(restored.entries) != 3 {
+		t.Errorf("after resuming, %d queries, %g samples and %d entries, want 3, 30 and 3",
+			count, g.QueryableSamples, len(restored.entries))
+	}
+}
+
+func TestLoadExporterState(t *testing.T) {
+	dir := t.TempDir()
+	state, err := loadExporterState(filepath.Join(dir, "missing"))
+	if err != nil || len(state.RuleGroups) != 0 || state.Version != exporterStateVersion {
+		t.Errorf("loading a missing file = %+v, %v, want an empty state", state, err)
+	}
+
+	name := filepath.Join(dir, "state")
+	e := &Exporter{state: newExporterState()}
+	e.state.Version = exporterStateVersion + 1
+	if err := e.SaveState(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadExporterState(name); err == nil {
+		t.Error("loading a state of another version succeeded")
+	}
+	if err := os.WriteFile(name, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadExporterState(name); err == nil {
+		t.Error("loading a corrupted state succeeded")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// defaultAPILimit is the number of items the list endpoints of serve return unless ?limit is set.
+const defaultAPILimit = 100
+
+// apiSummary is the response of /api/summary: the distributions of the metrics over the entries of the window.
+type apiSummary struct {
+	Window          string             `json:"window"`
+	From            *time.Time         `json:"from,omitempty"`
+	To              *time.Time         `json:"to,omitempty"`
+	Entries         int                `json:"entries"`
+	DistinctQueries int                `json:"distinctQueries"`
+	Percentiles     []ReportPercentile `json:"percentiles"`
+	Summaries       []ReportSummary    `json:"summaries"`
+	QueryTypes      []QueryTypeStats   `json:"queryTypes"`
+}
+
+// windowEntries returns the entries of the window of the API sorted by time.
+func (e *Exporter) windowEntries() querystats.LogEntries {
+	e.mu.Lock()
+	entries := slices.Clone(e.entries)
+	e.mu.Unlock()
+	sort.Sort(querystats.ByTime{LogEntries: entries})
+	return entries
+}
+
+// windowQueries groups the entries of the window.
+func (e *Exporter) windowQueries() ([]*querystats.Query, querystats.LogEntries, error) {
+	return querystats.GroupQueries(e.windowEntries(), querystats.LoadOptions{PercentileRanks: queryPercentileRanks, Log: logLoad})
+}
+
+// registerAPI adds the REST API over the entries of the window to the mux:
+//
+//	GET /api/summary                      percentiles and distributions over all entries
+//	GET /api/queries?sort=sum-exec&limit= the statistics of each query, ordered like the -report tables
+//	GET /api/queries/{id}/executions      every execution of the query with the id, see the id column
+func (e *Exporter) registerAPI(mux *http.ServeMux, ranks []int) {
+	mux.HandleFunc("GET /api/summary", func(w http.ResponseWriter, r *http.Request) {
+		queries, logs, err := e.windowQueries()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s := apiSummary{Window: e.window.String(), Entries: len(logs), DistinctQueries: len(queries)}
+		if len(logs) > 0 {
+			s.From, s.To = logs[0].TS, logs[len(logs)-1].TS
+			for _, m := range []Metric{MetricExecTotalTime, MetricTotalQueryableSamples, MetricPeakSamples} {
+				summary, percentiles, err := metricSummary(ranks, logs, m)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				for _, p := range ranks {
+					s.Percentiles = append(s.Percentiles, ReportPercentile{m.Name, p, percentiles[p]})
+				}
+				s.Summaries = append(s.Summaries, ReportSummary{m.Name, summary})
+			}
+			if s.QueryTypes, err = QueryTypeBreakdown(logs, ranks); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		writeAPIResponse(w, s)
+	})
+
+	mux.HandleFunc("GET /api/queries", func(w http.ResponseWriter, r *http.Request) {
+		order := r.URL.Query().Get("sort")
+		if order == "" {
+			order = "sum-exec"
+		}
+		sections, err := ParseReportSections(order, false)
+		if err != nil || len(sections) != 1 || sections[0].Percentile {
+			http.Error(w, fmt.Sprintf("invalid sort %q, must be a table of -report, e.g. avg-exec or max-samples:asc", order), http.StatusBadRequest)
+			return
+		}
+		limit, ok := apiLimit(w, r)
+		if !ok {
+			return
+		}
+		queries, _, err := e.windowQueries()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s := sections[0]
+		SortQueries(queries, s.Metric, s.Kind)
+		if s.Ascending {
+			slices.Reverse(queries)
+		}
+		stats := make([]*QueryStats, 0, min(limit, len(queries)))
+		for _, q := range queries[:min(limit, len(queries))] {
+			stats = append(stats, NewQueryStats(q, ranks[0], QueryLinks{}))
+		}
+		writeAPIResponse(w, stats)
+	})
+
+	mux.HandleFunc("GET /api/queries/{id}/executions", func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := apiLimit(w, r)
+		if !ok {
+			return
+		}
+		found := FindExecutions(e.windowEntries(), r.PathValue("id"))
+		if len(found) == 0 {
+			http.Error(w, "no executions of the query in the window", http.StatusNotFound)
+			return
+		}
+		// the latest executions are the most interesting ones of a tailed log
+		found = found[max(len(found)-limit, 0):]
+		executions := make([]Execution, 0, len(found))
+		for _, entry := range found {
+			executions = append(executions, newExecution(entry))
+		}
+		writeAPIResponse(w, executions)
+	})
+}
+
+// apiLimit parses the ?limit parameter. It writes the error and returns false if it is invalid.
+func apiLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultAPILimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		http.Error(w, fmt.Sprintf("invalid limit %q, must be a positive number", value), http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeAPIResponse(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
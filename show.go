@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// Execution is an execution of a query as printed by the show subcommand.
+type Execution struct {
+	TS                    time.Time `json:"ts"`
+	Query                 string    `json:"query"`
+	Type                  string    `json:"type"`
+	ExecTotalTime         float64   `json:"execTotalTime"`
+	ExecQueueTime         float64   `json:"execQueueTime"`
+	TotalQueryableSamples int       `json:"totalQueryableSamples"`
+	PeakSamples           int       `json:"peakSamples"`
+	// Range is the query range of range queries in seconds
+	Range     float64 `json:"range,omitempty"`
+	Step      int     `json:"step,omitempty"`
+	RuleGroup string  `json:"ruleGroup,omitempty"`
+	ClientIP  string  `json:"clientIP,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func newExecution(e *querystats.LogEntry) Execution {
+	x := Execution{
+		TS:                    e.TS.UTC(),
+		Query:                 e.Params.Query,
+		Type:                  string(e.Type()),
+		ExecTotalTime:         e.Stats.Timings.ExecTotalTime,
+		ExecQueueTime:         e.Stats.Timings.ExecQueueTime,
+		TotalQueryableSamples: e.Stats.Samples.TotalQueryableSamples,
+		PeakSamples:           e.Stats.Samples.PeakSamples,
+		Range:                 e.Range().Seconds(),
+		Step:                  e.Params.Step,
+		Error:                 e.Error,
+	}
+	if e.RuleGroup != nil {
+		x.RuleGroup = e.RuleGroup.Name
+	}
+	if e.HTTPRequest != nil {
+		x.ClientIP = e.HTTPRequest.ClientIP
+	}
+	return x
+}
+
+// FindExecutions returns the entries of the query given by its id, see the id column, or its text. Queries
+// differing only in literals share an id, so the executions of all variants are returned.
+func FindExecutions(entries querystats.LogEntries, query string) querystats.LogEntries {
+	ids := make(map[string]string)
+	want := QueryID(query)
+	var found querystats.LogEntries
+	for _, e := range entries {
+		id, ok := ids[e.Params.Query]
+		if !ok {
+			id = QueryID(e.Params.Query)
+			ids[e.Params.Query] = id
+		}
+		if id == query || id == want {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// PrintExecutions prints a row per execution in the order of the log, preceded by the variants of the query.
+func PrintExecutions(entries querystats.LogEntries) {
+	variants := make(map[string]int)
+	var order []string
+	for _, e := range entries {
+		if variants[e.Params.Query] == 0 {
+			order = append(order, e.Params.Query)
+		}
+		variants[e.Params.Query]++
+	}
+	fmt.Printf("%d executions of %s from %s to %s\n", len(entries), QueryID(entries[0].Params.Query),
+		formatTime(*entries[0].TS), formatTime(*entries[len(entries)-1].TS))
+	for _, q := range order {
+		fmt.Printf("  n=%-6d %s\n", variants[q], escapeTerminal(q))
+	}
+	fmt.Println()
+
+	// UTC timestamps end with Z, those of other time zones of -tz with their offset
+	tsWidth := len(displayTime(*entries[0].TS).Format(concurrencyTimeFormat))
+	fmt.Printf("%-*s %-8s %10s %10s %12s %10s %8s %8s  %s\n", tsWidth, "ts", "type", "exec", "queue", "samples", "peak", "range", "step", "source")
+	for _, e := range entries {
+		x := newExecution(e)
+		var rangeText, stepText string
+		if x.Type == string(querystats.QueryTypeRange) {
+			rangeText = formatBound(MetricRange, x.Range)
+			stepText = formatBound(MetricStep, float64(x.Step))
+		}
+		source := x.ClientIP
+		if x.RuleGroup != "" {
+			source = fmt.Sprintf("ruleName=\"%s\"", escapeTerminal(x.RuleGroup))
+		}
+		fmt.Printf("%-*s %-8s %10.3f %10.3f %12d %10d %8s %8s  %s", tsWidth, displayTime(x.TS).Format(concurrencyTimeFormat), x.Type,
+			x.ExecTotalTime, x.ExecQueueTime, x.TotalQueryableSamples, x.PeakSamples, rangeText, stepText, escapeTerminal(source))
+		if len(variants) > 1 {
+			fmt.Printf(" %s", escapeTerminal(x.Query))
+		}
+		if x.Error != "" {
+			fmt.Printf(" error=%q", x.Error)
+		}
+		fmt.Println()
+	}
+}
+
+// runShow implements the show subcommand printing every execution of a single query.
+func runShow(args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	var from, to timeFlag
+	fs.Var(&from, "from", "show executions after this time. Accepts the same formats as -from of analyze")
+	fs.Var(&to, "to", "show executions until this time")
+	output := fs.String("o", "text", "output format: text or json")
+	format := fs.String("format", "prometheus", "format of the query log, see analyze -h")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel")
+	fs.Var(locationFlag{}, "tz", tzUsage)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s show [flags] query [file...]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Prints every execution of a query given by its id (see the id column) or text. Queries differing only in literals are treated as one")
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files. Reads stdin if none is given")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *output != "text" && *output != "json" {
+		fatal("-o must be text or json")
+	}
+	decoder, ok := querystats.LookupDecoder(*format)
+	if !ok {
+		fatalf("Unknown query log format %q", *format)
+	}
+
+	files := args[1:]
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	files, err := ExpandInputs(files)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	defer closeInput()
+	entries, _, err := querystats.ReadLogEntries(input, querystats.LoadOptions{
+		From:         from.Time,
+		To:           to.Time,
+		Decoder:      &decoder,
+		MaxEntrySize: querystats.DefaultMaxEntrySize,
+		Jobs:         *jobs,
+		Log:          logLoad,
+	})
+	if err != nil {
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	found := FindExecutions(entries, args[0])
+	if len(found) == 0 {
+		fatalf("Query %q not found in the query log", args[0])
+	}
+
+	if *output == "json" {
+		executions := make([]Execution, 0, len(found))
+		for _, e := range found {
+			executions = append(executions, newExecution(e))
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(executions); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	PrintExecutions(found)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+const snapshotVersion = 2
+
+type snapshot struct {
+	Version int
+	Entries querystats.LogEntries
+	// Positions are how far the files were read, so that a restoring run reads only what was appended since
+	Positions []FilePosition
+}
+
+// WriteSnapshot serializes the loaded entries and the positions up to which they were read so that they can be
+// restored by a later run.
+func WriteSnapshot(w io.Writer, logs querystats.LogEntries, positions []FilePosition) error {
+	return gob.NewEncoder(w).Encode(snapshot{snapshotVersion, logs, positions})
+}
+
+func ReadSnapshot(r io.Reader) (querystats.LogEntries, []FilePosition, error) {
+	var s snapshot
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, nil, err
+	}
+	if s.Version != snapshotVersion {
+		return nil, nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	return s.Entries, s.Positions, nil
+}
+
+// SaveSnapshotFile writes the snapshot to a temporary file first and renames it,
+// so that an existing snapshot is never left half-written.
+func SaveSnapshotFile(name string, logs querystats.LogEntries, positions []FilePosition) error {
+	return writeFileAtomic(name, func(w io.Writer) error { return WriteSnapshot(w, logs, positions) })
+}
+
+func writeFileAtomic(name string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// LoadSnapshotFile restores entries from a snapshot. A missing file is not an error.
+func LoadSnapshotFile(name string) (querystats.LogEntries, []FilePosition, error) {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return ReadSnapshot(file)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// spillFanIn is the most runs merged at once, so merging opens a bounded number of files however many runs were
+// spilled. More runs are merged in several passes.
+const spillFanIn = 64
+
+// SpillSorter computes exact percentiles over more values than fit in memory.
+// Values are buffered up to a limit, after which the buffer is sorted and spilled
+// to a temporary file. Percentiles are then selected by merging the sorted runs.
+type SpillSorter struct {
+	limit int
+	dir   string
+	buf   []float64
+	// runs are the names of the temporary files of the sorted runs, which are closed until merged
+	runs []string
+	n    int
+}
+
+// NewSpillSorter returns a sorter keeping at most limit values in memory.
+// Temporary files are created in dir, or the default temporary directory if dir is empty.
+func NewSpillSorter(limit int, dir string) *SpillSorter {
+	return &SpillSorter{limit: limit, dir: dir}
+}
+
+// Add adds a value, spilling the buffered values as a sorted run once the limit is reached.
+func (s *SpillSorter) Add(v float64) error {
+	s.buf = append(s.buf, v)
+	s.n++
+	if len(s.buf) >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *SpillSorter) spill() error {
+	slices.Sort(s.buf)
+	err := s.writeRun(func(w *bufio.Writer) error {
+		for _, v := range s.buf {
+			if err := writeValue(w, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.buf = s.buf[:0]
+	return err
+}
+
+// writeRun writes a sorted run with write into a new temporary file, which is closed afterwards.
+func (s *SpillSorter) writeRun(write func(w *bufio.Writer) error) error {
+	file, err := os.CreateTemp(s.dir, "prom-query-stats-spill-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, file.Name())
+	w := bufio.NewWriter(file)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeValue(w *bufio.Writer, v float64) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	_, err := w.Write(b[:])
+	return err
+}
+
+// merge merges the runs into fewer runs until at most spillFanIn are left.
+func (s *SpillSorter) merge() error {
+	for len(s.runs) > spillFanIn {
+		runs := s.runs
+		s.runs = nil
+		for len(runs) > 0 {
+			group := runs[:min(spillFanIn, len(runs))]
+			runs = runs[len(group):]
+			h, closeRuns, err := openRuns(group)
+			if err == nil {
+				err = s.writeRun(func(w *bufio.Writer) error {
+					for len(h) > 0 {
+						if err := writeValue(w, h[0].head); err != nil {
+							return err
+						}
+						if err := h.advance(); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			}
+			closeRuns()
+			// the runs not merged yet are kept, so Close removes them
+			for _, name := range group {
+				os.Remove(name)
+			}
+			if err != nil {
+				s.runs = append(s.runs, runs...)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// openRuns opens the runs and returns a heap of their first values. closeRuns closes the files, also after an error.
+func openRuns(names []string) (h runHeap, closeRuns func(), err error) {
+	var files []*os.File
+	closeRuns = func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	h = make(runHeap, 0, len(names))
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, closeRuns, err
+		}
+		files = append(files, file)
+		r := &run{r: bufio.NewReader(file)}
+		if ok, err := r.next(); err != nil {
+			return nil, closeRuns, err
+		} else if ok {
+			h = append(h, r)
+		}
+	}
+	heap.Init(&h)
+	return h, closeRuns, nil
+}
+
+// Percentile returns the p-th percentile of the added values with the nearest-rank method.
+func (s *SpillSorter) Percentile(p int) (float64, error) {
+	percentiles, err := s.Percentiles([]int{p})
+	if err != nil {
+		return 0, err
+	}
+	return percentiles[p], nil
+}
+
+// Percentiles returns the percentiles of the given ranks of the added values with the nearest-rank method,
+// merging the sorted runs once for all of them.
+func (s *SpillSorter) Percentiles(ranks []int) (map[int]float64, error) {
+	for _, p := range ranks {
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("percentile %d is out of range", p)
+		}
+	}
+	if s.n == 0 {
+		return nil, fmt.Errorf("no values")
+	}
+	if len(s.runs) == 0 {
+		return querystats.PercentilesOf(ranks, slices.Clone(s.buf)), nil
+	}
+	if len(s.buf) > 0 {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.merge(); err != nil {
+		return nil, err
+	}
+
+	// positions maps the nearest ranks of the values to the percentiles selecting them
+	positions := make(map[int][]int, len(ranks))
+	last := 0
+	for _, p := range ranks {
+		pos := max(int(math.Ceil(float64(p)/100.0*float64(s.n))), 1)
+		positions[pos] = append(positions[pos], p)
+		last = max(last, pos)
+	}
+	h, closeRuns, err := openRuns(s.runs)
+	defer closeRuns()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]float64, len(ranks))
+	for i := 1; i <= last; i++ {
+		for _, p := range positions[i] {
+			result[p] = h[0].head
+		}
+		if err := h.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Close removes the temporary files.
+func (s *SpillSorter) Close() error {
+	var firstErr error
+	for _, name := range s.runs {
+		if err := os.Remove(name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.runs = nil
+	return firstErr
+}
+
+type run struct {
+	r    *bufio.Reader
+	head float64
+}
+
+func (r *run) next() (bool, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r.r, b[:]); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	r.head = math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+	return true, nil
+}
+
+type runHeap []*run
+
+// advance moves the run of the smallest value to its next value, dropping the run at its end.
+func (h *runHeap) advance() error {
+	ok, err := (*h)[0].next()
+	if err != nil {
+		return err
+	}
+	if ok {
+		heap.Fix(h, 0)
+	} else {
+		heap.Pop(h)
+	}
+	return nil
+}
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].head < h[j].head }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
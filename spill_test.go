@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/rand/v2"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+func TestSpillSorterPercentiles(t *testing.T) {
+	ranks := []int{1, 50, 90, 99, 100}
+	for _, limit := range []int{2, 100, 5000} {
+		dir := t.TempDir()
+		s := NewSpillSorter(limit, dir)
+		rng := rand.New(rand.NewPCG(1, 2))
+		var values []float64
+		// more runs than spillFanIn with the smallest limit, so they are merged in several passes
+		for range 3000 {
+			v := rng.Float64()
+			values = append(values, v)
+			if err := s.Add(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := s.Percentiles(ranks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := querystats.PercentilesOf(ranks, slices.Clone(values))
+		for _, p := range ranks {
+			if got[p] != want[p] {
+				t.Errorf("limit %d: p%d = %v, want %v", limit, p, got[p], want[p])
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if files, _ := os.ReadDir(dir); len(files) != 0 {
+			t.Errorf("limit %d: %d temporary files left", limit, len(files))
+		}
+	}
+}
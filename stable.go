@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var stableOutput bool
+
+func init() {
+	flag.BoolVar(&stableOutput, "stable", false, "make the output deterministic, e.g. for golden-file tests: ties are broken by query text, timestamps are printed in UTC or the fixed zone of -tz, and the run metadata is omitted, as it contains the version and the time of the run. Relative -from and -to still depend on the time of the run")
+}
+
+// validateStable rejects settings whose output depends on the machine or the run, and drops the run metadata.
+func validateStable() {
+	if displayLocation == time.Local || heatmapTZ == "local" {
+		fatal("-stable can't be used with -tz local or -heatmap-tz local, use a fixed time zone")
+	}
+	if sampleSeed == 0 {
+		fatal("-stable can't be used with -sample-seed 0, which picks a random seed")
+	}
+	embedRunMetadata = false
+}
+
+// stabilize orders the queries by their text and the entries by time, ties in the order of the queries and then of
+// the log, instead of the order of a map GroupQueries returns them in. Stable sorts of both then yield the same
+// output on every run.
+func stabilize(queries []*querystats.Query) querystats.LogEntries {
+	sort.SliceStable(queries, func(i, j int) bool { return queries[i].Query < queries[j].Query })
+	var logs querystats.LogEntries
+	for _, q := range queries {
+		logs = append(logs, q.Logs...)
+	}
+	sort.Stable(querystats.ByTime{LogEntries: logs})
+	return logs
+}
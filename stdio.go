@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// The stdio server speaks JSON-RPC 2.0 with one message per line. It keeps parsed entries
+// in memory, so clients like notebooks can change filters and fetch tables without re-reading the log.
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type stdioFilters struct {
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	QueryMatch string     `json:"query_match,omitempty"`
+}
+
+type stdioTableParams struct {
+	Metric string `json:"metric"`
+	Kind   string `json:"kind"`
+	Top    int    `json:"top"`
+}
+
+type stdioTableRow struct {
+	Query     string  `json:"query"`
+	RuleGroup string  `json:"rule_group,omitempty"`
+	Count     int     `json:"count"`
+	Value     float64 `json:"value"`
+}
+
+type stdioSummary struct {
+	Entries int        `json:"entries"`
+	Queries int        `json:"queries"`
+	From    *time.Time `json:"from,omitempty"`
+	To      *time.Time `json:"to,omitempty"`
+}
+
+type stdioServer struct {
+	entries querystats.LogEntries
+	opts    querystats.LoadOptions
+	filters stdioFilters
+	queries []*querystats.Query
+	logs    querystats.LogEntries
+}
+
+func (s *stdioServer) regroup() error {
+	opts := s.opts
+	opts.From, opts.To = s.filters.From, s.filters.To
+	var re *regexp.Regexp
+	if s.filters.QueryMatch != "" {
+		var err error
+		if re, err = regexp.Compile(s.filters.QueryMatch); err != nil {
+			return err
+		}
+	}
+	filtered := make(querystats.LogEntries, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if opts.Accept(entry) && (re == nil || re.MatchString(entry.Params.Query)) {
+			filtered = append(filtered, entry)
+		}
+	}
+	queries, logs, err := querystats.GroupQueries(filtered, opts)
+	if err != nil {
+		return err
+	}
+	s.queries, s.logs = queries, logs
+	return nil
+}
+
+func (s *stdioServer) handle(method string, params json.RawMessage) (any, *rpcError) {
+	invalid := func(err error) *rpcError { return &rpcError{rpcInvalidParams, err.Error()} }
+	switch method {
+	case "setFilters":
+		var f stdioFilters
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &f); err != nil {
+				return nil, invalid(err)
+			}
+		}
+		prev := s.filters
+		s.filters = f
+		if err := s.regroup(); err != nil {
+			s.filters = prev
+			return nil, invalid(err)
+		}
+		return s.summary(), nil
+	case "getFilters":
+		return s.filters, nil
+	case "summary":
+		return s.summary(), nil
+	case "table":
+		p := stdioTableParams{Metric: "exec", Kind: "avg", Top: 10}
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, invalid(err)
+			}
+		}
+		metric, ok := Metrics[p.Metric]
+		if !ok {
+			return nil, invalid(fmt.Errorf("unknown metric %q", p.Metric))
+		}
+		kind, err := ParseTableKind(p.Kind)
+		if err != nil {
+			return nil, invalid(err)
+		}
+		SortQueries(s.queries, metric, kind)
+		rows := make([]stdioTableRow, 0, p.Top)
+		for _, q := range s.queries[:min(max(p.Top, 0), len(s.queries))] {
+			row := stdioTableRow{Query: q.Query, Count: len(q.Logs), Value: Aggregate(q, metric, kind)}
+			if q.Logs[0].RuleGroup != nil {
+				row.RuleGroup = q.Logs[0].RuleGroup.Name
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	default:
+		return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+	}
+}
+
+func (s *stdioServer) summary() stdioSummary {
+	sum := stdioSummary{Entries: len(s.logs), Queries: len(s.queries)}
+	for _, entry := range s.logs {
+		if sum.From == nil || entry.TS.Before(*sum.From) {
+			sum.From = entry.TS
+		}
+		if sum.To == nil || entry.TS.After(*sum.To) {
+			sum.To = entry.TS
+		}
+	}
+	return sum
+}
+
+// ServeStdio answers JSON-RPC requests read from r about the given entries until r is exhausted.
+// Supported methods are setFilters, getFilters, summary and table.
+func ServeStdio(r io.Reader, w io.Writer, entries querystats.LogEntries, opts querystats.LoadOptions) error {
+	s := &stdioServer{entries: entries, opts: opts, filters: stdioFilters{From: opts.From, To: opts.To}}
+	if err := s.regroup(); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for {
+		var req rpcRequest
+		err := dec.Decode(&req)
+		if err == io.EOF {
+			return nil
+		}
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+		if err != nil {
+			resp.ID = json.RawMessage("null")
+			resp.Error = &rpcError{rpcParseError, err.Error()}
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+			// the stream cannot be resynchronized after a syntax error
+			return fmt.Errorf("failed to decode request: %w", err)
+		}
+		resp.Result, resp.Error = s.handle(req.Method, req.Params)
+		if req.ID == nil {
+			// notification
+			continue
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
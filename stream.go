@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// StreamArtifact summarizes the query log into an artifact in a single pass. Unlike loading the entries,
+// memory use is bounded by the number of distinct queries rather than the size of the log, so arbitrarily
+// large logs can be processed. Percentiles are estimated with digests. With spillAfter above zero, the global
+// percentiles of the given ranks are also computed exactly with a SpillSorter per metric, which keeps at most
+// spillAfter values in memory and sorts the rest on disk.
+func StreamArtifact(r io.Reader, opts querystats.LoadOptions, source string, ranks []int, spillAfter int) (*Artifact, querystats.LoadStats, error) {
+	a := &Artifact{
+		Version:        artifactVersion,
+		Sources:        []string{source},
+		ExecTimeDigest: NewDigest(digestAccuracy),
+		SamplesDigest:  NewDigest(digestAccuracy),
+	}
+	type key struct{ query, ruleGroup string }
+	index := make(map[key]*ArtifactQuery)
+	skipped, dropped := 0, 0
+	var skipErr, spillErr error
+	var execTimes, samples *SpillSorter
+	if spillAfter > 0 {
+		execTimes, samples = NewSpillSorter(spillAfter, ""), NewSpillSorter(spillAfter, "")
+		defer execTimes.Close()
+		defer samples.Close()
+	}
+
+	stats, err := querystats.ScanLogEntries(r, opts, func(entry *querystats.LogEntry) {
+		if entry.TS == nil {
+			if opts.Strict && skipErr == nil {
+				skipErr = fmt.Errorf("entry of query %q has no timestamp", entry.Params.Query)
+			}
+			skipped++
+			return
+		}
+		k := key{query: opts.Normalizer.Normalize(entry.Params.Query)}
+		if entry.RuleGroup != nil {
+			k.ruleGroup = entry.RuleGroup.Name
+		}
+		q := index[k]
+		if q == nil && opts.MaxQueries > 0 && len(index) >= opts.MaxQueries {
+			dropped++
+			return
+		}
+
+		if a.Entries == 0 || entry.TS.Before(a.From) {
+			a.From = *entry.TS
+		}
+		if a.Entries == 0 || entry.TS.After(a.To) {
+			a.To = *entry.TS
+		}
+		a.Entries++
+		execTime := entry.Stats.Timings.ExecTotalTime
+		a.ExecTimeDigest.Add(execTime)
+		a.SamplesDigest.Add(float64(entry.Stats.Samples.TotalQueryableSamples))
+		if spillAfter > 0 && spillErr == nil {
+			if spillErr = execTimes.Add(execTime); spillErr == nil {
+				spillErr = samples.Add(float64(entry.Stats.Samples.TotalQueryableSamples))
+			}
+		}
+
+		if q == nil {
+			q = &ArtifactQuery{
+				Query:          entry.Params.Query,
+				RuleGroup:      k.ruleGroup,
+				MaxExecTime:    execTime,
+				MaxExecTimeTS:  *entry.TS,
+				ExecTimeDigest: NewDigest(digestAccuracy),
+				sumExecTime:    newSum(),
+			}
+			index[k] = q
+			a.Queries = append(a.Queries, q)
+		}
+		q.Count++
+		q.sumExecTime.Add(execTime)
+		q.SumExecTime = q.sumExecTime.Value()
+		if execTime > q.MaxExecTime {
+			q.MaxExecTime, q.MaxExecTimeTS = execTime, *entry.TS
+		}
+		q.SumSamples += int64(entry.Stats.Samples.TotalQueryableSamples)
+		q.MaxPeakSamples = max(q.MaxPeakSamples, entry.Stats.Samples.PeakSamples)
+		q.ExecTimeDigest.Add(execTime)
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+	if skipErr != nil {
+		return nil, stats, skipErr
+	}
+	if spillErr != nil {
+		return nil, stats, fmt.Errorf("failed to spill values to disk: %w", spillErr)
+	}
+	if spillAfter > 0 && a.Entries > 0 {
+		if a.execTimePercentiles, err = execTimes.Percentiles(ranks); err != nil {
+			return nil, stats, err
+		}
+		if a.samplesPercentiles, err = samples.Percentiles(ranks); err != nil {
+			return nil, stats, err
+		}
+	}
+	if skipped > 0 {
+		slog.Warn("Skipped entries without a timestamp. Use -strict to abort instead", "entries", skipped)
+	}
+	if dropped > 0 {
+		slog.Warn("Dropped entries of queries beyond the limit of distinct queries", "entries", dropped, "limit", opts.MaxQueries)
+	}
+	return a, stats, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// RuleSuggestion is a recording rule precomputing a query executed often from the HTTP API.
+type RuleSuggestion struct {
+	Record string
+	Expr   string
+	// ID, PerHour and ExecTime describe the executions of the query the rule replaces
+	ID       string
+	PerHour  float64
+	Count    int
+	ExecTime float64
+}
+
+// SuggestRecordingRules returns recording rules for the first top queries by total execution time that don't come
+// from rule groups, are executed at least minPerHour times per hour and are recordable. logs must be
+// sorted by time.
+func SuggestRecordingRules(queries []*querystats.Query, logs querystats.LogEntries, top int, minPerHour float64) []RuleSuggestion {
+	if len(logs) == 0 {
+		return nil
+	}
+	hours := logs[len(logs)-1].TS.Sub(*logs[0].TS).Hours()
+	sorted := slices.Clone(queries)
+	SortQueries(sorted, MetricExecTotalTime, TableSum)
+	var result []RuleSuggestion
+	names := make(map[string]int)
+	for _, q := range sorted {
+		if len(result) == top {
+			break
+		}
+		if q.Logs[0].RuleGroup != nil || hours <= 0 || float64(len(q.Logs))/hours < minPerHour {
+			continue
+		}
+		expr, err := parser.ParseExpr(q.Query)
+		if err != nil || !recordable(expr) {
+			continue
+		}
+		id := QueryID(q.Query)
+		name := recordingRuleName(expr, id)
+		names[name]++
+		if names[name] > 1 {
+			name += "_" + strconv.Itoa(names[name])
+		}
+		result = append(result, RuleSuggestion{
+			Record:   name,
+			Expr:     q.Query,
+			ID:       id,
+			PerHour:  float64(len(q.Logs)) / hours,
+			Count:    len(q.Logs),
+			ExecTime: q.SumExecTotalTime,
+		})
+	}
+	return result
+}
+
+// recordable reports whether the result of the expression can be stored by a recording rule. Plain selectors
+// already are series, so there is nothing to precompute, and aggregations by __name__ yield series the rule name
+// would make identical.
+func recordable(expr parser.Expr) bool {
+	if _, ok := expr.(*parser.VectorSelector); ok || expr.Type() != parser.ValueTypeVector {
+		return false
+	}
+	byName := false
+	parser.Inspect(expr, func(node parser.Node, path []parser.Node) error {
+		if a, ok := node.(*parser.AggregateExpr); ok && !a.Without && slices.Contains(a.Grouping, labels.MetricName) {
+			byName = true
+		}
+		return nil
+	})
+	return !byName
+}
+
+// invalidMetricNameChars are the characters not allowed in metric names
+var invalidMetricNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]+`)
+
+// recordingRuleName derives a name following the level:metric:operations convention of Prometheus from the
+// expression: the grouping labels of its outermost aggregation, the name of its first metric and the operations
+// applied to it from the outermost, e.g. code:http_requests:rate5m for sum by (code) (rate(http_requests_total[5m])).
+// As the convention suggests, sum is implied unless it is the only operation. Names without operations end with the
+// id of the query, so that they can't clash with scraped metrics.
+func recordingRuleName(expr parser.Expr, id string) string {
+	var level []string
+	var metric string
+	var operations []string
+	parser.Inspect(expr, func(node parser.Node, path []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.AggregateExpr:
+			if level == nil && !n.Without {
+				level = []string{}
+				for _, l := range n.Grouping {
+					// histogram_quantile consumes the buckets
+					if l != "le" || !slices.ContainsFunc(path, isHistogramQuantile) {
+						level = append(level, l)
+					}
+				}
+			}
+			operations = append(operations, n.Op.String())
+		case *parser.Call:
+			op := n.Func.Name
+			if n.Func.Name == "histogram_quantile" {
+				if phi, ok := n.Args[0].(*parser.NumberLiteral); ok {
+					op = "p" + strconv.FormatFloat(100*phi.Val, 'f', -1, 64)
+				}
+			}
+			for _, arg := range n.Args {
+				if m, ok := arg.(*parser.MatrixSelector); ok {
+					op += formatPromDuration(m.Range)
+				}
+			}
+			operations = append(operations, op)
+		case *parser.BinaryExpr:
+			if n.Op == parser.DIV {
+				operations = append(operations, "ratio")
+			} else if n.VectorMatching != nil && (n.VectorMatching.Card == parser.CardManyToOne || n.VectorMatching.Card == parser.CardOneToMany) {
+				operations = append(operations, "join")
+			}
+		case *parser.VectorSelector:
+			if metric == "" {
+				metric = n.Name
+				if metric == "" {
+					for _, m := range n.LabelMatchers {
+						if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+							metric = m.Value
+						}
+					}
+				}
+				// the convention strips _total from counters passed to rate and increase
+				if slices.ContainsFunc(operations, isCounterFunction) {
+					metric = strings.TrimSuffix(metric, "_total")
+				}
+				if slices.ContainsFunc(path, isHistogramQuantile) {
+					metric = strings.TrimSuffix(metric, "_bucket")
+				}
+			}
+		}
+		return nil
+	})
+	if metric == "" {
+		metric = "series"
+	}
+	if len(operations) > 1 {
+		operations = slices.DeleteFunc(operations, func(op string) bool { return op == "sum" })
+	}
+	if len(operations) == 0 {
+		operations = []string{"query_" + id}
+	}
+	name := metric + ":" + strings.Join(operations, "_")
+	if len(level) > 0 {
+		name = strings.Join(level, "_") + ":" + name
+	}
+	name = invalidMetricNameChars.ReplaceAllString(name, "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func isHistogramQuantile(node parser.Node) bool {
+	call, ok := node.(*parser.Call)
+	return ok && call.Func.Name == "histogram_quantile"
+}
+
+// isCounterFunction reports whether the operation is a function of counters, e.g. rate5m.
+func isCounterFunction(op string) bool {
+	for _, f := range []string{"rate", "irate", "increase"} {
+		if strings.HasPrefix(op, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteRecordingRules writes a rule file with the suggested recording rules in a group evaluated every interval.
+// Each rule is preceded by a comment with the id and the executions of the query it replaces.
+func WriteRecordingRules(w io.Writer, suggestions []RuleSuggestion, group string, interval time.Duration) error {
+	if _, err := fmt.Fprintf(w, "groups:\n  - name: %q\n    interval: %s\n    rules:\n", group, formatPromDuration(interval)); err != nil {
+		return err
+	}
+	for _, s := range suggestions {
+		_, err := fmt.Fprintf(w, "      # %s: %.1f executions per hour, %d in total taking %.3fs\n      - record: %s\n        expr: %q\n",
+			s.ID, s.PerHour, s.Count, s.ExecTime, s.Record, s.Expr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSuggestRules implements the suggest-rules subcommand writing draft recording rules for the expensive queries
+// dashboards execute often, so they can be migrated onto precomputed series.
+func runSuggestRules(args []string) {
+	fs := flag.NewFlagSet("suggest-rules", flag.ExitOnError)
+	var from, to timeFlag
+	fs.Var(&from, "from", "load log entries after this time. Accepts the same formats as -from of analyze")
+	fs.Var(&to, "to", "load log entries until this time")
+	top := fs.Int("n", 10, "maximum number of rules to suggest")
+	minPerHour := fs.Float64("min-per-hour", recordingRuleExecutionsPerHour, "suggest rules only for queries executed at least this many times per hour")
+	group := fs.String("group", "prom-query-stats-suggestions", "name of the rule group")
+	interval := fs.Duration("interval", recordingRuleInterval, "evaluation interval of the rule group")
+	match := fs.String("query-match", "", "suggest rules only for queries matching this regular expression")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s suggest-rules [flags] [file...] > rules.yml\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Writes draft recording rules for the queries from the HTTP API with the highest total execution time")
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files. Reads stdin if none is given")
+		fs.PrintDefaults()
+	}
+	files := parseArgs(fs, args)
+	if *top <= 0 || *interval <= 0 {
+		fatal("-n and -interval must be positive")
+	}
+	if *minPerHour < 0 {
+		fatal("-min-per-hour must not be negative")
+	}
+	opts := querystats.LoadOptions{
+		From:            from.Time,
+		To:              to.Time,
+		MaxEntrySize:    querystats.DefaultMaxEntrySize,
+		PercentileRanks: queryPercentileRanks,
+		Jobs:            *jobs,
+		Log:             logLoad,
+	}
+	var err error
+	if *match != "" {
+		if opts.QueryMatch, err = regexp.Compile(*match); err != nil {
+			fatalf("Invalid -query-match value: %s", err)
+		}
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if files, err = ExpandInputs(files); err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	defer closeInput()
+	queries, logs, err := querystats.LoadQueriesFromLog(input, opts)
+	if err != nil {
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	if len(queries) == 0 {
+		fatal("Loaded 0 queries")
+	}
+	sort.Sort(querystats.ByTime{LogEntries: logs})
+
+	suggestions := SuggestRecordingRules(queries, logs, *top, *minPerHour)
+	slog.Info("Suggesting recording rules", "rules", len(suggestions))
+	if err := WriteRecordingRules(os.Stdout, suggestions, *group, *interval); err != nil {
+		fatalf("Failed to write the rules: %s", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// summaryNormalizer counts the distinct queries of the summary when -normalize is not set. It only merges trivially
+// different spellings, so the count tells whether -normalize would change the tables.
+var summaryNormalizer = querystats.Normalizer{Whitespace: true, Matchers: true}
+
+// summation is how the report sums and averages over entries, set by -summation.
+var summation querystats.Summation
+
+// newSum returns an empty sum adding values with the summation of -summation.
+func newSum() querystats.KahanSum {
+	return querystats.KahanSum{Summation: summation}
+}
+
+// PrintSummary prints an overview of the entries before the tables: their number and rate, the distinct queries as
+// logged and normalized, the execution time and samples they took, the failed ones and the window they cover. logs
+// must be sorted by time. The samples are left out when the log has none.
+func PrintSummary(logs querystats.LogEntries, normalizer querystats.Normalizer, samples bool) {
+	if !normalizer.Enabled() {
+		normalizer = summaryNormalizer
+	}
+	raw := make(map[string]struct{})
+	normalized := make(map[string]struct{})
+	execTime := newSum()
+	totalSamples, errors, timeouts := 0, 0, 0
+	for _, log := range logs {
+		if _, ok := raw[log.Params.Query]; !ok {
+			raw[log.Params.Query] = struct{}{}
+			normalized[normalizer.Normalize(log.Params.Query)] = struct{}{}
+		}
+		execTime.Add(log.Stats.Timings.ExecTotalTime)
+		totalSamples += log.Stats.Samples.TotalQueryableSamples
+		switch log.Outcome(timeoutProxy) {
+		case querystats.OutcomeError:
+			errors++
+		case querystats.OutcomeTimeout:
+			timeouts++
+		}
+	}
+	first, last := *logs[0].TS, *logs[len(logs)-1].TS
+	window := last.Sub(first)
+
+	fmt.Println("Summary:")
+	fmt.Printf("  Entries:           %d", len(logs))
+	if window > 0 {
+		fmt.Printf(", %.3f per second", float64(len(logs))/window.Seconds())
+	}
+	fmt.Println()
+	fmt.Printf("  Distinct queries:  %d raw, %d normalized\n", len(raw), len(normalized))
+	fmt.Printf("  Execution time:    %.3fs\n", execTime.Value())
+	if samples {
+		fmt.Printf("  Queryable samples: %d\n", totalSamples)
+	}
+	fmt.Printf("  Failed:            %d errors, %d timeouts\n", errors, timeouts)
+	fmt.Printf("  Window:            %s to %s (%s)\n", formatTime(first), formatTime(last), window.Round(time.Second))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// promqlParserVersion returns the version of the Prometheus module the PromQL parser comes from.
+func promqlParserVersion() string {
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range buildInfo.Deps {
+			if dep.Path == "github.com/prometheus/prometheus" {
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
+
+type syntaxError struct {
+	query *querystats.Query
+	err   error
+}
+
+// PrintSyntaxReport parses every distinct query with the PromQL parser and reports those that fail,
+// which points to log corruption or syntax that is no longer supported.
+func PrintSyntaxReport(queries []*querystats.Query, examples int) {
+	var failed []syntaxError
+	failedEntries := 0
+	for _, q := range queries {
+		if _, err := parser.ParseExpr(q.Query); err != nil {
+			failed = append(failed, syntaxError{q, err})
+			failedEntries += len(q.Logs)
+		}
+	}
+
+	fmt.Printf(
+		"PromQL syntax validation (parser %s): %d of %d queries failed to parse, %d entries\n",
+		promqlParserVersion(),
+		len(failed),
+		len(queries),
+		failedEntries,
+	)
+	sort.Slice(failed, func(i, j int) bool { return len(failed[i].query.Logs) > len(failed[j].query.Logs) })
+	for i, f := range failed[:min(examples, len(failed))] {
+		fmt.Printf("%2d) n=%-6d %s\n    error: %s\n", i+1, len(f.query.Logs), queryWithID(f.query.Query), escapeTerminal(f.err.Error()))
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// queryWidth is the number of terminal columns the query column of tables is truncated and padded to, so the
+// columns after it line up. 0 means no limit
+var queryWidth int
+
+// sparklines adds the spark column to the default columns of the top tables
+var sparklines bool
+
+// sparklineWidth is the number of buckets of the spark column
+const sparklineWidth = 20
+
+const sparklinesUsage = "add a sparkline of the metric of each top table over the executions of each query, from its first to its last, to tell steady degradation from one-off spikes. Same as adding the spark column"
+
+func init() {
+	flag.IntVar(&queryWidth, "query-width", 0, "truncate queries in the top tables to this many terminal columns and pad shorter ones, so the columns after them line up. Wide characters such as CJK and emoji count as two columns. 0 means no limit")
+	flag.BoolVar(&sparklines, "sparklines", false, sparklinesUsage)
+}
+
+// fitQueryWidth truncates and pads an escaped query to queryWidth columns.
+func fitQueryWidth(query string) string {
+	if queryWidth <= 0 || fullQuery {
+		return query
+	}
+	query = querystats.TruncateWidth(query, queryWidth)
+	return query + strings.Repeat(" ", max(queryWidth-querystats.DisplayWidth(query), 0))
+}
+
+// Metric is a per-entry value queries can be ranked by.
+type Metric struct {
+	// Name identifies the metric in flags and machine-readable output
+	Name  string
+	Title string
+	Unit  string
+	// Int is set for metrics that are counts, so they are printed without a fractional part
+	Int   bool
+	Value func(e *querystats.LogEntry) float64
+}
+
+func (m Metric) Format(v float64) string {
+	if m.Int {
+		return strconv.FormatFloat(v, 'f', 0, 64) + m.Unit
+	}
+	return strconv.FormatFloat(v, 'f', 3, 64) + m.Unit
+}
+
+// Values returns the values of the metric for all executions of the query.
+func (m Metric) Values(q *querystats.Query) []float64 {
+	vals := make([]float64, 0, len(q.Logs))
+	for _, log := range q.Logs {
+		vals = append(vals, m.Value(log))
+	}
+	return vals
+}
+
+// MaxEntry returns the first execution of the query with the highest value of the metric.
+func (m Metric) MaxEntry(q *querystats.Query) *querystats.LogEntry {
+	maxEntry := q.Logs[0]
+	for _, log := range q.Logs[1:] {
+		if m.Value(log) > m.Value(maxEntry) {
+			maxEntry = log
+		}
+	}
+	return maxEntry
+}
+
+var (
+	MetricExecTotalTime         = Metric{"exec", "execution time", "s", false, func(e *querystats.LogEntry) float64 { return e.Stats.Timings.ExecTotalTime }}
+	MetricTotalQueryableSamples = Metric{"samples", "total queryable samples", "", true, func(e *querystats.LogEntry) float64 { return float64(e.Stats.Samples.TotalQueryableSamples) }}
+	MetricPeakSamples           = Metric{"peak", "peak samples", "", true, func(e *querystats.LogEntry) float64 { return float64(e.Stats.Samples.PeakSamples) }}
+	MetricQueueTime             = Metric{"queue", "queue time", "s", false, func(e *querystats.LogEntry) float64 { return e.Stats.Timings.ExecQueueTime }}
+	MetricPreparationTime       = Metric{"prep", "query preparation time", "s", false, func(e *querystats.LogEntry) float64 { return e.Stats.Timings.QueryPreparationTime }}
+	MetricInnerEvalTime         = Metric{"eval", "inner evaluation time", "s", false, func(e *querystats.LogEntry) float64 { return e.Stats.Timings.InnerEvalTime }}
+	MetricResultSortTime        = Metric{"sort", "result sort time", "s", false, func(e *querystats.LogEntry) float64 { return e.Stats.Timings.ResultSortTime }}
+)
+
+// PhaseMetrics are the phases the execution time of a query is spent in.
+var PhaseMetrics = []Metric{MetricQueueTime, MetricPreparationTime, MetricInnerEvalTime, MetricResultSortTime}
+
+// TableKind is the aggregation a table ranks queries by.
+type TableKind int
+
+const (
+	TableAvg TableKind = iota
+	TableMax
+	TableSum
+	TableMin
+	// TableStdDev ranks queries by the standard deviation over their executions, i.e. how erratic they are
+	TableStdDev
+	// tablePercentile is the base of the kinds ranking queries by a percentile over their executions
+	tablePercentile TableKind = 1000
+)
+
+// PercentileTable returns the table kind ranking queries by the p-th percentile over their executions.
+func PercentileTable(p int) TableKind {
+	return tablePercentile + TableKind(p)
+}
+
+// Rank returns the percentile rank of percentile table kinds and 0 otherwise.
+func (k TableKind) Rank() int {
+	if k > tablePercentile {
+		return int(k - tablePercentile)
+	}
+	return 0
+}
+
+var tableKindTitles = map[TableKind]string{TableAvg: "average", TableMax: "max", TableSum: "total", TableMin: "min", TableStdDev: "standard deviation of"}
+
+func (k TableKind) Title() string {
+	if p := k.Rank(); p > 0 {
+		return fmt.Sprintf("p%d", p)
+	}
+	return tableKindTitles[k]
+}
+
+// tableTitle returns the title of a table ranking queries by the kind of aggregation of the metric.
+func tableTitle(metric Metric, kind TableKind) string {
+	if kind == TableSum && strings.HasPrefix(metric.Title, "total ") {
+		return metric.Title
+	}
+	return kind.Title() + " " + metric.Title
+}
+
+// Name returns the name of the kind accepted by ParseTableKind.
+func (k TableKind) Name() string {
+	if p := k.Rank(); p > 0 {
+		return fmt.Sprintf("p%d", p)
+	}
+	for name, kind := range TableKinds {
+		if kind == k {
+			return name
+		}
+	}
+	return ""
+}
+
+// ParseTableKind parses avg, max, sum, min, stddev, median or pNN, e.g. p99. median is p50.
+func ParseTableKind(name string) (TableKind, error) {
+	if k, ok := TableKinds[name]; ok {
+		return k, nil
+	}
+	if p, err := strconv.Atoi(strings.TrimPrefix(name, "p")); err == nil && strings.HasPrefix(name, "p") && p > 0 && p <= 100 {
+		return PercentileTable(p), nil
+	}
+	return 0, fmt.Errorf("unknown table kind %q", name)
+}
+
+type tableRow struct {
+	query  *querystats.Query
+	metric Metric
+	kind   TableKind
+	// labeled makes numeric columns print with their name, e.g. "avg=0.100s"
+	labeled bool
+}
+
+// Column renders a single field of a table row. Empty output omits the field.
+type Column func(r tableRow) string
+
+func labeled(r tableRow, name, value string) string {
+	if r.labeled {
+		return name + "=" + value
+	}
+	return value
+}
+
+var tableColumns = map[string]Column{
+	"n": func(r tableRow) string {
+		return fmt.Sprintf("n=%-6d", len(r.query.Logs))
+	},
+	"avg": func(r tableRow) string {
+		return labeled(r, "avg", strconv.FormatFloat(querystats.AvgWith(summation, r.metric.Values(r.query)), 'f', 3, 64)+r.metric.Unit)
+	},
+	"tavg": func(r tableRow) string {
+		return "tavg=" + strconv.FormatFloat(TimeWeightedAvg(r.query, r.metric, timeWeightBucket), 'f', 3, 64) + r.metric.Unit
+	},
+	"trate": func(r tableRow) string {
+		return "trate=" + strconv.FormatFloat(TimeWeightedMetricRate(r.query, r.metric, timeWeightBucket), 'f', 3, 64) + r.metric.Unit + "/s"
+	},
+	"max": func(r tableRow) string {
+		return labeled(r, "max", r.metric.Format(r.metric.Value(r.metric.MaxEntry(r.query))))
+	},
+	"min": func(r tableRow) string {
+		return labeled(r, "min", r.metric.Format(Aggregate(r.query, r.metric, TableMin)))
+	},
+	"median": func(r tableRow) string {
+		return labeled(r, "median", r.metric.Format(queryPercentile(r.query, r.metric, 50)))
+	},
+	"stddev": func(r tableRow) string {
+		return "stddev=" + strconv.FormatFloat(Aggregate(r.query, r.metric, TableStdDev), 'f', 3, 64) + r.metric.Unit
+	},
+	"sum": func(r tableRow) string {
+		var sum float64
+		for _, v := range r.metric.Values(r.query) {
+			sum += v
+		}
+		return labeled(r, "sum", r.metric.Format(sum))
+	},
+	"t": func(r tableRow) string {
+		return "t=" + formatTime(*r.metric.MaxEntry(r.query).TS)
+	},
+	"id": func(r tableRow) string {
+		return QueryID(r.query.Query)
+	},
+	"query": func(r tableRow) string {
+		return fitQueryWidth(escapeTerminal(r.query.Query))
+	},
+	"rule": func(r tableRow) string {
+		if r.query.Logs[0].RuleGroup == nil {
+			return ""
+		}
+		return fmt.Sprintf("| ruleName=\"%s\"", escapeTerminal(r.query.Logs[0].RuleGroup.Name))
+	},
+	"cost": func(r tableRow) string {
+		if !costModel.Enabled() {
+			return ""
+		}
+		// max tables show a single execution, so its cost is shown instead of the total
+		if r.kind == TableMax {
+			return fmt.Sprintf("| cost=%.2f", costModel.EntryCost(r.metric.MaxEntry(r.query)))
+		}
+		return fmt.Sprintf("| cost=%.2f", costModel.QueryCost(r.query))
+	},
+	"trend": trendColumn,
+	"spark": sparkColumn,
+	"phase": func(r tableRow) string {
+		return "phase=" + dominantPhaseName(r.query)
+	},
+}
+
+// sparkColumn renders the average of the metric over the executions of the query in sparklineWidth buckets between
+// its first and last execution, scaled to the highest bucket.
+// printRuleName prints the rule group of the query after it, if the query is evaluated by a rule.
+func printRuleName(q *querystats.Query) {
+	if rg := q.Logs[0].RuleGroup; rg != nil {
+		printRuleGroupName(rg.Name)
+	}
+}
+
+// printRuleGroupName prints the rule group name after a query, if it is not empty.
+func printRuleGroupName(name string) {
+	if name != "" {
+		fmt.Printf(" | ruleName=\"%s\"", escapeTerminal(name))
+	}
+}
+
+func sparkColumn(r tableRow) string {
+	from, to := *r.query.Logs[0].TS, *r.query.Logs[0].TS
+	for _, log := range r.query.Logs {
+		if log.TS.Before(from) {
+			from = *log.TS
+		}
+		if log.TS.After(to) {
+			to = *log.TS
+		}
+	}
+	timeline := metricTimeline(r.query, r.metric, from, to, sparklineWidth)
+	return "|" + sparkline(timeline, slices.Max(timeline)) + "|"
+}
+
+// percentileColumn renders the p-th percentile of the metric over executions of the query.
+func percentileColumn(p int) Column {
+	return func(r tableRow) string {
+		return fmt.Sprintf("p%d=%s", p, r.metric.Format(queryPercentile(r.query, r.metric, p)))
+	}
+}
+
+var defaultTableColumns = map[TableKind][]string{
+	TableAvg: {"n", "avg", "id", "query", "rule", "cost", "trend"},
+	TableMax: {"t", "max", "id", "query", "rule", "cost", "trend"},
+	TableSum: {"n", "sum", "id", "query", "rule", "trend"},
+	TableMin: {"n", "min", "id", "query", "rule", "cost", "trend"},
+	// the average tells whether a deviation is large
+	TableStdDev: {"n", "avg", "stddev", "id", "query", "rule", "trend"},
+}
+
+func (k TableKind) defaultColumns() []string {
+	columns := defaultTableColumns[k]
+	if p := k.Rank(); p > 0 {
+		columns = []string{"n", fmt.Sprintf("p%d", p), "id", "query", "rule", "cost", "trend"}
+	}
+	if sparklines {
+		i := slices.Index(columns, "id")
+		columns = slices.Insert(slices.Clone(columns), i, "spark")
+	}
+	return columns
+}
+
+// ParseColumns parses a comma-separated list of column names. pNN selects the NN-th percentile.
+func ParseColumns(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	columns := strings.Split(value, ",")
+	for i, name := range columns {
+		name = strings.TrimSpace(name)
+		columns[i] = name
+		if _, ok := tableColumns[name]; ok {
+			continue
+		}
+		if p, err := strconv.Atoi(strings.TrimPrefix(name, "p")); err == nil && strings.HasPrefix(name, "p") && p > 0 && p <= 100 {
+			continue
+		}
+		return nil, fmt.Errorf("unknown column %q", name)
+	}
+	return columns, nil
+}
+
+// PrintTable prints the first top queries ranked by the given aggregation of the metric.
+// The queries must be sorted already. If columns is empty, the default columns of the table kind are used.
+func PrintTable(queries []*querystats.Query, top int, metric Metric, kind TableKind, columns []string) {
+	printTable("Top", queries, top, metric, kind, columns)
+}
+
+// printTable is PrintTable with the first word of the title, e.g. "Bottom" for queries sorted in ascending order.
+func printTable(order string, queries []*querystats.Query, top int, metric Metric, kind TableKind, columns []string) {
+	title := tableTitle(metric, kind)
+	labeledColumns := len(columns) > 0
+	if !labeledColumns {
+		columns = kind.defaultColumns()
+	}
+	top = min(top, len(queries))
+
+	rows := make([][]string, top)
+	widths := make([]int, len(columns))
+	for i, query := range queries[:top] {
+		row := tableRow{query, metric, kind, labeledColumns}
+		rows[i] = make([]string, len(columns))
+		for j, name := range columns {
+			column, ok := tableColumns[name]
+			if !ok {
+				p, _ := strconv.Atoi(strings.TrimPrefix(name, "p"))
+				column = percentileColumn(p)
+			}
+			rows[i][j] = column(row)
+			widths[j] = max(widths[j], querystats.DisplayWidth(rows[i][j]))
+		}
+	}
+
+	// columns are aligned up to the query, and after it too if queries are padded to -query-width
+	queryColumn := slices.Index(columns, "query")
+	aligned := queryColumn < 0 || (queryWidth > 0 && !fullQuery)
+	termWidth := terminalWidth()
+	color := useColor()
+	fmt.Printf("%s %d queries by %s:\n", order, top, title)
+	for i, row := range rows {
+		fields := make([]string, 0, len(columns))
+		query := -1
+		for j, field := range row {
+			if j < len(columns)-1 && (j < queryColumn || aligned) && widths[j] > 0 {
+				field += strings.Repeat(" ", widths[j]-querystats.DisplayWidth(field))
+			} else if field == "" {
+				continue
+			}
+			if j == queryColumn {
+				query = len(fields)
+			}
+			fields = append(fields, field)
+		}
+		line := fmt.Sprintf("%2d) %s", i+1, strings.Join(fields, " "))
+		if termWidth > 0 && query >= 0 {
+			// truncate the query so the row fits the terminal
+			rest := querystats.DisplayWidth(line) - querystats.DisplayWidth(fields[query])
+			fields[query] = querystats.TruncateWidth(fields[query], max(termWidth-rest, minFittedQueryWidth))
+			line = fmt.Sprintf("%2d) %s", i+1, strings.Join(fields, " "))
+		}
+		if color {
+			line = severityLevels.Severity(queries[i], metric, kind).Colorize(line)
+		}
+		fmt.Println(line)
+	}
+}
+
+// Aggregate returns the value queries are ranked by in a table of the given kind.
+func Aggregate(q *querystats.Query, m Metric, kind TableKind) float64 {
+	if p := kind.Rank(); p > 0 {
+		return queryPercentile(q, m, p)
+	}
+	switch kind {
+	case TableMax:
+		return m.Value(m.MaxEntry(q))
+	case TableSum:
+		var sum float64
+		for _, v := range m.Values(q) {
+			sum += v
+		}
+		return sum
+	case TableMin:
+		return slices.Min(m.Values(q))
+	case TableStdDev:
+		return querystats.StdDev(m.Values(q))
+	default:
+		return querystats.AvgWith(summation, m.Values(q))
+	}
+}
+
+// SortQueries sorts queries in descending order of the aggregated metric.
+func SortQueries(queries []*querystats.Query, m Metric, kind TableKind) {
+	values := make(map[*querystats.Query]float64, len(queries))
+	for _, q := range queries {
+		values[q] = Aggregate(q, m, kind)
+	}
+	sort.SliceStable(queries, func(i, j int) bool { return values[queries[i]] > values[queries[j]] })
+}
+
+// Metrics maps names accepted on the command line to metrics.
+var Metrics = map[string]Metric{
+	MetricExecTotalTime.Name:         MetricExecTotalTime,
+	MetricTotalQueryableSamples.Name: MetricTotalQueryableSamples,
+	MetricPeakSamples.Name:           MetricPeakSamples,
+	MetricPoints.Name:                MetricPoints,
+	MetricRange.Name:                 MetricRange,
+	MetricStep.Name:                  MetricStep,
+	MetricQueueTime.Name:             MetricQueueTime,
+	MetricPreparationTime.Name:       MetricPreparationTime,
+	MetricInnerEvalTime.Name:         MetricInnerEvalTime,
+	MetricResultSortTime.Name:        MetricResultSortTime,
+}
+
+var TableKinds = map[string]TableKind{
+	"avg":    TableAvg,
+	"max":    TableMax,
+	"sum":    TableSum,
+	"min":    TableMin,
+	"stddev": TableStdDev,
+	"median": PercentileTable(50),
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+var (
+	topTalkers     bool
+	clientNetworks string
+	clientPrefix   int
+	clientPrefix6  int
+)
+
+func init() {
+	flag.BoolVar(&topTalkers, "top-talkers", false, "report the clients of the HTTP API by estimated cost, or execution time without a cost model. Client IPs are rolled up by -client-networks and -client-prefix")
+	flag.StringVar(&clientNetworks, "client-networks", "", "path to a file with lines of the form '<cidr> <name>', e.g. '10.8.0.0/16 office', naming networks of clients for -top-talkers. The most specific network wins. Implies -top-talkers")
+	flag.IntVar(&clientPrefix, "client-prefix", 32, "roll up IPv4 clients not in -client-networks by subnets of this prefix length, e.g. 24")
+	flag.IntVar(&clientPrefix6, "client-prefix6", 128, "roll up IPv6 clients not in -client-networks by subnets of this prefix length, e.g. 64")
+}
+
+// ClientNetwork is a named network of clients.
+type ClientNetwork struct {
+	Prefix netip.Prefix
+	Name   string
+}
+
+// ClientNetworks are sorted from the most to the least specific network.
+type ClientNetworks []ClientNetwork
+
+// LoadClientNetworks reads a mapping file where each line has the form "<cidr> <name>".
+// Empty lines and lines starting with '#' are ignored.
+func LoadClientNetworks(r io.Reader) (ClientNetworks, error) {
+	var networks ClientNetworks
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected 2 fields, got %d", lineNum, len(fields))
+		}
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		networks = append(networks, ClientNetwork{prefix.Masked(), fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(networks, func(i, j int) bool { return networks[i].Prefix.Bits() > networks[j].Prefix.Bits() })
+	return networks, nil
+}
+
+func loadClientNetworksFile(name string) (ClientNetworks, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadClientNetworks(file)
+}
+
+// Network returns the name of the most specific network containing addr, or its subnet of the given
+// prefix lengths if there is none.
+func (n ClientNetworks) Network(addr netip.Addr, prefix4, prefix6 int) string {
+	addr = addr.Unmap()
+	for _, network := range n {
+		if network.Prefix.Contains(addr) {
+			return network.Name
+		}
+	}
+	bits := prefix6
+	if addr.Is4() {
+		bits = prefix4
+	}
+	if bits >= addr.BitLen() {
+		return addr.String()
+	}
+	subnet, _ := addr.Prefix(bits)
+	return subnet.String()
+}
+
+// Talker is the load caused by the clients of a network.
+type Talker struct {
+	Network               string
+	Clients               int
+	Entries               int
+	ExecTotalTime         float64
+	TotalQueryableSamples int
+	execTotalTime         querystats.KahanSum
+	clients               map[netip.Addr]struct{}
+}
+
+// TopTalkers sums up the engine time and samples per client network. It also returns the number of entries
+// without a valid client IP, e.g. rule evaluations.
+func TopTalkers(networks ClientNetworks, logs querystats.LogEntries, prefix4, prefix6 int) ([]*Talker, int) {
+	talkers := make(map[string]*Talker)
+	unknown := 0
+	for _, entry := range logs {
+		if entry.HTTPRequest == nil {
+			unknown++
+			continue
+		}
+		addr, err := netip.ParseAddr(entry.HTTPRequest.ClientIP)
+		if err != nil {
+			unknown++
+			continue
+		}
+		name := networks.Network(addr, prefix4, prefix6)
+		t, ok := talkers[name]
+		if !ok {
+			t = &Talker{Network: name, execTotalTime: newSum(), clients: make(map[netip.Addr]struct{})}
+			talkers[name] = t
+		}
+		t.Entries++
+		t.execTotalTime.Add(entry.Stats.Timings.ExecTotalTime)
+		t.TotalQueryableSamples += entry.Stats.Samples.TotalQueryableSamples
+		t.clients[addr] = struct{}{}
+	}
+
+	result := make([]*Talker, 0, len(talkers))
+	for _, t := range talkers {
+		t.ExecTotalTime = t.execTotalTime.Value()
+		t.Clients = len(t.clients)
+		result = append(result, t)
+	}
+	return result, unknown
+}
+
+// PrintTopTalkers prints the client networks ordered by the estimated cost of their queries, or by their
+// execution time if the cost model isn't enabled.
+func PrintTopTalkers(talkers []*Talker, unknown, top int, cost CostModel) {
+	value := func(t *Talker) float64 { return t.ExecTotalTime }
+	by := "total execution time"
+	if cost.Enabled() {
+		value = func(t *Talker) float64 { return cost.Cost(t.ExecTotalTime, t.TotalQueryableSamples) }
+		by = "estimated cost"
+	}
+	sort.Slice(talkers, func(i, j int) bool {
+		if vi, vj := value(talkers[i]), value(talkers[j]); vi != vj {
+			return vi > vj
+		}
+		return talkers[i].Network < talkers[j].Network
+	})
+
+	fmt.Printf("Top %d client networks by %s", min(top, len(talkers)), by)
+	if unknown > 0 {
+		fmt.Printf(" (%d entries without a client IP, e.g. rule evaluations, are not counted)", unknown)
+	}
+	fmt.Println(":")
+	for i, t := range talkers[:min(top, len(talkers))] {
+		fmt.Printf("%2d) n=%-6d clients=%-4d exec=%.3fs samples=%d", i+1, t.Entries, t.Clients, t.ExecTotalTime, t.TotalQueryableSamples)
+		if cost.Enabled() {
+			fmt.Printf(" cost=%.2f", cost.Cost(t.ExecTotalTime, t.TotalQueryableSamples))
+		}
+		fmt.Printf(" %s\n", escapeTerminal(t.Network))
+	}
+}
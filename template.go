@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// argTemplate is the -template flag, the text of the template or @ and the path of a file holding it.
+var argTemplate string
+
+// queryTemplate is the parsed -template, nil if not set.
+var queryTemplate *template.Template
+
+func init() {
+	flag.StringVar(&argTemplate, "template", "", "Go text/template executed for each distinct query, ordered by total execution time, instead of printing the report, e.g. '{{.Count}} {{printf \"%.3f\" .AvgExecTotalTime}} {{.Query}}'. The fields are those of the queries of -o json. @path reads the template from a file. A newline is appended to each query's output if missing")
+}
+
+// queryTemplateFuncs are the functions available to -template in addition to the builtins of text/template.
+var queryTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"quote": strconv.Quote,
+	// shellquote quotes the text as a single argument of a POSIX shell, e.g. for generated commands
+	"shellquote": func(s string) string { return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'" },
+	"join":       strings.Join,
+}
+
+// ParseQueryTemplate parses the value of -template.
+func ParseQueryTemplate(value string) (*template.Template, error) {
+	text := value
+	if path, ok := strings.CutPrefix(value, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	return template.New("query").Funcs(queryTemplateFuncs).Parse(text)
+}
+
+// WriteQueryTemplate executes the template for each query, ordered by total execution time, over the statistics of
+// the query in the JSON report.
+func WriteQueryTemplate(w io.Writer, tmpl *template.Template, queries []*querystats.Query, perc int, links QueryLinks) error {
+	sorted := make([]*querystats.Query, len(queries))
+	copy(sorted, queries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].SumExecTotalTime > sorted[j].SumExecTotalTime })
+
+	bw := bufio.NewWriter(w)
+	var buf bytes.Buffer
+	for _, q := range sorted {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, NewQueryStats(q, perc, links)); err != nil {
+			return fmt.Errorf("query %s: %w", QueryID(q.Query), err)
+		}
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		bw.Write(buf.Bytes())
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// minFittedQueryWidth is the fewest terminal columns queries are truncated to when fitting rows to the terminal.
+const minFittedQueryWidth = 20
+
+var (
+	colorMode string
+	fullQuery bool
+)
+
+func init() {
+	flag.StringVar(&colorMode, "color", "auto", "color the rows of the top tables by severity, see -severity, and the cells of -heatmap: auto, if stdout is a terminal and NO_COLOR isn't set, always or never")
+	flag.BoolVar(&fullQuery, "full-query", false, "print queries in the top tables in full. By default they are truncated so rows fit the terminal if stdout is one, or to -query-width")
+	flag.Var(&severityLevels, "severity", "comma-separated warning and critical levels of metrics coloring the rows of the top tables yellow and red, e.g. exec=1s:10s. Totals are not colored. Rows breaching a -fail-if-* threshold are red as well")
+}
+
+// ValidateColorMode checks the -color flag.
+func ValidateColorMode() error {
+	switch colorMode {
+	case "auto", "always", "never":
+		return nil
+	}
+	return fmt.Errorf("-color must be auto, always or never")
+}
+
+// useColor reports whether to print ANSI colors by -color.
+func useColor() bool {
+	switch colorMode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return term.IsTerminal(os.Stdout.Fd())
+}
+
+// terminalWidth returns the width of the terminal rows of the top tables are fitted to, or 0 if they are not
+// fitted because of -full-query or -query-width or because stdout isn't a terminal.
+func terminalWidth() int {
+	if fullQuery || queryWidth > 0 || !term.IsTerminal(os.Stdout.Fd()) {
+		return 0
+	}
+	width, _, err := term.GetSize(os.Stdout.Fd())
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+// Severity is how alarming a value in a table is.
+type Severity int
+
+const (
+	SeverityOK Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+// severityColors are the ANSI escape sequences of severities, yellow and red.
+var severityColors = map[Severity]string{SeverityWarning: "\x1b[33m", SeverityCritical: "\x1b[31m"}
+
+// Colorize wraps the text in the color of the severity, if any.
+func (s Severity) Colorize(text string) string {
+	if color, ok := severityColors[s]; ok {
+		return color + text + "\x1b[0m"
+	}
+	return text
+}
+
+// SeverityLevels are the warning and critical levels of metrics by metric name, the -severity flag.
+type SeverityLevels map[string][2]float64
+
+var severityLevels = SeverityLevels{
+	MetricExecTotalTime.Name:         {1, 10},
+	MetricTotalQueryableSamples.Name: {1e7, 1e8},
+	MetricPeakSamples.Name:           {1e6, 1e7},
+}
+
+func (l *SeverityLevels) String() string {
+	if l == nil {
+		return ""
+	}
+	var levels []string
+	for name, v := range *l {
+		m := Metrics[name]
+		levels = append(levels, fmt.Sprintf("%s=%s:%s", name, formatBound(m, v[0]), formatBound(m, v[1])))
+	}
+	sort.Strings(levels)
+	return strings.Join(levels, ",")
+}
+
+// Set parses metric=warning:critical pairs. Levels of metrics in seconds can also be durations, e.g. 500ms. Metrics
+// that are not listed keep their default levels.
+func (l *SeverityLevels) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		name, levels, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return fmt.Errorf("%q is not of the form metric=warning:critical", pair)
+		}
+		m, ok := Metrics[name]
+		if !ok {
+			return fmt.Errorf("unknown metric %q", name)
+		}
+		warning, critical, ok := strings.Cut(levels, ":")
+		if !ok {
+			return fmt.Errorf("%q is not of the form metric=warning:critical", pair)
+		}
+		var v [2]float64
+		for i, s := range []string{warning, critical} {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil && m.Unit == "s" {
+				var d time.Duration
+				if d, err = time.ParseDuration(s); err == nil {
+					f = d.Seconds()
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("invalid level %q of %s", s, name)
+			}
+			v[i] = f
+		}
+		if v[0] > v[1] {
+			return fmt.Errorf("the warning level of %s exceeds the critical level", name)
+		}
+		(*l)[name] = v
+	}
+	return nil
+}
+
+// Severity returns the severity of a query ranked by the aggregation of the metric: critical if it breaches a
+// -fail-if-* threshold, else by the levels of the metric. Totals grow with the analyzed window, so they are only
+// judged by thresholds.
+func (l SeverityLevels) Severity(q *querystats.Query, m Metric, kind TableKind) Severity {
+	value := Aggregate(q, m, kind)
+	for _, t := range thresholds {
+		if t.IsSet && t.Metric.Name == m.Name && t.Kind == kind && value > t.Limit {
+			return SeverityCritical
+		}
+	}
+	levels, ok := l[m.Name]
+	switch {
+	case !ok || kind == TableSum:
+		return SeverityOK
+	case value >= levels[1]:
+		return SeverityCritical
+	case value >= levels[0]:
+		return SeverityWarning
+	}
+	return SeverityOK
+}
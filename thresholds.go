@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// thresholdExitCode is the exit status of runs breaching a -fail-if-* threshold. It differs from the status 1 of
+// invalid flags and failed runs, so CI can tell a regression from a broken job.
+const thresholdExitCode = 3
+
+// maxListedBreaches is the number of queries listed per breached threshold.
+const maxListedBreaches = 10
+
+// Threshold is the value of a flag limiting the aggregate of a metric, e.g. -fail-if-<kind>-<metric>, which fails
+// the run if the aggregate over the executions of any query exceeds Limit. The -notify-* flags of tail and the
+// flags of alert-rules are thresholds too.
+type Threshold struct {
+	Name   string
+	Metric Metric
+	Kind   TableKind
+	Limit  float64
+	IsSet  bool
+}
+
+// NewThreshold returns an unset threshold of the flag name on the aggregate of the metric.
+func NewThreshold(name string, m Metric, kind TableKind) *Threshold {
+	return &Threshold{Name: name, Metric: m, Kind: kind}
+}
+
+// WithDefault sets the limit the threshold has if its flag is not passed.
+func (t *Threshold) WithDefault(limit float64) *Threshold {
+	t.Limit, t.IsSet = limit, true
+	return t
+}
+
+func (t *Threshold) String() string {
+	if t == nil || !t.IsSet {
+		return ""
+	}
+	return strconv.FormatFloat(t.Limit, 'f', -1, 64) + t.Metric.Unit
+}
+
+// Exceeded reports whether the threshold is set and v exceeds it.
+func (t *Threshold) Exceeded(v float64) bool {
+	return t.IsSet && v > t.Limit
+}
+
+// Set parses a number or, for metrics in seconds, also a duration such as 2s or 500ms.
+func (t *Threshold) Set(value string) error {
+	limit, err := strconv.ParseFloat(value, 64)
+	if err != nil && t.Metric.Unit == "s" {
+		var d time.Duration
+		if d, err = time.ParseDuration(value); err == nil {
+			limit = d.Seconds()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("must be a number or, for times, a duration such as 2s")
+	}
+	t.Limit, t.IsSet = limit, true
+	return nil
+}
+
+// thresholds are all -fail-if-* flags, set or not.
+var thresholds []*Threshold
+
+func init() {
+	for _, kindName := range []string{"avg", "max", "p50", "p90", "p95", "p99"} {
+		kind, _ := ParseTableKind(kindName)
+		for _, m := range []Metric{MetricExecTotalTime, MetricTotalQueryableSamples, MetricPeakSamples} {
+			t := NewThreshold("fail-if-"+kindName+"-"+m.Name, m, kind)
+			thresholds = append(thresholds, t)
+			usage := fmt.Sprintf("exit with status %d if the %s of any query exceeds this", thresholdExitCode, tableTitle(m, kind))
+			if m.Unit == "s" {
+				usage += ", e.g. 2s"
+			}
+			flag.Var(t, t.Name, usage)
+		}
+	}
+}
+
+// thresholdsSet reports whether any -fail-if-* flag is set.
+func thresholdsSet() bool {
+	for _, t := range thresholds {
+		if t.IsSet {
+			return true
+		}
+	}
+	return false
+}
+
+// thresholdsBreached is set by CheckThresholds, so analyze exits with thresholdExitCode once its output is written.
+var thresholdsBreached bool
+
+// CheckThresholds logs the queries exceeding the -fail-if-* thresholds and reports whether there are any.
+func CheckThresholds(queries []*querystats.Query) bool {
+	breached := false
+	for _, t := range thresholds {
+		if !t.IsSet {
+			continue
+		}
+		type breach struct {
+			query *querystats.Query
+			value float64
+		}
+		var breaches []breach
+		for _, q := range queries {
+			if v := Aggregate(q, t.Metric, t.Kind); t.Exceeded(v) {
+				breaches = append(breaches, breach{q, v})
+			}
+		}
+		if len(breaches) == 0 {
+			continue
+		}
+		breached = true
+		sort.Slice(breaches, func(i, j int) bool { return breaches[i].value > breaches[j].value })
+		slog.Warn("Queries exceed a threshold", "queries", len(breaches), "flag", "-"+t.Name, "limit", t.Metric.Format(t.Limit))
+		for i, b := range breaches[:min(len(breaches), maxListedBreaches)] {
+			slog.Warn("Query exceeds a threshold", "rank", i+1, "flag", "-"+t.Name, t.Kind.Name(), t.Metric.Format(b.value), "query", escapeTerminal(b.query.Query))
+		}
+	}
+	return breached
+}
+
+// exitIfThresholdsBreached ends analyze with thresholdExitCode if CheckThresholds found breaches. It is deferred,
+// so every output format is written first.
+func exitIfThresholdsBreached() {
+	if thresholdsBreached {
+		os.Exit(thresholdExitCode)
+	}
+}
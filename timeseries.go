@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// SeriesBucket holds the percentiles of the executions that started in a bucket of a percentile time series.
+// Buckets without executions have no percentiles, so plots show a gap instead of a drop to zero.
+type SeriesBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	// ExecTotalTimePercentiles and TotalQueryableSamplesPercentiles map the ranks, e.g. "p99", to the percentiles
+	ExecTotalTimePercentiles         map[string]float64 `json:"execTotalTimePercentiles,omitempty"`
+	TotalQueryableSamplesPercentiles map[string]float64 `json:"totalQueryableSamplesPercentiles,omitempty"`
+}
+
+// PercentileSeries computes the percentiles of execution time and samples of the given ranks per bucket of width
+// bucket from start to end. logs must be sorted by time and lie within the window.
+func PercentileSeries(logs querystats.LogEntries, start, end time.Time, bucket time.Duration, ranks []int) []SeriesBucket {
+	start = start.Truncate(bucket)
+	var series []SeriesBucket
+	i := 0
+	for t := start; !t.After(end); t = t.Add(bucket) {
+		b := SeriesBucket{Start: t}
+		var execTimes []float64
+		var samples []int
+		for ; i < len(logs) && logs[i].TS.Before(t.Add(bucket)); i++ {
+			execTimes = append(execTimes, logs[i].Stats.Timings.ExecTotalTime)
+			samples = append(samples, logs[i].Stats.Samples.TotalQueryableSamples)
+		}
+		b.Count = len(execTimes)
+		if b.Count > 0 {
+			b.ExecTotalTimePercentiles = make(map[string]float64, len(ranks))
+			b.TotalQueryableSamplesPercentiles = make(map[string]float64, len(ranks))
+			for p, v := range querystats.PercentilesOf(ranks, execTimes) {
+				b.ExecTotalTimePercentiles["p"+strconv.Itoa(p)] = v
+			}
+			for p, v := range querystats.PercentilesOf(ranks, samples) {
+				b.TotalQueryableSamplesPercentiles["p"+strconv.Itoa(p)] = v
+			}
+		}
+		series = append(series, b)
+	}
+	return series
+}
+
+// WriteSeriesCSV writes a row per bucket with its start, the number of executions and the percentiles of execution
+// time and samples. comma separates the fields, e.g. '\t' for TSV. Percentiles of empty buckets are empty fields.
+func WriteSeriesCSV(w io.Writer, series []SeriesBucket, ranks []int, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	header := []string{"time", "count"}
+	for _, p := range ranks {
+		header = append(header, fmt.Sprintf("p%d_exec_time_seconds", p))
+	}
+	for _, p := range ranks {
+		header = append(header, fmt.Sprintf("p%d_total_queryable_samples", p))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, b := range series {
+		record := []string{formatTime(b.Start), strconv.Itoa(b.Count)}
+		for _, p := range ranks {
+			v, ok := b.ExecTotalTimePercentiles["p"+strconv.Itoa(p)]
+			record = append(record, formatSeriesValue(v, ok, 6))
+		}
+		for _, p := range ranks {
+			v, ok := b.TotalQueryableSamplesPercentiles["p"+strconv.Itoa(p)]
+			record = append(record, formatSeriesValue(v, ok, 0))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatSeriesValue formats v with prec decimals, or returns an empty field if there is no value.
+func formatSeriesValue(v float64, ok bool, prec int) string {
+	if !ok {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// runTimeseries implements the timeseries subcommand writing how the percentiles of execution time and samples
+// evolve over the window, e.g. to plot them with gnuplot or to import them into Grafana as a CSV data source.
+func runTimeseries(args []string) {
+	fs := flag.NewFlagSet("timeseries", flag.ExitOnError)
+	var from, to timeFlag
+	fs.Var(&from, "from", "load log entries after this time. Accepts the same formats as -from of analyze")
+	fs.Var(&to, "to", "load log entries until this time")
+	bucket := fs.Duration("bucket", 5*time.Minute, "width of the buckets the percentiles are computed over")
+	ranks := percentileRanks{50, 95, 99}
+	fs.Var(&ranks, "p", "comma-separated list of the percentile ranks to compute")
+	output := fs.String("o", "csv", "output format: csv, tsv or json")
+	match := fs.String("query-match", "", "include only entries whose query matches this regular expression")
+	format := fs.String("format", "prometheus", "format of the query log, see analyze -h")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel")
+	out := addOutputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s timeseries [flags] [file...] > series.csv\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Writes the percentiles of execution time and samples of the entries per time bucket")
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files. Reads stdin if none is given")
+		fs.PrintDefaults()
+	}
+	files := parseArgs(fs, args)
+	if *bucket <= 0 {
+		fatal("-bucket must be positive")
+	}
+	switch *output {
+	case "csv", "tsv", "json":
+	default:
+		fatalf("Unknown output format %q", *output)
+	}
+	decoder, ok := querystats.LookupDecoder(*format)
+	if !ok {
+		fatalf("Unknown query log format %q", *format)
+	}
+	opts := querystats.LoadOptions{
+		From:            from.Time,
+		To:              to.Time,
+		Decoder:         &decoder,
+		MaxEntrySize:    querystats.DefaultMaxEntrySize,
+		PercentileRanks: queryPercentileRanks,
+		Jobs:            *jobs,
+		Log:             logLoad,
+	}
+	var err error
+	if *match != "" {
+		if opts.QueryMatch, err = regexp.Compile(*match); err != nil {
+			fatalf("Invalid -query-match value: %s", err)
+		}
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if files, err = ExpandInputs(files); err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	defer closeInput()
+	queries, logs, err := querystats.LoadQueriesFromLog(input, opts)
+	if err != nil {
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	if len(queries) == 0 {
+		fatal("Loaded 0 queries")
+	}
+	sort.Sort(querystats.ByTime{LogEntries: logs})
+
+	// the window spans the filters if given, so series of several runs line up
+	start, end := *logs[0].TS, *logs[len(logs)-1].TS
+	if from.Time != nil {
+		start = *from.Time
+	}
+	if to.Time != nil {
+		end = *to.Time
+	}
+	series := PercentileSeries(logs, start, end, *bucket, ranks)
+	defer startOutput(*out)()
+	switch *output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(series)
+	case "tsv":
+		err = WriteSeriesCSV(os.Stdout, series, ranks, '\t')
+	default:
+		err = WriteSeriesCSV(os.Stdout, series, ranks, ',')
+	}
+	if err != nil {
+		fatalf("Failed to write the series: %s", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// timeWeightBucket is the width of the intervals time-weighted averages are computed over.
+var timeWeightBucket time.Duration
+
+func init() {
+	flag.DurationVar(&timeWeightBucket, "time-weight-bucket", 5*time.Minute, "width of the intervals the tavg and trate columns average over")
+}
+
+// timeBucket accumulates the executions of a query in an interval of -time-weight-bucket.
+type timeBucket struct {
+	sum   querystats.KahanSum
+	count int
+}
+
+// timeBuckets groups the executions of the query into intervals of the given width and returns those with at least
+// one execution in the order of time, so sums over them don't depend on the order of a map.
+func timeBuckets(q *querystats.Query, m Metric, bucket time.Duration) []*timeBucket {
+	buckets := make(map[int64]*timeBucket)
+	for _, log := range q.Logs {
+		k := log.TS.UnixNano() / int64(bucket)
+		if buckets[k] == nil {
+			buckets[k] = &timeBucket{sum: newSum()}
+		}
+		buckets[k].sum.Add(m.Value(log))
+		buckets[k].count++
+	}
+	result := make([]*timeBucket, 0, len(buckets))
+	for _, k := range slices.Sorted(maps.Keys(buckets)) {
+		result = append(result, buckets[k])
+	}
+	return result
+}
+
+// TimeWeightedAvg returns the mean of the per-bucket averages of the metric over buckets with at least one
+// execution of the query. Unlike the average over executions, a burst of executions, e.g. while a dashboard
+// is open, weighs as much as a single execution in a quiet interval of the same length.
+func TimeWeightedAvg(q *querystats.Query, m Metric, bucket time.Duration) float64 {
+	buckets := timeBuckets(q, m, bucket)
+	sum := newSum()
+	for _, b := range buckets {
+		sum.Add(b.sum.Value() / float64(b.count))
+	}
+	return sum.Value() / float64(len(buckets))
+}
+
+// TimeWeightedRate returns the executions of the query per second while it was executed: the mean of the rates of
+// the buckets with at least one execution. Unlike the rate between its first and last execution, a query executed
+// during one busy hour of a day isn't diluted by the quiet rest of it.
+func TimeWeightedRate(q *querystats.Query, bucket time.Duration) float64 {
+	buckets := timeBuckets(q, MetricExecTotalTime, bucket)
+	return float64(len(q.Logs)) / float64(len(buckets)) / bucket.Seconds()
+}
+
+// TimeWeightedMetricRate returns the metric per second while the query was executed, e.g. the execution time per
+// second it kept the engine busy, as the mean of the per-bucket sums over buckets with at least one execution.
+func TimeWeightedMetricRate(q *querystats.Query, m Metric, bucket time.Duration) float64 {
+	buckets := timeBuckets(q, m, bucket)
+	sum := newSum()
+	for _, b := range buckets {
+		sum.Add(b.sum.Value())
+	}
+	return sum.Value() / float64(len(buckets)) / bucket.Seconds()
+}
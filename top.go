@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// runTop implements the top subcommand printing only the selected top tables, a quick look without the full report.
+func runTop(args []string) {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	var from, to timeFlag
+	fs.Var(&from, "from", "load log entries after this time. Accepts the same formats as -from of analyze")
+	fs.Var(&to, "to", "load log entries until this time")
+	by := fs.String("by", "avg-exec", "comma-separated list of tables to print, named like the -report items of analyze, e.g. max-samples or p99-exec:asc")
+	top := fs.Int("n", 10, "number of top queries to display")
+	columns := fs.String("columns", "", "comma-separated list of columns shown in the tables, see analyze -h")
+	fs.IntVar(&queryWidth, "query-width", 0, "truncate queries to this many terminal columns and pad shorter ones, see analyze -h")
+	fs.BoolVar(&fullQuery, "full-query", false, "print queries in full instead of fitting rows to the terminal")
+	fs.BoolVar(&sparklines, "sparklines", false, sparklinesUsage)
+	normalize := fs.String("normalize", "", "comma-separated list of normalizations applied to queries before grouping: whitespace, case, matchers, fingerprint")
+	match := fs.String("query-match", "", "analyze only entries whose query matches this regular expression")
+	queryID := fs.String("query-id", "", "comma-separated list of query ids, see the id column, to analyze only entries of")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of goroutines decoding the query log in parallel")
+	fs.Var(locationFlag{}, "tz", tzUsage)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s top [flags] [file...]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Inputs can be globs, directories or .gz files. Reads stdin if none is given")
+		fs.PrintDefaults()
+	}
+	files := parseArgs(fs, args)
+	if *top <= 0 {
+		fatal("-n must be positive")
+	}
+	sections, err := ParseReportSections(*by, false)
+	if err != nil {
+		fatalf("Invalid -by value: %s", err)
+	}
+	cols, err := ParseColumns(*columns)
+	if err != nil {
+		fatalf("Invalid -columns value: %s", err)
+	}
+	normalizer, err := querystats.ParseNormalizer(*normalize)
+	if err != nil {
+		fatalf("Invalid -normalize value: %s", err)
+	}
+	opts := querystats.LoadOptions{
+		From:            from.Time,
+		To:              to.Time,
+		Normalizer:      normalizer,
+		MaxEntrySize:    querystats.DefaultMaxEntrySize,
+		PercentileRanks: queryPercentileRanks,
+		Jobs:            *jobs,
+		Log:             logLoad,
+	}
+	if *match != "" {
+		if opts.QueryMatch, err = regexp.Compile(*match); err != nil {
+			fatalf("Invalid -query-match value: %s", err)
+		}
+	}
+	queryIDs, err := ParseQueryIDs(*queryID)
+	if err != nil {
+		fatalf("Invalid -query-id value: %s", err)
+	}
+	opts.QueryFilter = QueryIDFilter(queryIDs)
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if files, err = ExpandInputs(files); err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	input, closeInput, err := OpenInputs(context.Background(), files)
+	if err != nil {
+		fatalf("Failed to read the query log file: %s", err)
+	}
+	defer closeInput()
+	queries, logs, err := querystats.LoadQueriesFromLog(input, opts)
+	if err != nil {
+		fatalf("Failed to parse the query log file: %s", err)
+	}
+	if len(queries) == 0 {
+		fatal("Loaded 0 queries")
+	}
+
+	for i, s := range sections {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := PrintReportSection(s, queries, logs, *top, []int{95}, cols); err != nil {
+			fatal(err)
+		}
+	}
+}
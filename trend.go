@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// PreviousReport holds the query statistics of an earlier JSON report, so the current values can be
+// compared with them.
+type PreviousReport struct {
+	queries map[string]*QueryStats
+}
+
+// previousReport is set when -previous is passed. Trend columns are empty otherwise.
+var previousReport *PreviousReport
+
+func reportKey(query, ruleGroup string) string {
+	return ruleGroup + "\x00" + query
+}
+
+// LoadPreviousReport reads a report written with -o json.
+func LoadPreviousReport(path string) (*PreviousReport, error) {
+	report, err := readReportFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &PreviousReport{queries: make(map[string]*QueryStats, len(report.Queries))}
+	for _, s := range report.Queries {
+		p.queries[reportKey(s.Query, s.RuleGroup)] = s
+	}
+	return p, nil
+}
+
+// Value returns the value the query had in the previous report for the given table.
+// ok is false if the query or the value is missing from the previous report.
+func (p *PreviousReport) Value(q *querystats.Query, m Metric, kind TableKind) (v float64, ok bool) {
+	ruleGroup := ""
+	if q.Logs[0].RuleGroup != nil {
+		ruleGroup = q.Logs[0].RuleGroup.Name
+	}
+	s, ok := p.queries[reportKey(q.Query, ruleGroup)]
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case m.Name == MetricExecTotalTime.Name && kind.Rank() > 0:
+		v, ok = s.ExecTotalTimePercentiles[kind.Name()]
+		return v, ok
+	case m.Name == MetricTotalQueryableSamples.Name && kind.Rank() > 0:
+		v, ok = s.TotalQueryableSamplesPercentiles[kind.Name()]
+		return v, ok
+	case m.Name == MetricExecTotalTime.Name && kind == TableAvg:
+		return s.AvgExecTotalTime, true
+	case m.Name == MetricExecTotalTime.Name && kind == TableMax:
+		return s.MaxExecTotalTime, true
+	case m.Name == MetricExecTotalTime.Name && kind == TableSum:
+		return s.SumExecTotalTime, true
+	case m.Name == MetricTotalQueryableSamples.Name && kind == TableAvg:
+		return s.AvgTotalQueryableSamples, true
+	case m.Name == MetricTotalQueryableSamples.Name && kind == TableMax:
+		return float64(s.MaxTotalQueryableSamples), true
+	case m.Name == MetricTotalQueryableSamples.Name && kind == TableSum:
+		return float64(s.SumTotalQueryableSamples), true
+	case m.Name == MetricPeakSamples.Name && kind == TableAvg:
+		return s.AvgPeakSamples, true
+	case m.Name == MetricPeakSamples.Name && kind == TableMax:
+		return float64(s.MaxPeakSamples), true
+	case m.Name == "cost" && kind == TableSum && s.Cost != nil:
+		return *s.Cost, true
+	}
+	return 0, false
+}
+
+// formatTrend renders the change from prev to cur as an arrow and a percentage.
+func formatTrend(prev, cur float64) string {
+	switch {
+	case prev == cur:
+		return "="
+	case prev == 0:
+		return "▲"
+	}
+	change := (cur - prev) / prev * 100
+	arrow := "▲"
+	if change < 0 {
+		arrow = "▼"
+	}
+	return fmt.Sprintf("%s%.1f%%", arrow, math.Abs(change))
+}
+
+// trendColumn shows how the ranked value of the query changed since the previous report.
+func trendColumn(r tableRow) string {
+	if previousReport == nil {
+		return ""
+	}
+	prev, ok := previousReport.Value(r.query, r.metric, r.kind)
+	if !ok {
+		return "| new"
+	}
+	return "| " + formatTrend(prev, Aggregate(r.query, r.metric, r.kind))
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cyril-s/prom-query-stats/pkg/querystats"
+)
+
+// metricExecutions counts executions, so a sum table of it ranks queries by how often they ran.
+var metricExecutions = Metric{"executions", "executions", "", true, func(e *querystats.LogEntry) float64 { return 1 }}
+
+// tuiColumn is a numeric column of the query table of the TUI, which it can be sorted by.
+type tuiColumn struct {
+	header string
+	metric Metric
+	kind   TableKind
+}
+
+// tuiColumnWidth is the width of numeric columns, enough for a sum of samples in the billions.
+const tuiColumnWidth = 12
+
+// tuiRow is a query of the table with its aggregates precomputed, so sorting doesn't recompute percentiles.
+type tuiRow struct {
+	query  *querystats.Query
+	values []float64
+	// text is the query escaped for the terminal
+	text string
+}
+
+type tuiModel struct {
+	columns []tuiColumn
+	rows    []*tuiRow
+	// visible are the rows matching the filter in the sort order
+	visible []*tuiRow
+	sortBy  int
+	asc     bool
+
+	filter    string
+	regex     bool
+	filtering bool
+	// filterErr is set while the filter is an invalid regular expression, the previous rows stay visible
+	filterErr string
+
+	cursor, offset int
+	// detail is the row whose executions are shown instead of the table
+	detail       *tuiRow
+	detailOffset int
+
+	width, height int
+}
+
+// RunTUI browses the queries in an interactive table that is sorted, filtered and drilled into without re-running
+// the analysis. Keys are read from the terminal, so the query log can still be piped to stdin.
+func RunTUI(queries []*querystats.Query, perc int) error {
+	m := &tuiModel{
+		columns: []tuiColumn{
+			{"n", metricExecutions, TableSum},
+			{"avg exec", MetricExecTotalTime, TableAvg},
+			{fmt.Sprintf("p%d exec", perc), MetricExecTotalTime, PercentileTable(perc)},
+			{"max exec", MetricExecTotalTime, TableMax},
+			{"sum exec", MetricExecTotalTime, TableSum},
+			{"avg samples", MetricTotalQueryableSamples, TableAvg},
+			{"sum samples", MetricTotalQueryableSamples, TableSum},
+			{"max peak", MetricPeakSamples, TableMax},
+		},
+		sortBy: 1,
+		width:  120,
+		height: 30,
+	}
+	for _, q := range queries {
+		row := &tuiRow{query: q, values: make([]float64, len(m.columns)), text: escapeTerminal(q.Query)}
+		for i, c := range m.columns {
+			row.values[i] = Aggregate(q, c.metric, c.kind)
+		}
+		m.rows = append(m.rows, row)
+	}
+	m.refresh()
+	_, err := tea.NewProgram(m, tea.WithAltScreen(), tea.WithInputTTY()).Run()
+	return err
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return nil
+}
+
+// refresh filters and sorts the rows and keeps the cursor on the table.
+func (m *tuiModel) refresh() {
+	match := func(r *tuiRow) bool { return strings.Contains(r.query.Query, m.filter) }
+	m.filterErr = ""
+	if m.regex && m.filter != "" {
+		re, err := regexp.Compile(m.filter)
+		if err != nil {
+			m.filterErr = err.Error()
+			return
+		}
+		match = func(r *tuiRow) bool { return re.MatchString(r.query.Query) }
+	}
+	m.visible = m.visible[:0]
+	for _, r := range m.rows {
+		if match(r) {
+			m.visible = append(m.visible, r)
+		}
+	}
+	sort.SliceStable(m.visible, func(i, j int) bool {
+		a, b := m.visible[i].values[m.sortBy], m.visible[j].values[m.sortBy]
+		if m.asc {
+			return a < b
+		}
+		return a > b
+	})
+	m.cursor = max(min(m.cursor, len(m.visible)-1), 0)
+	m.scroll()
+}
+
+// pageSize is the number of table rows that fit between the title, the header and the status line.
+func (m *tuiModel) pageSize() int {
+	return max(m.height-3, 1)
+}
+
+// scroll moves the table so the cursor is visible.
+func (m *tuiModel) scroll() {
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.pageSize() {
+		m.offset = m.cursor - m.pageSize() + 1
+	}
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.scroll()
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+		switch {
+		case m.filtering:
+			m.updateFilter(msg)
+		case m.detail != nil:
+			m.updateDetail(msg)
+		default:
+			return m, m.updateTable(msg)
+		}
+	}
+	return m, nil
+}
+
+func (m *tuiModel) updateFilter(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.filtering = false
+		return
+	case tea.KeyEsc:
+		m.filtering = false
+		m.filter = ""
+	case tea.KeyBackspace:
+		if m.filter != "" {
+			runes := []rune(m.filter)
+			m.filter = string(runes[:len(runes)-1])
+		}
+	case tea.KeyCtrlR:
+		m.regex = !m.regex
+	case tea.KeySpace:
+		m.filter += " "
+	case tea.KeyRunes:
+		m.filter += string(msg.Runes)
+	default:
+		return
+	}
+	m.refresh()
+}
+
+func (m *tuiModel) updateTable(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "q", "esc":
+		return tea.Quit
+	case "up", "k":
+		m.cursor--
+	case "down", "j":
+		m.cursor++
+	case "pgup":
+		m.cursor -= m.pageSize()
+	case "pgdown", " ":
+		m.cursor += m.pageSize()
+	case "home", "g":
+		m.cursor = 0
+	case "end", "G":
+		m.cursor = len(m.visible) - 1
+	case "right", "l", "tab":
+		m.sortBy = (m.sortBy + 1) % len(m.columns)
+		m.refresh()
+	case "left", "h", "shift+tab":
+		m.sortBy = (m.sortBy + len(m.columns) - 1) % len(m.columns)
+		m.refresh()
+	case "r":
+		m.asc = !m.asc
+		m.refresh()
+	case "/":
+		m.filtering = true
+	case "enter":
+		if len(m.visible) > 0 {
+			m.detail = m.visible[m.cursor]
+			m.detailOffset = 0
+		}
+	}
+	m.cursor = max(min(m.cursor, len(m.visible)-1), 0)
+	m.scroll()
+	return nil
+}
+
+func (m *tuiModel) updateDetail(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "q", "esc", "enter", "backspace":
+		m.detail = nil
+		return
+	case "up", "k":
+		m.detailOffset--
+	case "down", "j":
+		m.detailOffset++
+	case "pgup":
+		m.detailOffset -= m.pageSize()
+	case "pgdown", " ":
+		m.detailOffset += m.pageSize()
+	case "home", "g":
+		m.detailOffset = 0
+	case "end", "G":
+		m.detailOffset = len(m.detail.query.Logs)
+	}
+	m.detailOffset = max(min(m.detailOffset, len(m.detail.query.Logs)-m.pageSize()), 0)
+}
+
+// fitLine truncates a line to the width of the terminal, so it doesn't wrap and shift the rest of the screen.
+func (m *tuiModel) fitLine(line string) string {
+	return querystats.TruncateWidth(line, m.width)
+}
+
+func (m *tuiModel) View() string {
+	if m.detail != nil {
+		return m.viewDetail()
+	}
+	var b strings.Builder
+	order := "descending"
+	if m.asc {
+		order = "ascending"
+	}
+	fmt.Fprintf(&b, "%d of %d queries by %s, %s\n", len(m.visible), len(m.rows), tableTitle(m.columns[m.sortBy].metric, m.columns[m.sortBy].kind), order)
+
+	var header strings.Builder
+	for i, c := range m.columns {
+		title := c.header
+		if i == m.sortBy {
+			title = map[bool]string{false: "▼", true: "▲"}[m.asc] + title
+		}
+		header.WriteString(strings.Repeat(" ", max(tuiColumnWidth-querystats.DisplayWidth(title), 0)) + title + " ")
+	}
+	header.WriteString("query")
+	b.WriteString(m.fitLine(header.String()) + "\n")
+
+	end := min(m.offset+m.pageSize(), len(m.visible))
+	for i := m.offset; i < end; i++ {
+		row := m.visible[i]
+		var line strings.Builder
+		for j, c := range m.columns {
+			fmt.Fprintf(&line, "%*s ", tuiColumnWidth, c.metric.Format(row.values[j]))
+		}
+		line.WriteString(row.text)
+		text := m.fitLine(line.String())
+		if i == m.cursor {
+			// reverse video, padded so the whole line is highlighted
+			text = "\x1b[7m" + text + strings.Repeat(" ", max(m.width-querystats.DisplayWidth(text), 0)) + "\x1b[0m"
+		}
+		b.WriteString(text + "\n")
+	}
+	for i := end - m.offset; i < m.pageSize(); i++ {
+		b.WriteString("\n")
+	}
+
+	switch {
+	case m.filtering:
+		mode := "substring"
+		if m.regex {
+			mode = "regex"
+		}
+		status := fmt.Sprintf("filter (%s, ctrl+r toggles regex, enter applies, esc clears): %s█", mode, m.filter)
+		if m.filterErr != "" {
+			status += "  " + m.filterErr
+		}
+		b.WriteString(m.fitLine(status))
+	case m.filter != "":
+		b.WriteString(m.fitLine(fmt.Sprintf("filter: %s  ↑/↓ move  enter executions  ←/→ sort  r reverse  / filter  q quit", m.filter)))
+	default:
+		b.WriteString(m.fitLine("↑/↓ move  enter executions  ←/→ sort  r reverse  / filter  q quit"))
+	}
+	return b.String()
+}
+
+// viewDetail shows the executions of the selected query in the order they ran.
+func (m *tuiModel) viewDetail() string {
+	q := m.detail.query
+	var b strings.Builder
+	title := fmt.Sprintf("%s %s", QueryID(q.Query), m.detail.text)
+	if q.Logs[0].RuleGroup != nil {
+		title += fmt.Sprintf(" | ruleName=\"%s\"", escapeTerminal(q.Logs[0].RuleGroup.Name))
+	}
+	b.WriteString(m.fitLine(title) + "\n")
+	b.WriteString(m.fitLine(fmt.Sprintf("%-20s %10s %10s %10s %12s %12s %10s %6s  %s", "time", "exec", "queue", "eval", "samples", "peak", "range", "step", "source")) + "\n")
+
+	logs := make(querystats.LogEntries, len(q.Logs))
+	copy(logs, q.Logs)
+	sort.Sort(querystats.ByTime{LogEntries: logs})
+	end := min(m.detailOffset+m.pageSize(), len(logs))
+	for _, e := range logs[m.detailOffset:end] {
+		var rng time.Duration
+		if e.Params.Start != nil && e.Params.End != nil {
+			rng = e.Params.End.Sub(*e.Params.Start)
+		}
+		source := ""
+		switch {
+		case e.RuleGroup != nil:
+			source = "rule group " + e.RuleGroup.Name
+		case e.HTTPRequest != nil:
+			source = e.HTTPRequest.ClientIP
+		}
+		if e.Error != "" {
+			source += " error: " + e.Error
+		}
+		line := fmt.Sprintf("%-20s %10s %10s %10s %12d %12d %10s %6d  %s", formatTime(*e.TS),
+			MetricExecTotalTime.Format(e.Stats.Timings.ExecTotalTime), MetricQueueTime.Format(e.Stats.Timings.ExecQueueTime),
+			MetricInnerEvalTime.Format(e.Stats.Timings.InnerEvalTime), e.Stats.Samples.TotalQueryableSamples,
+			e.Stats.Samples.PeakSamples, rng, e.Params.Step, escapeTerminal(source))
+		b.WriteString(m.fitLine(line) + "\n")
+	}
+	for i := end - m.detailOffset; i < m.pageSize(); i++ {
+		b.WriteString("\n")
+	}
+	b.WriteString(m.fitLine(fmt.Sprintf("executions %d-%d of %d  ↑/↓ scroll  esc back  ctrl+c quit", m.detailOffset+1, end, len(logs))))
+	return b.String()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+)
+
+// displayLocation is the time zone timestamps are printed in, see -tz. Timestamps of filters and of machine-readable
+// outputs such as JSON and SQLite are not affected.
+var displayLocation = time.UTC
+
+const tzUsage = "time zone timestamps are printed in, e.g. local or Europe/Berlin. Filters such as -from still take UTC unless the timestamp has an offset, and JSON output stays in UTC"
+
+func init() {
+	flag.Var(locationFlag{}, "tz", tzUsage+". Also the default of -heatmap-tz")
+}
+
+// locationFlag sets displayLocation.
+type locationFlag struct{}
+
+func (locationFlag) String() string {
+	return displayLocation.String()
+}
+
+func (locationFlag) Set(value string) error {
+	loc, err := loadLocation(value)
+	if err != nil {
+		return err
+	}
+	displayLocation = loc
+	return nil
+}
+
+// loadLocation is time.LoadLocation, which also accepts local and utc in any case.
+func loadLocation(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// displayTime returns t in the time zone of -tz.
+func displayTime(t time.Time) time.Time {
+	return t.In(displayLocation)
+}
+
+// formatTime formats t as RFC 3339 in the time zone of -tz.
+func formatTime(t time.Time) string {
+	return displayTime(t).Format(time.RFC3339)
+}
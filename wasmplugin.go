@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WasmPlugin maps log lines of an arbitrary format to the Prometheus query log
+// format with a WebAssembly module. The module must export its memory and the following functions:
+//
+//	alloc(size i32) i32             allocates size bytes and returns a pointer to them
+//	map_line(ptr i32, len i32) i64  maps the line stored at ptr and returns (ptr << 32 | len)
+//	                                of the resulting JSON document, or 0 to skip the line
+//
+// It may also export free(ptr i32, size i32), which is called for every buffer returned by
+// alloc and map_line once it is no longer used. WASI is available to the module.
+type WasmPlugin struct {
+	ctx     context.Context
+	runtime wazero.Runtime
+	module  api.Module
+	alloc   api.Function
+	mapLine api.Function
+	free    api.Function
+}
+
+func LoadWasmPlugin(ctx context.Context, name string) (*WasmPlugin, error) {
+	bin, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime := wazero.NewRuntime(ctx)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	config := wazero.NewModuleConfig().WithStartFunctions("_initialize").WithStderr(os.Stderr)
+	module, err := runtime.InstantiateWithConfig(ctx, bin, config)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate the module: %w", err)
+	}
+
+	p := &WasmPlugin{
+		ctx:     ctx,
+		runtime: runtime,
+		module:  module,
+		alloc:   module.ExportedFunction("alloc"),
+		mapLine: module.ExportedFunction("map_line"),
+		free:    module.ExportedFunction("free"),
+	}
+	if p.alloc == nil || p.mapLine == nil || module.Memory() == nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("the module must export memory, alloc and map_line")
+	}
+	return p, nil
+}
+
+func (p *WasmPlugin) Close() error {
+	return p.runtime.Close(p.ctx)
+}
+
+// MapLine returns the line converted to the Prometheus query log format or nil if the line must be skipped.
+func (p *WasmPlugin) MapLine(line []byte) ([]byte, error) {
+	res, err := p.alloc.Call(p.ctx, uint64(len(line)))
+	if err != nil {
+		return nil, fmt.Errorf("alloc failed: %w", err)
+	}
+	inPtr := uint32(res[0])
+	defer p.release(inPtr, uint32(len(line)))
+	if !p.module.Memory().Write(inPtr, line) {
+		return nil, fmt.Errorf("alloc returned an out of range pointer %d", inPtr)
+	}
+
+	res, err = p.mapLine.Call(p.ctx, uint64(inPtr), uint64(len(line)))
+	if err != nil {
+		return nil, fmt.Errorf("map_line failed: %w", err)
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	if outLen == 0 {
+		return nil, nil
+	}
+	defer p.release(outPtr, outLen)
+	out, ok := p.module.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("map_line returned an out of range buffer (%d, %d)", outPtr, outLen)
+	}
+	// out is a view of the module memory, which is reused by subsequent calls
+	return append([]byte(nil), out...), nil
+}
+
+func (p *WasmPlugin) release(ptr, size uint32) {
+	if p.free != nil {
+		p.free.Call(p.ctx, uint64(ptr), uint64(size))
+	}
+}